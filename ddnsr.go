@@ -1,53 +1,416 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ddnsr/dns"
+	"ddnsr/resolver"
+	"ddnsr/server"
 )
 
 type ClientConfig struct {
+	adds		stringList
+	algorithm	string
+	anchor		string
+	anchors		[]dns.ResourceRecord // Trust anchors, for -dnssec
+	bits		uint
+	bufsize		uint
+	cache		bool
+	command		string	// Subcommand, if any, e.g. "serve"
+	deletes		stringList
+	dnssec		bool
+	expiration	string
+	follow		bool
+	format		string
+	https		string
+	httpget		bool
+	inception	string
+	iterations	uint
+	iterative	bool
+	key			string
+	keyalg		uint8	// Parsed -algorithm, for keygen
+	ksk			bool
+	listen		string
+	nsec3		bool
+	optout		bool
+	primary		bool	// Update the primary server from the SOA
+	prohibits	stringList
+	quic		bool
 	raw			bool
 	recursive	bool
+	requires	stringList
+	resolvconf	string
+	retries		uint
+	reverse		bool
+	roots		stringList
+	rotate		bool
 	rtype		string
-	server		string
+	salt		string
+	search		*resolver.ResolvConf // Search list, if any
+	serial		int64	// IXFR=serial, else -1
+	servers		stringList
+	signing		dns.SigningOptions	// Parsed -inception, -nsec3, etc, for sign
+	signkeys	stringList
+	snapshot	string
+	tcp			bool
 	timeout		uint
+	tls			bool
+	tlsca		string
+	tlsname		string
+	tlspins		stringList
+	trace		bool
+	tsig		*dns.TSIGKey
+	udp			bool
+	zones		stringList
+}
+
+// Repeatable string flags, e.g. -server
+type stringList []string
+
+func (servers *stringList) String() string {
+	return strings.Join(*servers, ",")
+}
+
+func (servers *stringList) Set(server string) error {
+	*servers = append(*servers, server)
+	return nil
 }
 
+const DefaultServer = "1.1.1.1"
+const DefaultListen = "127.0.0.1:53"
+
+// Concurrent lookups when sweeping a prefix with -x
+const ReverseWorkers = 16
+
 
 func initializeConfig() ClientConfig {
 	var config = ClientConfig{}
 
 	// Describe all flags
-	flag.BoolVar(&config.raw, "raw", false, "Show the raw packet bytes?")
+	flag.Var(&config.adds, "add",
+		"Record to add, as \"name ttl type rdata\", for update, after any " +
+		"-delete; repeatable")
+	flag.StringVar(&config.algorithm, "algorithm", "ECDSAP256SHA256",
+		"DNSSEC algorithm of the key, for keygen: RSASHA256, " +
+		"ECDSAP256SHA256, ECDSAP384SHA384 or ED25519")
+	flag.StringVar(&config.anchor, "anchor", "",
+		"Trust anchor file of DS or DNSKEY records, for -dnssec (default " +
+		"built-in root KSKs)")
+	flag.UintVar(&config.bits, "bits", dns.DefaultRSABits,
+		"Size of RSA keys, for keygen")
+	flag.UintVar(&config.bufsize, "bufsize", dns.EDNSDefaultUDPSize,
+		"Advertised EDNS UDP payload size, or 0 to disable EDNS")
+	flag.BoolVar(&config.cache, "cache", false,
+		"Cache answers across all of the hostnames, and show the cache statistics?")
+	flag.Var(&config.deletes, "delete",
+		"Name, RRset or record to delete, as \"name [type [rdata]]\", for " +
+		"update; repeatable")
+	flag.BoolVar(&config.dnssec, "dnssec", false,
+		"Request DNSSEC records, and validate each answer as Secure, " +
+		"Insecure, Bogus or Indeterminate?")
+	flag.StringVar(&config.expiration, "expiration", "+30d",
+		"Signature expiration, as YYYYMMDDHHmmSS or an offset from now, " +
+		"for sign")
+	flag.BoolVar(&config.follow, "follow", true,
+		"Follow CNAME/DNAME chains across additional queries?")
+	flag.StringVar(&config.format, "format", "text",
+		"Output format for hostname lookups: text, or json for one RFC 8427 " +
+		"object per hostname")
+	flag.StringVar(&config.https, "https", "",
+		"Send queries over DNS-over-HTTPS to this URL, instead of -server")
+	flag.BoolVar(&config.httpget, "httpget", false,
+		"Send DNS-over-HTTPS queries via GET, rather than POST?")
+	flag.StringVar(&config.inception, "inception", "-1h",
+		"Signature inception, as YYYYMMDDHHmmSS or an offset from now, " +
+		"for sign")
+	flag.UintVar(&config.iterations, "iterations", 0,
+		"Additional NSEC3 hash iterations, for sign -nsec3")
+	flag.BoolVar(&config.iterative, "iterative", false,
+		"Resolve iteratively from the root servers, rather than via -server?")
+	flag.StringVar(&config.key, "k", "",
+		"TSIG key to sign requests + verify replies with, as " +
+		"name:algorithm:secret or the path to a key file")
+	flag.BoolVar(&config.ksk, "ksk", false,
+		"Generate a key-signing key, rather than a zone-signing key, for " +
+		"keygen?")
+	flag.StringVar(&config.listen, "listen", DefaultListen,
+		"IP address:port to answer queries on, for serve + authoritative")
+	flag.BoolVar(&config.nsec3, "nsec3", false,
+		"Prove non-existence via NSEC3, rather than NSEC, for sign?")
+	flag.BoolVar(&config.optout, "optout", false,
+		"Leave unsigned delegations out of the NSEC3 chain, for sign -nsec3?")
+	flag.Var(&config.prohibits, "prohibit",
+		"Prerequisite that a name or RRset does not exist, as \"name [type]\", " +
+		"for update; repeatable")
+	flag.BoolVar(&config.quic, "quic", false,
+		"Send queries over DNS-over-QUIC, on port 853 by default?")
+	flag.BoolVar(&config.raw, "raw", false,
+		"Show the raw packet bytes?  With -format json, as hex members")
 	flag.BoolVar(&config.recursive, "recursive", true,
 		"Send a recursive DNS query?")
+	flag.Var(&config.requires, "require",
+		"Prerequisite that a name, RRset or exact RRset exists, as " +
+		"\"name [type [rdata]]\", for update; repeatable")
+	flag.StringVar(&config.resolvconf, "resolvconf", resolver.DefaultResolvConf,
+		"Resolver configuration, used when no -server is given")
+	flag.UintVar(&config.retries, "retries", 2,
+		"Number of retries after every server fails")
+	flag.Var(&config.roots, "root",
+		"IP address[:port] of a root server for -iterative, repeatable " +
+		"(default built-in root hints)")
 	flag.StringVar(&config.rtype, "rtype", "A",
-		"DNS record type (A, ALL, CNAME, MX, PTR, SOA, TXT, etc)")
-	flag.StringVar(&config.server, "server", "1.1.1.1",
-		"IP address of upstream DNS server")
-	flag.UintVar(&config.timeout, "timeout", 3, "Request timeout, in seconds")
+		"DNS record type (A, ALL, CNAME, DNSKEY, DS, MX, PTR, SOA, TXT, " +
+		"etc), or a zone transfer via AXFR or IXFR=serial")
+	flag.StringVar(&config.salt, "salt", "-",
+		"NSEC3 salt in hex, or - for none, for sign -nsec3")
+	flag.Var(&config.servers, "server",
+		"IP address[:port] of upstream DNS server, repeatable (default " +
+		"from -resolvconf, else " + DefaultServer + "; for update, the " +
+		"primary server named by the SOA)")
+	flag.Var(&config.signkeys, "signkey",
+		"Key to sign with, as the path of a key file from keygen, for sign; " +
+		"repeatable")
+	flag.StringVar(&config.snapshot, "snapshot", "",
+		"Zone file of the current zone, to apply IXFR differences to")
+	flag.BoolVar(&config.tcp, "tcp", false, "Send queries over TCP only?")
+	flag.UintVar(&config.timeout, "timeout", 3, "Per-attempt request timeout, in seconds")
+	flag.BoolVar(&config.trace, "trace", false,
+		"Show each delegation step of the resolution (implies -iterative)?")
+	flag.BoolVar(&config.tls, "tls", false,
+		"Send queries over DNS-over-TLS, on port 853 by default?")
+	flag.StringVar(&config.tlsca, "tlsca", "",
+		"PEM bundle of CA certificates for TLS (default system roots)")
+	flag.StringVar(&config.tlsname, "tlsname", "",
+		"Name to authenticate the TLS server against (default server address)")
+	flag.Var(&config.tlspins, "tlspin",
+		"Base64 SHA-256 SPKI pin of the TLS server, repeatable")
+	flag.BoolVar(&config.udp, "udp", false,
+		"Send queries over UDP only, without TCP fallback on truncation?")
+	flag.BoolVar(&config.reverse, "x", false,
+		"Reverse lookups: each argument is an IP address or CIDR prefix?")
+	flag.Var(&config.zones, "zone",
+		"Zone to serve or sign, as origin=path to a zone file, for " +
+		"authoritative + sign; repeatable")
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(),
-			"Usage: %s [options] hostname1 hostname2 ...\n", os.Args[0])
+			"Usage: %s [options] hostname1 hostname2 ...\n" +
+			"       %s serve [options]\n" +
+			"       %s authoritative -zone origin=path [options]\n" +
+			"       %s update [options] zone\n" +
+			"       %s sign -zone origin=path -signkey file [options]\n" +
+			"       %s keygen [options] zone\n",
+			os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0],
+			os.Args[0])
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	// Parse + validate any command-line arguments
-	flag.Parse()
-	if (flag.NArg() == 0) {
+	// Parse + validate any command-line arguments, after the subcommand
+	arguments := os.Args[1:]
+	if (len(arguments) > 0 && (arguments[0] == "serve" ||
+		arguments[0] == "authoritative" || arguments[0] == "update" ||
+		arguments[0] == "sign" || arguments[0] == "keygen")) {
+		config.command = arguments[0]
+		arguments = arguments[1:]
+	}
+	flag.CommandLine.Parse(arguments)
+	if (config.command == "update" || config.command == "keygen") {
+		if (flag.NArg() != 1 || config.reverse) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"%s takes a single zone name, and no -x\n", config.command)
+			flag.Usage()
+		}
+	} else if (config.command != "") {
+		if (flag.NArg() > 0 || config.reverse) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"%s takes no hostnames, nor -x\n", config.command)
+			flag.Usage()
+		}
+		host, _, err := net.SplitHostPort(config.listen)
+		if (err != nil || net.ParseIP(host) == nil) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid listen address: %s\n", config.listen)
+			flag.Usage()
+		}
+	} else if (flag.NArg() == 0) {
+		flag.Usage()
+	}
+	if ((config.command == "authoritative" || config.command == "sign") !=
+		(len(config.zones) > 0)) {
+		fmt.Fprintf(flag.CommandLine.Output(), "authoritative + sign " +
+			"require -zone, and -zone requires authoritative or sign\n")
+		flag.Usage()
+	}
+	for _, zone := range config.zones {
+		origin, path, ok := strings.Cut(zone, "=")
+		if (!ok || origin == "" || path == "") {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid zone, expected origin=path: %s\n", zone)
+			flag.Usage()
+		}
+	}
+	if (config.command != "update" && len(config.adds) + len(config.deletes) +
+		len(config.requires) + len(config.prohibits) > 0) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"-add, -delete, -require and -prohibit require update\n")
+		flag.Usage()
+	}
+	validateSigning(&config)
+	config.primary = (config.command == "update" &&
+		len(config.servers) == 0 && config.https == "")
+	config.iterative = (config.iterative || config.trace)
+	if (config.iterative) {
+		if (len(config.servers) > 0 || config.https != "" || config.quic ||
+			config.tls) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"-iterative queries the root servers directly, not -server, " +
+				"-https, -quic or -tls\n")
+			flag.Usage()
+		}
+		for _, root := range config.roots {
+			if (!resolver.ValidServer(root)) {
+				fmt.Fprintf(flag.CommandLine.Output(),
+					"Invalid root server: %s\n", root)
+				flag.Usage()
+			}
+		}
+	} else if (len(config.roots) > 0) {
+		fmt.Fprintf(flag.CommandLine.Output(), "-root requires -iterative\n")
+		flag.Usage()
+	} else if (config.https != "") {
+		if (len(config.servers) > 0 || !resolver.ValidURL(config.https)) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid DNS-over-HTTPS URL: %s\n", config.https)
+			flag.Usage()
+		}
+	} else if (len(config.servers) == 0) {
+		loadResolvConf(&config)
+	}
+	for _, server := range config.servers {
+		if (!resolver.ValidServer(server)) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid DNS server: %s\n", server)
+			flag.Usage()
+		}
+	}
+	if (config.bufsize != 0 &&
+		(config.bufsize < dns.EDNSMinUDPSize ||
+		config.bufsize > resolver.TCPMaxMessageSize)) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Invalid EDNS buffer size: %d\n", config.bufsize)
 		flag.Usage()
 	}
-	if (net.ParseIP(config.server) == nil) {
+	transports := 0
+	for _, set := range []bool{ config.https != "", config.quic, config.tcp,
+		config.tls, config.udp } {
+		if (set) {
+			transports++
+		}
+	}
+	if (transports > 1) {
 		fmt.Fprintf(flag.CommandLine.Output(),
-			"Invalid DNS server: %s\n", config.server)
+			"Conflicting transports: only one of -https, -quic, -tcp, -tls and -udp\n")
 		flag.Usage()
 	}
-	if (RecordTypeMapToType[config.rtype] == 0) {
+	if (!config.tls && !config.quic && config.https == "" &&
+		(config.tlsca != "" || config.tlsname != "" || len(config.tlspins) > 0)) {
 		fmt.Fprintf(flag.CommandLine.Output(),
-			"Invalid record type: %s", config.rtype)
+			"TLS options require -https, -quic or -tls\n")
+		flag.Usage()
+	}
+	if (config.httpget && config.https == "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "-httpget requires -https\n")
+		flag.Usage()
+	}
+	if (config.key != "") {
+		var err error
+		if (strings.Count(config.key, ":") == 2) {
+			config.tsig, err = dns.ParseTSIGKey(config.key)
+		} else {
+			config.tsig, err = dns.LoadTSIGKey(config.key)
+		}
+		if (err != nil) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid TSIG key: %s\n", err)
+			flag.Usage()
+		}
+	}
+	config.serial = -1
+	rtype, serial, incremental := strings.Cut(config.rtype, "=")
+	if (incremental) {
+		parsed, err := strconv.ParseUint(serial, 10, 32)
+		if (rtype != "IXFR" || err != nil) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid IXFR serial: %s\n", config.rtype)
+			flag.Usage()
+		}
+		config.rtype = rtype
+		config.serial = int64(parsed)
+	}
+	if (dns.RecordTypeMapToType[config.rtype] == 0) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Invalid record type: %s\n", config.rtype)
+		flag.Usage()
+	}
+	if (config.rtype == "AXFR" || config.rtype == "IXFR") {
+		if (config.command != "" || config.reverse || config.iterative ||
+			config.https != "" || config.quic || config.udp) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Zone transfers take zone names, over -tcp or -tls only\n")
+			flag.Usage()
+		}
+		if (config.rtype == "IXFR" && config.serial < 0 &&
+			config.snapshot == "") {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"IXFR requires a serial, or a -snapshot to take it from\n")
+			flag.Usage()
+		}
+	} else if (config.snapshot != "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "-snapshot requires IXFR\n")
+		flag.Usage()
+	}
+	if (config.dnssec) {
+		if (config.command != "" || config.reverse || config.rtype == "AXFR" ||
+			config.rtype == "IXFR" || config.bufsize == 0) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"-dnssec requires EDNS, and hostname lookups\n")
+			flag.Usage()
+		}
+		config.anchors = resolver.RootAnchors
+		if (config.anchor != "") {
+			var err error
+			config.anchors, err = resolver.LoadTrustAnchors(config.anchor)
+			if (err != nil) {
+				fmt.Fprintf(flag.CommandLine.Output(),
+					"Invalid trust anchors: %s\n", err)
+				flag.Usage()
+			}
+		}
+	} else if (config.anchor != "") {
+		fmt.Fprintf(flag.CommandLine.Output(), "-anchor requires -dnssec\n")
+		flag.Usage()
+	}
+	if (config.format == "json") {
+		if (config.command != "" || config.reverse || config.rtype == "AXFR" ||
+			config.rtype == "IXFR" || config.trace || config.dnssec) {
+			fmt.Fprintf(flag.CommandLine.Output(), "-format json requires " +
+				"hostname lookups, without -trace or -dnssec\n")
+			flag.Usage()
+		}
+	} else if (config.format != "text") {
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid format: %s\n",
+			config.format)
 		flag.Usage()
 	}
 
@@ -55,11 +418,724 @@ func initializeConfig() ClientConfig {
 }
 
 
+// Validate the options of sign + keygen, which only apply to those
+func validateSigning(config *ClientConfig) {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, name := range []string{ "expiration", "inception", "iterations",
+		"nsec3", "optout", "salt", "signkey" } {
+		if (explicit[name] && config.command != "sign") {
+			fmt.Fprintf(flag.CommandLine.Output(), "-%s requires sign\n", name)
+			flag.Usage()
+		}
+	}
+	for _, name := range []string{ "algorithm", "bits", "ksk" } {
+		if (explicit[name] && config.command != "keygen") {
+			fmt.Fprintf(flag.CommandLine.Output(), "-%s requires keygen\n",
+				name)
+			flag.Usage()
+		}
+	}
+
+	if (config.command == "keygen") {
+		config.keyalg = dns.ParseAlgorithm(config.algorithm)
+		if (config.keyalg == 0) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Unsupported DNSSEC algorithm: %s\n", config.algorithm)
+			flag.Usage()
+		}
+		if (config.keyalg == dns.AlgorithmRSASHA256 &&
+			(config.bits < 1024 || config.bits > 4096)) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Invalid RSA key size: %d\n", config.bits)
+			flag.Usage()
+		}
+	}
+	if (config.command != "sign") {
+		return
+	}
+
+	if (len(config.zones) != 1 || len(config.signkeys) == 0) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"sign requires a single -zone, and at least one -signkey\n")
+		flag.Usage()
+	}
+	now := time.Now()
+	var err error
+	config.signing.Inception, err = parseSigningTime(config.inception, now)
+	if (err == nil) {
+		config.signing.Expiration, err = parseSigningTime(config.expiration,
+			now)
+	}
+	if (err != nil || int32(config.signing.Expiration -
+		config.signing.Inception) <= 0) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"Invalid signature validity: %s to %s\n", config.inception,
+			config.expiration)
+		flag.Usage()
+	}
+
+	if (!config.nsec3) {
+		if (config.optout || config.iterations > 0 || config.salt != "-") {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"-salt, -iterations and -optout require -nsec3\n")
+			flag.Usage()
+		}
+		return
+	}
+	if (config.iterations > resolver.NSEC3MaxIterations) {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"-iterations above %d are treated as insecure\n",
+			resolver.NSEC3MaxIterations)
+		flag.Usage()
+	}
+	params := &dns.NSEC3Params{ HashAlgorithm: dns.NSEC3HashSHA1,
+		Iterations: uint16(config.iterations) }
+	if (config.optout) {
+		params.Flags = dns.NSEC3FlagOptOut
+	}
+	if (config.salt != "-") {
+		params.Salt, err = hex.DecodeString(config.salt)
+		if (err != nil || len(params.Salt) > 255) {
+			fmt.Fprintf(flag.CommandLine.Output(), "Invalid NSEC3 salt: %s\n",
+				config.salt)
+			flag.Usage()
+		}
+	}
+	config.signing.NSEC3 = params
+}
+
+// Signature time, as YYYYMMDDHHmmSS, seconds since the epoch, or an offset
+// from now, with units, e.g. +30d or -1h
+func parseSigningTime(field string, now time.Time) (uint32, error) {
+	if (strings.HasPrefix(field, "+") || strings.HasPrefix(field, "-")) {
+		offset, err := dns.ParseTTL(field[1:])
+		if (err != nil) {
+			return 0, err
+		}
+		if (field[0] == '-') {
+			return uint32(now.Unix() - int64(offset)), nil
+		}
+		return uint32(now.Unix() + int64(offset)), nil
+	}
+	return dns.ParseSignatureTime(field)
+}
+
+
+// Use the upstream servers, search list and options from resolv.conf, unless
+// overridden on the command line
+func loadResolvConf(config *ClientConfig) {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	conf, err := resolver.LoadResolvConf(config.resolvconf)
+	if (err != nil) {
+		// A missing system configuration is not fatal; just fall back to
+		// a well-known public server
+		if (explicit["resolvconf"]) {
+			fmt.Fprintf(flag.CommandLine.Output(),
+				"Unable to load %s: %s\n", config.resolvconf, err)
+			flag.Usage()
+		}
+		config.servers = stringList{ DefaultServer }
+		return
+	}
+
+	config.servers	= conf.Nameservers
+	config.search	= conf
+	config.rotate	= conf.Rotate
+	if (!explicit["timeout"]) {
+		config.timeout = uint(conf.Timeout / time.Second)
+	}
+	if (!explicit["retries"]) {
+		config.retries = uint(conf.Attempts - 1)
+	}
+}
+
+
+func dumpBytes(header string, rawBytes []byte) {
+	fmt.Printf("%s: % x\n", header, rawBytes)
+}
+
+
+func newClient(config ClientConfig) (*resolver.Client, error) {
+	// Locate the upstream DNS resolvers
+	client := &resolver.Client{
+		Servers:	config.servers,
+		Timeout:	time.Duration(config.timeout) * time.Second,
+		Retries:	int(config.retries),
+		Backoff:	resolver.DefaultBackoff,
+		Rotate:		config.rotate,
+		TSIG:		config.tsig,
+	}
+	if (config.tcp) {
+		client.Transport = resolver.TransportTCP
+	} else if (config.udp) {
+		client.Transport = resolver.TransportUDP
+	} else if (config.tls) {
+		client.Transport = resolver.TransportTLS
+	} else if (config.quic) {
+		client.Transport = resolver.TransportQUIC
+	} else if (config.https != "") {
+		client.Servers = []string{ config.https }
+		client.Transport = resolver.TransportHTTPS
+		client.HTTPGet = config.httpget
+	}
+	if (config.raw && config.format != "json") {
+		client.Dump = dumpBytes
+	}
+
+	// Authenticate the DoT/DoH/DoQ server, if any
+	if (config.tls || config.quic || config.https != "") {
+		var err error
+		client.TLSConfig, err = resolver.NewTLSConfig(config.tlsname,
+			config.tlsca, config.tlspins)
+		if (err != nil) {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+
+func newIterator(config ClientConfig, client *resolver.Client) *resolver.Iterator {
+	iterator := &resolver.Iterator{ Client: client }
+	for _, root := range config.roots {
+		iterator.Roots = append(iterator.Roots,
+			resolver.Nameserver{ Name: root, Address: root })
+	}
+	if (config.trace) {
+		iterator.Trace = printStep
+	}
+	return iterator
+}
+
+// Show the referral (or final answer) from each step, as with dig +trace
+func printStep(step resolver.TraceStep) {
+	for _, rr := range step.Reply.Answers {
+		fmt.Println(rr)
+	}
+	for _, rr := range step.Reply.Nameservers {
+		fmt.Println(rr)
+	}
+	fmt.Printf(";; %s\n\n", step)
+}
+
+
+func query(ctx context.Context, config ClientConfig,
+	exchange resolver.ExchangeFunc, name string,
+	rtype uint16) (*resolver.Chain, error) {
+	// Create the initial DNS request
+	request := dns.NewQuery(name, rtype)
+	if (config.recursive) {
+		request.Header.Flags |= dns.MessageHeaderFlagRecursionDesired
+	}
+	if (config.bufsize > 0) {
+		request.SetEDNS(dns.OPTRecord{
+			UDPSize:	uint16(config.bufsize),
+			Version:	dns.EDNSVersion,
+			DNSSECOK:	config.dnssec,
+		})
+	}
+
+	// Validate locally, even the answers an upstream validator rejects
+	if (config.dnssec) {
+		request.Header.Flags |= dns.MessageHeaderFlagCheckingDisabled
+	}
+
+	if (config.follow) {
+		return resolver.FollowChain(ctx, exchange, &request, 0)
+	}
+	reply, err := exchange(ctx, &request)
+	if (reply == nil) {
+		return nil, err
+	}
+	return &resolver.Chain{ Answers: reply.Answers, Reply: reply,
+		Replies: []*dns.Message{ reply }, Queries: 1 }, err
+}
+
+// Show the last reply.  If the answer required several queries, then also
+// show the complete chain of aliases.  As JSON, only the last reply is shown,
+// as a single line, unless it cannot be encoded
+func printChain(config ClientConfig, chain *resolver.Chain) error {
+	if (config.format == "json") {
+		encoded, err := chain.Reply.JSON(config.raw)
+		if (err != nil) {
+			fmt.Fprintln(os.Stderr, "Unable to encode the reply: ", err)
+			return err
+		}
+		fmt.Println(string(encoded))
+		return nil
+	}
+	fmt.Println(*chain.Reply)
+	if (chain.Queries > 1) {
+		for _, rr := range chain.Links {
+			fmt.Printf("CH: %s\n", rr)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// Show the DNSSEC status of the entire chain, if required
+func printValidation(ctx context.Context, validator *resolver.Validator,
+	chain *resolver.Chain) {
+	if (validator != nil) {
+		fmt.Printf(";; DNSSEC: %s\n", validator.ValidateChain(ctx, chain))
+	}
+}
+
+func resolve(ctx context.Context, config ClientConfig,
+	exchange resolver.ExchangeFunc, validator *resolver.Validator,
+	host string) error {
+	// Relative names may expand into several candidates via the search list
+	names := []string{ host }
+	if (config.search != nil) {
+		names = config.search.SearchNames(host)
+	}
+
+	// Try each candidate in turn, until one of them yields an answer.  A
+	// reply with an error code is still a valid reply, so if none of the
+	// candidates succeed, show the first (or only) such reply
+	var fallback *resolver.Chain
+	var err error
+	for _, name := range names {
+		var chain *resolver.Chain
+		chain, err = query(ctx, config, exchange, name,
+			dns.RecordTypeMapToType[config.rtype])
+		var rcodeErr *dns.RcodeError
+		if (err != nil && !errors.As(err, &rcodeErr)) {
+			continue
+		}
+		if (err == nil && len(chain.Answers) > 0) {
+			err = printChain(config, chain)
+			if (err == nil) {
+				printValidation(ctx, validator, chain)
+			}
+			return err
+		}
+		if (fallback == nil) {
+			fallback = chain
+		}
+	}
+
+	if (fallback == nil) {
+		// Keep the JSON output parseable
+		output := os.Stdout
+		if (config.format == "json") {
+			output = os.Stderr
+		}
+		fmt.Fprintln(output, "DNS request failed: ", err)
+		return err
+	}
+	printErr := printChain(config, fallback)
+	if (printErr != nil) {
+		return printErr
+	}
+	printValidation(ctx, validator, fallback)
+
+	return err
+}
+
+
+// Look up the names for an address, or for each address in a CIDR prefix,
+// with forward-confirmation of each name
+func reverse(ctx context.Context, config ClientConfig,
+	exchange resolver.ExchangeFunc, prefix string) error {
+	addresses, err := resolver.ReverseAddresses(prefix)
+	if (err != nil) {
+		fmt.Printf("%s: %s\n", prefix, err)
+		return err
+	}
+	lookup := func(ctx context.Context, name string,
+		rtype uint16) (*resolver.Chain, error) {
+		return query(ctx, config, exchange, name, rtype)
+	}
+
+	// Sweep the addresses concurrently, but show the results in order
+	results	:= make([]*resolver.ReverseResult, len(addresses))
+	errs	:= make([]error, len(addresses))
+	slots	:= make(chan struct{}, ReverseWorkers)
+	var wg sync.WaitGroup
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address net.IP) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i], errs[i] = resolver.ReverseLookup(ctx, lookup, address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	// Sweeps only show the addresses that actually have names, or that
+	// could not be looked up at all.  The latter fail the entire sweep
+	sweep := (len(addresses) > 1)
+	for i, address := range addresses {
+		var rcodeErr *dns.RcodeError
+		if (errs[i] != nil) {
+			if (!sweep || !errors.As(errs[i], &rcodeErr)) {
+				fmt.Printf("%s: %s\n", address, errs[i])
+				err = errs[i]
+			}
+		} else if (len(results[i].Names) > 0) {
+			fmt.Println(results[i])
+		} else if (!sweep) {
+			fmt.Printf("%s: No PTR records\n", address)
+		}
+	}
+
+	return err
+}
+
+
+// Transfer the zone and show it as a zone file.  For IXFR, either apply the
+// differences to the snapshot, if any, or else show the differences alone
+func transfer(ctx context.Context, config ClientConfig,
+	client *resolver.Client, zone string) error {
+	var snapshot []dns.ResourceRecord
+	serial := uint32(config.serial)
+	if (config.snapshot != "") {
+		var err error
+		snapshot, err = dns.LoadZone(config.snapshot, zone)
+		if (err == nil && (len(snapshot) == 0 ||
+			snapshot[0].Type != dns.RecordTypeSOA)) {
+			err = resolver.ErrSnapshotMismatch
+		}
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Unable to load snapshot %s: %s\n",
+				config.snapshot, err)
+			return err
+		}
+		if (config.serial < 0) {
+			serial = snapshot[0].Data.(*dns.RDataSOA).Serial
+		}
+	}
+
+	result, err := client.Transfer(ctx, zone,
+		dns.RecordTypeMapToType[config.rtype], serial)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Zone transfer of %s failed: %s\n", zone,
+			err)
+		return err
+	}
+	if (result.Differences != nil && snapshot == nil) {
+		for _, difference := range result.Differences {
+			fmt.Printf("- %s\n", dns.ZoneString(difference.From))
+			for _, rr := range difference.Deleted {
+				fmt.Printf("- %s\n", dns.ZoneString(rr))
+			}
+			fmt.Printf("+ %s\n", dns.ZoneString(difference.To))
+			for _, rr := range difference.Added {
+				fmt.Printf("+ %s\n", dns.ZoneString(rr))
+			}
+		}
+	} else {
+		records, err := result.Apply(snapshot)
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Zone transfer of %s failed: %s\n", zone,
+				err)
+			return err
+		}
+		err = dns.WriteZone(os.Stdout, records)
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Unable to write zone %s: %s\n", zone, err)
+			return err
+		}
+	}
+
+	if (result.UpToDate) {
+		fmt.Printf(";; Zone %s is up to date, at serial %d\n", zone, serial)
+	} else {
+		fmt.Printf(";; Transfer of %s: %d records, %d differences, in %d " +
+			"messages\n", zone, len(result.Records), len(result.Differences),
+			result.Messages)
+	}
+	return nil
+}
+
+
+// UPDATE request for the zone, from the prerequisites + changes on the
+// command line.  Deletions come before additions, so that an RRset may be
+// replaced in a single update
+func newUpdate(config ClientConfig, zone string) (dns.Message, error) {
+	message := dns.NewUpdate(zone)
+	for _, spec := range config.requires {
+		rr, err := dns.ParseUpdateRecord(spec, zone)
+		if (err != nil) {
+			return message, fmt.Errorf("-require %s: %w", spec, err)
+		}
+		if (rr.Type == dns.RecordTypeALL) {
+			message.RequireName(rr.Name)
+		} else if (rr.Data == nil) {
+			message.RequireRRset(rr.Name, rr.Type)
+		} else {
+			message.RequireRR(rr)
+		}
+	}
+	for _, spec := range config.prohibits {
+		rr, err := dns.ParseUpdateRecord(spec, zone)
+		if (err == nil && rr.Data != nil) {
+			err = errors.New("RDATA is not allowed")
+		}
+		if (err != nil) {
+			return message, fmt.Errorf("-prohibit %s: %w", spec, err)
+		}
+		if (rr.Type == dns.RecordTypeALL) {
+			message.RequireNoName(rr.Name)
+		} else {
+			message.RequireNoRRset(rr.Name, rr.Type)
+		}
+	}
+	for _, spec := range config.deletes {
+		rr, err := dns.ParseUpdateRecord(spec, zone)
+		if (err != nil) {
+			return message, fmt.Errorf("-delete %s: %w", spec, err)
+		}
+		if (rr.Type == dns.RecordTypeALL) {
+			message.DeleteName(rr.Name)
+		} else if (rr.Data == nil) {
+			message.DeleteRRset(rr.Name, rr.Type)
+		} else {
+			message.DeleteRR(rr)
+		}
+	}
+	for _, spec := range config.adds {
+		records, err := dns.ParseZone(strings.NewReader(spec), zone, "-add")
+		if (err == nil && len(records) != 1) {
+			err = errors.New("expected a single record")
+		}
+		if (err != nil) {
+			return message, fmt.Errorf("-add %s: %w", spec, err)
+		}
+		message.AddRR(records[0])
+	}
+	return message, nil
+}
+
+// Send the update to the primary server, and show the response code
+func update(ctx context.Context, config ClientConfig, client *resolver.Client,
+	exchange resolver.ExchangeFunc, zone string) error {
+	message, err := newUpdate(config, zone)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Invalid update: %s\n", err)
+		return err
+	}
+	if (config.primary) {
+		lookup := func(ctx context.Context, name string,
+			rtype uint16) (*resolver.Chain, error) {
+			return query(ctx, config, exchange, name, rtype)
+		}
+		client.Servers, err = resolver.PrimaryServers(ctx, lookup, zone)
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Unable to locate the primary server " +
+				"for %s: %s\n", zone, err)
+			return err
+		}
+		fmt.Printf(";; Primary server for %s: %s\n", zone,
+			strings.Join(client.Servers, ", "))
+	}
+
+	reply, err := client.Exchange(ctx, &message)
+	if (reply == nil) {
+		fmt.Fprintln(os.Stderr, "DNS update failed: ", err)
+		return err
+	}
+	fmt.Println(*reply)
+	fmt.Printf(";; Update of %s: %s\n", zone, dns.RcodeString(reply.Rcode()))
+	return err
+}
+
+
+// Answer queries from other clients on the listen address until
+// interrupted, e.g. via the cache and upstream servers
+func serve(ctx context.Context, config ClientConfig,
+	handler server.Handler) error {
+	listener := &server.Server{
+		Address:	config.listen,
+		Handler:	handler,
+		Timeout:	time.Duration(config.timeout * (config.retries + 1)) * time.Second,
+	}
+	address, err := listener.Listen()
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to listen on %s: %s\n", config.listen,
+			err)
+		return err
+	}
+	fmt.Printf(";; Listening on %s (UDP + TCP)\n", address)
+	err = listener.Serve(ctx)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to serve on %s: %s\n", address, err)
+	}
+	return err
+}
+
+// Answer queries from the zone files
+func authoritative(ctx context.Context, config ClientConfig) error {
+	var zones []*server.Zone
+	for _, zone := range config.zones {
+		origin, path, _ := strings.Cut(zone, "=")
+		records, err := dns.LoadZone(path, origin)
+		if (err == nil) {
+			var loaded *server.Zone
+			loaded, err = server.NewZone(origin, records)
+			zones = append(zones, loaded)
+		}
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Unable to load zone %s: %s\n", origin, err)
+			return err
+		}
+		fmt.Printf(";; Loaded zone %s: %d records\n", origin, len(records))
+	}
+	return serve(ctx, config, server.Authoritative(zones...))
+}
+
+
+// Sign the zone with the keys from keygen, and write out the signed zone
+func sign(config ClientConfig) error {
+	origin, path, _ := strings.Cut(config.zones[0], "=")
+	records, err := dns.LoadZone(path, origin)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to load zone %s: %s\n", origin, err)
+		return err
+	}
+	var keys []*dns.SigningKey
+	for _, file := range config.signkeys {
+		key, err := dns.LoadSigningKey(file)
+		if (err != nil) {
+			fmt.Fprintf(os.Stderr, "Unable to load key %s: %s\n", file, err)
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	signed, err := dns.SignZone(records, origin, keys, config.signing)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to sign zone %s: %s\n", origin, err)
+		return err
+	}
+	err = dns.WriteZone(os.Stdout, signed)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to write zone %s: %s\n", origin, err)
+		return err
+	}
+
+	// The zone alone goes to stdout, which is likely redirected to a file
+	fmt.Fprintf(os.Stderr, ";; Signed zone %s: %d records, with %d keys, " +
+		"valid %s to %s\n", origin, len(signed), len(keys),
+		dns.SignatureTimeString(config.signing.Inception),
+		dns.SignatureTimeString(config.signing.Expiration))
+	return nil
+}
+
+// Generate a key pair for the zone, into key files in the current
+// directory, and show its DNSKEY + the DS record for the parent zone
+func keygen(config ClientConfig, zone string) error {
+	var flags uint16
+	if (config.ksk) {
+		flags = dns.DNSKEYFlagSEP
+	}
+	key, err := dns.GenerateSigningKey(zone, config.keyalg, flags,
+		int(config.bits))
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to generate a key for %s: %s\n", zone,
+			err)
+		return err
+	}
+	ds, err := key.DNSKEY.DS(key.Name, dns.DigestSHA256)
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to compute the DS for %s: %s\n", zone,
+			err)
+		return err
+	}
+	base, err := dns.SaveSigningKey(key, ".")
+	if (err != nil) {
+		fmt.Fprintf(os.Stderr, "Unable to save the key for %s: %s\n", zone,
+			err)
+		return err
+	}
+
+	fmt.Println(dns.ZoneString(key.Record(dns.DefaultDNSKEYTTL)))
+	fmt.Println(dns.ZoneString(dns.ResourceRecord{
+		Name:	key.Name,
+		Type:	dns.RecordTypeDS,
+		Class:	dns.RecordClassIN,
+		TTL:	dns.DefaultDNSKEYTTL,
+		Data:	ds,
+	}))
+	fmt.Printf(";; Created %s.key + %s.private\n", base, base)
+	return nil
+}
+
+
 func main() {
 	config := initializeConfig()
-	for _, host := range flag.Args() {
-		resolve(config, host)
+
+	// Abandon any pending queries on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The same client, and any open connections, serve all of the hostnames
+	client, err := newClient(config)
+	if (err != nil) {
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid TLS options: %s\n", err)
+		flag.Usage()
+	}
+	defer client.Close()
+
+	exchange := client.Exchange
+	if (config.iterative) {
+		exchange = newIterator(config, client).Resolve
+	}
+	var cache *resolver.Cache
+	if (config.cache || config.command == "serve") {
+		cache = &resolver.Cache{}
+		exchange = cache.Wrap(exchange)
+	}
+	var validator *resolver.Validator
+	if (config.dnssec) {
+		validator = &resolver.Validator{
+			Exchange:	exchange,
+			Anchors:	config.anchors,
+		}
 	}
+	if (config.command == "serve") {
+		err = serve(ctx, config, server.Forwarder(exchange))
+	} else if (config.command == "authoritative") {
+		err = authoritative(ctx, config)
+	} else if (config.command == "sign") {
+		err = sign(config)
+	} else if (config.command == "keygen") {
+		err = keygen(config, flag.Arg(0))
+	} else if (config.command == "update") {
+		err = update(ctx, config, client, exchange, flag.Arg(0))
+	} else {
+		for _, host := range flag.Args() {
+			var failed error
+			if (config.reverse) {
+				failed = reverse(ctx, config, exchange, host)
+			} else if (config.rtype == "AXFR" || config.rtype == "IXFR") {
+				failed = transfer(ctx, config, client, host)
+			} else {
+				failed = resolve(ctx, config, exchange, validator, host)
+			}
 
-	return
+			// Keep going with any other hosts, but still fail overall
+			if (failed != nil) {
+				err = failed
+			}
+		}
+	}
+	if (cache != nil && config.format != "json") {
+		fmt.Printf(";; %s\n", cache.Stats())
+	}
+
+	// A failed subcommand must not pass for a successful one, e.g. when its
+	// output is redirected to a file
+	if (err != nil) {
+		stop()
+		client.Close()
+		os.Exit(1)
+	}
 }
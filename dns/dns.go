@@ -0,0 +1,929 @@
+//
+// DNS protocol + structures defined by RFC 1035, 3596, et al.  See edns.go
+// for EDNS (RFC 6891).
+//
+
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+)
+
+
+//
+// DNS message header
+//
+type MessageHeader struct {
+	Id					uint16
+	Flags				uint16
+	QuestionCount		uint16
+	AnswerCount			uint16
+	NameserverCount		uint16
+	AdditionalCount		uint16
+}
+const MessageHeaderSize = 12 // 6 fields, 16b each
+
+const MessageHeaderFlagResponse				= 0x8000
+const MessageHeaderFlagOpcodeMask			= 0x7800
+const MessageHeaderFlagAuthoritative		= 0x0400
+const MessageHeaderFlagTruncation			= 0x0200
+const MessageHeaderFlagRecursionDesired		= 0x0100
+const MessageHeaderFlagRecursionAvailable	= 0x0080
+const MessageHeaderFlagAuthenticData		= 0x0020 // RFC 4035, 3.2.3
+const MessageHeaderFlagCheckingDisabled		= 0x0010 // RFC 4035, 3.2.2
+const MessageHeaderFlagResponseCodeMask		= 0x000F
+const messageHeaderOpcodeShift				= 11
+
+const OpcodeQuery			= 0
+const OpcodeNotify			= 4 // RFC 1996
+const OpcodeUpdate			= 5 // RFC 2136
+
+var OpcodeMapToString = map[uint16]string{
+		OpcodeQuery:	"QUERY",
+		OpcodeNotify:	"NOTIFY",
+		OpcodeUpdate:	"UPDATE",
+	}
+
+const RcodeNoError			= 0
+const RcodeFormatError		= 1
+const RcodeServerFailure	= 2
+const RcodeNameError		= 3
+const RcodeNotImplemented	= 4
+const RcodeRefused			= 5
+const RcodeNameExists		= 6 // RFC 2136, for UPDATE prerequisites
+const RcodeRRsetExists		= 7
+const RcodeRRsetMissing		= 8
+const RcodeNotAuthoritative	= 9
+const RcodeNotZone			= 10
+const RcodeBadVersion		= 16 // Extended, via EDNS
+
+var RcodeMapToString = map[uint16]string{
+		RcodeNoError:			"NOERROR",
+		RcodeFormatError:		"BAD-FORMAT",
+		RcodeServerFailure:		"SERVER-ERROR",
+		RcodeNameError:			"NXDOMAIN",
+		RcodeNotImplemented:	"NOT-IMPLEMENTED",
+		RcodeRefused:			"REFUSED",
+		RcodeNameExists:		"YXDOMAIN",
+		RcodeRRsetExists:		"YXRRSET",
+		RcodeRRsetMissing:		"NXRRSET",
+		RcodeNotAuthoritative:	"NOTAUTH",
+		RcodeNotZone:			"NOTZONE",
+		RcodeBadVersion:		"BADVERS",
+	}
+
+func RcodeString(rcode uint16) string {
+	name, ok := RcodeMapToString[rcode]
+	if (!ok) {
+		name = fmt.Sprintf("%d", int(rcode))
+	}
+	return name
+}
+
+func OpcodeString(opcode uint16) string {
+	name, ok := OpcodeMapToString[opcode]
+	if (!ok) {
+		name = fmt.Sprintf("%d", int(opcode))
+	}
+	return name
+}
+
+func (header MessageHeader) Opcode() uint16 {
+	return (header.Flags & MessageHeaderFlagOpcodeMask) >> messageHeaderOpcodeShift
+}
+
+func (header *MessageHeader) SetOpcode(opcode uint16) {
+	header.Flags = (header.Flags &^ MessageHeaderFlagOpcodeMask) |
+		((opcode << messageHeaderOpcodeShift) & MessageHeaderFlagOpcodeMask)
+}
+
+func (header MessageHeader) String() string {
+	// Expand flag fields into human-friendly codes
+	var flags []string
+	if (header.Flags & MessageHeaderFlagResponse != 0) {
+		flags = append(flags, "QR")
+	}
+	if (header.Opcode() != OpcodeQuery) {
+		flags = append(flags, fmt.Sprintf("OPCODE:%s",
+			OpcodeString(header.Opcode())))
+	}
+	if (header.Flags & MessageHeaderFlagAuthoritative != 0) {
+		flags = append(flags, "AA")
+	}
+	if (header.Flags & MessageHeaderFlagTruncation != 0) {
+		flags = append(flags, "TC")
+	}
+	if (header.Flags & MessageHeaderFlagRecursionDesired != 0) {
+		flags = append(flags, "RD")
+	}
+	if (header.Flags & MessageHeaderFlagRecursionAvailable != 0) {
+		flags = append(flags, "RA")
+	}
+	if (header.Flags & MessageHeaderFlagAuthenticData != 0) {
+		flags = append(flags, "AD")
+	}
+	if (header.Flags & MessageHeaderFlagCheckingDisabled != 0) {
+		flags = append(flags, "CD")
+	}
+	if (header.Flags & MessageHeaderFlagResponseCodeMask != 0) {
+		rcode := RcodeString(header.Flags & MessageHeaderFlagResponseCodeMask)
+		flags = append(flags, fmt.Sprintf("RCODE:%s", rcode))
+	}
+
+	return fmt.Sprintf(
+    "flags %#04x (%s), QD %d, AN %d, NS %d, AR %d",
+	header.Flags,
+	strings.Join(flags, " "),
+	header.QuestionCount,
+	header.AnswerCount,
+	header.NameserverCount,
+	header.AdditionalCount)
+}
+
+func (header MessageHeader) Pack() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, header)
+	return buffer.Bytes()
+}
+
+func (header *MessageHeader) Unpack(rawBytes []byte, offset int) (int, error) {
+	if (offset + MessageHeaderSize > len(rawBytes)) {
+		return 0, ErrUnexpectedEnd
+	}
+	reader := bytes.NewReader(rawBytes[offset:])
+	err := binary.Read(reader, binary.BigEndian, header)
+	return MessageHeaderSize, err
+}
+
+
+//
+// Composite domain-name manipulation
+//
+const DomainNameMaxLength	= 255
+const LabelMaxLength		= 63
+
+const LabelTypeMask				= 0xC0
+const LabelTypePointer			= 0xC0
+const CompressionPointerMask	= 0xC000
+const CompressionPointerMax		= 0x3FFF // Largest offset within a pointer
+
+// Offsets of names already written into the message, keyed by lowercase
+// name suffix, for name compression (RFC 1035, 4.1.4)
+type CompressionMap map[string]int
+
+func PackName(name string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := packNameTo(buffer, name, nil)
+	return buffer.Bytes(), err
+}
+
+// Labels of a domain name, as held without the trailing dot.  The root name
+// is empty, and has none
+func nameLabels(name string) []string {
+	if (name == "") {
+		return []string{}
+	}
+	return strings.Split(name, ".")
+}
+
+// Whether the name, as held without the trailing dot, fits the wire format:
+// no empty labels, no labels longer than 63 bytes, and no more than 255 bytes
+// in all (RFC 1035, 2.3.4)
+func checkName(name string) error {
+	nameLength := 1 // Trailing zero-length label
+	for _, label := range nameLabels(name) {
+		if (label == "") {
+			return fmt.Errorf("%w: %s", ErrEmptyLabel, name)
+		}
+		if (len(label) > LabelMaxLength) {
+			return fmt.Errorf("%w: %s", ErrLabelTooLong, name)
+		}
+		nameLength += 1 + len(label)
+	}
+	if (nameLength > DomainNameMaxLength) {
+		return fmt.Errorf("%w: %s", ErrNameTooLong, name)
+	}
+	return nil
+}
+
+// Append a domain name to a partially-packed message.  If a compression map
+// is supplied, then replace the longest previously-written suffix of the name
+// with a pointer, and remember any new suffixes for later names.  Names that
+// do not fit the wire format are errors, and leave the buffer untouched
+func packNameTo(buffer *bytes.Buffer, name string,
+	compression CompressionMap) error {
+	// The root name is either empty or "."
+	name = strings.TrimSuffix(name, ".")
+	err := checkName(name)
+	if (err != nil) {
+		return err
+	}
+	labels := nameLabels(name)
+
+	// Pack the individual labels into a continuous byte sequence
+	for i, label := range labels {
+		if (compression != nil) {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if pointer, ok := compression[suffix]; ok {
+				binary.Write(buffer, binary.BigEndian,
+					uint16(CompressionPointerMask | pointer))
+				return nil
+			}
+			if (buffer.Len() <= CompressionPointerMax) {
+				compression[suffix] = buffer.Len()
+			}
+		}
+		buffer.WriteByte(byte(len(label)))
+		buffer.WriteString(label)
+	}
+
+	// Trailing zero-length label
+	buffer.WriteByte(0)
+	return nil
+}
+
+func UnpackName(rawBytes []byte, offset int) (string, int, error) {
+	compressed	:= false
+	labels		:= []string{}
+	length		:= 0
+	nameLength	:= 0 // Uncompressed length, including all length bytes
+
+	// Compression pointers must always point backwards, to a name that
+	// precedes the current one.  Otherwise the pointers might loop
+	segmentStart := offset
+
+	for {
+		if (offset >= len(rawBytes)) {
+			return "", 0, ErrUnexpectedEnd
+		}
+
+		labelLength := int(rawBytes[offset])
+		if (labelLength > LabelMaxLength) {
+			// This is a compressed label.  The pointer consumes 2 bytes,
+			// but no more bytes at this offset.  The remaining label types
+			// are reserved (RFC 6891, 5)
+			if (labelLength & LabelTypeMask != LabelTypePointer) {
+				return "", 0, ErrLabelType
+			}
+			if (offset + 2 > len(rawBytes)) {
+				return "", 0, ErrUnexpectedEnd
+			}
+			if (!compressed) {
+				length += 2 // 2 offset bytes
+			}
+			compressed = true
+
+			// Jump to the new offset and continue unpacking from there
+			pointer := int(binary.BigEndian.Uint16(rawBytes[offset:offset+2]) &
+				CompressionPointerMax)
+			if (pointer > offset) {
+				return "", 0, ErrForwardPointer
+			}
+			if (pointer >= segmentStart) {
+				return "", 0, ErrPointerLoop
+			}
+			offset = pointer
+			segmentStart = pointer
+			continue
+		}
+
+		nameLength += labelLength + 1
+		if (nameLength > DomainNameMaxLength) {
+			return "", 0, ErrNameTooLong
+		}
+
+		if (labelLength == 0) {
+			// Zero-length label.  This is the end of the domain name.
+			if (!compressed) {
+				length++ // Account for the trailing zero-byte
+			}
+			break
+		} else {
+			// Otherwise, this is a normal, inline label.  Not compressed.  Just
+			// read the label directly
+			if (offset + labelLength + 1 > len(rawBytes)) {
+				return "", 0, ErrUnexpectedEnd
+			}
+			label := string(rawBytes[offset+1:offset+labelLength+1])
+			labels = append(labels, label)
+
+			// Continue reading the next label
+			offset += labelLength+1
+			if (!compressed) {
+				length += (labelLength + 1) // Include the length byte
+			}
+		}
+	}
+
+	// Assemble the individual labels into a full, dotted DNS name
+	return strings.Join(labels, "."), length, nil
+}
+
+// Canonical form of a name, for comparisons: lowercase, without the trailing
+// dot, as returned by UnpackName.  The root is the empty name
+func CanonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// Is the name equal to, or somewhere below, the given zone?
+func IsSubdomain(name string, zone string) bool {
+	name = CanonicalName(name)
+	zone = CanonicalName(zone)
+	return (zone == "" || name == zone || strings.HasSuffix(name, "." + zone))
+}
+
+
+//
+// Common Question/Record types + constants.  Most of these are valid for
+// both Question + ResourceRecord types
+//
+const RecordTypeA		= 1 
+const RecordTypeNS		= 2
+const RecordTypeCNAME	= 5
+const RecordTypeSOA		= 6
+const RecordTypePTR		= 12
+const RecordTypeMX		= 15
+const RecordTypeTXT		= 16
+const RecordTypeAAAA	= 28
+const RecordTypeDNAME	= 39 // RFC 6672
+const RecordTypeIXFR	= 251 // RFC 1995
+const RecordTypeAXFR	= 252 // RFC 5936
+const RecordTypeALL		= 255
+
+const RecordClassIN		= 1
+const RecordClassCH		= 3
+const RecordClassHS		= 4
+const RecordClassNONE	= 254 // RFC 2136, for UPDATE
+const RecordClassANY	= 255
+
+var RecordTypeMapToType = map[string]uint16{
+		"A":		RecordTypeA,
+		"NS":		RecordTypeNS,
+		"CNAME":	RecordTypeCNAME,
+		"SOA":		RecordTypeSOA,
+		"PTR":		RecordTypePTR,
+		"MX":		RecordTypeMX,
+		"TXT":		RecordTypeTXT,
+		"AAAA":		RecordTypeAAAA,
+		"DNAME":	RecordTypeDNAME,
+		"DS":		RecordTypeDS,
+		"RRSIG":	RecordTypeRRSIG,
+		"NSEC":		RecordTypeNSEC,
+		"DNSKEY":	RecordTypeDNSKEY,
+		"NSEC3":	RecordTypeNSEC3,
+		"NSEC3PARAM":	RecordTypeNSEC3PARAM,
+		"IXFR":		RecordTypeIXFR,
+		"AXFR":		RecordTypeAXFR,
+		"TSIG":		RecordTypeTSIG,
+		"ALL":		RecordTypeALL,
+	}
+var RecordTypeMapToString = map[uint16]string{}
+
+func init() {
+	for k, v := range RecordTypeMapToType {
+		RecordTypeMapToString[v] = k
+	}
+}
+
+// Mnemonic for the record type, or the generic TYPEnnn form (RFC 3597, 5)
+func RecordTypeString(rtype uint16) string {
+	name, ok := RecordTypeMapToString[rtype]
+	if (!ok) {
+		name = fmt.Sprintf("TYPE%d", int(rtype))
+	}
+	return name
+}
+
+var RecordClassMapToString = map[uint16]string{
+		RecordClassIN:		"IN",
+		RecordClassCH:		"CH",
+		RecordClassHS:		"HS",
+		RecordClassNONE:	"NONE",
+		RecordClassANY:		"ANY",
+	}
+
+// Mnemonic for the class, or the generic CLASSnnn form (RFC 3597, 5)
+func RecordClassString(class uint16) string {
+	name, ok := RecordClassMapToString[class]
+	if (!ok) {
+		name = fmt.Sprintf("CLASS%d", int(class))
+	}
+	return name
+}
+
+//
+// Question section
+//
+type Question struct {
+	Name	string
+	Type	uint16
+	Class	uint16
+}
+const QuestionFixedSize = 4 // Type + Class, excluding the Name
+
+func (question Question) String() string {
+	var qtype string = RecordTypeMapToString[question.Type]
+	if (qtype == "") {
+		qtype = fmt.Sprintf("%d", int(question.Type))
+	}
+
+	return fmt.Sprintf("%s (%s)", question.Name, qtype)
+}
+
+func (question Question) Pack() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := question.packTo(buffer, nil)
+	return buffer.Bytes(), err
+}
+
+func (question Question) packTo(buffer *bytes.Buffer,
+	compression CompressionMap) error {
+	err := packNameTo(buffer, question.Name, compression)
+	if (err != nil) {
+		return err
+	}
+	binary.Write(buffer, binary.BigEndian, question.Type)
+	binary.Write(buffer, binary.BigEndian, question.Class)
+	return nil
+}
+
+func (question *Question) Unpack(rawBytes []byte, offset int) (int, error) {
+	var err error	= nil
+	var length int	= 0
+
+	// Parse the initial Name string, variable-length
+	question.Name, length, err = UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return 0, err
+	}
+
+	// Parse the fixed fields after the Name string
+	if (offset + length + QuestionFixedSize > len(rawBytes)) {
+		return 0, ErrUnexpectedEnd
+	}
+	reader := bytes.NewReader(rawBytes[offset+length:])
+	err = binary.Read(reader, binary.BigEndian, &question.Type)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(question.Type).Size())
+
+	err = binary.Read(reader, binary.BigEndian, &question.Class)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(question.Class).Size())
+
+	return length, err
+}
+
+
+//
+// Resource records (RR)
+//
+
+type ResourceRecord struct {
+	Name		string
+	Type		uint16
+	Class		uint16
+	TTL			int32
+	RDLength	uint16
+	RData		[]byte	// Raw payload, as received
+
+	Data		RData	// Typed payload, see rdata.go
+}
+const ResourceRecordFixedSize = 10 // Type thru RDLength, excluding the Name
+
+
+func (rr ResourceRecord) String() string {
+	var rtype string = RecordTypeMapToString[rr.Type]
+	if (rtype == "") {
+		rtype = fmt.Sprintf("%d", int(rr.Type))
+	}
+
+	// Where possible, show the decoded payload
+	var rdata string
+	if (rr.Data != nil) {
+		rdata = rr.Data.String()
+	} else {
+		rdata = fmt.Sprintf("rdata (%d bytes) % x", rr.RDLength, rr.RData)
+	}
+
+	return fmt.Sprintf("%s (%s), TTL %d: %s",
+		rr.Name, rtype, rr.TTL, rdata)
+}
+
+func (rr ResourceRecord) Pack() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	err := rr.packTo(buffer, nil)
+	return buffer.Bytes(), err
+}
+
+func (rr ResourceRecord) packTo(buffer *bytes.Buffer,
+	compression CompressionMap) error {
+	err := packNameTo(buffer, rr.Name, compression)
+	if (err != nil) {
+		return err
+	}
+	binary.Write(buffer, binary.BigEndian, rr.Type)
+	binary.Write(buffer, binary.BigEndian, rr.Class)
+	binary.Write(buffer, binary.BigEndian, rr.TTL)
+
+	// Reserve space for the RDLENGTH; the actual length is only known after
+	// the payload is packed + possibly compressed
+	lengthOffset := buffer.Len()
+	binary.Write(buffer, binary.BigEndian, uint16(0))
+
+	// Prefer the typed payload, if any, over the raw bytes
+	if (rr.Data != nil) {
+		err = rr.Data.Pack(buffer, compression)
+		if (err != nil) {
+			return fmt.Errorf("%s RDATA: %w", RecordTypeString(rr.Type), err)
+		}
+	} else {
+		buffer.Write(rr.RData)
+	}
+
+	rdlength := buffer.Len() - lengthOffset - 2
+	binary.BigEndian.PutUint16(buffer.Bytes()[lengthOffset:], uint16(rdlength))
+	return nil
+}
+
+func (rr *ResourceRecord) Unpack(rawBytes []byte, offset int) (int, error) {
+	var err error	= nil
+	var length int	= 0
+
+	// Parse the initial Name string, variable-length
+	rr.Name, length, err = UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return 0, err
+	}
+
+	// RR type
+	if (offset + length + ResourceRecordFixedSize > len(rawBytes)) {
+		return 0, ErrUnexpectedEnd
+	}
+	reader := bytes.NewReader(rawBytes[offset+length:])
+	err = binary.Read(reader, binary.BigEndian, &rr.Type)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(rr.Type).Size())
+
+	// RR class
+	err = binary.Read(reader, binary.BigEndian, &rr.Class)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(rr.Class).Size())
+
+	// RR TTL
+	err = binary.Read(reader, binary.BigEndian, &rr.TTL)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(rr.TTL).Size())
+
+	// RR RDLength (payload length)
+	err = binary.Read(reader, binary.BigEndian, &rr.RDLength)
+	if (err != nil) {
+		return 0, err
+	}
+	length += int(reflect.TypeOf(rr.RDLength).Size())
+
+	// RR RDLength (payload), variable-length
+	rdataEnd := offset + length + int(rr.RDLength)
+	if (rdataEnd > len(rawBytes)) {
+		return 0, ErrUnexpectedEnd
+	}
+	rr.RData = make([]byte, rr.RDLength)
+	err = binary.Read(reader, binary.BigEndian, &rr.RData)
+	if (err != nil) {
+		return 0, err
+	}
+
+	// UPDATE prerequisites + deletions may carry no payload at all (RFC 2136,
+	// 2.4 + 2.5), even for types that otherwise require one
+	if (rr.RDLength == 0 &&
+		(rr.Class == RecordClassANY || rr.Class == RecordClassNONE)) {
+		rr.Data = nil
+		return length, nil
+	}
+
+	// Decode the payload according to its type.  The payload may contain
+	// compressed names, so decode it in the context of the entire message.
+	// Hide everything after the payload, though, so that no field within
+	// the payload can extend past RDLENGTH
+	rr.Data = NewRData(rr.Type)
+	err = rr.Data.Unpack(rawBytes[:rdataEnd], offset + length,
+		int(rr.RDLength))
+	if (err == ErrUnexpectedEnd) {
+		err = ErrRDataOverrun
+	}
+	if (err != nil) {
+		return 0, err
+	}
+
+	// Include the payload bytes in the total, regardless of whether they
+	// were unpacked or not
+	length += int(rr.RDLength)
+
+	return length, err
+}
+
+//
+// Composite DNS request/response messages
+//
+type Message struct {
+	Header			MessageHeader
+	Questions		[]Question
+	Answers			[]ResourceRecord
+	Nameservers		[]ResourceRecord
+	AdditionalRR	[]ResourceRecord
+	EDNS			*OPTRecord // Counted in the additional section, if any
+	TSIG			*ResourceRecord // Likewise, and always last; see tsig.go
+}
+
+// Create a new request with a single question, and a random message id.  The
+// caller may adjust the header flags, EDNS, etc before sending
+func NewQuery(name string, rtype uint16) Message {
+	message := Message{}
+	message.Header.Id = uint16(rand.Int31())
+	message.AddQuestion(Question{ name, rtype, RecordClassIN })
+	return message
+}
+
+// Create an empty reply to the request, with the given response code.  The
+// id, opcode, RD flag and question are those of the request.  Extended
+// response codes require EDNS, so the reply carries an OPT record if either
+// the request did, or the response code needs one
+func NewReply(request Message, rcode uint16) Message {
+	message := Message{}
+	message.Header.Id = request.Header.Id
+	message.Header.Flags = MessageHeaderFlagResponse |
+		(request.Header.Flags & (MessageHeaderFlagOpcodeMask |
+		MessageHeaderFlagRecursionDesired)) |
+		(rcode & MessageHeaderFlagResponseCodeMask)
+	for _, question := range request.Questions {
+		message.AddQuestion(question)
+	}
+	if (request.EDNS != nil || rcode > MessageHeaderFlagResponseCodeMask) {
+		message.SetEDNS(OPTRecord{
+			UDPSize:		EDNSDefaultUDPSize,
+			ExtendedRcode:	uint8(rcode >> 4),
+			Version:		EDNSVersion,
+		})
+	}
+	return message
+}
+
+func (message *Message) AddQuestion(question Question) {
+	// Add a new Question to a Request message, mostly useful for coordinating
+	// changes to both the header and payload
+	message.Header.QuestionCount++
+	message.Questions = append(message.Questions, question)
+}
+
+func (message *Message) AddAnswer(rr ResourceRecord) {
+	message.Header.AnswerCount++
+	message.Answers = append(message.Answers, rr)
+}
+
+func (message *Message) AddNameserver(rr ResourceRecord) {
+	message.Header.NameserverCount++
+	message.Nameservers = append(message.Nameservers, rr)
+}
+
+func (message *Message) AddAdditional(rr ResourceRecord) {
+	message.Header.AdditionalCount++
+	message.AdditionalRR = append(message.AdditionalRR, rr)
+}
+
+func (message *Message) SetEDNS(opt OPTRecord) {
+	// Attach an OPT pseudo-record to the message.  At most one OPT record
+	// is allowed per message
+	if (message.EDNS == nil) {
+		message.Header.AdditionalCount++
+	}
+	message.EDNS = &opt
+}
+
+func (message Message) String() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "H:  %s\n", message.Header)
+	for _, q := range message.Questions {
+		fmt.Fprintf(&builder, "Q:  %s\n", q)
+	}
+	for _, a := range message.Answers {
+		fmt.Fprintf(&builder, "A:  %s\n", a)
+	}
+	for _, ns := range message.Nameservers {
+		fmt.Fprintf(&builder, "NS: %s\n", ns)
+	}
+	for _, rr := range message.AdditionalRR {
+		fmt.Fprintf(&builder, "RR: %s\n", rr)
+	}
+	if (message.EDNS != nil) {
+		fmt.Fprintf(&builder, "OPT: %s\n", message.EDNS)
+	}
+	if (message.TSIG != nil) {
+		fmt.Fprintf(&builder, "TSIG: %s\n", message.TSIG)
+	}
+	return builder.String()
+}
+
+// Complete response code, including the extended bits from EDNS, if any
+func (message Message) Rcode() uint16 {
+	rcode := message.Header.Flags & MessageHeaderFlagResponseCodeMask
+	if (message.EDNS != nil) {
+		rcode |= uint16(message.EDNS.ExtendedRcode) << 4
+	}
+	return rcode
+}
+
+func (reply Message) Validate(request Message) error {
+	// Expect the request/response ids to match
+	if (reply.Header.Id != request.Header.Id) {
+		return(ErrIdMismatch)
+	}
+
+	// Expect a response message
+	if (reply.Header.Flags & MessageHeaderFlagResponse == 0) {
+		return(ErrNotResponse)
+	}
+
+	// Truncated message is incomplete.  Let the caller decide whether to
+	// retry over TCP
+	if (reply.Header.Flags & MessageHeaderFlagTruncation != 0) {
+		return(ErrTruncated)
+	}
+
+	// Here, the reply itself appears to be valid.  It may contain an
+	// error message or unexpected RR, etc, but the message itself appears
+	// to be well-formed, etc
+	return(nil)
+}
+
+func (message Message) Pack() ([]byte, error) {
+	// Section counts always reflect the actual section contents
+	header := message.Header
+	header.QuestionCount	= uint16(len(message.Questions))
+	header.AnswerCount		= uint16(len(message.Answers))
+	header.NameserverCount	= uint16(len(message.Nameservers))
+	header.AdditionalCount	= uint16(len(message.AdditionalRR))
+	if (message.EDNS != nil) {
+		header.AdditionalCount++
+	}
+	if (message.TSIG != nil) {
+		header.AdditionalCount++
+	}
+
+	// Pack each section in order, compressing names across all sections
+	buffer := new(bytes.Buffer)
+	compression := CompressionMap{}
+	buffer.Write(header.Pack())
+	for q, question := range message.Questions {
+		err := question.packTo(buffer, compression)
+		if (err != nil) {
+			return nil, fmt.Errorf("Unable to pack question %d: %w", q, err)
+		}
+	}
+	for _, section := range [][]ResourceRecord{
+		message.Answers, message.Nameservers, message.AdditionalRR } {
+		for _, rr := range section {
+			err := rr.packTo(buffer, compression)
+			if (err != nil) {
+				return nil, fmt.Errorf("Unable to pack RR %s: %w", rr.Name,
+					err)
+			}
+		}
+	}
+	if (message.EDNS != nil) {
+		buffer.Write(message.EDNS.Pack())
+	}
+
+	// The TSIG must follow everything else, without compression, since its
+	// MAC covers the rest of the message
+	if (message.TSIG != nil) {
+		err := message.TSIG.packTo(buffer, nil)
+		if (err != nil) {
+			return nil, fmt.Errorf("Unable to pack TSIG: %w", err)
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (message *Message) Unpack(rawBytes []byte) (int, error) {
+	var err error	= nil
+	var length int	= 0
+	*message		= Message{}
+
+	// Message header is always present
+	length, err = message.Header.Unpack(rawBytes, 0)
+	if (err != nil) {
+		return 0, fmt.Errorf("Unable to unpack header: %w", err)
+	}
+
+	// Parse the Questions, if any
+	for q := 0; q < int(message.Header.QuestionCount); q++ {
+		var question Question
+		questionLength, err := question.Unpack(rawBytes, length)
+		if (err != nil) {
+			return 0, fmt.Errorf("Unable to unpack question %d: %w", q, err)
+		}
+		message.Questions = append(message.Questions, question)
+
+		// Continue parsing the next Question, if any
+		length += questionLength
+	}
+
+	// Helper function for unmarshalling the different RR sections.  Just
+	// collect all of the RR's embedded in an individual section
+	unpackRRSection := func(section string, count int) ([]ResourceRecord, error) {
+		var records = []ResourceRecord{}
+
+		for i := 0; i < count; i++ {
+			// Attempt to parse the next RR in this section
+			var rr ResourceRecord
+			rrLength, err := rr.Unpack(rawBytes, length)
+			if (err != nil) {
+				return records, fmt.Errorf("Unable to unpack %s RR %d: %w",
+					section, i, err)
+			}
+
+			// Collect all of the RR's in this section
+			records = append(records, rr)
+
+			// Resume parsing at the next RR, if any
+			length += rrLength
+		}
+
+		return records, nil
+	}
+
+	// Parse the Answers, if any
+	message.Answers, err = unpackRRSection("answer",
+		int(message.Header.AnswerCount))
+	if (err != nil) {
+		return 0, err
+	}
+
+	// Parse the Nameservers, if any
+	message.Nameservers, err = unpackRRSection("nameserver",
+		int(message.Header.NameserverCount))
+	if (err != nil) {
+		return 0, err
+	}
+
+	// Parse the related RR's, if any
+	message.AdditionalRR, err = unpackRRSection("additional",
+		int(message.Header.AdditionalCount))
+	if (err != nil) {
+		return 0, err
+	}
+
+	err = message.extractPseudoRecords()
+	if (err != nil) {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// Extract the OPT + TSIG pseudo-records, if any, from the additional
+// section.  Only the last record may be a TSIG
+func (message *Message) extractPseudoRecords() error {
+	var additional = []ResourceRecord{}
+	for i, rr := range message.AdditionalRR {
+		if (rr.Type == RecordTypeTSIG) {
+			if (i != len(message.AdditionalRR) - 1) {
+				return ErrMisplacedTSIG
+			}
+			message.TSIG = &rr
+			continue
+		}
+		if (rr.Type != RecordTypeOPT) {
+			additional = append(additional, rr)
+			continue
+		}
+		if (message.EDNS != nil) {
+			return ErrMultipleOPT
+		}
+
+		var opt OPTRecord
+		err := opt.fromResourceRecord(rr)
+		if (err != nil) {
+			return err
+		}
+		message.EDNS = &opt
+	}
+	message.AdditionalRR = additional
+
+	return nil
+}
@@ -0,0 +1,549 @@
+package dns
+
+import(
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	)
+
+// Packed message, RR or question, which must pack
+func mustPack(t testing.TB, value interface{ Pack() ([]byte, error) }) []byte {
+	t.Helper()
+	packed, err := value.Pack()
+	if (err != nil) {
+		t.Fatal("Packing error: ", err)
+	}
+	return packed
+}
+
+//
+// Validate header packing + unpacking
+//
+func TestMessageHeaderPacking(t *testing.T) {
+	// Pack a trivial header into bytes
+	header1 := MessageHeader{0,1,2,3,4,5}
+	rawBytes := header1.Pack()
+	if len(rawBytes) != MessageHeaderSize {
+		t.Error("Packed header size is ", len(rawBytes))
+	}
+
+	// Unpack the bytes back into a new header and compare to the original.
+	// Expect the two headers to be identical
+	header2 := MessageHeader{}
+	_, err := header2.Unpack(rawBytes, 0)
+	if (err != nil) {
+		t.Error("Unpacking error: ", err)
+	}
+	if (header1.Id != header2.Id) {
+		t.Error("Id mismatch")
+	}
+	if (header1.Flags != header2.Flags) {
+		t.Error("Flags mismatch", header1.Flags, header2.Flags)
+	}
+	if (header1.QuestionCount != header2.QuestionCount) {
+		t.Error("QuestionCount mismatch", header1.QuestionCount, header2.QuestionCount)
+	}
+	if (header1.AnswerCount != header2.AnswerCount) {
+		t.Error("AnswerCount mismatch", header1.AnswerCount, header2.AnswerCount)
+	}
+}
+
+
+//
+// Validate full message packing
+//
+func TestMessagePacking(t *testing.T) {
+	// Pack a trivial message into bytes
+	message1 := Message{}
+	message1.Header.Id = 0xFEFF
+	message1.Header.Flags |= MessageHeaderFlagRecursionDesired
+	message1.AddQuestion( Question{ "a.com", 0, 0 } )
+	rawBytes := mustPack(t, message1)
+
+	// Unpack the bytes back into a new message and revalidate
+	message2 := Message{}
+	_, err := message2.Unpack(rawBytes)
+	if (err != nil) {
+		t.Error("Unpacking error: ", err)
+	}
+	if (message1.Header.Id != message2.Header.Id) {
+		t.Error("Id mismatch")
+	}
+	if (message1.Questions[0].Name != message2.Questions[0].Name) {
+		t.Error("Name mismatch")
+	}
+
+	friendlyText := fmt.Sprintf("%s", message2)
+	if (len(friendlyText) < 8) {
+		t.Error("String conversion is probably wrong: ", friendlyText)
+	}
+}
+
+
+//
+// Validate unpacking/parsing of compressed names
+//
+func TestNameCompression(t *testing.T) {
+	rawBytes := []byte{ 5, 'l', 'a', 'b', 'e', 'l',
+						3, 'c', 'o', 'm',
+						0,
+						10, 'c', 'o', 'm', 'p', 'r', 'e', 's', 's', 'e', 'd',
+						0xC0, 0 }
+
+	unpackedName, length, err := UnpackName(rawBytes, 11)
+	if (err != nil) {
+		t.Error("Unpacking error: ", err)
+	}
+	if unpackedName != "compressed.label.com" {
+		t.Error("Unexpected unpacked name: ", unpackedName)
+	}
+	if length != (1+10+2) {
+		t.Error("Unexpected length: ", length)
+	}
+}
+
+
+//
+// Validate composite domain-name packing/unpacking
+//
+func TestNamePacking(t *testing.T) {
+	testCases := []struct{
+		name			string
+		unpackedName	string
+		packedName		[]byte
+	}{
+		{ "a",      "a.com",      []byte{ 1, 'a', 3, 'c', 'o', 'm', 0 } },
+		{ "amazon", "amazon.com", []byte{ 6, 'a', 'm', 'a', 'z', 'o', 'n', 3, 'c', 'o', 'm', 0 } },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			// Pack and verify the domain name
+			packedName, err := PackName(test.unpackedName)
+			if (err != nil || !bytes.Equal(packedName, test.packedName)) {
+				t.Error("Unexpected packed name: ", packedName, test.packedName)
+			}
+
+			// Unpack the labels and revalidate
+			unpackedName, _, err := UnpackName(packedName, 0)
+			if (err != nil) {
+				t.Error("Unpacking error: ", err)
+			}
+			if unpackedName != test.unpackedName {
+				t.Error("Unexpected unpacked name: ", unpackedName,
+					test.unpackedName)
+			}
+		})
+	}
+
+}
+
+
+//
+// Validate the rejection of names that do not fit the wire format, whether
+// alone or anywhere in a message
+//
+func TestNamePackingErrors(t *testing.T) {
+	label := strings.Repeat("a", LabelMaxLength)
+	testCases := []struct{
+		name	string
+		err		error
+	}{
+		{ "a..com",										ErrEmptyLabel },
+		{ ".com",										ErrEmptyLabel },
+		{ label + "a.com",								ErrLabelTooLong },
+		{ strings.Repeat(label + ".", 4) + "com",		ErrNameTooLong },
+	}
+
+	for _, test := range testCases {
+		_, err := PackName(test.name)
+		if (!errors.Is(err, test.err)) {
+			t.Errorf("%s: expected %v: %v", test.name, test.err, err)
+		}
+
+		message := NewQuery("example.com", RecordTypeCNAME)
+		message.AddAnswer(ResourceRecord{ Name: "example.com",
+			Type: RecordTypeCNAME, Class: RecordClassIN,
+			Data: &RDataCNAME{ test.name } })
+		_, err = message.Pack()
+		if (!errors.Is(err, test.err)) {
+			t.Errorf("%s: expected %v from the message: %v", test.name,
+				test.err, err)
+		}
+	}
+
+	// The longest name that fits: 255 bytes, with the lengths
+	longest := strings.Repeat(label + ".", 3) + strings.Repeat("a", 61)
+	packed, err := PackName(longest)
+	if (err != nil || len(packed) != DomainNameMaxLength) {
+		t.Error("Unexpected packed name: ", len(packed), err)
+	}
+}
+
+
+//
+// Validate RR packing + unpacking
+//
+func TestResourceRecordPacking(t *testing.T) {
+	rr1 := ResourceRecord{
+		"abcdefghijklmnopqrstuvwxyz.com",
+		0,
+		0,
+		128,
+		4,
+		[]byte("1234"),
+		nil,
+	}
+
+	packedRR := mustPack(t, rr1)
+	expectedLength := (1+26+1+3+1) + 2 + 2 + 4 + 2 + 4
+	if (len(packedRR) != expectedLength) {
+		t.Error("Unexpected packed RR length: ", len(packedRR))
+	}
+
+	// Unpack the bytes back into a new RR and compare to the original.
+	// Expect the two RR to be identical
+	rr2 := ResourceRecord{}
+	unpackedLength, err := rr2.Unpack(packedRR, 0)
+	if err != nil {
+		t.Error("Unpacking error: ", err)
+	}
+	if (unpackedLength != expectedLength) {
+		t.Error("Length mismatch during unpacking: ", unpackedLength)
+	}
+	if (rr1.Name != rr2.Name) {
+		t.Error("Name mismatch")
+	}
+	if (rr1.Type != rr2.Type) {
+		t.Error("Type mismatch")
+	}
+	if (rr1.Class != rr2.Class) {
+		t.Error("Class mismatch")
+	}
+	if (rr1.TTL != rr2.TTL) {
+		t.Error("TTL mismatch")
+	}
+	if (rr1.RDLength != rr2.RDLength) {
+		t.Error("TTL mismatch")
+	}
+	if !bytes.Equal(rr1.RData, rr2.RData) {
+		t.Error("Unexpected packed data: ", rr1.RData, rr2.RData)
+	}
+}
+
+//
+// Validate Question packing + unpacking
+//
+func TestQuestionPacking(t *testing.T) {
+	question1 := Question{ "abcdefghijklmnopqrstuvwxyz.com", 0, 0 }
+
+	packedQuestion := mustPack(t, question1)
+	expectedLength := (1+26+1+3+1) + 2 + 2
+	if (len(packedQuestion) != expectedLength) {
+		t.Error("Unexpected packed question length: ", len(packedQuestion))
+	}
+
+	// Unpack the bytes back into a new Question and compare to the original.
+	// Expect the two Questions to be identical
+	question2 := Question{}
+	unpackedLength, err := question2.Unpack(packedQuestion, 0)
+	if err != nil {
+		t.Error("Unpacking error: ", err)
+	}
+	if (unpackedLength != expectedLength) {
+		t.Error("Length mismatch during unpacking: ", unpackedLength)
+	}
+	if (question1.Name != question2.Name) {
+		t.Error("Name mismatch")
+	}
+	if (question1.Type != question2.Type) {
+		t.Error("Type mismatch", question1.Type, question2.Type)
+	}
+	if (question1.Class != question2.Class) {
+		t.Error("Class mismatch")
+	}
+}
+
+
+//
+// Validate truncation handling in reply validation
+//
+func TestTruncatedReply(t *testing.T) {
+	request := Message{}
+	request.Header.Id = 0x1234
+	reply := request
+	reply.Header.Flags |= MessageHeaderFlagResponse
+	if (reply.Validate(request) != nil) {
+		t.Error("Unexpected validation error")
+	}
+
+	reply.Header.Flags |= MessageHeaderFlagTruncation
+	if (reply.Validate(request) != ErrTruncated) {
+		t.Error("Expected truncation error")
+	}
+}
+
+
+//
+// Validate the id, flags, question + EDNS of new replies
+//
+func TestNewReply(t *testing.T) {
+	request := NewQuery("a.com", RecordTypeMX)
+	request.Header.Flags |= MessageHeaderFlagRecursionDesired
+	reply := NewReply(request, RcodeNameError)
+	if (reply.Validate(request) != nil || reply.Rcode() != RcodeNameError ||
+		reply.Header.Flags & MessageHeaderFlagRecursionDesired == 0 ||
+		reply.Header.QuestionCount != 1 || reply.Questions[0] != request.Questions[0] ||
+		reply.EDNS != nil) {
+		t.Error("Unexpected reply: ", reply)
+	}
+
+	reply = NewReply(request, RcodeBadVersion)
+	if (reply.Rcode() != RcodeBadVersion || reply.Header.AdditionalCount != 1) {
+		t.Error("Unexpected extended reply: ", reply)
+	}
+	unpacked := Message{}
+	_, err := unpacked.Unpack(mustPack(t, reply))
+	if (err != nil || unpacked.Rcode() != RcodeBadVersion) {
+		t.Error("Unexpected unpacked reply: ", unpacked, err)
+	}
+}
+
+
+//
+// Validate packing + unpacking of all message sections, with compression
+//
+func TestMessageSectionPacking(t *testing.T) {
+	newRR := func(name string, rtype uint16, data RData) ResourceRecord {
+		return ResourceRecord{
+			Name:	name,
+			Type:	rtype,
+			Class:	RecordClassIN,
+			TTL:	300,
+			Data:	data,
+		}
+	}
+
+	message1 := Message{}
+	message1.Header.Id = 0x4242
+	message1.Header.Flags |= MessageHeaderFlagResponse
+	message1.AddQuestion( Question{ "www.example.com", RecordTypeA, RecordClassIN } )
+	message1.AddAnswer(newRR("www.example.com", RecordTypeCNAME,
+		&RDataCNAME{ "web.example.com" }))
+	message1.AddAnswer(newRR("web.example.com", RecordTypeA,
+		&RDataA{ net.ParseIP("192.0.2.1").To4() }))
+	message1.AddNameserver(newRR("example.com", RecordTypeNS,
+		&RDataNS{ "ns1.example.com" }))
+	message1.AddNameserver(newRR("EXAMPLE.com", RecordTypeSOA,
+		&RDataSOA{ "ns1.example.com", "admin.example.com", 1, 2, 3, 4, 5 }))
+	message1.AddAdditional(newRR("ns1.example.com", RecordTypeA,
+		&RDataA{ net.ParseIP("192.0.2.53").To4() }))
+	message1.AddAdditional(newRR("example.com", RecordTypeMX,
+		&RDataMX{ 10, "mail.example.net" }))
+	message1.SetEDNS(OPTRecord{ UDPSize: EDNSDefaultUDPSize })
+
+	// Expect compression to shrink the message, relative to the sum of the
+	// individually-packed sections
+	rawBytes := mustPack(t, message1)
+	uncompressedLength := MessageHeaderSize +
+		len(mustPack(t, message1.Questions[0])) +
+		len(message1.EDNS.Pack())
+	for _, section := range [][]ResourceRecord{
+		message1.Answers, message1.Nameservers, message1.AdditionalRR } {
+		for _, rr := range section {
+			uncompressedLength += len(mustPack(t, rr))
+		}
+	}
+	if (len(rawBytes) >= uncompressedLength) {
+		t.Error("Expected compression: ", len(rawBytes), uncompressedLength)
+	}
+
+	// Unpack the bytes back into a new message and revalidate each section
+	message2 := Message{}
+	length, err := message2.Unpack(rawBytes)
+	if (err != nil) {
+		t.Fatal("Unpacking error: ", err)
+	}
+	if (length != len(rawBytes)) {
+		t.Error("Length mismatch during unpacking: ", length)
+	}
+	if (message1.Header != message2.Header) {
+		t.Error("Header mismatch: ", message1.Header, message2.Header)
+	}
+	if (message2.EDNS == nil) {
+		t.Error("Missing OPT record")
+	}
+
+	compareSection := func(name string, rrs1 []ResourceRecord,
+		rrs2 []ResourceRecord) {
+		if (len(rrs1) != len(rrs2)) {
+			t.Fatal(name, " count mismatch: ", len(rrs1), len(rrs2))
+		}
+		for i := range rrs1 {
+			// Names are case-insensitive, so compression may alter the case
+			if (!strings.EqualFold(rrs1[i].Name, rrs2[i].Name) ||
+				rrs1[i].Type != rrs2[i].Type ||
+				rrs1[i].TTL != rrs2[i].TTL ||
+				!reflect.DeepEqual(rrs1[i].Data, rrs2[i].Data)) {
+				t.Error(name, " mismatch: ", rrs1[i], rrs2[i])
+			}
+		}
+	}
+	compareSection("Answer", message1.Answers, message2.Answers)
+	compareSection("Nameserver", message1.Nameservers, message2.Nameservers)
+	compareSection("Additional", message1.AdditionalRR, message2.AdditionalRR)
+}
+
+
+//
+// Validate the compressed encoding of repeated names
+//
+func TestNameCompressionPacking(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	compression := CompressionMap{}
+	packNameTo(buffer, "label.com", compression)
+	packNameTo(buffer, "compressed.LABEL.com", compression)
+
+	expected := []byte{ 5, 'l', 'a', 'b', 'e', 'l',
+						3, 'c', 'o', 'm',
+						0,
+						10, 'c', 'o', 'm', 'p', 'r', 'e', 's', 's', 'e', 'd',
+						0xC0, 0 }
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Error("Unexpected compressed names: ", buffer.Bytes())
+	}
+}
+
+
+//
+// Validate rejection of malformed domain names
+//
+func TestMalformedNames(t *testing.T) {
+	longName := []byte{}
+	for i := 0; i < 5; i++ {
+		longName = append(longName, LabelMaxLength)
+		longName = append(longName, bytes.Repeat([]byte{'a'}, LabelMaxLength)...)
+	}
+	longName = append(longName, 0)
+
+	testCases := []struct{
+		name		string
+		rawBytes	[]byte
+		offset		int
+		err			error
+	}{
+		{ "empty",			[]byte{},								0, ErrUnexpectedEnd },
+		{ "truncated",		[]byte{ 5, 'a', 'b' },					0, ErrUnexpectedEnd },
+		{ "unterminated",	[]byte{ 1, 'a' },						0, ErrUnexpectedEnd },
+		{ "half-pointer",	[]byte{ 1, 'a', 0xC0 },					0, ErrUnexpectedEnd },
+		{ "self-pointer",	[]byte{ 0xC0, 0 },						0, ErrPointerLoop },
+		{ "loop",			[]byte{ 1, 'a', 0xC0, 0 },				0, ErrPointerLoop },
+		{ "forward",		[]byte{ 0xC0, 2, 0 },					0, ErrForwardPointer },
+		{ "label-type",		[]byte{ 0x40, 0 },						0, ErrLabelType },
+		{ "too-long",		longName,								0, ErrNameTooLong },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := UnpackName(test.rawBytes, test.offset)
+			if (err != test.err) {
+				t.Error("Unexpected error: ", err, test.err)
+			}
+		})
+	}
+}
+
+
+//
+// Validate name comparisons
+//
+func TestSubdomain(t *testing.T) {
+	testCases := []struct{
+		name		string
+		zone		string
+		subdomain	bool
+	}{
+		{ "www.example.com",	"example.com",	true },
+		{ "WWW.Example.COM.",	"example.com.",	true },
+		{ "example.com",		"example.com",	true },
+		{ "example.com",		"",				true },
+		{ "example.com",		".",			true },
+		{ "badexample.com",		"example.com",	false },
+		{ "example.com",		"www.example.com", false },
+		{ "",					"com",			false },
+	}
+
+	for _, test := range testCases {
+		if (IsSubdomain(test.name, test.zone) != test.subdomain) {
+			t.Errorf("Unexpected result for %s in %s", test.name, test.zone)
+		}
+	}
+	if (CanonicalName("Example.COM.") != "example.com" || CanonicalName(".") != "") {
+		t.Error("Unexpected canonical names")
+	}
+}
+
+
+//
+// Validate rejection of RDATA fields that extend past RDLENGTH
+//
+func TestRDataOverrun(t *testing.T) {
+	rr := ResourceRecord{
+		Name:	"example.com",
+		Type:	RecordTypeCNAME,
+		Class:	RecordClassIN,
+		RData:	[]byte{ 3, 'a', 'b', 'c' }, // No terminating label
+	}
+	rawBytes := append(mustPack(t, rr), 0)
+
+	_, err := rr.Unpack(rawBytes, 0)
+	if (err != ErrRDataOverrun) {
+		t.Error("Expected RDATA overrun: ", err)
+	}
+}
+
+
+//
+// Fuzz the message parser.  Arbitrary input must never panic or hang; any
+// error must be reported via the return value instead
+//
+func FuzzUnpackMessage(f *testing.F) {
+	query := Message{}
+	query.AddQuestion( Question{ "www.example.com", RecordTypeA, RecordClassIN } )
+	query.SetEDNS(OPTRecord{ UDPSize: EDNSDefaultUDPSize })
+	f.Add(mustPack(f, query))
+
+	reply := query
+	reply.Header.Flags |= MessageHeaderFlagResponse
+	reply.AddAnswer(ResourceRecord{ Name: "www.example.com",
+		Type: RecordTypeCNAME, Class: RecordClassIN,
+		Data: &RDataCNAME{ "web.example.com" } })
+	reply.AddAnswer(ResourceRecord{ Name: "web.example.com",
+		Type: RecordTypeTXT, Class: RecordClassIN,
+		Data: &RDataTXT{ []string{ "hello", "world" } } })
+	reply.AddNameserver(ResourceRecord{ Name: "example.com",
+		Type: RecordTypeSOA, Class: RecordClassIN,
+		Data: &RDataSOA{ "ns.example.com", "admin.example.com", 1, 2, 3, 4, 5 } })
+	reply.AddAdditional(ResourceRecord{ Name: "example.com",
+		Type: RecordTypeMX, Class: RecordClassIN,
+		Data: &RDataMX{ 10, "mail.example.com" } })
+	f.Add(mustPack(f, reply))
+
+	f.Add([]byte{ 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12 })
+
+	f.Fuzz(func(t *testing.T, rawBytes []byte) {
+		message := Message{}
+		length, err := message.Unpack(rawBytes)
+		if (err != nil) {
+			return
+		}
+		if (length > len(rawBytes)) {
+			t.Error("Unpacked length exceeds input: ", length)
+		}
+		_ = message.String()
+	})
+}
@@ -0,0 +1,627 @@
+//
+// DNSSEC resource record types, as defined by RFC 4034 + RFC 5155: the
+// DNSKEY + DS records that form the chain of trust, the RRSIG signatures
+// over each RRset, and the NSEC + NSEC3 records that prove non-existence.
+// Validation itself lives elsewhere; these are just the payloads.
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+
+const RecordTypeDS			= 43
+const RecordTypeRRSIG		= 46
+const RecordTypeNSEC		= 47
+const RecordTypeDNSKEY		= 48
+const RecordTypeNSEC3		= 50 // RFC 5155
+const RecordTypeNSEC3PARAM	= 51
+
+// DNSKEY flags
+const DNSKEYFlagZone		= 0x0100
+const DNSKEYFlagRevoke		= 0x0080 // RFC 5011
+const DNSKEYFlagSEP			= 0x0001 // Secure entry point, i.e. a KSK
+const DNSKEYProtocol		= 3
+
+// Security algorithms, as far as they are relevant here (RFC 8624)
+const AlgorithmRSAMD5			= 1
+const AlgorithmRSASHA256		= 8
+const AlgorithmECDSAP256SHA256	= 13
+const AlgorithmECDSAP384SHA384	= 14
+const AlgorithmED25519			= 15
+
+// DS digest types
+const DigestSHA1			= 1
+const DigestSHA256			= 2
+const DigestSHA384			= 4
+
+// NSEC3 hash algorithm + flags
+const NSEC3HashSHA1			= 1
+const NSEC3FlagOptOut		= 0x01
+
+// Signature times are seconds since the epoch, as YYYYMMDDHHmmSS in UTC
+const signatureTimeFormat	= "20060102150405"
+
+var base32Hex = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+
+//
+// DNSKEY, a public key of the zone
+//
+type RDataDNSKEY struct {
+	Flags		uint16
+	Protocol	uint8
+	Algorithm	uint8
+	PublicKey	[]byte
+}
+const RDataDNSKEYFixedSize = 4
+
+func (rdata *RDataDNSKEY) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	binary.Write(buffer, binary.BigEndian, rdata.Flags)
+	buffer.WriteByte(rdata.Protocol)
+	buffer.WriteByte(rdata.Algorithm)
+	buffer.Write(rdata.PublicKey)
+	return nil
+}
+
+func (rdata *RDataDNSKEY) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length < RDataDNSKEYFixedSize) {
+		return ErrRDataLength
+	}
+	rdata.Flags		= binary.BigEndian.Uint16(rawBytes[offset:])
+	rdata.Protocol	= rawBytes[offset + 2]
+	rdata.Algorithm	= rawBytes[offset + 3]
+	rdata.PublicKey	= append([]byte{},
+		rawBytes[offset + RDataDNSKEYFixedSize:offset + length]...)
+	return nil
+}
+
+// The key tag follows as a comment, as it is not part of the RDATA
+func (rdata *RDataDNSKEY) String() string {
+	role := "ZSK"
+	if (rdata.Flags & DNSKEYFlagSEP != 0) {
+		role = "KSK"
+	}
+	return fmt.Sprintf("%s ; %s, key tag %d", rdata.presentation(), role,
+		rdata.KeyTag())
+}
+
+// The RDATA alone, without the comment (see commentedRData)
+func (rdata *RDataDNSKEY) presentation() string {
+	return fmt.Sprintf("%d %d %d %s", rdata.Flags, rdata.Protocol,
+		rdata.Algorithm, base64.StdEncoding.EncodeToString(rdata.PublicKey))
+}
+
+func (rdata *RDataDNSKEY) Parse(fields []string, origin string) error {
+	if (len(fields) < 4) {
+		return ErrRDataSyntax
+	}
+	values, err := parseRDataUints(fields[:3], 16, 8, 8)
+	if (err != nil) {
+		return err
+	}
+	rdata.Flags		= uint16(values[0])
+	rdata.Protocol	= uint8(values[1])
+	rdata.Algorithm	= uint8(values[2])
+	rdata.PublicKey, err = parseBase64(fields[3:])
+	return err
+}
+
+// Key tag, which identifies the key within RRSIG + DS records, per RFC 4034,
+// Appendix B.  Not necessarily unique
+func (rdata *RDataDNSKEY) KeyTag() uint16 {
+	buffer := new(bytes.Buffer)
+	rdata.Pack(buffer, nil)
+	wire := buffer.Bytes()
+
+	// The original RSA/MD5 algorithm uses the low bits of the modulus
+	if (rdata.Algorithm == AlgorithmRSAMD5) {
+		if (len(wire) < 3) {
+			return 0
+		}
+		return binary.BigEndian.Uint16(wire[len(wire) - 3:])
+	}
+
+	var accumulator uint32
+	for i, b := range wire {
+		if (i & 1 == 0) {
+			accumulator += uint32(b) << 8
+		} else {
+			accumulator += uint32(b)
+		}
+	}
+	accumulator += (accumulator >> 16) & 0xFFFF
+	return uint16(accumulator)
+}
+
+
+//
+// DS, delegation signer: the digest of a DNSKEY of the child zone, as
+// published in the parent zone
+//
+type RDataDS struct {
+	KeyTag		uint16
+	Algorithm	uint8
+	DigestType	uint8
+	Digest		[]byte
+}
+const RDataDSFixedSize = 4
+
+func (rdata *RDataDS) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	binary.Write(buffer, binary.BigEndian, rdata.KeyTag)
+	buffer.WriteByte(rdata.Algorithm)
+	buffer.WriteByte(rdata.DigestType)
+	buffer.Write(rdata.Digest)
+	return nil
+}
+
+func (rdata *RDataDS) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length < RDataDSFixedSize) {
+		return ErrRDataLength
+	}
+	rdata.KeyTag		= binary.BigEndian.Uint16(rawBytes[offset:])
+	rdata.Algorithm		= rawBytes[offset + 2]
+	rdata.DigestType	= rawBytes[offset + 3]
+	rdata.Digest		= append([]byte{},
+		rawBytes[offset + RDataDSFixedSize:offset + length]...)
+	return nil
+}
+
+func (rdata *RDataDS) String() string {
+	return fmt.Sprintf("%d %d %d %X", rdata.KeyTag, rdata.Algorithm,
+		rdata.DigestType, rdata.Digest)
+}
+
+func (rdata *RDataDS) Parse(fields []string, origin string) error {
+	if (len(fields) < 4) {
+		return ErrRDataSyntax
+	}
+	values, err := parseRDataUints(fields[:3], 16, 8, 8)
+	if (err != nil) {
+		return err
+	}
+	rdata.KeyTag		= uint16(values[0])
+	rdata.Algorithm		= uint8(values[1])
+	rdata.DigestType	= uint8(values[2])
+	rdata.Digest, err = hex.DecodeString(strings.Join(fields[3:], ""))
+	if (err != nil) {
+		return fmt.Errorf("%w: digest is not hex", ErrRDataSyntax)
+	}
+	return nil
+}
+
+
+//
+// RRSIG, the signature over a single RRset.  The signer name is never
+// compressed (RFC 4034, 3.1.7)
+//
+type RRSIGHeader struct {
+	TypeCovered		uint16
+	Algorithm		uint8
+	Labels			uint8
+	OriginalTTL		uint32
+	Expiration		uint32
+	Inception		uint32
+	KeyTag			uint16
+}
+const RRSIGHeaderSize = 18
+
+type RDataRRSIG struct {
+	RRSIGHeader
+	SignerName		string
+	Signature		[]byte
+}
+
+func (rdata *RDataRRSIG) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	binary.Write(buffer, binary.BigEndian, rdata.RRSIGHeader)
+	err := packNameTo(buffer, rdata.SignerName, nil)
+	if (err != nil) {
+		return err
+	}
+	buffer.Write(rdata.Signature)
+	return nil
+}
+
+func (rdata *RDataRRSIG) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length < RRSIGHeaderSize) {
+		return ErrRDataLength
+	}
+	reader := bytes.NewReader(rawBytes[offset:offset + RRSIGHeaderSize])
+	binary.Read(reader, binary.BigEndian, &rdata.RRSIGHeader)
+
+	var err error
+	var nameLength int
+	rdata.SignerName, nameLength, err = UnpackName(rawBytes,
+		offset + RRSIGHeaderSize)
+	if (err != nil) {
+		return err
+	}
+	start := offset + RRSIGHeaderSize + nameLength
+	if (start > offset + length) {
+		return ErrRDataLength
+	}
+	rdata.Signature = append([]byte{}, rawBytes[start:offset + length]...)
+	return nil
+}
+
+func (rdata *RDataRRSIG) String() string {
+	return fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+		RecordTypeString(rdata.TypeCovered), rdata.Algorithm, rdata.Labels,
+		rdata.OriginalTTL, SignatureTimeString(rdata.Expiration),
+		SignatureTimeString(rdata.Inception), rdata.KeyTag,
+		nameString(rdata.SignerName),
+		base64.StdEncoding.EncodeToString(rdata.Signature))
+}
+
+func (rdata *RDataRRSIG) Parse(fields []string, origin string) error {
+	if (len(fields) < 9) {
+		return ErrRDataSyntax
+	}
+	rdata.TypeCovered = parseType(strings.ToUpper(fields[0]))
+	if (rdata.TypeCovered == 0) {
+		return fmt.Errorf("%w: type %s", ErrRDataSyntax, fields[0])
+	}
+	values, err := parseRDataUints(fields[1:4], 8, 8, 32)
+	if (err != nil) {
+		return err
+	}
+	rdata.Algorithm		= uint8(values[0])
+	rdata.Labels		= uint8(values[1])
+	rdata.OriginalTTL	= uint32(values[2])
+
+	rdata.Expiration, err = ParseSignatureTime(fields[4])
+	if (err != nil) {
+		return err
+	}
+	rdata.Inception, err = ParseSignatureTime(fields[5])
+	if (err != nil) {
+		return err
+	}
+	keyTag, err := parseRDataUint(fields[6], 16)
+	if (err != nil) {
+		return err
+	}
+	rdata.KeyTag		= uint16(keyTag)
+	rdata.SignerName	= AbsoluteName(fields[7], origin)
+	rdata.Signature, err = parseBase64(fields[8:])
+	return err
+}
+
+func SignatureTimeString(value uint32) string {
+	return time.Unix(int64(value), 0).UTC().Format(signatureTimeFormat)
+}
+
+// Signature time, as either YYYYMMDDHHmmSS or seconds since the epoch
+// (RFC 4034, 3.2)
+func ParseSignatureTime(field string) (uint32, error) {
+	if (len(field) == len(signatureTimeFormat)) {
+		parsed, err := time.Parse(signatureTimeFormat, field)
+		if (err != nil) {
+			return 0, fmt.Errorf("%w: time %s", ErrRDataSyntax, field)
+		}
+		return uint32(parsed.Unix()), nil
+	}
+	value, err := parseRDataUint(field, 32)
+	return uint32(value), err
+}
+
+
+//
+// NSEC, the next owner name in the zone, in canonical order, and the types
+// present at this one.  The next name is never compressed
+//
+type RDataNSEC struct {
+	NextDomain	string
+	Types		[]uint16	// Ascending
+}
+
+func (rdata *RDataNSEC) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	err := packNameTo(buffer, rdata.NextDomain, nil)
+	if (err != nil) {
+		return err
+	}
+	packTypeBitmap(buffer, rdata.Types)
+	return nil
+}
+
+func (rdata *RDataNSEC) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	var nameLength int
+	rdata.NextDomain, nameLength, err = UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return err
+	}
+	if (nameLength > length) {
+		return ErrRDataLength
+	}
+	rdata.Types, err = unpackTypeBitmap(
+		rawBytes[offset + nameLength:offset + length])
+	return err
+}
+
+func (rdata *RDataNSEC) String() string {
+	return strings.TrimSpace(nameString(rdata.NextDomain) + " " +
+		typeBitmapString(rdata.Types))
+}
+
+func (rdata *RDataNSEC) Parse(fields []string, origin string) error {
+	if (len(fields) < 1) {
+		return ErrRDataSyntax
+	}
+	var err error
+	rdata.NextDomain = AbsoluteName(fields[0], origin)
+	rdata.Types, err = parseTypeBitmap(fields[1:])
+	return err
+}
+
+
+//
+// NSEC3, the next hashed owner name in the zone, and the types present at
+// this one.  The hashes are base32hex in presentation format
+//
+type NSEC3Params struct {
+	HashAlgorithm	uint8
+	Flags			uint8
+	Iterations		uint16
+	Salt			[]byte
+}
+
+type RDataNSEC3 struct {
+	NSEC3Params
+	NextHashed		[]byte
+	Types			[]uint16	// Ascending
+}
+
+func (rdata *RDataNSEC3) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	rdata.NSEC3Params.pack(buffer)
+	buffer.WriteByte(byte(len(rdata.NextHashed)))
+	buffer.Write(rdata.NextHashed)
+	packTypeBitmap(buffer, rdata.Types)
+	return nil
+}
+
+func (rdata *RDataNSEC3) Unpack(rawBytes []byte, offset int, length int) error {
+	payload := rawBytes[offset:offset + length]
+	paramsLength, err := rdata.NSEC3Params.unpack(payload)
+	if (err != nil) {
+		return err
+	}
+	payload = payload[paramsLength:]
+	if (len(payload) < 1 || len(payload) < 1 + int(payload[0])) {
+		return ErrRDataLength
+	}
+	rdata.NextHashed = append([]byte{}, payload[1:1 + int(payload[0])]...)
+	rdata.Types, err = unpackTypeBitmap(payload[1 + int(payload[0]):])
+	return err
+}
+
+func (rdata *RDataNSEC3) String() string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", rdata.NSEC3Params,
+		rdata.NextHash(),
+		typeBitmapString(rdata.Types)))
+}
+
+// Next hashed owner name, in lowercase base32hex as in the owner names
+func (rdata *RDataNSEC3) NextHash() string {
+	return strings.ToLower(base32Hex.EncodeToString(rdata.NextHashed))
+}
+
+func (rdata *RDataNSEC3) Parse(fields []string, origin string) error {
+	if (len(fields) < 5) {
+		return ErrRDataSyntax
+	}
+	err := rdata.NSEC3Params.parse(fields[:4])
+	if (err != nil) {
+		return err
+	}
+	rdata.NextHashed, err = base32Hex.DecodeString(strings.ToUpper(fields[4]))
+	if (err != nil) {
+		return fmt.Errorf("%w: hash is not base32hex", ErrRDataSyntax)
+	}
+	rdata.Types, err = parseTypeBitmap(fields[5:])
+	return err
+}
+
+
+//
+// NSEC3PARAM, the parameters for the NSEC3 chain of the zone, at its apex
+//
+type RDataNSEC3PARAM struct {
+	NSEC3Params
+}
+
+func (rdata *RDataNSEC3PARAM) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	rdata.NSEC3Params.pack(buffer)
+	return nil
+}
+
+func (rdata *RDataNSEC3PARAM) Unpack(rawBytes []byte, offset int, length int) error {
+	paramsLength, err := rdata.NSEC3Params.unpack(rawBytes[offset:offset + length])
+	if (err == nil && paramsLength != length) {
+		err = ErrRDataLength
+	}
+	return err
+}
+
+func (rdata *RDataNSEC3PARAM) String() string {
+	return rdata.NSEC3Params.String()
+}
+
+func (rdata *RDataNSEC3PARAM) Parse(fields []string, origin string) error {
+	if (len(fields) != 4) {
+		return ErrRDataSyntax
+	}
+	return rdata.NSEC3Params.parse(fields)
+}
+
+// Hash algorithm, flags, iterations + salt, common to NSEC3 + NSEC3PARAM
+func (params NSEC3Params) pack(buffer *bytes.Buffer) {
+	buffer.WriteByte(params.HashAlgorithm)
+	buffer.WriteByte(params.Flags)
+	binary.Write(buffer, binary.BigEndian, params.Iterations)
+	buffer.WriteByte(byte(len(params.Salt)))
+	buffer.Write(params.Salt)
+}
+
+func (params *NSEC3Params) unpack(payload []byte) (int, error) {
+	if (len(payload) < 5 || len(payload) < 5 + int(payload[4])) {
+		return 0, ErrRDataLength
+	}
+	params.HashAlgorithm	= payload[0]
+	params.Flags			= payload[1]
+	params.Iterations		= binary.BigEndian.Uint16(payload[2:])
+	params.Salt				= append([]byte{}, payload[5:5 + int(payload[4])]...)
+	return 5 + len(params.Salt), nil
+}
+
+// An empty salt is "-"
+func (params NSEC3Params) String() string {
+	salt := "-"
+	if (len(params.Salt) > 0) {
+		salt = strings.ToUpper(hex.EncodeToString(params.Salt))
+	}
+	return fmt.Sprintf("%d %d %d %s", params.HashAlgorithm, params.Flags,
+		params.Iterations, salt)
+}
+
+func (params *NSEC3Params) parse(fields []string) error {
+	values, err := parseRDataUints(fields[:3], 8, 8, 16)
+	if (err != nil) {
+		return err
+	}
+	params.HashAlgorithm	= uint8(values[0])
+	params.Flags			= uint8(values[1])
+	params.Iterations		= uint16(values[2])
+	params.Salt = []byte{}
+	if (fields[3] != "-") {
+		params.Salt, err = hex.DecodeString(fields[3])
+		if (err != nil || len(params.Salt) > 255) {
+			return fmt.Errorf("%w: salt %s", ErrRDataSyntax, fields[3])
+		}
+	}
+	return nil
+}
+
+// Hash of the name, with the iterations + salt (RFC 5155, 5), in base32hex
+// as in the owner names of NSEC3 records.  The names hashed are those of
+// records + their ancestors, which always pack
+func (params NSEC3Params) HashName(name string) string {
+	digest, _ := PackName(CanonicalName(name))
+	for i := 0; i <= int(params.Iterations); i++ {
+		hash := sha1.New()
+		hash.Write(digest)
+		hash.Write(params.Salt)
+		digest = hash.Sum(nil)
+	}
+	return strings.ToLower(base32Hex.EncodeToString(digest))
+}
+
+
+//
+// Type bitmaps, as in NSEC + NSEC3: for each window of 256 types in use, the
+// window number, the bitmap length, then the bitmap itself with the most
+// significant bit first (RFC 4034, 4.1.2)
+//
+func packTypeBitmap(buffer *bytes.Buffer, types []uint16) {
+	sorted := append([]uint16{}, types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for i := 0; i < len(sorted); {
+		window := sorted[i] >> 8
+		var bitmap [32]byte
+		length := 0
+		for (i < len(sorted) && sorted[i] >> 8 == window) {
+			low := sorted[i] & 0xFF
+			bitmap[low / 8] |= 0x80 >> (low % 8)
+			length = int(low / 8) + 1
+			i++
+		}
+		buffer.WriteByte(byte(window))
+		buffer.WriteByte(byte(length))
+		buffer.Write(bitmap[:length])
+	}
+}
+
+func unpackTypeBitmap(payload []byte) ([]uint16, error) {
+	types := []uint16{}
+	previous := -1
+	for (len(payload) > 0) {
+		if (len(payload) < 2) {
+			return nil, ErrRDataLength
+		}
+		window, length := int(payload[0]), int(payload[1])
+		if (window <= previous || length == 0 || length > 32 ||
+			len(payload) < 2 + length) {
+			return nil, ErrRDataLength
+		}
+		for i, bits := range payload[2:2 + length] {
+			for bit := 0; bit < 8; bit++ {
+				if (bits & (0x80 >> bit) != 0) {
+					types = append(types, uint16(window << 8 + i * 8 + bit))
+				}
+			}
+		}
+		previous = window
+		payload = payload[2 + length:]
+	}
+	return types, nil
+}
+
+func typeBitmapString(types []uint16) string {
+	names := make([]string, len(types))
+	for i, rtype := range types {
+		names[i] = RecordTypeString(rtype)
+	}
+	return strings.Join(names, " ")
+}
+
+func parseTypeBitmap(fields []string) ([]uint16, error) {
+	types := []uint16{}
+	seen := map[uint16]bool{}
+	for _, field := range fields {
+		rtype := parseType(strings.ToUpper(field))
+		if (rtype == 0) {
+			return nil, fmt.Errorf("%w: type %s", ErrRDataSyntax, field)
+		}
+		if (!seen[rtype]) {
+			types = append(types, rtype)
+			seen[rtype] = true
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types, nil
+}
+
+
+// Parse several unsigned integer fields, each of the given size
+func parseRDataUints(fields []string, bits ...int) ([]uint64, error) {
+	values := make([]uint64, len(bits))
+	for i, size := range bits {
+		value, err := parseRDataUint(fields[i], size)
+		if (err != nil) {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// Base64 that may be split across several fields
+func parseBase64(fields []string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(fields, ""))
+	if (err != nil) {
+		return nil, fmt.Errorf("%w: not base64", ErrRDataSyntax)
+	}
+	return decoded, nil
+}
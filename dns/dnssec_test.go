@@ -0,0 +1,108 @@
+package dns
+
+import(
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	)
+
+// DNSKEY + DS example from RFC 4034, 5.4
+const exampleDNSKEY = "256 3 5 AQOeiiR0GOMYkDshWoSKz9XzfwJr1AYtsmx3TGkJaNXVbfi/" +
+	"2pHm822aJ5iI9BMzNXxeYCmZDRD99WYwYqUSdjMmmAphXdvxegXd/M5+X7OrzKBaMbCVdFLU" +
+	"Uh6DhweJBjEVv5f2wwjM9XzcnOf+EPbtG9DMBmADjFDc2w/rljwvFw=="
+
+//
+// Validate the key tag computation (RFC 4034, Appendix B)
+//
+func TestKeyTag(t *testing.T) {
+	key := &RDataDNSKEY{}
+	err := key.Parse(splitFields(exampleDNSKEY), "")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	if (key.KeyTag() != 60485) {
+		t.Error("Unexpected key tag: ", key.KeyTag())
+	}
+
+	// RSA/MD5 keys use the low bits of the modulus instead
+	key.Algorithm = AlgorithmRSAMD5
+	if (key.KeyTag() != 0x3c2f) {
+		t.Errorf("Unexpected RSA/MD5 key tag: %#x", key.KeyTag())
+	}
+}
+
+//
+// Validate hashed owner names against RFC 5155, Appendix A
+//
+func TestNSEC3Hash(t *testing.T) {
+	params := NSEC3Params{
+		HashAlgorithm:	NSEC3HashSHA1,
+		Iterations:		12,
+		Salt:			[]byte{ 0xaa, 0xbb, 0xcc, 0xdd },
+	}
+	testCases := map[string]string{
+		"example":			"0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example":		"35mthgpgcu1qg68fab165klnsnk3dpvl",
+		"A.Example.":		"35mthgpgcu1qg68fab165klnsnk3dpvl",
+		"ns1.example":		"2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+	}
+	for name, hash := range testCases {
+		if (params.HashName(name) != hash) {
+			t.Errorf("Unexpected hash of %s: %s", name, params.HashName(name))
+		}
+	}
+}
+
+//
+// Validate the window blocks of type bitmaps, and malformed bitmaps
+//
+func TestTypeBitmap(t *testing.T) {
+	types := []uint16{ RecordTypeA, RecordTypeMX, RecordTypeRRSIG,
+		RecordTypeNSEC, 1234 }
+	buffer := new(bytes.Buffer)
+	packTypeBitmap(buffer, []uint16{ 1234, RecordTypeMX, RecordTypeA,
+		RecordTypeNSEC, RecordTypeRRSIG })
+	expected := append([]byte{ 0x00, 0x06, 0x40, 0x01, 0x00, 0x00, 0x00, 0x03,
+		0x04, 0x1b }, append(make([]byte, 26), 0x20)...)
+	if (!bytes.Equal(buffer.Bytes(), expected)) {
+		t.Errorf("Unexpected bitmap: % x", buffer.Bytes())
+	}
+	unpacked, err := unpackTypeBitmap(buffer.Bytes())
+	if (err != nil || !reflect.DeepEqual(unpacked, types)) {
+		t.Error("Unexpected types: ", unpacked, err)
+	}
+
+	for _, bitmap := range [][]byte{ { 0x00 }, { 0x00, 0x00 },
+		{ 0x00, 0x21 }, { 0x00, 0x02, 0x40 }, { 0x01, 0x01, 0x40, 0x00, 0x01, 0x40 } } {
+		_, err := unpackTypeBitmap(bitmap)
+		if (!errors.Is(err, ErrRDataLength)) {
+			t.Errorf("% x: expected an error: %v", bitmap, err)
+		}
+	}
+}
+
+func TestSignatureTime(t *testing.T) {
+	value, err := ParseSignatureTime("20240102030405")
+	if (err != nil || value != 1704164645 ||
+		SignatureTimeString(value) != "20240102030405") {
+		t.Error("Unexpected time: ", value, err)
+	}
+	value, err = ParseSignatureTime("1704164645")
+	if (err != nil || value != 1704164645) {
+		t.Error("Unexpected time: ", value, err)
+	}
+	_, err = ParseSignatureTime("20241302030405")
+	if (!errors.Is(err, ErrRDataSyntax)) {
+		t.Error("Expected a syntax error: ", err)
+	}
+}
+
+func splitFields(text string) []string {
+	entries, _ := tokenizeZone(text)
+	var fields []string
+	for _, field := range entries[0].fields {
+		fields = append(fields, field.text)
+	}
+	return fields
+}
@@ -0,0 +1,133 @@
+//
+// Extension mechanisms for DNS, EDNS(0), as defined by RFC 6891.  The OPT
+// pseudo-record travels in the additional section of the message, but its
+// fields are overloaded so it is unpacked into its own structure here rather
+// than a generic ResourceRecord.
+//
+
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+
+const RecordTypeOPT			= 41
+
+const EDNSVersion			= 0
+const EDNSDefaultUDPSize	= 1232 // Avoids IP fragmentation in practice
+const EDNSMinUDPSize		= 512  // RFC 6891, 6.2.3
+
+const EDNSFlagDNSSECOK		= 0x8000 // DO bit, upper 16b of the TTL field
+
+
+//
+// Individual option within the OPT RDATA, {code, length, data}
+//
+type EDNSOption struct {
+	Code	uint16
+	Data	[]byte
+}
+
+func (option EDNSOption) String() string {
+	return fmt.Sprintf("option %d (%d bytes) % x",
+		option.Code, len(option.Data), option.Data)
+}
+
+
+//
+// OPT pseudo-record
+//
+type OPTRecord struct {
+	UDPSize			uint16	// Overloads the RR CLASS field
+	ExtendedRcode	uint8	// Upper 8b of the 12b RCODE, overloads the TTL
+	Version			uint8
+	DNSSECOK		bool	// DO bit
+	Options			[]EDNSOption
+}
+
+func (opt OPTRecord) String() string {
+	var flags []string
+	if (opt.DNSSECOK) {
+		flags = append(flags, "DO")
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "version %d, flags (%s), udp %d",
+		opt.Version, strings.Join(flags, " "), opt.UDPSize)
+	if (opt.ExtendedRcode != 0) {
+		fmt.Fprintf(&builder, ", extended rcode %d", opt.ExtendedRcode)
+	}
+	for _, option := range opt.Options {
+		fmt.Fprintf(&builder, ", %s", option)
+	}
+
+	return builder.String()
+}
+
+func (opt OPTRecord) Pack() []byte {
+	// The root owner name + the opaque RDATA always pack
+	packed, _ := opt.resourceRecord().Pack()
+	return packed
+}
+
+// Generic RR equivalent of the OPT record, as it appears in the additional
+// section
+func (opt OPTRecord) resourceRecord() ResourceRecord {
+	// Pack the option list, which becomes the RDATA
+	rdata := new(bytes.Buffer)
+	for _, option := range opt.Options {
+		binary.Write(rdata, binary.BigEndian, option.Code)
+		binary.Write(rdata, binary.BigEndian, uint16(len(option.Data)))
+		rdata.Write(option.Data)
+	}
+
+	// Overload the TTL field with the extended RCODE, version and flags
+	ttl := uint32(opt.ExtendedRcode) << 24 | uint32(opt.Version) << 16
+	if (opt.DNSSECOK) {
+		ttl |= EDNSFlagDNSSECOK
+	}
+
+	return ResourceRecord{
+		Name:		"",
+		Type:		RecordTypeOPT,
+		Class:		opt.UDPSize,
+		TTL:		int32(ttl),
+		RDLength:	uint16(rdata.Len()),
+		RData:		rdata.Bytes(),
+	}
+}
+
+// Convert a generic RR, already unpacked from the additional section, into
+// its OPT equivalent
+func (opt *OPTRecord) fromResourceRecord(rr ResourceRecord) error {
+	ttl := uint32(rr.TTL)
+	*opt = OPTRecord{
+		UDPSize:		rr.Class,
+		ExtendedRcode:	uint8(ttl >> 24),
+		Version:		uint8(ttl >> 16),
+		DNSSECOK:		(ttl & EDNSFlagDNSSECOK != 0),
+	}
+
+	// Walk the option list
+	reader := bytes.NewReader(rr.RData)
+	for (reader.Len() > 0) {
+		var code, length uint16
+		err := binary.Read(reader, binary.BigEndian, &code)
+		if (err == nil) {
+			err = binary.Read(reader, binary.BigEndian, &length)
+		}
+		if (err != nil || int(length) > reader.Len()) {
+			return ErrMalformedOption
+		}
+
+		option := EDNSOption{ code, make([]byte, length) }
+		reader.Read(option.Data)
+		opt.Options = append(opt.Options, option)
+	}
+
+	return nil
+}
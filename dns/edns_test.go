@@ -0,0 +1,82 @@
+package dns
+
+import(
+	"bytes"
+	"testing"
+	)
+
+//
+// Validate OPT packing + unpacking as part of a full message
+//
+func TestOPTRecordPacking(t *testing.T) {
+	opt1 := OPTRecord{
+		UDPSize:		4096,
+		ExtendedRcode:	1,
+		Version:		EDNSVersion,
+		DNSSECOK:		true,
+		Options:		[]EDNSOption{ { 10, []byte{1, 2, 3, 4, 5, 6, 7, 8} } },
+	}
+
+	message1 := Message{}
+	message1.AddQuestion( Question{ "a.com", RecordTypeA, RecordClassIN } )
+	message1.SetEDNS(opt1)
+	message1.SetEDNS(opt1)
+	if (message1.Header.AdditionalCount != 1) {
+		t.Error("Unexpected additional count: ", message1.Header.AdditionalCount)
+	}
+
+	// Unpack the bytes back into a new message.  Expect the OPT record to
+	// be extracted from the additional section
+	rawBytes := mustPack(t, message1)
+	message2 := Message{}
+	length, err := message2.Unpack(rawBytes)
+	if (err != nil) {
+		t.Fatal("Unpacking error: ", err)
+	}
+	if (length != len(rawBytes)) {
+		t.Error("Length mismatch during unpacking: ", length)
+	}
+	if (len(message2.AdditionalRR) != 0) {
+		t.Error("Unexpected additional RR: ", message2.AdditionalRR)
+	}
+	if (message2.EDNS == nil) {
+		t.Fatal("Missing OPT record")
+	}
+
+	opt2 := *message2.EDNS
+	if (opt1.UDPSize != opt2.UDPSize) {
+		t.Error("UDPSize mismatch", opt1.UDPSize, opt2.UDPSize)
+	}
+	if (opt1.ExtendedRcode != opt2.ExtendedRcode) {
+		t.Error("ExtendedRcode mismatch")
+	}
+	if (opt1.Version != opt2.Version) {
+		t.Error("Version mismatch")
+	}
+	if (opt1.DNSSECOK != opt2.DNSSECOK) {
+		t.Error("DO mismatch")
+	}
+	if (len(opt2.Options) != 1 || opt2.Options[0].Code != 10 ||
+		!bytes.Equal(opt1.Options[0].Data, opt2.Options[0].Data)) {
+		t.Error("Options mismatch", opt2.Options)
+	}
+}
+
+
+//
+// Validate rejection of a malformed option list
+//
+func TestMalformedOPTRecord(t *testing.T) {
+	rr := ResourceRecord{
+		Type:		RecordTypeOPT,
+		Class:		EDNSDefaultUDPSize,
+		RDLength:	5,
+		RData:		[]byte{ 0, 10, 0, 8, 1 },
+	}
+
+	var opt OPTRecord
+	err := opt.fromResourceRecord(rr)
+	if (err == nil) {
+		t.Error("Expected error on truncated option")
+	}
+}
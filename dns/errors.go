@@ -0,0 +1,80 @@
+//
+// Errors returned by the DNS codec.  Callers should compare against these
+// with errors.Is/errors.As, since most are wrapped with additional context
+// about where in the message the failure occurred.
+//
+
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+
+// Malformed-message errors, returned by the various Unpack functions.  The
+// name errors also come from the Pack functions, for names that do not fit
+var ErrUnexpectedEnd	= errors.New("Unexpected end of message")
+var ErrLabelType		= errors.New("Unsupported label type")
+var ErrForwardPointer	= errors.New("Forward compression pointer")
+var ErrPointerLoop		= errors.New("Compression pointer loop")
+var ErrNameTooLong		= errors.New("Domain name too long")
+var ErrLabelTooLong		= errors.New("Label too long")
+var ErrEmptyLabel		= errors.New("Empty label")
+var ErrRDataLength		= errors.New("RDATA length mismatch")
+var ErrRDataOverrun		= errors.New("RDATA exceeds RDLENGTH")
+var ErrMultipleOPT		= errors.New("Multiple OPT records")
+var ErrMalformedOption	= errors.New("Malformed EDNS option")
+var ErrMisplacedTSIG	= errors.New("TSIG is not the last record")
+
+// Zone file errors, returned by ParseZone + the RData Parse methods
+var ErrZoneSyntax		= errors.New("Zone file syntax error")
+var ErrRDataSyntax		= errors.New("Malformed RDATA")
+
+// Reply validation errors, returned by Message.Validate
+var ErrIdMismatch		= errors.New("Header id mismatch")
+var ErrNotResponse		= errors.New("Expected DNS response")
+
+// TSIG errors, returned by TSIGKey.Verify + TSIGStream.Verify.  The last
+// four correspond to the TSIG error codes of the same name (RFC 8945, 5.2)
+var ErrTSIGKey			= errors.New("Invalid TSIG key")
+var ErrTSIGAlgorithm	= errors.New("Unsupported TSIG algorithm")
+var ErrTSIGMissing		= errors.New("Message is not signed")
+var ErrTSIGFormat		= errors.New("Malformed TSIG record")
+var ErrBadSig			= errors.New("TSIG signature mismatch (BADSIG)")
+var ErrBadKey			= errors.New("Unknown TSIG key or algorithm (BADKEY)")
+var ErrBadTime			= errors.New("TSIG time outside of the fudge (BADTIME)")
+var ErrBadTrunc			= errors.New("TSIG MAC truncated too far (BADTRUNC)")
+
+// DNSSEC errors, returned by RDataRRSIG.Verify + the DNSKEY methods
+var ErrDNSSECAlgorithm	= errors.New("Unsupported DNSSEC algorithm")
+var ErrDigestType		= errors.New("Unsupported DS digest type")
+var ErrPublicKey		= errors.New("Malformed DNSSEC public key")
+var ErrRRSIGMismatch	= errors.New("RRSIG does not match the RRset or key")
+var ErrSignature		= errors.New("DNSSEC signature does not verify")
+var ErrSignatureExpired	= errors.New("DNSSEC signature has expired")
+var ErrSignatureNotYetValid	= errors.New("DNSSEC signature is not yet valid")
+
+// DNSSEC signing errors, returned by SignZone + LoadSigningKey
+var ErrSigningKey		= errors.New("Invalid DNSSEC signing key")
+var ErrZoneContent		= errors.New("Zone cannot be signed")
+
+// Returned by Message.UnmarshalJSON, for JSON that does not describe a
+// message (RFC 8427)
+var ErrMessageJSON		= errors.New("Malformed JSON message")
+
+// Returned when the reply does not fit in a single UDP datagram.  The caller
+// may retry the same request over TCP
+var ErrTruncated		= errors.New("DNS response truncated")
+
+
+//
+// Well-formed reply carrying a non-zero response code
+//
+type RcodeError struct {
+	Rcode	uint16
+}
+
+func (err *RcodeError) Error() string {
+	return fmt.Sprintf("DNS error: %s", RcodeString(err.Rcode))
+}
@@ -0,0 +1,448 @@
+//
+// JSON representation of DNS messages (RFC 8427).  Names are shown without
+// the trailing dot, as elsewhere, except for the root
+//
+
+package dns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+
+//
+// Message object (RFC 8427, 2.1 + 2.2).  A single question is shown via the
+// QNAME, QTYPE + QCLASS members, and any other number of questions via
+// questionRRs
+//
+type jsonMessage struct {
+	ID					uint16		`json:"ID"`
+	QR					jsonFlag	`json:"QR"`
+	Opcode				uint16		`json:"Opcode"`
+	AA					jsonFlag	`json:"AA"`
+	TC					jsonFlag	`json:"TC"`
+	RD					jsonFlag	`json:"RD"`
+	RA					jsonFlag	`json:"RA"`
+	AD					jsonFlag	`json:"AD"`
+	CD					jsonFlag	`json:"CD"`
+	RCODE				uint16		`json:"RCODE"`
+	QDCOUNT				uint16		`json:"QDCOUNT"`
+	ANCOUNT				uint16		`json:"ANCOUNT"`
+	NSCOUNT				uint16		`json:"NSCOUNT"`
+	ARCOUNT				uint16		`json:"ARCOUNT"`
+	QNAME				string		`json:"QNAME,omitempty"`
+	QTYPE				uint16		`json:"QTYPE,omitempty"`
+	QTYPEname			string		`json:"QTYPEname,omitempty"`
+	QCLASS				uint16		`json:"QCLASS,omitempty"`
+	QCLASSname			string		`json:"QCLASSname,omitempty"`
+	QuestionRRs			[]jsonRR	`json:"questionRRs,omitempty"`
+	AnswerRRs			[]jsonRR	`json:"answerRRs,omitempty"`
+	AuthorityRRs		[]jsonRR	`json:"authorityRRs,omitempty"`
+	AdditionalRRs		[]jsonRR	`json:"additionalRRs,omitempty"`
+	MessageOctetsHEX	string		`json:"messageOctetsHEX,omitempty"`
+}
+
+// RFC 8427 representation of the message, as a single JSON object.  The OPT
+// + TSIG pseudo-records appear in the additional section, as on the wire.
+// With raw, the object also carries the packed message + every RDATA in hex
+func (message Message) JSON(raw bool) ([]byte, error) {
+	flag := func(mask uint16) jsonFlag {
+		return jsonFlag(message.Header.Flags & mask != 0)
+	}
+	object := jsonMessage{
+		ID:			message.Header.Id,
+		QR:			flag(MessageHeaderFlagResponse),
+		Opcode:		message.Header.Opcode(),
+		AA:			flag(MessageHeaderFlagAuthoritative),
+		TC:			flag(MessageHeaderFlagTruncation),
+		RD:			flag(MessageHeaderFlagRecursionDesired),
+		RA:			flag(MessageHeaderFlagRecursionAvailable),
+		AD:			flag(MessageHeaderFlagAuthenticData),
+		CD:			flag(MessageHeaderFlagCheckingDisabled),
+		RCODE:		message.Header.Flags & MessageHeaderFlagResponseCodeMask,
+	}
+
+	if (len(message.Questions) == 1) {
+		question := message.Questions[0]
+		object.QNAME		= nameString(question.Name)
+		object.QTYPE		= question.Type
+		object.QTYPEname	= RecordTypeString(question.Type)
+		object.QCLASS		= question.Class
+		object.QCLASSname	= RecordClassString(question.Class)
+	} else {
+		for _, question := range message.Questions {
+			object.QuestionRRs = append(object.QuestionRRs, jsonRR{
+				NAME:		nameString(question.Name),
+				TYPE:		question.Type,
+				TYPEname:	RecordTypeString(question.Type),
+				CLASS:		question.Class,
+				CLASSname:	RecordClassString(question.Class),
+			})
+		}
+	}
+
+	// The pseudo-records follow the rest of the additional section
+	additional := message.AdditionalRR
+	if (message.EDNS != nil) {
+		additional = append(additional[:len(additional):len(additional)],
+			message.EDNS.resourceRecord())
+	}
+	if (message.TSIG != nil) {
+		additional = append(additional[:len(additional):len(additional)],
+			*message.TSIG)
+	}
+	for _, rr := range message.Answers {
+		object.AnswerRRs = append(object.AnswerRRs, newJSONRR(rr, raw))
+	}
+	for _, rr := range message.Nameservers {
+		object.AuthorityRRs = append(object.AuthorityRRs, newJSONRR(rr, raw))
+	}
+	for _, rr := range additional {
+		object.AdditionalRRs = append(object.AdditionalRRs, newJSONRR(rr, raw))
+	}
+
+	// Counts reflect the actual section contents, as in Pack
+	object.QDCOUNT = uint16(len(message.Questions))
+	object.ANCOUNT = uint16(len(object.AnswerRRs))
+	object.NSCOUNT = uint16(len(object.AuthorityRRs))
+	object.ARCOUNT = uint16(len(object.AdditionalRRs))
+	if (raw) {
+		packed, err := message.Pack()
+		if (err != nil) {
+			return nil, err
+		}
+		object.MessageOctetsHEX = strings.ToUpper(hex.EncodeToString(packed))
+	}
+
+	return json.Marshal(object)
+}
+
+// Same as JSON, without the raw hex
+func (message Message) MarshalJSON() ([]byte, error) {
+	return message.JSON(false)
+}
+
+// Decode an RFC 8427 message object, e.g. from JSON, into a message that may
+// be packed + sent.  The messageOctetsHEX member, if any, takes precedence
+// over the others.  Otherwise, the header counts follow the actual sections,
+// and the types + classes may be given by mnemonic alone, with IN as the
+// default class
+func (message *Message) UnmarshalJSON(data []byte) error {
+	var object jsonMessage
+	err := json.Unmarshal(data, &object)
+	if (err != nil && !errors.Is(err, ErrMessageJSON)) {
+		err = fmt.Errorf("%w: %s", ErrMessageJSON, err)
+	}
+	if (err != nil) {
+		return err
+	}
+	if (object.MessageOctetsHEX != "") {
+		rawBytes, err := hex.DecodeString(object.MessageOctetsHEX)
+		if (err != nil) {
+			return fmt.Errorf("%w: messageOctetsHEX: %s", ErrMessageJSON, err)
+		}
+		_, err = message.Unpack(rawBytes)
+		return err
+	}
+
+	// Header fields + flags
+	if (object.Opcode > 15 || object.RCODE > 15) {
+		return fmt.Errorf("%w: Opcode %d, RCODE %d", ErrMessageJSON,
+			object.Opcode, object.RCODE)
+	}
+	*message = Message{}
+	message.Header.Id = object.ID
+	message.Header.SetOpcode(object.Opcode)
+	message.Header.Flags |= object.RCODE
+	flags := []struct{
+		set		jsonFlag
+		mask	uint16
+	}{
+		{ object.QR, MessageHeaderFlagResponse },
+		{ object.AA, MessageHeaderFlagAuthoritative },
+		{ object.TC, MessageHeaderFlagTruncation },
+		{ object.RD, MessageHeaderFlagRecursionDesired },
+		{ object.RA, MessageHeaderFlagRecursionAvailable },
+		{ object.AD, MessageHeaderFlagAuthenticData },
+		{ object.CD, MessageHeaderFlagCheckingDisabled },
+	}
+	for _, flag := range flags {
+		if (flag.set) {
+			message.Header.Flags |= flag.mask
+		}
+	}
+
+	// Questions, via QNAME and/or questionRRs
+	questions := object.QuestionRRs
+	if (object.QNAME != "") {
+		questions = append([]jsonRR{{ NAME: object.QNAME, TYPE: object.QTYPE,
+			TYPEname: object.QTYPEname, CLASS: object.QCLASS,
+			CLASSname: object.QCLASSname }}, questions...)
+	}
+	for _, question := range questions {
+		rtype, class, err := question.typeClass()
+		if (err != nil) {
+			return err
+		}
+		name, err := jsonName(question.NAME)
+		if (err != nil) {
+			return err
+		}
+		message.AddQuestion(Question{ name, rtype, class })
+	}
+
+	// Each RR section, with the pseudo-records extracted from the
+	// additional section, as in Unpack
+	sections := []struct{
+		objects	[]jsonRR
+		add		func(rr ResourceRecord)
+	}{
+		{ object.AnswerRRs, message.AddAnswer },
+		{ object.AuthorityRRs, message.AddNameserver },
+		{ object.AdditionalRRs, message.AddAdditional },
+	}
+	for _, section := range sections {
+		for _, object := range section.objects {
+			rr, err := object.resourceRecord()
+			if (err != nil) {
+				return err
+			}
+			section.add(rr)
+		}
+	}
+	return message.extractPseudoRecords()
+}
+
+
+//
+// Resource record object (RFC 8427, 2.3).  The RDATA is shown in
+// presentation format via the rdata<TYPE> member (e.g. rdataMX), where
+// supported, and otherwise in hex.  Questions omit the TTL + RDATA
+//
+type jsonRR struct {
+	NAME		string	`json:"NAME"`
+	TYPE		uint16	`json:"TYPE"`
+	TYPEname	string	`json:"TYPEname,omitempty"`
+	CLASS		uint16	`json:"CLASS"`
+	CLASSname	string	`json:"CLASSname,omitempty"`
+	TTL			*int32	`json:"TTL,omitempty"`
+	RDLENGTH	*uint16	`json:"RDLENGTH,omitempty"`
+	RDATAHEX	string	`json:"RDATAHEX,omitempty"`
+
+	rdata		string	// Presentation format, as rdata<rdataType>
+	rdataType	string
+}
+
+func newJSONRR(rr ResourceRecord, raw bool) jsonRR {
+	rdata := rr.RData
+	if (rr.Data != nil) {
+		buffer := new(bytes.Buffer)
+		rr.Data.Pack(buffer, nil)
+		rdata = buffer.Bytes()
+	}
+	length := uint16(len(rdata))
+	object := jsonRR{
+		NAME:		nameString(rr.Name),
+		TYPE:		rr.Type,
+		TYPEname:	RecordTypeString(rr.Type),
+		CLASS:		rr.Class,
+		TTL:		&rr.TTL,
+		RDLENGTH:	&length,
+	}
+
+	// The OPT CLASS is the UDP payload size, rather than a class
+	if (rr.Type != RecordTypeOPT) {
+		object.CLASSname = RecordClassString(rr.Class)
+	}
+
+	if _, unknown := rr.Data.(*RDataUnknown); (rr.Data != nil && !unknown) {
+		object.rdata = rdataString(rr.Data)
+		object.rdataType = object.TYPEname
+	}
+
+	// TSIG RDATA has no presentation format to parse, so always include the
+	// hex as well
+	if (raw || object.rdata == "" || rr.Type == RecordTypeTSIG) {
+		object.RDATAHEX = strings.ToUpper(hex.EncodeToString(rdata))
+	}
+	return object
+}
+
+// Members of the plain object, plus the rdata<TYPE> member, if any
+func (object jsonRR) MarshalJSON() ([]byte, error) {
+	type plain jsonRR
+	encoded, err := json.Marshal(plain(object))
+	if (err != nil || object.rdata == "") {
+		return encoded, err
+	}
+	member, err := json.Marshal(map[string]string{
+		"rdata" + object.rdataType: object.rdata })
+	if (err != nil) {
+		return nil, err
+	}
+	encoded = append(encoded[:len(encoded) - 1], ',')
+	return append(encoded, member[1:]...), nil
+}
+
+func (object *jsonRR) UnmarshalJSON(data []byte) error {
+	type plain jsonRR
+	err := json.Unmarshal(data, (*plain)(object))
+	if (err != nil) {
+		return err
+	}
+	var members map[string]json.RawMessage
+	json.Unmarshal(data, &members)
+	for name, value := range members {
+		if (!strings.HasPrefix(name, "rdata") || name == "rdata") {
+			continue
+		}
+		if (object.rdataType != "") {
+			return fmt.Errorf("%w: multiple rdata members", ErrMessageJSON)
+		}
+		err = json.Unmarshal(value, &object.rdata)
+		if (err != nil) {
+			return fmt.Errorf("%w: %s: %s", ErrMessageJSON, name, err)
+		}
+		object.rdataType = name[len("rdata"):]
+	}
+	return nil
+}
+
+// Type + class, by number or else by mnemonic.  The class defaults to IN
+func (object jsonRR) typeClass() (uint16, uint16, error) {
+	rtype, class := object.TYPE, object.CLASS
+	if (rtype == 0 && object.TYPEname != "") {
+		rtype = parseMnemonic(object.TYPEname, "TYPE", RecordTypeMapToString)
+	}
+	if (class == 0 && object.CLASSname != "") {
+		class = parseMnemonic(object.CLASSname, "CLASS",
+			RecordClassMapToString)
+	} else if (class == 0 && rtype != RecordTypeOPT) {
+		class = RecordClassIN
+	}
+	if (rtype == 0 || (class == 0 && rtype != RecordTypeOPT)) {
+		return 0, 0, fmt.Errorf("%w: %s has no valid type or class",
+			ErrMessageJSON, object.NAME)
+	}
+	return rtype, class, nil
+}
+
+// Convert back to an RR.  The RDATA may be in hex, or in presentation format,
+// with names relative to the root; the hex takes precedence.  Either must fit
+// in RDLENGTH, and only the types that allow it may have no RDATA at all
+func (object jsonRR) resourceRecord() (ResourceRecord, error) {
+	rtype, class, err := object.typeClass()
+	if (err != nil) {
+		return ResourceRecord{}, err
+	}
+	name, err := jsonName(object.NAME)
+	if (err != nil) {
+		return ResourceRecord{}, err
+	}
+	rr := ResourceRecord{ Name: name, Type: rtype, Class: class }
+	if (object.TTL != nil) {
+		rr.TTL = *object.TTL
+	}
+
+	if (object.RDATAHEX != "") {
+		rr.RData, err = hex.DecodeString(object.RDATAHEX)
+		if (err == nil) {
+			rr.Data = NewRData(rtype)
+			err = rr.Data.Unpack(rr.RData, 0, len(rr.RData))
+		}
+	} else if (object.rdataType != "") {
+		if (object.rdataType != RecordTypeString(rtype)) {
+			return rr, fmt.Errorf("%w: rdata%s in a %s record", ErrMessageJSON,
+				object.rdataType, RecordTypeString(rtype))
+		}
+		var entries []zoneEntry
+		entries, err = tokenizeZone(object.rdata)
+		if (err == nil && len(entries) != 1) {
+			err = ErrRDataSyntax
+		}
+		if (err == nil) {
+			rr.Data, err = parseRData(rtype, entries[0].fields, "")
+		}
+		if (err == nil) {
+			buffer := new(bytes.Buffer)
+			err = rr.Data.Pack(buffer, nil)
+			rr.RData = buffer.Bytes()
+		}
+	} else if (class != RecordClassANY && class != RecordClassNONE &&
+		rtype != RecordTypeOPT) {
+		// Empty RDATA is only valid for UPDATE prerequisites + deletions,
+		// and OPT, unless the type itself allows it
+		rr.Data = NewRData(rtype)
+		err = rr.Data.Unpack(rr.RData, 0, 0)
+	}
+	if (err == nil && len(rr.RData) > 0xFFFF) {
+		err = ErrRDataLength
+	}
+	if (err != nil) {
+		return rr, fmt.Errorf("%w: %s %s RDATA: %s", ErrMessageJSON, rr.Name,
+			RecordTypeString(rtype), err)
+	}
+
+	rr.RDLength = uint16(len(rr.RData))
+	if (object.RDLENGTH != nil && *object.RDLENGTH != rr.RDLength) {
+		return rr, fmt.Errorf("%w: %s %s RDLENGTH %d, for %d bytes",
+			ErrMessageJSON, rr.Name, RecordTypeString(rtype), *object.RDLENGTH,
+			rr.RDLength)
+	}
+	return rr, nil
+}
+
+
+//
+// Helpers
+//
+
+// Header flag, shown as a boolean.  Decoding also accepts 0 + 1, as in the
+// examples of RFC 8427
+type jsonFlag bool
+
+func (flag *jsonFlag) UnmarshalJSON(data []byte) error {
+	switch (string(data)) {
+		case "true", "1":
+			*flag = true
+		case "false", "0", "null":
+			*flag = false
+		default:
+			return fmt.Errorf("%w: invalid flag %s", ErrMessageJSON, data)
+	}
+	return nil
+}
+
+// Name as stored, without the trailing dot.  The name must fit in a message
+func jsonName(name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+	err := checkName(name)
+	if (err != nil) {
+		return "", fmt.Errorf("%w: %w", ErrMessageJSON, err)
+	}
+	return name, nil
+}
+
+// Value of a mnemonic, or of its generic form, e.g. TYPE65 or CLASS3.  Zero
+// if unknown
+func parseMnemonic(field string, generic string,
+	names map[uint16]string) uint16 {
+	if (strings.HasPrefix(field, generic)) {
+		value, err := strconv.ParseUint(field[len(generic):], 10, 16)
+		if (err == nil) {
+			return uint16(value)
+		}
+	}
+	for value, name := range names {
+		if (strings.EqualFold(name, field)) {
+			return value
+		}
+	}
+	return 0
+}
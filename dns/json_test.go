@@ -0,0 +1,189 @@
+package dns
+
+import(
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	)
+
+const jsonZone = `
+$ORIGIN example.com.
+$TTL 300
+@		IN	MX		10 mail
+www		IN	A		192.0.2.1
+www		IN	TXT		"v=spf1 -all" "say \"hi\";"
+@		IN	TYPE65	\# 3 010203
+@		IN	SOA		ns1 host 1 7200 900 1209600 300
+@		IN	DNSKEY	257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=
+`
+
+// Reply with every section, EDNS + a TSIG
+func jsonReply(t *testing.T) Message {
+	records, err := ParseZone(strings.NewReader(jsonZone), "", "test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	request := NewQuery("example.com", RecordTypeMX)
+	reply := NewReply(request, RcodeNoError)
+	reply.Header.Flags |= MessageHeaderFlagAuthoritative
+	reply.SetEDNS(OPTRecord{ UDPSize: 1232, DNSSECOK: true,
+		Options: []EDNSOption{ { 10, []byte{ 1, 2, 3, 4, 5, 6, 7, 8 } } } })
+	for _, rr := range records[:4] {
+		reply.AddAnswer(rr)
+	}
+	reply.AddNameserver(records[4])
+	reply.AddAdditional(records[5])
+	reply.AddAdditional(ResourceRecord{ Name: "www.example.com",
+		Type: RecordTypeA, Class: RecordClassANY })
+	_, _, err = testKey().Sign(&reply, nil, signTime)
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	return reply
+}
+
+//
+// Validate the RFC 8427 members, and that the JSON decodes back into the
+// same message, whether via the presentation format, the RDATA in hex, or
+// the entire message in hex
+//
+func TestMessageJSON(t *testing.T) {
+	reply := jsonReply(t)
+	encoded, err := reply.JSON(false)
+	if (err != nil) {
+		t.Fatal("Encoding error: ", err)
+	}
+	expected := []string{
+		`"QR":true,"Opcode":0,"AA":true,"TC":false,"RD":false`,
+		`"RCODE":0,"QDCOUNT":1,"ANCOUNT":4,"NSCOUNT":1,"ARCOUNT":4`,
+		`"QNAME":"example.com","QTYPE":15,"QTYPEname":"MX","QCLASS":1,"QCLASSname":"IN"`,
+		`"NAME":"example.com","TYPE":15,"TYPEname":"MX","CLASS":1,"CLASSname":"IN","TTL":300,"RDLENGTH":20,"rdataMX":"10 mail.example.com"`,
+		`"rdataTXT":"\"v=spf1 -all\" \"say \\\"hi\\\";\""`,
+		`"TYPEname":"TYPE65","CLASS":1,"CLASSname":"IN","TTL":300,"RDLENGTH":3,"RDATAHEX":"010203"}`,
+		`"NAME":".","TYPE":41,"TYPEname":"TYPE41","CLASS":1232,"TTL":32768,"RDLENGTH":12,"RDATAHEX":"000A00080102030405060708"}`,
+		`"NAME":"www.example.com","TYPE":1,"TYPEname":"A","CLASS":255,"CLASSname":"ANY","TTL":0,"RDLENGTH":0}`,
+		`"rdataDNSKEY":"257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4="}`,
+		`"rdataTSIG":"hmac-sha256. 1700000000 300 32 `,
+	}
+	for _, member := range expected {
+		if (!bytes.Contains(encoded, []byte(member))) {
+			t.Errorf("Missing %s in %s", member, encoded)
+		}
+	}
+	if (bytes.Contains(encoded, []byte("messageOctetsHEX")) ||
+		bytes.Contains(encoded, []byte(`"RDATAHEX":"C0`))) {
+		t.Error("Unexpected raw hex: ", string(encoded))
+	}
+	marshalled, _ := json.Marshal(reply)
+	if (!bytes.Equal(marshalled, encoded)) {
+		t.Error("Unexpected MarshalJSON: ", string(marshalled))
+	}
+
+	// Decode from the presentation format
+	packed := mustPack(t, reply)
+	var decoded Message
+	err = json.Unmarshal(encoded, &decoded)
+	if (err != nil) {
+		t.Fatal("Decoding error: ", err)
+	}
+	if (!bytes.Equal(mustPack(t, decoded), packed) || decoded.EDNS == nil ||
+		decoded.TSIG == nil || decoded.Header.AdditionalCount != 4) {
+		t.Errorf("Unexpected decoded message: %s", decoded)
+	}
+
+	// Decode from the raw hex, both with + without the packed message
+	raw, err := reply.JSON(true)
+	if (err != nil || !bytes.Contains(raw, []byte(`"messageOctetsHEX"`)) ||
+		!bytes.Contains(raw, []byte(`"RDATAHEX":"C0000201"`))) {
+		t.Fatal("Unexpected raw JSON: ", string(raw), err)
+	}
+	decoded = Message{}
+	err = json.Unmarshal(raw, &decoded)
+	if (err != nil || !bytes.Equal(mustPack(t, decoded), packed)) {
+		t.Error("Unexpected decoding of messageOctetsHEX: ", decoded, err)
+	}
+	var members map[string]any
+	json.Unmarshal(raw, &members)
+	delete(members, "messageOctetsHEX")
+	for _, rr := range members["answerRRs"].([]any) {
+		object := rr.(map[string]any)
+		if (object["TYPEname"] == "MX") {
+			object["rdataMX"] = "20 ignored.example.com"
+		}
+		delete(object, "rdataA")
+	}
+	raw, _ = json.Marshal(members)
+	decoded = Message{}
+	err = decoded.UnmarshalJSON(raw)
+	if (err != nil || !bytes.Equal(mustPack(t, decoded), packed)) {
+		t.Error("Unexpected decoding of RDATAHEX: ", decoded, err)
+	}
+}
+
+//
+// Validate decoding of hand-written messages, as in the examples of RFC 8427,
+// and of malformed ones
+//
+func TestMessageJSONDecode(t *testing.T) {
+	var message Message
+	err := message.UnmarshalJSON([]byte(`{ "ID": 19678, "QR": 0, ` +
+		`"Opcode": 0, "AA": 0, "TC": 0, "RD": 0, "RA": 0, "AD": 0, "CD": 0, ` +
+		`"RCODE": 0, "QDCOUNT": 1, "ANCOUNT": 0, "NSCOUNT": 0, ` +
+		`"ARCOUNT": 0, "QNAME": "example.com", "QTYPE": 1, "QCLASS": 1 }`))
+	expected := NewQuery("example.com", RecordTypeA)
+	expected.Header.Id = 19678
+	if (err != nil ||
+		!bytes.Equal(mustPack(t, message), mustPack(t, expected))) {
+		t.Error("Unexpected RFC 8427 example: ", message, err)
+	}
+
+	// Mnemonics alone suffice, and the class defaults to IN
+	err = message.UnmarshalJSON([]byte(`{ "ID": 1, "RD": true, ` +
+		`"questionRRs": [ { "NAME": "example.com.", "TYPEname": "aaaa" }, ` +
+		`{ "NAME": ".", "TYPEname": "TYPE65", "CLASSname": "ANY" } ], ` +
+		`"additionalRRs": [ { "NAME": ".", "TYPE": 41, "CLASS": 4096 } ] }`))
+	if (err != nil || len(message.Questions) != 2 ||
+		message.Questions[0] != (Question{ "example.com", RecordTypeAAAA,
+			RecordClassIN }) ||
+		message.Questions[1] != (Question{ "", 65, RecordClassANY }) ||
+		message.Header.Flags != MessageHeaderFlagRecursionDesired ||
+		message.EDNS == nil || message.EDNS.UDPSize != 4096) {
+		t.Error("Unexpected mnemonic decoding: ", message, err)
+	}
+
+	testCases := []string{
+		`[]`,
+		`{ "QR": 2 }`,
+		`{ "Opcode": 16 }`,
+		`{ "QNAME": "example.com" }`,
+		`{ "QNAME": "example.com", "QTYPEname": "BOGUS" }`,
+		`{ "messageOctetsHEX": "XYZ" }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "rdataMX": "10 b" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "rdataA": "10 b" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "rdataA": "192.0.2.1", ` +
+			`"RDLENGTH": 5 } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "RDATAHEX": "0102" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "RDATAHEX": "XY" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "RDATAHEX": "" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 15 } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 65, "RDATAHEX": "` +
+			strings.Repeat("00", 0x10000) + `" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 1, "rdataA": "192.0.2.1", ` +
+			`"rdataAAAA": "::1" } ] }`,
+		`{ "QNAME": "a..com", "QTYPE": 1 }`,
+		`{ "QNAME": "` + strings.Repeat("a", 64) + `.com", "QTYPE": 1 }`,
+		`{ "answerRRs": [ { "NAME": "a..", "TYPE": 1, ` +
+			`"rdataA": "192.0.2.1" } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 5, "rdataCNAME": "b..c." } ] }`,
+		`{ "answerRRs": [ { "NAME": "a", "TYPE": 5, "rdataCNAME": "` +
+			strings.Repeat("a.", 128) + `" } ] }`,
+	}
+	for _, testCase := range testCases {
+		err := message.UnmarshalJSON([]byte(testCase))
+		if (!errors.Is(err, ErrMessageJSON)) {
+			t.Errorf("%s: expected ErrMessageJSON, got %v", testCase, err)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+//
+// Typed RDATA payloads for the common resource record types.  Each type
+// knows how to pack itself, unpack itself from a complete message buffer
+// (so that compressed names resolve correctly) and render itself in
+// presentation format.
+//
+
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+
+type RData interface {
+	// Append the payload to a partially-packed message, excluding the
+	// RDLENGTH prefix.  Types with well-known embedded names may compress
+	// them via the compression map, if any (RFC 3597, 4).  Names that do not
+	// fit the wire format are errors
+	Pack(buffer *bytes.Buffer, compression CompressionMap) error
+
+	// Unpack the payload located at rawBytes[offset:offset+length].  The
+	// entire message is required to resolve any compressed names
+	Unpack(rawBytes []byte, offset int, length int) error
+
+	// Presentation format, as in a zone file.  Types that follow the RDATA
+	// with a comment implement commentedRData as well
+	String() string
+
+	// Parse the presentation format, as split into fields from a zone file
+	// (see zone.go).  Relative names are relative to the origin
+	Parse(fields []string, origin string) error
+}
+
+// RDATA whose String adds a comment for zone files, e.g. the DNSKEY key tag.
+// Anything other than a zone file, such as the rdata<TYPE> members of JSON,
+// wants the RDATA alone
+type commentedRData interface {
+	RData
+
+	// Presentation format, without the comment
+	presentation() string
+}
+
+// Presentation format of the RDATA alone, without any comment
+func rdataString(rdata RData) string {
+	if commented, ok := rdata.(commentedRData); ok {
+		return commented.presentation()
+	}
+	return rdata.String()
+}
+
+// Constructors for each supported RDATA type, indexed by RR type.  Types
+// without an entry here are treated as opaque RDataUnknown payloads
+var RDataRegistry = map[uint16]func() RData{
+		RecordTypeA:		func() RData { return &RDataA{} },
+		RecordTypeNS:		func() RData { return &RDataNS{} },
+		RecordTypeCNAME:	func() RData { return &RDataCNAME{} },
+		RecordTypeSOA:		func() RData { return &RDataSOA{} },
+		RecordTypePTR:		func() RData { return &RDataPTR{} },
+		RecordTypeMX:		func() RData { return &RDataMX{} },
+		RecordTypeTXT:		func() RData { return &RDataTXT{} },
+		RecordTypeAAAA:		func() RData { return &RDataAAAA{} },
+		RecordTypeDNAME:	func() RData { return &RDataDNAME{} },
+		RecordTypeDS:		func() RData { return &RDataDS{} },
+		RecordTypeRRSIG:	func() RData { return &RDataRRSIG{} },
+		RecordTypeNSEC:		func() RData { return &RDataNSEC{} },
+		RecordTypeDNSKEY:	func() RData { return &RDataDNSKEY{} },
+		RecordTypeNSEC3:	func() RData { return &RDataNSEC3{} },
+		RecordTypeNSEC3PARAM:	func() RData { return &RDataNSEC3PARAM{} },
+		RecordTypeTSIG:		func() RData { return &RDataTSIG{} },
+	}
+
+func NewRData(rtype uint16) RData {
+	constructor, ok := RDataRegistry[rtype]
+	if (!ok) {
+		return &RDataUnknown{}
+	}
+	return constructor()
+}
+
+// Unpack a single domain name that must fill the entire RDATA
+func unpackRDataName(rawBytes []byte, offset int, length int) (string, error) {
+	name, nameLength, err := UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return "", err
+	}
+	if (nameLength != length) {
+		return "", ErrRDataLength
+	}
+	return name, nil
+}
+
+// Presentation format of a name within RDATA.  The root is the only name
+// that needs its trailing dot
+func nameString(name string) string {
+	if (name == "") {
+		return "."
+	}
+	return escapeName(name)
+}
+
+// Name with any bytes that a zone file would misread escaped, as \X for the
+// special characters, else \DDD (RFC 1035, 5.1).  Dots always separate labels
+func escapeName(name string) string {
+	var builder strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+			case c <= ' ' || c > '~':
+				fmt.Fprintf(&builder, "\\%03d", c)
+			case strings.IndexByte(";()\"\\", c) >= 0:
+				builder.WriteByte('\\')
+				builder.WriteByte(c)
+			default:
+				builder.WriteByte(c)
+		}
+	}
+	return builder.String()
+}
+
+// Parse a single domain name that must fill the entire RDATA
+func parseRDataName(fields []string, origin string) (string, error) {
+	if (len(fields) != 1) {
+		return "", ErrRDataSyntax
+	}
+	return AbsoluteName(fields[0], origin), nil
+}
+
+// Parse an unsigned integer field of the given size
+func parseRDataUint(field string, bits int) (uint64, error) {
+	value, err := strconv.ParseUint(field, 10, bits)
+	if (err != nil) {
+		return 0, fmt.Errorf("%w: %s", ErrRDataSyntax, field)
+	}
+	return value, nil
+}
+
+
+//
+// A, IPv4 address
+//
+type RDataA struct {
+	Address	net.IP
+}
+
+func (rdata *RDataA) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	buffer.Write(rdata.Address.To4())
+	return nil
+}
+
+func (rdata *RDataA) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length != net.IPv4len) {
+		return ErrRDataLength
+	}
+	rdata.Address = net.IP(append([]byte{}, rawBytes[offset:offset+length]...))
+	return nil
+}
+
+func (rdata *RDataA) String() string {
+	return rdata.Address.String()
+}
+
+func (rdata *RDataA) Parse(fields []string, origin string) error {
+	var address net.IP
+	if (len(fields) == 1 && !strings.Contains(fields[0], ":")) {
+		address = net.ParseIP(fields[0]).To4()
+	}
+	if (address == nil) {
+		return ErrRDataSyntax
+	}
+	rdata.Address = address
+	return nil
+}
+
+
+//
+// AAAA, IPv6 address (RFC 3596)
+//
+type RDataAAAA struct {
+	Address	net.IP
+}
+
+func (rdata *RDataAAAA) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	buffer.Write(rdata.Address.To16())
+	return nil
+}
+
+func (rdata *RDataAAAA) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length != net.IPv6len) {
+		return ErrRDataLength
+	}
+	rdata.Address = net.IP(append([]byte{}, rawBytes[offset:offset+length]...))
+	return nil
+}
+
+func (rdata *RDataAAAA) String() string {
+	return rdata.Address.String()
+}
+
+func (rdata *RDataAAAA) Parse(fields []string, origin string) error {
+	var address net.IP
+	if (len(fields) == 1 && strings.Contains(fields[0], ":")) {
+		address = net.ParseIP(fields[0])
+	}
+	if (address == nil) {
+		return ErrRDataSyntax
+	}
+	rdata.Address = address
+	return nil
+}
+
+
+//
+// NS, authoritative nameserver
+//
+type RDataNS struct {
+	Host	string
+}
+
+func (rdata *RDataNS) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	return packNameTo(buffer, rdata.Host, compression)
+}
+
+func (rdata *RDataNS) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	rdata.Host, err = unpackRDataName(rawBytes, offset, length)
+	return err
+}
+
+func (rdata *RDataNS) String() string {
+	return nameString(rdata.Host)
+}
+
+func (rdata *RDataNS) Parse(fields []string, origin string) error {
+	var err error
+	rdata.Host, err = parseRDataName(fields, origin)
+	return err
+}
+
+
+//
+// CNAME, canonical name for an alias
+//
+type RDataCNAME struct {
+	Target	string
+}
+
+func (rdata *RDataCNAME) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	return packNameTo(buffer, rdata.Target, compression)
+}
+
+func (rdata *RDataCNAME) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	rdata.Target, err = unpackRDataName(rawBytes, offset, length)
+	return err
+}
+
+func (rdata *RDataCNAME) String() string {
+	return nameString(rdata.Target)
+}
+
+func (rdata *RDataCNAME) Parse(fields []string, origin string) error {
+	var err error
+	rdata.Target, err = parseRDataName(fields, origin)
+	return err
+}
+
+
+//
+// DNAME, redirection for an entire subtree (RFC 6672).  The target is never
+// compressed (RFC 6672, 2.5)
+//
+type RDataDNAME struct {
+	Target	string
+}
+
+func (rdata *RDataDNAME) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	return packNameTo(buffer, rdata.Target, nil)
+}
+
+func (rdata *RDataDNAME) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	rdata.Target, err = unpackRDataName(rawBytes, offset, length)
+	return err
+}
+
+func (rdata *RDataDNAME) String() string {
+	return nameString(rdata.Target)
+}
+
+func (rdata *RDataDNAME) Parse(fields []string, origin string) error {
+	var err error
+	rdata.Target, err = parseRDataName(fields, origin)
+	return err
+}
+
+
+//
+// PTR, domain name pointer
+//
+type RDataPTR struct {
+	Host	string
+}
+
+func (rdata *RDataPTR) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	return packNameTo(buffer, rdata.Host, compression)
+}
+
+func (rdata *RDataPTR) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	rdata.Host, err = unpackRDataName(rawBytes, offset, length)
+	return err
+}
+
+func (rdata *RDataPTR) String() string {
+	return nameString(rdata.Host)
+}
+
+func (rdata *RDataPTR) Parse(fields []string, origin string) error {
+	var err error
+	rdata.Host, err = parseRDataName(fields, origin)
+	return err
+}
+
+
+//
+// MX, mail exchange
+//
+type RDataMX struct {
+	Preference	uint16
+	Exchange	string
+}
+
+func (rdata *RDataMX) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	binary.Write(buffer, binary.BigEndian, rdata.Preference)
+	return packNameTo(buffer, rdata.Exchange, compression)
+}
+
+func (rdata *RDataMX) Unpack(rawBytes []byte, offset int, length int) error {
+	if (length < 2) {
+		return ErrRDataLength
+	}
+	rdata.Preference = binary.BigEndian.Uint16(rawBytes[offset:])
+
+	var err error
+	rdata.Exchange, err = unpackRDataName(rawBytes, offset + 2, length - 2)
+	return err
+}
+
+func (rdata *RDataMX) String() string {
+	return fmt.Sprintf("%d %s", rdata.Preference, nameString(rdata.Exchange))
+}
+
+func (rdata *RDataMX) Parse(fields []string, origin string) error {
+	if (len(fields) != 2) {
+		return ErrRDataSyntax
+	}
+	preference, err := parseRDataUint(fields[0], 16)
+	if (err != nil) {
+		return err
+	}
+	rdata.Preference	= uint16(preference)
+	rdata.Exchange		= AbsoluteName(fields[1], origin)
+	return nil
+}
+
+
+//
+// SOA, start of a zone of authority
+//
+type RDataSOA struct {
+	MName		string
+	RName		string
+	Serial		uint32
+	Refresh		uint32
+	Retry		uint32
+	Expire		uint32
+	Minimum		uint32
+}
+const RDataSOAFixedSize = 20 // 5 fields, 32b each
+
+func (rdata *RDataSOA) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	err := packNameTo(buffer, rdata.MName, compression)
+	if (err != nil) {
+		return err
+	}
+	err = packNameTo(buffer, rdata.RName, compression)
+	if (err != nil) {
+		return err
+	}
+	binary.Write(buffer, binary.BigEndian, rdata.Serial)
+	binary.Write(buffer, binary.BigEndian, rdata.Refresh)
+	binary.Write(buffer, binary.BigEndian, rdata.Retry)
+	binary.Write(buffer, binary.BigEndian, rdata.Expire)
+	binary.Write(buffer, binary.BigEndian, rdata.Minimum)
+	return nil
+}
+
+func (rdata *RDataSOA) Unpack(rawBytes []byte, offset int, length int) error {
+	var mlen, rlen int
+	var err error
+	rdata.MName, mlen, err = UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return err
+	}
+	rdata.RName, rlen, err = UnpackName(rawBytes, offset + mlen)
+	if (err != nil) {
+		return err
+	}
+	if (mlen + rlen + RDataSOAFixedSize != length) {
+		return ErrRDataLength
+	}
+
+	// Remaining fields are fixed-size
+	fields := rawBytes[offset+mlen+rlen:]
+	rdata.Serial	= binary.BigEndian.Uint32(fields[0:])
+	rdata.Refresh	= binary.BigEndian.Uint32(fields[4:])
+	rdata.Retry		= binary.BigEndian.Uint32(fields[8:])
+	rdata.Expire	= binary.BigEndian.Uint32(fields[12:])
+	rdata.Minimum	= binary.BigEndian.Uint32(fields[16:])
+	return nil
+}
+
+func (rdata *RDataSOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d",
+		nameString(rdata.MName), nameString(rdata.RName), rdata.Serial,
+		rdata.Refresh, rdata.Retry, rdata.Expire, rdata.Minimum)
+}
+
+// The timers may use TTL units, e.g. 1h (see ParseTTL)
+func (rdata *RDataSOA) Parse(fields []string, origin string) error {
+	if (len(fields) != 7) {
+		return ErrRDataSyntax
+	}
+	rdata.MName = AbsoluteName(fields[0], origin)
+	rdata.RName = AbsoluteName(fields[1], origin)
+	serial, err := parseRDataUint(fields[2], 32)
+	if (err != nil) {
+		return err
+	}
+	rdata.Serial = uint32(serial)
+
+	timers := []*uint32{
+		&rdata.Refresh, &rdata.Retry, &rdata.Expire, &rdata.Minimum }
+	for i, timer := range timers {
+		*timer, err = ParseTTL(fields[3 + i])
+		if (err != nil) {
+			return fmt.Errorf("%w: %s", ErrRDataSyntax, fields[3 + i])
+		}
+	}
+	return nil
+}
+
+// Negative caching TTL, given the TTL of the SOA record itself: the lesser
+// of that + the MINIMUM (RFC 2308, 5).  The MINIMUM is unsigned, so compare
+// without wrapping it; a negative TTL counts as zero
+func (rdata *RDataSOA) NegativeTTL(ttl int32) int32 {
+	return int32(max(0, min(int64(ttl), int64(rdata.Minimum))))
+}
+
+
+//
+// TXT, one or more character-strings
+//
+type RDataTXT struct {
+	Strings	[]string
+}
+
+func (rdata *RDataTXT) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	// The length byte limits each character-string to 255 bytes
+	for _, s := range rdata.Strings {
+		if (len(s) > 255) {
+			return fmt.Errorf("%w: character-string too long", ErrRDataSyntax)
+		}
+	}
+	for _, s := range rdata.Strings {
+		buffer.WriteByte(byte(len(s)))
+		buffer.WriteString(s)
+	}
+	return nil
+}
+
+func (rdata *RDataTXT) Unpack(rawBytes []byte, offset int, length int) error {
+	rdata.Strings = []string{}
+
+	// Each character-string is a single length byte + the string itself
+	payload := rawBytes[offset:offset+length]
+	for (len(payload) > 0) {
+		stringLength := int(payload[0])
+		if (stringLength + 1 > len(payload)) {
+			return ErrRDataLength
+		}
+		rdata.Strings = append(rdata.Strings,
+			string(payload[1:stringLength+1]))
+		payload = payload[stringLength+1:]
+	}
+
+	return nil
+}
+
+func (rdata *RDataTXT) String() string {
+	quoted := make([]string, len(rdata.Strings))
+	for i, s := range rdata.Strings {
+		quoted[i] = quoteCharacterString(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (rdata *RDataTXT) Parse(fields []string, origin string) error {
+	if (len(fields) == 0) {
+		return ErrRDataSyntax
+	}
+	for _, field := range fields {
+		if (len(field) > 255) {
+			return fmt.Errorf("%w: character-string too long", ErrRDataSyntax)
+		}
+	}
+	rdata.Strings = append([]string{}, fields...)
+	return nil
+}
+
+// Presentation format of a single character-string: quoted, with embedded
+// quotes, backslashes and non-printable bytes escaped (RFC 1035, 5.1)
+func quoteCharacterString(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+			case c == '"' || c == '\\':
+				builder.WriteByte('\\')
+				builder.WriteByte(c)
+			case c < ' ' || c > '~':
+				fmt.Fprintf(&builder, "\\%03d", c)
+			default:
+				builder.WriteByte(c)
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+
+//
+// Any other RR type.  Payload is opaque, presented per RFC 3597
+//
+type RDataUnknown struct {
+	Bytes	[]byte
+}
+
+func (rdata *RDataUnknown) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	buffer.Write(rdata.Bytes)
+	return nil
+}
+
+func (rdata *RDataUnknown) Unpack(rawBytes []byte, offset int, length int) error {
+	rdata.Bytes = append([]byte{}, rawBytes[offset:offset+length]...)
+	return nil
+}
+
+func (rdata *RDataUnknown) String() string {
+	if (len(rdata.Bytes) == 0) {
+		return "\\# 0"
+	}
+	return fmt.Sprintf("\\# %d %x", len(rdata.Bytes), rdata.Bytes)
+}
+
+// Generic encoding, with the payload in hex (RFC 3597, 5), e.g. \# 2 dead
+func (rdata *RDataUnknown) Parse(fields []string, origin string) error {
+	if (len(fields) < 2 || fields[0] != "\\#") {
+		return ErrRDataSyntax
+	}
+	length, err := parseRDataUint(fields[1], 16)
+	if (err != nil) {
+		return err
+	}
+	payload, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if (err != nil || len(payload) != int(length)) {
+		return ErrRDataSyntax
+	}
+	rdata.Bytes = payload
+	return nil
+}
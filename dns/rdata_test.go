@@ -0,0 +1,219 @@
+package dns
+
+import(
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	)
+
+//
+// Validate typed RDATA packing, unpacking and presentation format
+//
+func TestRDataPacking(t *testing.T) {
+	testCases := []struct{
+		name			string
+		rtype			uint16
+		data			RData
+		presentation	string
+	}{
+		{ "A", RecordTypeA,
+			&RDataA{ net.ParseIP("192.0.2.1").To4() },
+			"192.0.2.1" },
+		{ "AAAA", RecordTypeAAAA,
+			&RDataAAAA{ net.ParseIP("2001:db8::1") },
+			"2001:db8::1" },
+		{ "NS", RecordTypeNS,
+			&RDataNS{ "ns1.example.com" },
+			"ns1.example.com" },
+		{ "CNAME", RecordTypeCNAME,
+			&RDataCNAME{ "www.example.com" },
+			"www.example.com" },
+		{ "DNAME", RecordTypeDNAME,
+			&RDataDNAME{ "example.net" },
+			"example.net" },
+		{ "PTR", RecordTypePTR,
+			&RDataPTR{ "host.example.com" },
+			"host.example.com" },
+		{ "MX", RecordTypeMX,
+			&RDataMX{ 10, "mail.example.com" },
+			"10 mail.example.com" },
+		{ "SOA", RecordTypeSOA,
+			&RDataSOA{ "ns1.example.com", "admin.example.com",
+				2021010101, 7200, 3600, 1209600, 300 },
+			"ns1.example.com admin.example.com 2021010101 7200 3600 1209600 300" },
+		{ "TXT", RecordTypeTXT,
+			&RDataTXT{ []string{ "v=spf1 -all", "say \"hi\"" } },
+			"\"v=spf1 -all\" \"say \\\"hi\\\"\"" },
+		{ "DNSKEY", RecordTypeDNSKEY,
+			&RDataDNSKEY{ 257, 3, AlgorithmED25519, []byte{ 0xDE, 0xAD, 0xBE, 0xEF } },
+			"257 3 15 3q2+7w== ; KSK, key tag 41389" },
+		{ "DS", RecordTypeDS,
+			&RDataDS{ 60485, 5, DigestSHA1, []byte{ 0x2B, 0xB1, 0x83, 0xAF } },
+			"60485 5 1 2BB183AF" },
+		{ "RRSIG", RecordTypeRRSIG,
+			&RDataRRSIG{ RRSIGHeader{ RecordTypeMX, AlgorithmRSASHA256, 2,
+				3600, 1704164645, 1701486245, 60485 }, "example.com",
+				[]byte{ 0xDE, 0xAD } },
+			"MX 8 2 3600 20240102030405 20231202030405 60485 example.com 3q0=" },
+		{ "NSEC", RecordTypeNSEC,
+			&RDataNSEC{ "host.example.com", []uint16{ RecordTypeA,
+				RecordTypeRRSIG, RecordTypeNSEC, 65280 } },
+			"host.example.com A RRSIG NSEC TYPE65280" },
+		{ "NSEC3", RecordTypeNSEC3,
+			&RDataNSEC3{ NSEC3Params{ NSEC3HashSHA1, NSEC3FlagOptOut, 12,
+				[]byte{ 0xAA, 0xBB, 0xCC, 0xDD } },
+				[]byte{ 0x05, 0x92, 0x5e, 0x9a }, []uint16{ RecordTypeMX } },
+			"1 1 12 AABBCCDD 0m95t6g MX" },
+		{ "NSEC3 without salt or types", RecordTypeNSEC3,
+			&RDataNSEC3{ NSEC3Params{ NSEC3HashSHA1, 0, 0, []byte{} },
+				[]byte{ 0x05, 0x92, 0x5e, 0x9a }, []uint16{} },
+			"1 0 0 - 0m95t6g" },
+		{ "NSEC3PARAM", RecordTypeNSEC3PARAM,
+			&RDataNSEC3PARAM{ NSEC3Params{ NSEC3HashSHA1, 0, 0, []byte{} } },
+			"1 0 0 -" },
+		{ "unknown", 65280,
+			&RDataUnknown{ []byte{ 0xDE, 0xAD } },
+			"\\# 2 dead" },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rr1 := ResourceRecord{
+				Name:	"example.com",
+				Type:	test.rtype,
+				Class:	RecordClassIN,
+				TTL:	60,
+				Data:	test.data,
+			}
+
+			// Pack the RR + unpack it again.  Expect the typed payload to
+			// survive the round trip
+			packedRR := mustPack(t, rr1)
+			rr2 := ResourceRecord{}
+			length, err := rr2.Unpack(packedRR, 0)
+			if (err != nil) {
+				t.Fatal("Unpacking error: ", err)
+			}
+			if (length != len(packedRR)) {
+				t.Error("Length mismatch during unpacking: ", length)
+			}
+			if !reflect.DeepEqual(rr1.Data, rr2.Data) {
+				t.Error("RDATA mismatch: ", rr1.Data, rr2.Data)
+			}
+			if (rr2.Data.String() != test.presentation) {
+				t.Error("Unexpected presentation format: ", rr2.Data)
+			}
+			if (strings.Contains(rdataString(rr2.Data), " ; ")) {
+				t.Error("Comment in the RDATA alone: ", rdataString(rr2.Data))
+			}
+
+			// Parse the presentation format back again
+			entries, err := tokenizeZone(test.presentation)
+			if (err != nil || len(entries) != 1) {
+				t.Fatal("Tokenizing error: ", err)
+			}
+			var fields []string
+			for _, field := range entries[0].fields {
+				fields = append(fields, field.text)
+			}
+			rr3 := NewRData(test.rtype)
+			err = rr3.Parse(fields, "")
+			if (err != nil) {
+				t.Fatal("Parsing error: ", err)
+			}
+			if !reflect.DeepEqual(rr1.Data, rr3) {
+				t.Error("Parsed RDATA mismatch: ", rr1.Data, rr3)
+			}
+		})
+	}
+}
+
+
+//
+// Validate rejection of RDATA that does not match RDLENGTH
+//
+func TestRDataLengthMismatch(t *testing.T) {
+	rr := ResourceRecord{
+		Name:	"example.com",
+		Type:	RecordTypeA,
+		Class:	RecordClassIN,
+		RData:	[]byte{ 1, 2, 3 },
+	}
+
+	_, err := rr.Unpack(mustPack(t, rr), 0)
+	if (err != ErrRDataLength) {
+		t.Error("Expected RDATA length error: ", err)
+	}
+}
+
+
+//
+// Validate rejection of TXT character-strings that do not fit the length byte
+//
+func TestTXTStringTooLong(t *testing.T) {
+	rr := ResourceRecord{
+		Name:	"example.com",
+		Type:	RecordTypeTXT,
+		Class:	RecordClassIN,
+		Data:	&RDataTXT{ Strings: []string{ strings.Repeat("a", 256) } },
+	}
+	_, err := rr.Pack()
+	if (!errors.Is(err, ErrRDataSyntax)) {
+		t.Error("Expected a syntax error: ", err)
+	}
+
+	rr.Data = &RDataTXT{ Strings: []string{ strings.Repeat("a", 255) } }
+	packed := mustPack(t, rr)
+	unpacked := ResourceRecord{}
+	_, err = unpacked.Unpack(packed, 0)
+	if (err != nil || !reflect.DeepEqual(unpacked.Data, rr.Data)) {
+		t.Error("Unexpected unpacked TXT: ", unpacked.Data, err)
+	}
+}
+
+
+//
+// Validate the negative TTL from an SOA, including MINIMUMs beyond int32
+//
+func TestSOANegativeTTL(t *testing.T) {
+	testCases := []struct{
+		ttl			int32
+		minimum		uint32
+		expected	int32
+	}{
+		{ 3600, 300, 300 },
+		{ 120, 300, 120 },
+		{ 3600, 0xFFFFFFFF, 3600 },
+		{ 3600, 0x80000000, 3600 },
+		{ -1, 300, 0 },
+	}
+	for _, testCase := range testCases {
+		soa := &RDataSOA{ Minimum: testCase.minimum }
+		if (soa.NegativeTTL(testCase.ttl) != testCase.expected) {
+			t.Errorf("TTL %d, minimum %d: unexpected negative TTL %d",
+				testCase.ttl, testCase.minimum, soa.NegativeTTL(testCase.ttl))
+		}
+	}
+}
+
+
+//
+// Validate that DNAME targets are never compressed, unlike CNAME targets
+//
+func TestDNAMECompression(t *testing.T) {
+	message := NewQuery("www.example.com", RecordTypeA)
+	message.AddAnswer(ResourceRecord{
+		Name:	"example.com",
+		Type:	RecordTypeDNAME,
+		Class:	RecordClassIN,
+		Data:	&RDataDNAME{ "example.com" },
+	})
+	packed := mustPack(t, message)
+	target, _ := PackName("example.com")
+	if (!bytes.HasSuffix(packed, target)) {
+		t.Error("Expected an uncompressed DNAME target: ", packed)
+	}
+}
@@ -0,0 +1,350 @@
+//
+// DNSSEC signatures.  An RRSIG signs the canonical form of an entire RRset
+// (RFC 4034, 6): lowercase owner names, uncompressed, with the RDATA sorted
+// + deduplicated, and the original TTL.  Verification supports the
+// algorithms a validator must implement today (RFC 8624): RSA/SHA-256, ECDSA
+// P-256/SHA-256, ECDSA P-384/SHA-384 and Ed25519.
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+
+// Digest algorithms for DS records
+var digestHashes = map[uint8]func() hash.Hash{
+		DigestSHA1:		sha1.New,
+		DigestSHA256:	sha256.New,
+		DigestSHA384:	sha512.New384,
+	}
+
+// Supported algorithms, which a validator treats as unsigned otherwise
+func SupportedAlgorithm(algorithm uint8) bool {
+	switch (algorithm) {
+		case AlgorithmRSASHA256, AlgorithmECDSAP256SHA256,
+			AlgorithmECDSAP384SHA384, AlgorithmED25519:
+			return true
+	}
+	return false
+}
+
+func SupportedDigest(digestType uint8) bool {
+	_, ok := digestHashes[digestType]
+	return ok
+}
+
+
+//
+// Canonical form + ordering (RFC 4034, 6)
+//
+
+// Number of labels in the name, as in the RRSIG labels field: excluding the
+// root, and any leading wildcard label
+func LabelCount(name string) int {
+	name = CanonicalName(name)
+	if (name == "") {
+		return 0
+	}
+	count := strings.Count(name, ".") + 1
+	if (strings.HasPrefix(name, "*.") || name == "*") {
+		count--
+	}
+	return count
+}
+
+// Compare two names in canonical order: label by label from the root, each
+// label as lowercase bytes.  Returns -1, 0 or 1, as with strings.Compare
+func CompareNames(a string, b string) int {
+	aLabels := reverseLabels(a)
+	bLabels := reverseLabels(b)
+	for i := 0; i < len(aLabels) && i < len(bLabels); i++ {
+		result := strings.Compare(aLabels[i], bLabels[i])
+		if (result != 0) {
+			return result
+		}
+	}
+	return compareInts(len(aLabels), len(bLabels))
+}
+
+func compareInts(a int, b int) int {
+	if (a < b) {
+		return -1
+	} else if (a > b) {
+		return 1
+	}
+	return 0
+}
+
+// Labels of the canonical name, starting with the top-level
+func reverseLabels(name string) []string {
+	name = CanonicalName(name)
+	if (name == "") {
+		return nil
+	}
+	labels := strings.Split(name, ".")
+	for i, j := 0, len(labels) - 1; i < j; i, j = i + 1, j - 1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+// RDATA in canonical form: uncompressed, with the embedded names of the
+// older types in lowercase (RFC 4034, 6.2 + RFC 6840, 5.1)
+func canonicalRData(rr ResourceRecord) []byte {
+	if (rr.Data == nil) {
+		return rr.RData
+	}
+	data := rr.Data
+	switch typed := rr.Data.(type) {
+		case *RDataNS:
+			data = &RDataNS{ Host: CanonicalName(typed.Host) }
+		case *RDataCNAME:
+			data = &RDataCNAME{ Target: CanonicalName(typed.Target) }
+		case *RDataDNAME:
+			data = &RDataDNAME{ Target: CanonicalName(typed.Target) }
+		case *RDataPTR:
+			data = &RDataPTR{ Host: CanonicalName(typed.Host) }
+		case *RDataMX:
+			data = &RDataMX{ Preference: typed.Preference,
+				Exchange: CanonicalName(typed.Exchange) }
+		case *RDataSOA:
+			soa := *typed
+			soa.MName = CanonicalName(soa.MName)
+			soa.RName = CanonicalName(soa.RName)
+			data = &soa
+		case *RDataRRSIG:
+			rrsig := *typed
+			rrsig.SignerName = CanonicalName(rrsig.SignerName)
+			data = &rrsig
+	}
+	buffer := new(bytes.Buffer)
+	data.Pack(buffer, nil)
+	return buffer.Bytes()
+}
+
+// The data an RRSIG signs: its own RDATA, less the signature, then each RR
+// of the set in canonical form + order, with the original TTL.  The owner
+// of a wildcard expansion is the wildcard itself (RFC 4034, 3.1.8.1)
+func signedData(rrset []ResourceRecord, rrsig *RDataRRSIG) []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, rrsig.RRSIGHeader)
+	packNameTo(buffer, CanonicalName(rrsig.SignerName), nil)
+
+	owner := CanonicalName(rrset[0].Name)
+	if (LabelCount(owner) > int(rrsig.Labels)) {
+		labels := strings.Split(owner, ".")
+		owner = strings.Join(append([]string{ "*" },
+			labels[len(labels) - int(rrsig.Labels):]...), ".")
+	}
+
+	rdatas := make([][]byte, len(rrset))
+	for i, rr := range rrset {
+		rdatas[i] = canonicalRData(rr)
+	}
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+	for i, rdata := range rdatas {
+		if (i > 0 && bytes.Equal(rdata, rdatas[i - 1])) {
+			continue
+		}
+		packNameTo(buffer, owner, nil)
+		binary.Write(buffer, binary.BigEndian, rrset[0].Type)
+		binary.Write(buffer, binary.BigEndian, rrset[0].Class)
+		binary.Write(buffer, binary.BigEndian, rrsig.OriginalTTL)
+		binary.Write(buffer, binary.BigEndian, uint16(len(rdata)))
+		buffer.Write(rdata)
+	}
+	return buffer.Bytes()
+}
+
+
+//
+// Verification
+//
+
+// Verify the signature over the RRset with the key, at the given time.  The
+// caller is responsible for the key being a trusted key of the signer zone
+func (rdata *RDataRRSIG) Verify(rrset []ResourceRecord, key *RDataDNSKEY,
+	now time.Time) error {
+	if (len(rrset) == 0) {
+		return ErrRRSIGMismatch
+	}
+	owner := rrset[0].Name
+	for _, rr := range rrset {
+		if (CanonicalName(rr.Name) != CanonicalName(owner) ||
+			rr.Type != rrset[0].Type || rr.Class != rrset[0].Class) {
+			return ErrRRSIGMismatch
+		}
+	}
+	if (rdata.TypeCovered != rrset[0].Type ||
+		int(rdata.Labels) > LabelCount(owner) ||
+		!IsSubdomain(owner, rdata.SignerName) ||
+		rdata.Algorithm != key.Algorithm || rdata.KeyTag != key.KeyTag() ||
+		key.Protocol != DNSKEYProtocol || key.Flags & DNSKEYFlagZone == 0 ||
+		key.Flags & DNSKEYFlagRevoke != 0) {
+		return ErrRRSIGMismatch
+	}
+
+	// Times are compared with serial number arithmetic (RFC 4034, 3.1.5)
+	current := uint32(now.Unix())
+	if (int32(current - rdata.Expiration) > 0) {
+		return fmt.Errorf("%w: at %s", ErrSignatureExpired,
+			SignatureTimeString(rdata.Expiration))
+	}
+	if (int32(rdata.Inception - current) > 0) {
+		return fmt.Errorf("%w: until %s", ErrSignatureNotYetValid,
+			SignatureTimeString(rdata.Inception))
+	}
+
+	return verifySignature(key, signedData(rrset, rdata), rdata.Signature)
+}
+
+func verifySignature(key *RDataDNSKEY, data []byte, signature []byte) error {
+	publicKey, err := key.CryptoPublicKey()
+	if (err != nil) {
+		return err
+	}
+
+	valid := false
+	switch (key.Algorithm) {
+		case AlgorithmRSASHA256:
+			digest := sha256.Sum256(data)
+			valid = (rsa.VerifyPKCS1v15(publicKey.(*rsa.PublicKey),
+				crypto.SHA256, digest[:], signature) == nil)
+		case AlgorithmECDSAP256SHA256:
+			digest := sha256.Sum256(data)
+			valid = verifyECDSA(publicKey.(*ecdsa.PublicKey), digest[:],
+				signature)
+		case AlgorithmECDSAP384SHA384:
+			digest := sha512.Sum384(data)
+			valid = verifyECDSA(publicKey.(*ecdsa.PublicKey), digest[:],
+				signature)
+		case AlgorithmED25519:
+			valid = ed25519.Verify(publicKey.(ed25519.PublicKey), data,
+				signature)
+	}
+	if (!valid) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// ECDSA signatures are r + s, each the size of the curve (RFC 6605, 4)
+func verifyECDSA(publicKey *ecdsa.PublicKey, digest []byte,
+	signature []byte) bool {
+	size := (publicKey.Curve.Params().BitSize + 7) / 8
+	if (len(signature) != 2 * size) {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	return ecdsa.Verify(publicKey, digest, r, s)
+}
+
+// The public key as the crypto package expects it, for the supported
+// algorithms: *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (rdata *RDataDNSKEY) CryptoPublicKey() (crypto.PublicKey, error) {
+	key := rdata.PublicKey
+	switch (rdata.Algorithm) {
+		case AlgorithmRSASHA256:
+			// Exponent length, in either one or three bytes, then the
+			// exponent + modulus (RFC 3110, 2)
+			if (len(key) < 1) {
+				return nil, ErrPublicKey
+			}
+			length := int(key[0])
+			key = key[1:]
+			if (length == 0) {
+				if (len(key) < 2) {
+					return nil, ErrPublicKey
+				}
+				length = int(binary.BigEndian.Uint16(key))
+				key = key[2:]
+			}
+			if (length == 0 || length > 4 || len(key) <= length) {
+				return nil, ErrPublicKey
+			}
+			exponent := new(big.Int).SetBytes(key[:length])
+			return &rsa.PublicKey{
+				N:	new(big.Int).SetBytes(key[length:]),
+				E:	int(exponent.Int64()),
+			}, nil
+
+		case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+			// Uncompressed point, x + y, without the usual 0x04 prefix
+			curve := elliptic.P256()
+			if (rdata.Algorithm == AlgorithmECDSAP384SHA384) {
+				curve = elliptic.P384()
+			}
+			size := (curve.Params().BitSize + 7) / 8
+			if (len(key) != 2 * size) {
+				return nil, ErrPublicKey
+			}
+			x := new(big.Int).SetBytes(key[:size])
+			y := new(big.Int).SetBytes(key[size:])
+			if (!curve.IsOnCurve(x, y)) {
+				return nil, ErrPublicKey
+			}
+			return &ecdsa.PublicKey{ Curve: curve, X: x, Y: y }, nil
+
+		case AlgorithmED25519:
+			if (len(key) != ed25519.PublicKeySize) {
+				return nil, ErrPublicKey
+			}
+			return ed25519.PublicKey(key), nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrDNSSECAlgorithm, rdata.Algorithm)
+}
+
+
+// DS record for the key, as published in the parent zone: a digest over the
+// owner name + DNSKEY RDATA (RFC 4034, 5.1.4)
+func (rdata *RDataDNSKEY) DS(owner string, digestType uint8) (*RDataDS, error) {
+	newHash, ok := digestHashes[digestType]
+	if (!ok) {
+		return nil, fmt.Errorf("%w: %d", ErrDigestType, digestType)
+	}
+	owned, err := PackName(CanonicalName(owner))
+	if (err != nil) {
+		return nil, err
+	}
+	digest := newHash()
+	digest.Write(owned)
+	buffer := new(bytes.Buffer)
+	rdata.Pack(buffer, nil)
+	digest.Write(buffer.Bytes())
+
+	return &RDataDS{
+		KeyTag:		rdata.KeyTag(),
+		Algorithm:	rdata.Algorithm,
+		DigestType:	digestType,
+		Digest:		digest.Sum(nil),
+	}, nil
+}
+
+// Does the DS refer to this key?
+func (rdata *RDataDNSKEY) Matches(owner string, ds *RDataDS) bool {
+	if (ds.KeyTag != rdata.KeyTag() || ds.Algorithm != rdata.Algorithm) {
+		return false
+	}
+	computed, err := rdata.DS(owner, ds.DigestType)
+	return (err == nil && bytes.Equal(computed.Digest, ds.Digest))
+}
@@ -0,0 +1,255 @@
+package dns
+
+import(
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	)
+
+// Ed25519 example from RFC 8080, 6.1
+const ed25519Zone = `
+$ORIGIN example.com.
+@	3600	IN	DNSKEY	257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=
+@	3600	IN	MX		10 mail.example.com.
+@	3600	IN	RRSIG	MX 15 2 3600 1440021600 1438207200 3613 example.com. (
+			oL9krJun7xfBOIWcGHi7mag5/hdZrKWw15jPGrHpjQeRAvTdszaPD+QLs3fx8A4M3e23
+			mRZ9VrbpMngwcrqNAg== )
+`
+
+var ed25519Time = time.Unix(1439000000, 0)
+
+// The key, signed RRset + signature of the example
+func ed25519Example(t *testing.T) (*RDataDNSKEY, []ResourceRecord, *RDataRRSIG) {
+	records, err := ParseZone(strings.NewReader(ed25519Zone), "", "test")
+	if (err != nil || len(records) != 3) {
+		t.Fatal("Parsing error: ", err)
+	}
+	return records[0].Data.(*RDataDNSKEY), records[1:2],
+		records[2].Data.(*RDataRRSIG)
+}
+
+//
+// Validate signature verification against RFC 8080, including the checks of
+// the RRSIG against the key + RRset
+//
+func TestVerifyRRSIG(t *testing.T) {
+	key, rrset, rrsig := ed25519Example(t)
+	err := rrsig.Verify(rrset, key, ed25519Time)
+	if (err != nil) {
+		t.Fatal("Verification error: ", err)
+	}
+
+	// Owner names + names in the RDATA are compared in canonical form
+	rrset[0].Name = "EXAMPLE.com"
+	rrset[0].Data = &RDataMX{ Preference: 10, Exchange: "Mail.Example.COM" }
+	err = rrsig.Verify(rrset, key, ed25519Time)
+	if (err != nil) {
+		t.Error("Verification error in mixed case: ", err)
+	}
+
+	// Duplicate records are only signed once
+	err = rrsig.Verify(append(rrset, rrset[0]), key, ed25519Time)
+	if (err != nil) {
+		t.Error("Verification error with duplicates: ", err)
+	}
+
+	testCases := []struct{
+		description	string
+		alter		func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG)
+		now			time.Time
+		expected	error
+	}{
+		{ "Altered RDATA", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			rrset[0].Data = &RDataMX{ Preference: 20, Exchange: "mail.example.com" }
+		}, ed25519Time, ErrSignature },
+		{ "Altered signature", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			rrsig.Signature[0] ^= 1
+		}, ed25519Time, ErrSignature },
+		{ "Other owner", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			rrset[0].Name = "example.net"
+		}, ed25519Time, ErrRRSIGMismatch },
+		{ "Other type", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			rrsig.TypeCovered = RecordTypeA
+		}, ed25519Time, ErrRRSIGMismatch },
+		{ "Revoked key", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			key.Flags |= DNSKEYFlagRevoke
+		}, ed25519Time, ErrRRSIGMismatch },
+		{ "Not a zone key", func(key *RDataDNSKEY, rrset []ResourceRecord,
+			rrsig *RDataRRSIG) {
+			key.Flags = DNSKEYFlagSEP
+			rrsig.KeyTag = key.KeyTag()
+		}, ed25519Time, ErrRRSIGMismatch },
+		{ "Expired", nil, time.Unix(1440021601, 0), ErrSignatureExpired },
+		{ "Not yet valid", nil, time.Unix(1438207199, 0),
+			ErrSignatureNotYetValid },
+	}
+	for _, testCase := range testCases {
+		key, rrset, rrsig := ed25519Example(t)
+		if (testCase.alter != nil) {
+			testCase.alter(key, rrset, rrsig)
+		}
+		err := rrsig.Verify(rrset, key, testCase.now)
+		if (!errors.Is(err, testCase.expected)) {
+			t.Errorf("%s: expected %s, got %v", testCase.description,
+				testCase.expected, err)
+		}
+	}
+}
+
+//
+// Validate DS digests against RFC 8080 + RFC 4034, 5.4
+//
+func TestDS(t *testing.T) {
+	key, _, _ := ed25519Example(t)
+	ds, err := key.DS("example.com", DigestSHA256)
+	if (err != nil) {
+		t.Fatal("Digest error: ", err)
+	}
+	if (ds.String() != "3613 15 2 3AA5AB37EFCE57F737FC1627013FEE07BDF241BD10F3B1964AB55C78E79A304B") {
+		t.Error("Unexpected DS: ", ds)
+	}
+	if (!key.Matches("Example.COM.", ds) || key.Matches("example.net", ds)) {
+		t.Error("Unexpected DS match")
+	}
+
+	key = &RDataDNSKEY{}
+	key.Parse(splitFields(exampleDNSKEY), "")
+	ds, err = key.DS("dskey.example.com", DigestSHA1)
+	if (err != nil ||
+		ds.String() != "60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118") {
+		t.Error("Unexpected DS: ", ds, err)
+	}
+
+	_, err = key.DS("dskey.example.com", 3)
+	if (!errors.Is(err, ErrDigestType)) {
+		t.Error("Expected ErrDigestType, got ", err)
+	}
+}
+
+//
+// Validate the conversion of each supported algorithm's public key, and
+// malformed keys
+//
+func TestCryptoPublicKey(t *testing.T) {
+	key, _, _ := ed25519Example(t)
+	publicKey, err := key.CryptoPublicKey()
+	if (err != nil || !bytes.Equal(publicKey.(ed25519.PublicKey), key.PublicKey)) {
+		t.Error("Unexpected Ed25519 key: ", publicKey, err)
+	}
+
+	// RFC 6605, 6.1
+	key = &RDataDNSKEY{}
+	key.Parse(splitFields("257 3 13 GojIhhXUN/u4v54ZQqGSnyhWJwaubCvTmeexv7bR6edb" +
+		"krSqQpF64cYbcB7wNcP+e+MAnLr+Wi9xMWyQLc8NAA=="), "")
+	publicKey, err = key.CryptoPublicKey()
+	if (err != nil || publicKey.(*ecdsa.PublicKey).Curve.Params().Name != "P-256") {
+		t.Error("Unexpected ECDSA key: ", publicKey, err)
+	}
+
+	key = &RDataDNSKEY{}
+	key.Parse(splitFields(exampleDNSKEY), "")
+	key.Algorithm = AlgorithmRSASHA256
+	_, err = key.CryptoPublicKey()
+	if (err != nil) {
+		t.Error("Unexpected RSA key error: ", err)
+	}
+
+	testCases := []struct{
+		algorithm	uint8
+		publicKey	[]byte
+		expected	error
+	}{
+		{ AlgorithmRSASHA256, []byte{}, ErrPublicKey },
+		{ AlgorithmRSASHA256, []byte{ 0, 0, 0 }, ErrPublicKey },
+		{ AlgorithmRSASHA256, []byte{ 3, 1, 0, 1 }, ErrPublicKey },
+		{ AlgorithmECDSAP256SHA256, make([]byte, 64), ErrPublicKey },
+		{ AlgorithmECDSAP384SHA384, make([]byte, 64), ErrPublicKey },
+		{ AlgorithmED25519, make([]byte, 31), ErrPublicKey },
+		{ AlgorithmRSAMD5, make([]byte, 64), ErrDNSSECAlgorithm },
+	}
+	for _, testCase := range testCases {
+		key := &RDataDNSKEY{ Flags: DNSKEYFlagZone, Protocol: DNSKEYProtocol,
+			Algorithm: testCase.algorithm, PublicKey: testCase.publicKey }
+		_, err := key.CryptoPublicKey()
+		if (!errors.Is(err, testCase.expected)) {
+			t.Errorf("Algorithm %d, key %x: expected %s, got %v",
+				testCase.algorithm, testCase.publicKey, testCase.expected, err)
+		}
+	}
+}
+
+//
+// Validate canonical ordering against RFC 4034, 6.1, and label counts
+//
+func TestCompareNames(t *testing.T) {
+	ordered := []string{ "", "example", "a.example", "yljkjljk.a.example",
+		"Z.a.example", "zABC.a.EXAMPLE", "z.example", "*.z.example" }
+	for i := range ordered {
+		for j := range ordered {
+			expected := compareInts(i, j)
+			if (strings.EqualFold(ordered[i], ordered[j])) {
+				expected = 0
+			}
+			if (CompareNames(ordered[i], ordered[j]) != expected) {
+				t.Errorf("Unexpected order of %s + %s", ordered[i], ordered[j])
+			}
+		}
+	}
+
+	labels := map[string]int{
+		"":						0,
+		"example":				1,
+		"www.Example.COM.":		3,
+		"*.example.com":		2,
+		"*":					0,
+	}
+	for name, count := range labels {
+		if (LabelCount(name) != count) {
+			t.Errorf("Unexpected label count for %s: %d", name,
+				LabelCount(name))
+		}
+	}
+}
+
+//
+// Validate the canonical form of RDATA: lowercase names for the older types
+// only, and never compressed
+//
+func TestCanonicalRData(t *testing.T) {
+	testCases := []struct{
+		rr			ResourceRecord
+		expected	[]byte
+	}{
+		{ ResourceRecord{ Type: RecordTypeCNAME,
+			Data: &RDataCNAME{ Target: "WWW.Example" } },
+			[]byte("\x03www\x07example\x00") },
+		{ ResourceRecord{ Type: RecordTypeMX,
+			Data: &RDataMX{ Preference: 10, Exchange: "Mail.Example" } },
+			[]byte("\x00\x0a\x04mail\x07example\x00") },
+		{ ResourceRecord{ Type: RecordTypeNSEC,
+			Data: &RDataNSEC{ NextDomain: "WWW.Example",
+				Types: []uint16{ RecordTypeA } } },
+			[]byte("\x03WWW\x07Example\x00\x00\x01\x40") },
+		{ ResourceRecord{ Type: RecordTypeA,
+			Data: &RDataA{ Address: net.ParseIP("192.0.2.1").To4() } },
+			[]byte{ 192, 0, 2, 1 } },
+	}
+	for _, testCase := range testCases {
+		rdata := canonicalRData(testCase.rr)
+		if (!bytes.Equal(rdata, testCase.expected)) {
+			t.Errorf("Unexpected canonical form of %s: %q",
+				ZoneString(testCase.rr), rdata)
+		}
+	}
+}
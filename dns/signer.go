@@ -0,0 +1,707 @@
+//
+// DNSSEC signing: key pairs, as generated by keygen and stored in the file
+// formats of BIND's dnssec-keygen, and zone signing.  SignZone signs each
+// authoritative RRset of a zone, then builds the NSEC or NSEC3 chain that
+// proves non-existence, so that the output is ready to serve.
+//
+
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+const DefaultRSABits		= 2048
+const DefaultDNSKEYTTL		= 3600
+
+// Mnemonics of the supported algorithms (RFC 8624), as in key files
+var AlgorithmNames = map[uint8]string{
+		AlgorithmRSASHA256:			"RSASHA256",
+		AlgorithmECDSAP256SHA256:	"ECDSAP256SHA256",
+		AlgorithmECDSAP384SHA384:	"ECDSAP384SHA384",
+		AlgorithmED25519:			"ED25519",
+	}
+
+// Algorithm by mnemonic or number, or zero if unsupported
+func ParseAlgorithm(field string) uint8 {
+	for algorithm, name := range AlgorithmNames {
+		if (strings.EqualFold(field, name) ||
+			field == strconv.Itoa(int(algorithm))) {
+			return algorithm
+		}
+	}
+	return 0
+}
+
+
+//
+// Key pair of the zone, i.e. the DNSKEY + its private key
+//
+type SigningKey struct {
+	Name	string	// Zone, i.e. the owner of the DNSKEY
+	DNSKEY	RDataDNSKEY
+	Private	crypto.Signer
+}
+
+// Generate a new key pair, as a KSK if the flags include DNSKEYFlagSEP.  The
+// size only applies to RSA keys, and defaults to DefaultRSABits
+func GenerateSigningKey(name string, algorithm uint8, flags uint16,
+	bits int) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch (algorithm) {
+		case AlgorithmRSASHA256:
+			if (bits == 0) {
+				bits = DefaultRSABits
+			}
+			private, err = rsa.GenerateKey(rand.Reader, bits)
+		case AlgorithmECDSAP256SHA256:
+			private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		case AlgorithmECDSAP384SHA384:
+			private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		case AlgorithmED25519:
+			_, private, err = ed25519.GenerateKey(rand.Reader)
+		default:
+			return nil, fmt.Errorf("%w: %d", ErrDNSSECAlgorithm, algorithm)
+	}
+	if (err != nil) {
+		return nil, err
+	}
+	return newSigningKey(name, algorithm, flags, private)
+}
+
+// The DNSKEY for the private key, with the public key in the format of the
+// algorithm: RFC 3110 for RSA, RFC 6605 for ECDSA + RFC 8080 for Ed25519
+func newSigningKey(name string, algorithm uint8, flags uint16,
+	private crypto.Signer) (*SigningKey, error) {
+	var publicKey []byte
+	switch typed := private.(type) {
+		case *rsa.PrivateKey:
+			exponent := big.NewInt(int64(typed.E)).Bytes()
+			if (len(exponent) > 255) {
+				return nil, ErrPublicKey
+			}
+			publicKey = append([]byte{ byte(len(exponent)) }, exponent...)
+			publicKey = append(publicKey, typed.N.Bytes()...)
+		case *ecdsa.PrivateKey:
+			size := (typed.Curve.Params().BitSize + 7) / 8
+			publicKey = make([]byte, 2 * size)
+			typed.X.FillBytes(publicKey[:size])
+			typed.Y.FillBytes(publicKey[size:])
+		case ed25519.PrivateKey:
+			publicKey = append([]byte{}, typed.Public().(ed25519.PublicKey)...)
+	}
+	key := &SigningKey{
+		Name:		CanonicalName(name),
+		DNSKEY:		RDataDNSKEY{
+			Flags:		flags | DNSKEYFlagZone,
+			Protocol:	DNSKEYProtocol,
+			Algorithm:	algorithm,
+			PublicKey:	publicKey,
+		},
+		Private:	private,
+	}
+
+	// The key must be one the algorithm can actually verify with
+	_, err := key.DNSKEY.CryptoPublicKey()
+	if (err != nil) {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Is this a key-signing key, which only signs the DNSKEY RRset?
+func (key *SigningKey) KSK() bool {
+	return (key.DNSKEY.Flags & DNSKEYFlagSEP != 0)
+}
+
+func (key *SigningKey) Record(ttl int32) ResourceRecord {
+	dnskey := key.DNSKEY
+	return ResourceRecord{
+		Name:	key.Name,
+		Type:	RecordTypeDNSKEY,
+		Class:	RecordClassIN,
+		TTL:	ttl,
+		Data:	&dnskey,
+	}
+}
+
+// Base name of the key files, as Kname.+algorithm+tag, e.g.
+// Kexample.com.+013+12345
+func (key *SigningKey) FileName() string {
+	return fmt.Sprintf("K%s.+%03d+%05d", key.Name, key.DNSKEY.Algorithm,
+		key.DNSKEY.KeyTag())
+}
+
+// Sign the RRset, with the given validity period.  The original TTL is the
+// smallest of the RRset
+func (key *SigningKey) Sign(rrset []ResourceRecord, inception uint32,
+	expiration uint32) (ResourceRecord, error) {
+	ttl := rrset[0].TTL
+	for _, rr := range rrset {
+		ttl = min(ttl, rr.TTL)
+	}
+	rrsig := &RDataRRSIG{
+		RRSIGHeader:	RRSIGHeader{
+			TypeCovered:	rrset[0].Type,
+			Algorithm:		key.DNSKEY.Algorithm,
+			Labels:			uint8(LabelCount(rrset[0].Name)),
+			OriginalTTL:	uint32(ttl),
+			Expiration:		expiration,
+			Inception:		inception,
+			KeyTag:			key.DNSKEY.KeyTag(),
+		},
+		SignerName:		key.Name,
+	}
+
+	var err error
+	data := signedData(rrset, rrsig)
+	switch (key.DNSKEY.Algorithm) {
+		case AlgorithmRSASHA256:
+			digest := sha256.Sum256(data)
+			rrsig.Signature, err = key.Private.Sign(rand.Reader, digest[:],
+				crypto.SHA256)
+		case AlgorithmECDSAP256SHA256:
+			digest := sha256.Sum256(data)
+			rrsig.Signature, err = signECDSA(key.Private, digest[:])
+		case AlgorithmECDSAP384SHA384:
+			digest := sha512.Sum384(data)
+			rrsig.Signature, err = signECDSA(key.Private, digest[:])
+		case AlgorithmED25519:
+			rrsig.Signature, err = key.Private.Sign(rand.Reader, data,
+				crypto.Hash(0))
+		default:
+			err = fmt.Errorf("%w: %d", ErrDNSSECAlgorithm,
+				key.DNSKEY.Algorithm)
+	}
+	if (err != nil) {
+		return ResourceRecord{}, err
+	}
+	return ResourceRecord{
+		Name:	rrset[0].Name,
+		Type:	RecordTypeRRSIG,
+		Class:	rrset[0].Class,
+		TTL:	ttl,
+		Data:	rrsig,
+	}, nil
+}
+
+// ECDSA signatures are r + s, each the size of the curve, rather than ASN.1
+func signECDSA(private crypto.Signer, digest []byte) ([]byte, error) {
+	typed, ok := private.(*ecdsa.PrivateKey)
+	if (!ok) {
+		return nil, ErrSigningKey
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, typed, digest)
+	if (err != nil) {
+		return nil, err
+	}
+	size := (typed.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2 * size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+	return signature, nil
+}
+
+
+//
+// Key files, as with BIND's dnssec-keygen: the DNSKEY record in Kname.key,
+// and the private key in Kname.private, as "Field: value" lines in the
+// Private-key-format v1.3
+//
+
+// Write both of the key files into the directory.  Returns the base path
+func SaveSigningKey(key *SigningKey, directory string) (string, error) {
+	base := filepath.Join(directory, key.FileName())
+	role := "zone-signing"
+	if (key.KSK()) {
+		role = "key-signing"
+	}
+	public := fmt.Sprintf("; This is a %s key, keyid %d, for %s.\n%s\n",
+		role, key.DNSKEY.KeyTag(), key.Name,
+		ZoneString(key.Record(DefaultDNSKEYTTL)))
+	err := os.WriteFile(base + ".key", []byte(public), 0644)
+	if (err == nil) {
+		err = os.WriteFile(base + ".private", key.privateFile(), 0600)
+	}
+	return base, err
+}
+
+func (key *SigningKey) privateFile() []byte {
+	buffer := new(bytes.Buffer)
+	field := func(name string, value []byte) {
+		fmt.Fprintf(buffer, "%s: %s\n", name,
+			base64.StdEncoding.EncodeToString(value))
+	}
+	fmt.Fprintf(buffer, "Private-key-format: v1.3\nAlgorithm: %d (%s)\n",
+		key.DNSKEY.Algorithm, AlgorithmNames[key.DNSKEY.Algorithm])
+	switch typed := key.Private.(type) {
+		case *rsa.PrivateKey:
+			field("Modulus", typed.N.Bytes())
+			field("PublicExponent", big.NewInt(int64(typed.E)).Bytes())
+			field("PrivateExponent", typed.D.Bytes())
+			field("Prime1", typed.Primes[0].Bytes())
+			field("Prime2", typed.Primes[1].Bytes())
+			field("Exponent1", typed.Precomputed.Dp.Bytes())
+			field("Exponent2", typed.Precomputed.Dq.Bytes())
+			field("Coefficient", typed.Precomputed.Qinv.Bytes())
+		case *ecdsa.PrivateKey:
+			size := (typed.Curve.Params().BitSize + 7) / 8
+			field("PrivateKey", typed.D.FillBytes(make([]byte, size)))
+		case ed25519.PrivateKey:
+			field("PrivateKey", typed.Seed())
+	}
+	return buffer.Bytes()
+}
+
+// Load a key pair, given the path of either key file, or their base path
+func LoadSigningKey(path string) (*SigningKey, error) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, ".key"), ".private")
+	file, err := os.Open(base + ".key")
+	if (err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+
+	// BIND omits the TTL
+	records, err := ParseZone(io.MultiReader(strings.NewReader("$TTL 3600\n"),
+		file), "", base + ".key")
+	if (err != nil) {
+		return nil, err
+	}
+	if (len(records) != 1 || records[0].Type != RecordTypeDNSKEY) {
+		return nil, fmt.Errorf("%w: expected a single DNSKEY in %s.key",
+			ErrSigningKey, base)
+	}
+	dnskey := records[0].Data.(*RDataDNSKEY)
+
+	text, err := os.ReadFile(base + ".private")
+	if (err != nil) {
+		return nil, err
+	}
+	private, err := parsePrivateKey(text, dnskey.Algorithm)
+	if (err != nil) {
+		return nil, fmt.Errorf("%w: %s.private: %w", ErrSigningKey, base, err)
+	}
+	key, err := newSigningKey(records[0].Name, dnskey.Algorithm,
+		dnskey.Flags, private)
+	if (err != nil) {
+		return nil, err
+	}
+	if (!bytes.Equal(key.DNSKEY.PublicKey, dnskey.PublicKey)) {
+		return nil, fmt.Errorf("%w: %s.private does not match the DNSKEY",
+			ErrSigningKey, base)
+	}
+	return key, nil
+}
+
+func parsePrivateKey(text []byte, algorithm uint8) (crypto.Signer, error) {
+	fields := map[string][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for (scanner.Scan()) {
+		name, value, ok := strings.Cut(scanner.Text(), ":")
+		if (!ok || name == "Private-key-format" || name == "Created" ||
+			name == "Publish" || name == "Activate") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if (name == "Algorithm") {
+			number, _, _ := strings.Cut(value, " ")
+			if (number != strconv.Itoa(int(algorithm))) {
+				return nil, fmt.Errorf("algorithm %s", value)
+			}
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if (err != nil) {
+			return nil, fmt.Errorf("%s is not base64", name)
+		}
+		fields[name] = decoded
+	}
+	number := func(name string) *big.Int {
+		return new(big.Int).SetBytes(fields[name])
+	}
+
+	switch (algorithm) {
+		case AlgorithmRSASHA256:
+			private := &rsa.PrivateKey{
+				PublicKey:	rsa.PublicKey{
+					N:	number("Modulus"),
+					E:	int(number("PublicExponent").Int64()),
+				},
+				D:			number("PrivateExponent"),
+				Primes:		[]*big.Int{ number("Prime1"), number("Prime2") },
+			}
+			err := private.Validate()
+			if (err != nil) {
+				return nil, err
+			}
+			private.Precompute()
+			return private, nil
+
+		case AlgorithmECDSAP256SHA256, AlgorithmECDSAP384SHA384:
+			curve := elliptic.P256()
+			if (algorithm == AlgorithmECDSAP384SHA384) {
+				curve = elliptic.P384()
+			}
+			size := (curve.Params().BitSize + 7) / 8
+			if (len(fields["PrivateKey"]) != size) {
+				return nil, fmt.Errorf("PrivateKey is not %d bytes", size)
+			}
+			private := &ecdsa.PrivateKey{ D: number("PrivateKey") }
+			private.Curve = curve
+			private.X, private.Y = curve.ScalarBaseMult(fields["PrivateKey"])
+			return private, nil
+
+		case AlgorithmED25519:
+			if (len(fields["PrivateKey"]) != ed25519.SeedSize) {
+				return nil, fmt.Errorf("PrivateKey is not %d bytes",
+					ed25519.SeedSize)
+			}
+			return ed25519.NewKeyFromSeed(fields["PrivateKey"]), nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrDNSSECAlgorithm, algorithm)
+}
+
+
+//
+// Zone signing
+//
+type SigningOptions struct {
+	Inception	uint32			// Signature validity, as in RRSIG records
+	Expiration	uint32
+	NSEC3		*NSEC3Params	// Hashed denial of existence, else NSEC
+}
+
+// Records of the zone, by owner name + type
+type signingZone struct {
+	origin	string
+	soa		ResourceRecord
+	rrsets	map[string]map[uint16][]ResourceRecord	// Canonical names
+	cuts	map[string]bool							// Delegations
+}
+
+// Sign the zone with the keys: each authoritative RRset with the ZSKs, and
+// the DNSKEY RRset with the KSKs, unless there are only KSKs or only ZSKs.
+// Any existing signatures + NSEC/NSEC3 records are replaced, and the DNSKEYs
+// of the keys are added to the apex.  Returns the records of the signed zone
+// in canonical order (RFC 4034, 6.1), with each RRSIG after its RRset
+func SignZone(records []ResourceRecord, origin string, keys []*SigningKey,
+	options SigningOptions) ([]ResourceRecord, error) {
+	zone, err := newSigningZone(records, origin)
+	if (err != nil) {
+		return nil, err
+	}
+	if (len(keys) == 0) {
+		return nil, fmt.Errorf("%w: no keys for %s", ErrSigningKey,
+			nameString(zone.origin))
+	}
+	var ksks, zsks []*SigningKey
+	for _, key := range keys {
+		if (CanonicalName(key.Name) != zone.origin) {
+			return nil, fmt.Errorf("%w: key %d is for %s, not %s",
+				ErrSigningKey, key.DNSKEY.KeyTag(), nameString(key.Name),
+				nameString(zone.origin))
+		}
+		if (key.KSK()) {
+			ksks = append(ksks, key)
+		} else {
+			zsks = append(zsks, key)
+		}
+		zone.addDNSKEY(key)
+	}
+	if (len(ksks) == 0) {
+		ksks = zsks
+	} else if (len(zsks) == 0) {
+		zsks = ksks
+	}
+
+	if (options.NSEC3 != nil) {
+		params := *options.NSEC3
+		params.Flags = 0
+		zone.add(ResourceRecord{ Name: zone.soa.Name,
+			Type: RecordTypeNSEC3PARAM, Class: RecordClassIN,
+			Data: &RDataNSEC3PARAM{ params } })
+		err = zone.nsec3Chain(*options.NSEC3)
+	} else {
+		zone.nsecChain()
+	}
+	if (err != nil) {
+		return nil, err
+	}
+
+	var signed []ResourceRecord
+	for _, name := range zone.names() {
+		for rtype, rrset := range zone.rrsets[name] {
+			signed = append(signed, rrset...)
+			if (!zone.authoritative(name, rtype)) {
+				continue
+			}
+			signers := zsks
+			if (rtype == RecordTypeDNSKEY) {
+				signers = ksks
+			}
+			for _, key := range signers {
+				rrsig, err := key.Sign(rrset, options.Inception,
+					options.Expiration)
+				if (err != nil) {
+					return nil, err
+				}
+				signed = append(signed, rrsig)
+			}
+		}
+	}
+	sortCanonical(signed)
+	return signed, nil
+}
+
+func newSigningZone(records []ResourceRecord, origin string) (*signingZone,
+	error) {
+	zone := &signingZone{
+		origin:	CanonicalName(origin),
+		rrsets:	map[string]map[uint16][]ResourceRecord{},
+		cuts:	map[string]bool{},
+	}
+	for _, rr := range records {
+		name := CanonicalName(rr.Name)
+		if (!IsSubdomain(name, zone.origin)) {
+			return nil, fmt.Errorf("%w: %s is outside of %s", ErrZoneContent,
+				nameString(rr.Name), nameString(zone.origin))
+		}
+		switch (rr.Type) {
+			case RecordTypeRRSIG, RecordTypeNSEC, RecordTypeNSEC3,
+				RecordTypeNSEC3PARAM:
+				continue
+			case RecordTypeSOA:
+				if (name == zone.origin) {
+					zone.soa = rr
+				}
+			case RecordTypeNS:
+				if (name != zone.origin) {
+					zone.cuts[name] = true
+				}
+		}
+		zone.add(rr)
+	}
+	if (zone.soa.Data == nil) {
+		return nil, fmt.Errorf("%w: no SOA for %s", ErrZoneContent,
+			nameString(zone.origin))
+	}
+	return zone, nil
+}
+
+func (zone *signingZone) add(rr ResourceRecord) {
+	name := CanonicalName(rr.Name)
+	if (zone.rrsets[name] == nil) {
+		zone.rrsets[name] = map[uint16][]ResourceRecord{}
+	}
+	zone.rrsets[name][rr.Type] = append(zone.rrsets[name][rr.Type], rr)
+}
+
+// Add the key to the apex, unless it is already there
+func (zone *signingZone) addDNSKEY(key *SigningKey) {
+	for _, rr := range zone.rrsets[zone.origin][RecordTypeDNSKEY] {
+		if (bytes.Equal(canonicalRData(rr), canonicalRData(key.Record(0)))) {
+			return
+		}
+	}
+	rr := key.Record(zone.soa.TTL)
+	rr.Name = zone.soa.Name
+	zone.add(rr)
+}
+
+// Is the name below a delegation?  Records there belong to the child zone,
+// e.g. glue
+func (zone *signingZone) occluded(name string) bool {
+	for (name != zone.origin) {
+		name = parentName(name)
+		if (zone.cuts[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is the RRset authoritative data of the zone, and hence signed?  At a
+// delegation, only the DS + NSEC records are
+func (zone *signingZone) authoritative(name string, rtype uint16) bool {
+	if (zone.occluded(name)) {
+		return false
+	}
+	return (!zone.cuts[name] || rtype == RecordTypeDS ||
+		rtype == RecordTypeNSEC)
+}
+
+// Owner names, in canonical order
+func (zone *signingZone) names() []string {
+	names := make([]string, 0, len(zone.rrsets))
+	for name := range zone.rrsets {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return CompareNames(names[i], names[j]) < 0
+	})
+	return names
+}
+
+// Owner names that the denial chain must cover: every name besides those
+// below a delegation
+func (zone *signingZone) chainNames() []string {
+	var names []string
+	for _, name := range zone.names() {
+		if (!zone.occluded(name)) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Types present at the name, as in an NSEC or NSEC3 bitmap: only NS + DS
+// at a delegation
+func (zone *signingZone) types(name string) []uint16 {
+	var types []uint16
+	for rtype := range zone.rrsets[name] {
+		if (!zone.cuts[name] || rtype == RecordTypeNS || rtype == RecordTypeDS) {
+			types = append(types, rtype)
+		}
+	}
+	return types
+}
+
+// Negative TTL, for NSEC + NSEC3 records (RFC 9077, 3)
+func (zone *signingZone) negativeTTL() int32 {
+	return zone.soa.Data.(*RDataSOA).NegativeTTL(zone.soa.TTL)
+}
+
+// NSEC chain, linking each name to the next, and the last back to the apex
+// (RFC 4034, 4.1.1)
+func (zone *signingZone) nsecChain() {
+	names := zone.chainNames()
+	for i, name := range names {
+		types := append(zone.types(name), RecordTypeRRSIG, RecordTypeNSEC)
+		zone.add(ResourceRecord{
+			Name:	name,
+			Type:	RecordTypeNSEC,
+			Class:	RecordClassIN,
+			TTL:	zone.negativeTTL(),
+			Data:	&RDataNSEC{
+				NextDomain:	names[(i + 1) % len(names)],
+				Types:		sortTypes(types),
+			},
+		})
+	}
+}
+
+// NSEC3 chain, linking each hashed name to the next in order, including the
+// empty non-terminals.  With opt-out, unsigned delegations are left out
+// (RFC 5155, 7.1)
+func (zone *signingZone) nsec3Chain(params NSEC3Params) error {
+	optOut := (params.Flags & NSEC3FlagOptOut != 0)
+	included := map[string]bool{}
+	for _, name := range zone.chainNames() {
+		if (optOut && zone.cuts[name] &&
+			zone.rrsets[name][RecordTypeDS] == nil) {
+			continue
+		}
+		for ; !included[name]; name = parentName(name) {
+			included[name] = true
+			if (name == zone.origin) {
+				break
+			}
+		}
+	}
+
+	hashes := map[string]string{}
+	var sorted []string
+	for name := range included {
+		hash := params.HashName(name)
+		if (hashes[hash] != "") {
+			return fmt.Errorf("%w: NSEC3 hash collision between %s and %s",
+				ErrZoneContent, nameString(name), nameString(hashes[hash]))
+		}
+		hashes[hash] = name
+		sorted = append(sorted, hash)
+	}
+	sort.Strings(sorted)
+
+	for i, hash := range sorted {
+		name := hashes[hash]
+		types := zone.types(name)
+		signed := (len(types) > 0 && (!zone.cuts[name] ||
+			zone.rrsets[name][RecordTypeDS] != nil))
+		if (signed) {
+			types = append(types, RecordTypeRRSIG)
+		}
+		next, _ := base32Hex.DecodeString(strings.ToUpper(
+			sorted[(i + 1) % len(sorted)]))
+		zone.add(ResourceRecord{
+			Name:	AbsoluteName(hash, zone.origin),
+			Type:	RecordTypeNSEC3,
+			Class:	RecordClassIN,
+			TTL:	zone.negativeTTL(),
+			Data:	&RDataNSEC3{
+				NSEC3Params:	params,
+				NextHashed:		next,
+				Types:			sortTypes(types),
+			},
+		})
+	}
+	return nil
+}
+
+func parentName(name string) string {
+	_, parent, _ := strings.Cut(name, ".")
+	return parent
+}
+
+func sortTypes(types []uint16) []uint16 {
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Sort the records in canonical order, with the SOA first at the apex, and
+// each RRSIG after the RRset it covers
+func sortCanonical(records []ResourceRecord) {
+	rank := func(rr ResourceRecord) (uint16, bool) {
+		rtype := rr.Type
+		rrsig, signature := rr.Data.(*RDataRRSIG)
+		if (signature) {
+			rtype = rrsig.TypeCovered
+		}
+		if (rtype == RecordTypeSOA) {
+			rtype = 0
+		}
+		return rtype, signature
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		order := CompareNames(records[i].Name, records[j].Name)
+		if (order != 0) {
+			return (order < 0)
+		}
+		iType, iSignature := rank(records[i])
+		jType, jSignature := rank(records[j])
+		if (iType != jType) {
+			return (iType < jType)
+		}
+		return (!iSignature && jSignature)
+	})
+}
@@ -0,0 +1,404 @@
+package dns
+
+import(
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	)
+
+// Private key of the RFC 8080, 6.1 example, as from BIND's dnssec-keygen
+const ed25519Private = `Private-key-format: v1.2
+Algorithm: 15 (ED25519)
+PrivateKey: ODIyNjAzODQ2MjgwODAxMjI2NDUxOTAyMDQxNDIyNjI=
+`
+
+var signingTime = time.Unix(1767225600, 0)
+
+//
+// Validate key generation for each algorithm, the key files, and signatures
+// that verify
+//
+func TestSigningKeys(t *testing.T) {
+	directory := t.TempDir()
+	rrset := []ResourceRecord{
+		{ Name: "www.example", Type: RecordTypeTXT, Class: RecordClassIN,
+			TTL: 300, Data: &RDataTXT{ Strings: []string{ "b" } } },
+		{ Name: "WWW.example", Type: RecordTypeTXT, Class: RecordClassIN,
+			TTL: 60, Data: &RDataTXT{ Strings: []string{ "a" } } },
+	}
+	inception := uint32(signingTime.Unix() - 3600)
+	expiration := uint32(signingTime.Unix() + 3600)
+
+	for algorithm := range AlgorithmNames {
+		key, err := GenerateSigningKey("Example.", algorithm, DNSKEYFlagSEP,
+			1024)
+		if (err != nil) {
+			t.Fatalf("Algorithm %d: key generation error: %s", algorithm, err)
+		}
+		if (key.Name != "example" || !key.KSK() ||
+			key.DNSKEY.Flags != DNSKEYFlagZone | DNSKEYFlagSEP) {
+			t.Errorf("Algorithm %d: unexpected key: %s", algorithm, &key.DNSKEY)
+		}
+
+		base, err := SaveSigningKey(key, directory)
+		if (err != nil) {
+			t.Fatalf("Algorithm %d: unable to save the key: %s", algorithm, err)
+		}
+		if (filepath.Base(base) != key.FileName() ||
+			!strings.HasPrefix(key.FileName(), "Kexample.+0")) {
+			t.Errorf("Algorithm %d: unexpected file name: %s", algorithm, base)
+		}
+		loaded, err := LoadSigningKey(base + ".private")
+		if (err != nil) {
+			t.Fatalf("Algorithm %d: unable to load the key: %s", algorithm, err)
+		}
+		if (loaded.DNSKEY.String() != key.DNSKEY.String()) {
+			t.Errorf("Algorithm %d: unexpected key: %s", algorithm,
+				&loaded.DNSKEY)
+		}
+
+		rr, err := loaded.Sign(rrset, inception, expiration)
+		if (err != nil) {
+			t.Fatalf("Algorithm %d: signing error: %s", algorithm, err)
+		}
+		rrsig := rr.Data.(*RDataRRSIG)
+		if (rr.Name != "www.example" || rr.TTL != 60 ||
+			rrsig.OriginalTTL != 60 || rrsig.Labels != 2 ||
+			rrsig.SignerName != "example") {
+			t.Errorf("Algorithm %d: unexpected RRSIG: %s", algorithm,
+				ZoneString(rr))
+		}
+		err = rrsig.Verify(rrset, &key.DNSKEY, signingTime)
+		if (err != nil) {
+			t.Errorf("Algorithm %d: verification error: %s", algorithm, err)
+		}
+	}
+
+	_, err := GenerateSigningKey("example", AlgorithmRSAMD5, 0, 0)
+	if (!errors.Is(err, ErrDNSSECAlgorithm)) {
+		t.Error("Expected ErrDNSSECAlgorithm, got ", err)
+	}
+	if (ParseAlgorithm("ecdsap256sha256") != AlgorithmECDSAP256SHA256 ||
+		ParseAlgorithm("8") != AlgorithmRSASHA256 || ParseAlgorithm("5") != 0) {
+		t.Error("Unexpected algorithm")
+	}
+}
+
+//
+// Validate key files from BIND, against the deterministic Ed25519 signature
+// of RFC 8080, and malformed key files
+//
+func TestLoadSigningKey(t *testing.T) {
+	directory := t.TempDir()
+	base := filepath.Join(directory, "Kexample.com.+015+03613")
+	write := func(public string, private string) {
+		os.WriteFile(base + ".key", []byte(public), 0644)
+		os.WriteFile(base + ".private", []byte(private), 0600)
+	}
+	public := "; This is a key-signing key, keyid 3613, for example.com.\n" +
+		"example.com. IN DNSKEY 257 3 15 " +
+		"l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4=\n"
+	write(public, ed25519Private)
+
+	key, err := LoadSigningKey(base)
+	if (err != nil) {
+		t.Fatal("Unable to load the key: ", err)
+	}
+	_, rrset, expected := ed25519Example(t)
+	rr, err := key.Sign(rrset, expected.Inception, expected.Expiration)
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	if (rr.Data.String() != expected.String()) {
+		t.Error("Unexpected RRSIG: ", rr.Data)
+	}
+
+	testCases := []struct{
+		public		string
+		private		string
+		expected	error
+	}{
+		{ public, strings.Replace(ed25519Private, "ODIy", "ODIz", 1),
+			ErrSigningKey },
+		{ public, strings.Replace(ed25519Private, "15 (", "13 (", 1),
+			ErrSigningKey },
+		{ public, "PrivateKey: c2hvcnQ=\n", ErrSigningKey },
+		{ public + public, ed25519Private, ErrSigningKey },
+		{ "example.com. IN A 192.0.2.1\n", ed25519Private, ErrSigningKey },
+	}
+	for _, testCase := range testCases {
+		write(testCase.public, testCase.private)
+		_, err := LoadSigningKey(base + ".key")
+		if (!errors.Is(err, testCase.expected)) {
+			t.Errorf("Expected %s, got %v", testCase.expected, err)
+		}
+	}
+
+	_, err = LoadSigningKey(filepath.Join(directory, "Kmissing"))
+	if (!errors.Is(err, os.ErrNotExist)) {
+		t.Error("Expected a missing file, got ", err)
+	}
+}
+
+
+const signingZoneFile = `
+$ORIGIN example.
+$TTL 3600
+@			SOA		ns hostmaster 1 7200 1800 1209600 300
+			NS		ns
+ns			A		192.0.2.1
+www			A		192.0.2.2
+*.wild		TXT		"wildcard"
+deep.ent	A		192.0.2.3
+secure		NS		ns.secure
+			DS		60485 5 1 2BB183AF5F22588179A53B0A98631FAD1A292118
+ns.secure	A		192.0.2.4
+insecure	NS		ns.insecure
+ns.insecure	A		192.0.2.5
+www			RRSIG	A 13 2 3600 20350101000000 20250101000000 1 example. AAAA
+`
+
+func signTestZone(t *testing.T, params *NSEC3Params,
+	keys ...*SigningKey) []ResourceRecord {
+	records, err := ParseZone(strings.NewReader(signingZoneFile), "example",
+		"test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	signed, err := SignZone(records, "example", keys, SigningOptions{
+		Inception:	uint32(signingTime.Unix() - 3600),
+		Expiration:	uint32(signingTime.Unix() + 3600),
+		NSEC3:		params,
+	})
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	return signed
+}
+
+// Verify every RRSIG of the zone, and return the owner + type of each RRset
+// that was signed, and by which key
+func verifyTestZone(t *testing.T, signed []ResourceRecord,
+	keys ...*SigningKey) map[string][]uint16 {
+	rrsets := map[string][]ResourceRecord{}
+	for _, rr := range signed {
+		if (rr.Type != RecordTypeRRSIG) {
+			key := CanonicalName(rr.Name) + " " + RecordTypeString(rr.Type)
+			rrsets[key] = append(rrsets[key], rr)
+		}
+	}
+	signers := map[string][]uint16{}
+	for _, rr := range signed {
+		rrsig, ok := rr.Data.(*RDataRRSIG)
+		if (!ok) {
+			continue
+		}
+		name := rr.Name + " " + RecordTypeString(rrsig.TypeCovered)
+		for _, key := range keys {
+			if (key.DNSKEY.KeyTag() == rrsig.KeyTag) {
+				err := rrsig.Verify(rrsets[name], &key.DNSKEY, signingTime)
+				if (err != nil) {
+					t.Errorf("%s: %s", ZoneString(rr), err)
+				}
+			}
+		}
+		signers[name] = append(signers[name], rrsig.KeyTag)
+	}
+	return signers
+}
+
+//
+// Validate the signatures of a zone, and its NSEC chain
+//
+func TestSignZoneNSEC(t *testing.T) {
+	ksk, _ := GenerateSigningKey("example", AlgorithmECDSAP256SHA256,
+		DNSKEYFlagSEP, 0)
+	zsk, _ := GenerateSigningKey("example", AlgorithmED25519, 0, 0)
+	signed := signTestZone(t, nil, ksk, zsk)
+	signers := verifyTestZone(t, signed, ksk, zsk)
+
+	// The DNSKEY RRset only by the KSK, everything else by the ZSK, besides
+	// the delegations + glue
+	expected := map[string][]uint16{
+		"example SOA":				{ zsk.DNSKEY.KeyTag() },
+		"example NS":				{ zsk.DNSKEY.KeyTag() },
+		"example DNSKEY":			{ ksk.DNSKEY.KeyTag() },
+		"example NSEC":				{ zsk.DNSKEY.KeyTag() },
+		"ns.example A":				{ zsk.DNSKEY.KeyTag() },
+		"www.example A":			{ zsk.DNSKEY.KeyTag() },
+		"*.wild.example TXT":		{ zsk.DNSKEY.KeyTag() },
+		"deep.ent.example A":		{ zsk.DNSKEY.KeyTag() },
+		"secure.example DS":		{ zsk.DNSKEY.KeyTag() },
+	}
+	for name, keyTags := range expected {
+		if (len(signers[name]) != 1 || signers[name][0] != keyTags[0]) {
+			t.Errorf("%s: expected key %d, got %v", name, keyTags[0],
+				signers[name])
+		}
+	}
+	for _, name := range []string{ "secure.example NS", "ns.secure.example A",
+		"insecure.example NS", "ns.insecure.example A" } {
+		if (signers[name] != nil) {
+			t.Errorf("%s: unexpected signature", name)
+		}
+	}
+
+	// Canonical order, with the SOA first, and the chain back to the apex
+	var nsecs []string
+	for _, rr := range signed {
+		if (rr.Type == RecordTypeNSEC) {
+			nsecs = append(nsecs, ZoneString(rr))
+		}
+	}
+	expectedNSEC := []string{
+		"example.\t300\tIN\tNSEC\tdeep.ent.example NS SOA RRSIG NSEC DNSKEY",
+		"deep.ent.example.\t300\tIN\tNSEC\tinsecure.example A RRSIG NSEC",
+		"insecure.example.\t300\tIN\tNSEC\tns.example NS RRSIG NSEC",
+		"ns.example.\t300\tIN\tNSEC\tsecure.example A RRSIG NSEC",
+		"secure.example.\t300\tIN\tNSEC\t*.wild.example NS DS RRSIG NSEC",
+		"*.wild.example.\t300\tIN\tNSEC\twww.example TXT RRSIG NSEC",
+		"www.example.\t300\tIN\tNSEC\texample A RRSIG NSEC",
+	}
+	if (strings.Join(nsecs, "\n") != strings.Join(expectedNSEC, "\n")) {
+		t.Error("Unexpected NSEC chain: ", strings.Join(nsecs, "\n"))
+	}
+	if (signed[0].Type != RecordTypeSOA || signed[1].Type != RecordTypeRRSIG) {
+		t.Error("Unexpected order: ", ZoneString(signed[0]))
+	}
+	for i := 1; i < len(signed); i++ {
+		if (CompareNames(signed[i - 1].Name, signed[i].Name) > 0) {
+			t.Error("Unexpected order: ", ZoneString(signed[i]))
+		}
+	}
+
+	// The NSEC TTL is the SOA TTL, for a MINIMUM beyond int32
+	records, _ := ParseZone(strings.NewReader(signingZoneFile), "example",
+		"test")
+	records[0].Data.(*RDataSOA).Minimum = 0xFFFFFFFF
+	signed, err := SignZone(records, "example", []*SigningKey{ zsk },
+		SigningOptions{ Inception: uint32(signingTime.Unix()),
+			Expiration: uint32(signingTime.Unix() + 3600) })
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	for _, rr := range signed {
+		if (rr.Type == RecordTypeNSEC && rr.TTL != 3600) {
+			t.Error("Unexpected NSEC TTL: ", ZoneString(rr))
+		}
+	}
+}
+
+//
+// Validate an NSEC3 chain, with + without opt-out
+//
+func TestSignZoneNSEC3(t *testing.T) {
+	key, _ := GenerateSigningKey("example", AlgorithmECDSAP256SHA256,
+		DNSKEYFlagSEP, 0)
+	params := NSEC3Params{
+		HashAlgorithm:	NSEC3HashSHA1,
+		Iterations:		1,
+		Salt:			[]byte{ 0xaa, 0xbb },
+	}
+
+	for _, optOut := range []bool{ false, true } {
+		params.Flags = 0
+		if (optOut) {
+			params.Flags = NSEC3FlagOptOut
+		}
+		signed := signTestZone(t, &params, key)
+		signers := verifyTestZone(t, signed, key)
+		if (signers["example NSEC3PARAM"] == nil) {
+			t.Error("Expected a signed NSEC3PARAM")
+		}
+
+		// Each name, including the empty non-terminals, but not the
+		// unsigned delegation with opt-out
+		types := map[string]string{}
+		for _, rr := range signed {
+			nsec3, ok := rr.Data.(*RDataNSEC3)
+			if (rr.Type == RecordTypeNSEC) {
+				t.Error("Unexpected NSEC: ", ZoneString(rr))
+			}
+			if (!ok) {
+				continue
+			}
+			if (nsec3.Flags != params.Flags ||
+				signers[rr.Name + " NSEC3"] == nil) {
+				t.Error("Unexpected NSEC3: ", ZoneString(rr))
+			}
+			types[rr.Name] = typeBitmapString(nsec3.Types)
+		}
+		expected := map[string]string{
+			"example":				"NS SOA RRSIG DNSKEY NSEC3PARAM",
+			"ns.example":			"A RRSIG",
+			"www.example":			"A RRSIG",
+			"wild.example":			"",
+			"*.wild.example":		"TXT RRSIG",
+			"ent.example":			"",
+			"deep.ent.example":		"A RRSIG",
+			"secure.example":		"NS DS RRSIG",
+			"insecure.example":		"NS",
+		}
+		if (optOut) {
+			delete(expected, "insecure.example")
+		}
+		if (len(types) != len(expected)) {
+			t.Errorf("Opt-out %t: unexpected NSEC3 records: %v", optOut, types)
+		}
+		for name, bitmap := range expected {
+			hashed := params.HashName(name) + ".example"
+			if (types[hashed] != bitmap) {
+				t.Errorf("Opt-out %t: unexpected types for %s: %q", optOut,
+					name, types[hashed])
+			}
+		}
+	}
+}
+
+//
+// Validate zones that cannot be signed
+//
+func TestSignZoneErrors(t *testing.T) {
+	key, _ := GenerateSigningKey("example", AlgorithmED25519, 0, 0)
+	other, _ := GenerateSigningKey("example.net", AlgorithmED25519, 0, 0)
+	soa, _ := ParseZone(strings.NewReader("@ 3600 SOA ns hostmaster 1 2 3 4 5"),
+		"example", "test")
+	www, _ := ParseZone(strings.NewReader("www 3600 A 192.0.2.1"),
+		"example.net", "test")
+
+	testCases := []struct{
+		records		[]ResourceRecord
+		keys		[]*SigningKey
+		expected	error
+	}{
+		{ soa, nil, ErrSigningKey },
+		{ soa, []*SigningKey{ other }, ErrSigningKey },
+		{ www, []*SigningKey{ key }, ErrZoneContent },
+		{ append(soa, www...), []*SigningKey{ key }, ErrZoneContent },
+	}
+	for _, testCase := range testCases {
+		_, err := SignZone(testCase.records, "example", testCase.keys,
+			SigningOptions{})
+		if (!errors.Is(err, testCase.expected)) {
+			t.Errorf("Expected %s, got %v", testCase.expected, err)
+		}
+	}
+
+	// The key is added to the apex, once
+	signed, err := SignZone(append(soa, key.Record(60)), "example.",
+		[]*SigningKey{ key }, SigningOptions{})
+	count := 0
+	for _, rr := range signed {
+		if (rr.Type == RecordTypeDNSKEY) {
+			count++
+		}
+	}
+	if (err != nil || count != 1 ||
+		!bytes.Equal(canonicalRData(signed[0]), canonicalRData(soa[0]))) {
+		t.Error("Unexpected DNSKEYs: ", count, err)
+	}
+}
@@ -0,0 +1,468 @@
+//
+// Transaction signatures, TSIG, as defined by RFC 8945.  A shared secret key
+// authenticates each message via an HMAC over the packed message, carried in
+// a TSIG pseudo-record at the very end of the additional section.  Replies
+// chain to the MAC of the request, and each message of a multi-message reply
+// (e.g. AXFR) chains to the MAC of the one before.
+//
+
+package dns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+
+const RecordTypeTSIG			= 250
+
+const TSIGAlgorithmHMACSHA256	= "hmac-sha256"
+const TSIGAlgorithmHMACSHA512	= "hmac-sha512"
+
+var TSIGAlgorithms = map[string]func() hash.Hash{
+		TSIGAlgorithmHMACSHA256:	sha256.New,
+		TSIGAlgorithmHMACSHA512:	sha512.New,
+	}
+
+const TSIGDefaultFudge			= 300 // Seconds, RFC 8945, 10
+
+// Unsigned messages allowed between signed ones, within a single stream of
+// replies (RFC 8945, 5.3.1)
+const TSIGMaxUnsigned			= 99
+
+// TSIG error field values, distinct from the message RCODE, which is
+// NOTAUTH for all of these
+const TSIGErrorBadSig			= 16
+const TSIGErrorBadKey			= 17
+const TSIGErrorBadTime			= 18
+const TSIGErrorBadTrunc			= 22
+
+var tsigErrors = map[uint16]error{
+		TSIGErrorBadSig:	ErrBadSig,
+		TSIGErrorBadKey:	ErrBadKey,
+		TSIGErrorBadTime:	ErrBadTime,
+		TSIGErrorBadTrunc:	ErrBadTrunc,
+	}
+
+var tsigErrorStrings = map[uint16]string{
+		TSIGErrorBadSig:	"BADSIG",
+		TSIGErrorBadKey:	"BADKEY",
+		TSIGErrorBadTime:	"BADTIME",
+		TSIGErrorBadTrunc:	"BADTRUNC",
+	}
+
+func TSIGErrorString(code uint16) string {
+	name, ok := tsigErrorStrings[code]
+	if (!ok) {
+		name = RcodeString(code)
+	}
+	return name
+}
+
+
+//
+// TSIG RDATA.  The algorithm name is never compressed
+//
+type RDataTSIG struct {
+	Algorithm	string
+	TimeSigned	uint64	// Seconds since the epoch, 48b on the wire
+	Fudge		uint16
+	MAC			[]byte
+	OriginalId	uint16
+	Error		uint16
+	OtherData	[]byte
+}
+const rdataTSIGFixedSize = 16 // Time thru Other Len, excluding the MAC
+
+func (rdata *RDataTSIG) Pack(buffer *bytes.Buffer, compression CompressionMap) error {
+	err := packNameTo(buffer, rdata.Algorithm, nil)
+	if (err != nil) {
+		return err
+	}
+	buffer.Write(rdata.timers())
+	binary.Write(buffer, binary.BigEndian, uint16(len(rdata.MAC)))
+	buffer.Write(rdata.MAC)
+	binary.Write(buffer, binary.BigEndian, rdata.OriginalId)
+	binary.Write(buffer, binary.BigEndian, rdata.Error)
+	binary.Write(buffer, binary.BigEndian, uint16(len(rdata.OtherData)))
+	buffer.Write(rdata.OtherData)
+	return nil
+}
+
+func (rdata *RDataTSIG) Unpack(rawBytes []byte, offset int, length int) error {
+	var err error
+	var nameLength int
+	end := offset + length
+	rdata.Algorithm, nameLength, err = UnpackName(rawBytes, offset)
+	if (err != nil) {
+		return err
+	}
+	offset += nameLength
+	if (offset + rdataTSIGFixedSize > end) {
+		return ErrRDataLength
+	}
+
+	rdata.TimeSigned = uint64(binary.BigEndian.Uint16(rawBytes[offset:])) << 32 |
+		uint64(binary.BigEndian.Uint32(rawBytes[offset + 2:]))
+	rdata.Fudge = binary.BigEndian.Uint16(rawBytes[offset + 6:])
+	macSize := int(binary.BigEndian.Uint16(rawBytes[offset + 8:]))
+	offset += 10
+	if (offset + macSize + 6 > end) {
+		return ErrRDataLength
+	}
+	rdata.MAC = append([]byte{}, rawBytes[offset:offset + macSize]...)
+	offset += macSize
+
+	rdata.OriginalId = binary.BigEndian.Uint16(rawBytes[offset:])
+	rdata.Error = binary.BigEndian.Uint16(rawBytes[offset + 2:])
+	otherLength := int(binary.BigEndian.Uint16(rawBytes[offset + 4:]))
+	offset += 6
+	if (offset + otherLength != end) {
+		return ErrRDataLength
+	}
+	rdata.OtherData = append([]byte{}, rawBytes[offset:end]...)
+	return nil
+}
+
+func (rdata *RDataTSIG) String() string {
+	return fmt.Sprintf("%s. %d %d %d %s %d %s %d", rdata.Algorithm,
+		rdata.TimeSigned, rdata.Fudge, len(rdata.MAC),
+		base64.StdEncoding.EncodeToString(rdata.MAC), rdata.OriginalId,
+		TSIGErrorString(rdata.Error), len(rdata.OtherData))
+}
+
+// TSIG records only ever appear in messages, never in zone files
+func (rdata *RDataTSIG) Parse(fields []string, origin string) error {
+	return fmt.Errorf("%w: TSIG is not allowed in zone files", ErrRDataSyntax)
+}
+
+// Time Signed + Fudge, as covered by the MAC of every message
+func (rdata *RDataTSIG) timers() []byte {
+	timers := make([]byte, 8)
+	binary.BigEndian.PutUint16(timers, uint16(rdata.TimeSigned >> 32))
+	binary.BigEndian.PutUint32(timers[2:], uint32(rdata.TimeSigned))
+	binary.BigEndian.PutUint16(timers[6:], rdata.Fudge)
+	return timers
+}
+
+
+//
+// Shared secret key, as named by both the client + server
+//
+type TSIGKey struct {
+	Name		string
+	Algorithm	string	// e.g. TSIGAlgorithmHMACSHA256
+	Secret		[]byte
+	Fudge		uint16	// Default TSIGDefaultFudge
+}
+
+// Parse a key given as name:algorithm:secret, with the secret in base64
+func ParseTSIGKey(spec string) (*TSIGKey, error) {
+	fields := strings.Split(spec, ":")
+	if (len(fields) != 3 || fields[0] == "") {
+		return nil, fmt.Errorf("%w: expected name:algorithm:secret", ErrTSIGKey)
+	}
+	return newTSIGKey(fields[0], fields[1], fields[2])
+}
+
+var keyFileName			= regexp.MustCompile(`key\s+"?([^"\s{]+)"?\s*\{`)
+var keyFileAlgorithm	= regexp.MustCompile(`algorithm\s+"?([\w.-]+)"?\s*;`)
+var keyFileSecret		= regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+
+// Load a key file in the format of BIND's tsig-keygen, i.e.
+// key "name" { algorithm hmac-sha256; secret "base64"; };
+func LoadTSIGKey(path string) (*TSIGKey, error) {
+	text, err := os.ReadFile(path)
+	if (err != nil) {
+		return nil, err
+	}
+	name := keyFileName.FindSubmatch(text)
+	algorithm := keyFileAlgorithm.FindSubmatch(text)
+	secret := keyFileSecret.FindSubmatch(text)
+	if (name == nil || algorithm == nil || secret == nil) {
+		return nil, fmt.Errorf("%w: %s", ErrTSIGKey, path)
+	}
+	return newTSIGKey(string(name[1]), string(algorithm[1]), string(secret[1]))
+}
+
+func newTSIGKey(name string, algorithm string, secret string) (*TSIGKey, error) {
+	algorithm = CanonicalName(algorithm)
+	if (TSIGAlgorithms[algorithm] == nil) {
+		return nil, fmt.Errorf("%w: %s", ErrTSIGAlgorithm, algorithm)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if (err != nil || len(decoded) == 0) {
+		return nil, fmt.Errorf("%w: secret is not base64", ErrTSIGKey)
+	}
+	return &TSIGKey{
+		Name:		CanonicalName(name),
+		Algorithm:	algorithm,
+		Secret:		decoded,
+	}, nil
+}
+
+// Sign the message, either as a request, or as a reply to a request with
+// the given MAC.  Returns the packed message, and its MAC for any reply
+func (key *TSIGKey) Sign(message *Message, requestMAC []byte,
+	now time.Time) ([]byte, []byte, error) {
+	return key.sign(message, requestMAC, nil, true, now)
+}
+
+// Sign the message, chained to the prior MAC + any unsigned messages since.
+// The first message of a stream covers all of the TSIG variables, but later
+// ones only the timers
+func (key *TSIGKey) sign(message *Message, priorMAC []byte, pending []byte,
+	first bool, now time.Time) ([]byte, []byte, error) {
+	newHash := TSIGAlgorithms[CanonicalName(key.Algorithm)]
+	if (newHash == nil) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrTSIGAlgorithm, key.Algorithm)
+	}
+	fudge := key.Fudge
+	if (fudge == 0) {
+		fudge = TSIGDefaultFudge
+	}
+
+	message.TSIG = nil
+	tsig := &RDataTSIG{
+		Algorithm:	CanonicalName(key.Algorithm),
+		TimeSigned:	uint64(now.Unix()),
+		Fudge:		fudge,
+		OriginalId:	message.Header.Id,
+	}
+	rr := ResourceRecord{
+		Name:	CanonicalName(key.Name),
+		Type:	RecordTypeTSIG,
+		Class:	RecordClassANY,
+		Data:	tsig,
+	}
+	unsigned, err := message.Pack()
+	if (err != nil) {
+		return nil, nil, err
+	}
+	mac := hmac.New(newHash, key.Secret)
+	writeMAC(mac, priorMAC)
+	mac.Write(pending)
+	mac.Write(unsigned)
+	if (first) {
+		mac.Write(tsigVariables(rr))
+	} else {
+		mac.Write(tsig.timers())
+	}
+	tsig.MAC = mac.Sum(nil)
+
+	message.TSIG = &rr
+	signed, err := message.Pack()
+	if (err != nil) {
+		return nil, nil, err
+	}
+	return signed, tsig.MAC, nil
+}
+
+// Verify a single signed reply to a request with the given MAC
+func (key *TSIGKey) Verify(rawBytes []byte, requestMAC []byte,
+	now time.Time) error {
+	stream := key.NewStream(requestMAC)
+	err := stream.Verify(rawBytes, now)
+	if (err == nil && !stream.Signed()) {
+		err = ErrTSIGMissing
+	}
+	return err
+}
+
+// MAC over the variables of the first message: everything in the record
+// besides the MAC + original id, with canonical names (RFC 8945, 4.3.3).
+// Names that do not fit fail later, when the TSIG itself is packed
+func tsigVariables(rr ResourceRecord) []byte {
+	tsig := rr.Data.(*RDataTSIG)
+	buffer := new(bytes.Buffer)
+	packNameTo(buffer, CanonicalName(rr.Name), nil)
+	binary.Write(buffer, binary.BigEndian, uint16(RecordClassANY))
+	binary.Write(buffer, binary.BigEndian, uint32(0))
+	packNameTo(buffer, CanonicalName(tsig.Algorithm), nil)
+	buffer.Write(tsig.timers())
+	binary.Write(buffer, binary.BigEndian, tsig.Error)
+	binary.Write(buffer, binary.BigEndian, uint16(len(tsig.OtherData)))
+	buffer.Write(tsig.OtherData)
+	return buffer.Bytes()
+}
+
+// Prior MAC, with its length, if any
+func writeMAC(mac hash.Hash, prior []byte) {
+	if (prior != nil) {
+		binary.Write(mac, binary.BigEndian, uint16(len(prior)))
+		mac.Write(prior)
+	}
+}
+
+
+//
+// Sequence of replies to a single request, e.g. AXFR, as signed by the server
+// + verified by the client.  The first + last replies must be signed, but up
+// to TSIGMaxUnsigned replies in between may not be, in which case the next
+// MAC covers them too
+//
+type TSIGStream struct {
+	key			*TSIGKey
+	priorMAC	[]byte	// Request MAC, then that of each signed reply
+	pending		[]byte	// Unsigned replies since the last signed one
+	unsigned	int
+	messages	int
+}
+
+func (key *TSIGKey) NewStream(requestMAC []byte) *TSIGStream {
+	return &TSIGStream{ key: key, priorMAC: requestMAC }
+}
+
+// Sign the next reply of the stream, as a server would, e.g. for AXFR
+func (stream *TSIGStream) Sign(message *Message, now time.Time) ([]byte, error) {
+	signed, mac, err := stream.key.sign(message, stream.priorMAC,
+		stream.pending, (stream.messages == 0), now)
+	if (err != nil) {
+		return nil, err
+	}
+	stream.priorMAC = mac
+	stream.pending = nil
+	stream.unsigned = 0
+	stream.messages++
+	return signed, nil
+}
+
+// Pack the next reply of the stream without signing it, so that the next
+// signed reply covers it instead
+func (stream *TSIGStream) Skip(message *Message) ([]byte, error) {
+	message.TSIG = nil
+	packed, err := message.Pack()
+	if (err != nil) {
+		return nil, err
+	}
+	stream.pending = append(stream.pending, packed...)
+	stream.unsigned++
+	stream.messages++
+	return packed, nil
+}
+
+// Whether the most recent reply was signed, as the final one must be
+func (stream *TSIGStream) Signed() bool {
+	return (stream.messages > 0 && stream.unsigned == 0)
+}
+
+// Verify the next reply of the stream.  Errors the server reports in its own
+// TSIG are returned as-is, wrapped to tell them apart from local failures
+func (stream *TSIGStream) Verify(rawBytes []byte, now time.Time) error {
+	offset, rr, err := findTSIG(rawBytes)
+	if (err != nil) {
+		return err
+	}
+	if (rr == nil) {
+		if (stream.messages == 0 || stream.unsigned >= TSIGMaxUnsigned) {
+			return ErrTSIGMissing
+		}
+		stream.pending = append(stream.pending, rawBytes...)
+		stream.unsigned++
+		stream.messages++
+		return nil
+	}
+
+	tsig := rr.Data.(*RDataTSIG)
+	if (tsig.Error != 0) {
+		reported, ok := tsigErrors[tsig.Error]
+		if (!ok) {
+			reported = &RcodeError{ Rcode: tsig.Error }
+		}
+		return fmt.Errorf("Server rejected the TSIG: %w", reported)
+	}
+	newHash := TSIGAlgorithms[CanonicalName(tsig.Algorithm)]
+	if (CanonicalName(rr.Name) != CanonicalName(stream.key.Name) ||
+		CanonicalName(tsig.Algorithm) != CanonicalName(stream.key.Algorithm) ||
+		newHash == nil) {
+		return ErrBadKey
+	}
+
+	// Truncated MACs must keep at least half of the hash, and 10 bytes
+	// (RFC 8945, 5.2.2.1)
+	mac := hmac.New(newHash, stream.key.Secret)
+	if (len(tsig.MAC) > mac.Size() ||
+		len(tsig.MAC) < max(10, mac.Size() / 2)) {
+		return ErrBadTrunc
+	}
+
+	// The MAC covers the message as sent, i.e. without the TSIG, and with
+	// the original id
+	message := append([]byte{}, rawBytes[:offset]...)
+	binary.BigEndian.PutUint16(message, tsig.OriginalId)
+	arcount := binary.BigEndian.Uint16(message[10:])
+	binary.BigEndian.PutUint16(message[10:], arcount - 1)
+
+	writeMAC(mac, stream.priorMAC)
+	mac.Write(stream.pending)
+	mac.Write(message)
+	if (stream.messages == 0) {
+		mac.Write(tsigVariables(*rr))
+	} else {
+		mac.Write(tsig.timers())
+	}
+	if (!hmac.Equal(mac.Sum(nil)[:len(tsig.MAC)], tsig.MAC)) {
+		return ErrBadSig
+	}
+
+	// Only a valid signature vouches for the time
+	delta := now.Unix() - int64(tsig.TimeSigned)
+	if (delta > int64(tsig.Fudge) || -delta > int64(tsig.Fudge)) {
+		return ErrBadTime
+	}
+
+	stream.priorMAC = tsig.MAC
+	stream.pending = nil
+	stream.unsigned = 0
+	stream.messages++
+	return nil
+}
+
+// Offset + contents of the TSIG record, if any, which is always the last
+// record of the message
+func findTSIG(rawBytes []byte) (int, *ResourceRecord, error) {
+	header := MessageHeader{}
+	offset, err := header.Unpack(rawBytes, 0)
+	if (err != nil) {
+		return 0, nil, err
+	}
+	for i := 0; i < int(header.QuestionCount); i++ {
+		var question Question
+		length, err := question.Unpack(rawBytes, offset)
+		if (err != nil) {
+			return 0, nil, err
+		}
+		offset += length
+	}
+
+	records := int(header.AnswerCount) + int(header.NameserverCount) +
+		int(header.AdditionalCount)
+	for i := 0; i < records; i++ {
+		var rr ResourceRecord
+		length, err := rr.Unpack(rawBytes, offset)
+		if (err != nil) {
+			return 0, nil, err
+		}
+		if (rr.Type == RecordTypeTSIG) {
+			if (i != records - 1 || header.AdditionalCount == 0) {
+				return 0, nil, ErrMisplacedTSIG
+			}
+			if (rr.Data == nil) {
+				return 0, nil, ErrTSIGFormat
+			}
+			return offset, &rr, nil
+		}
+		offset += length
+	}
+	return offset, nil, nil
+}
@@ -0,0 +1,226 @@
+package dns
+
+import(
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	)
+
+func testKey() *TSIGKey {
+	key, err := ParseTSIGKey("Key.Example.:HMAC-SHA256:c2VjcmV0IGtleSBieXRlcw==")
+	if (err != nil) {
+		panic(err)
+	}
+	return key
+}
+
+var signTime = time.Unix(1700000000, 0)
+
+//
+// Validate the MAC of a request against one computed by hand, per RFC 8945,
+// 4.3.3, and the round trip of the TSIG record
+//
+func TestTSIGSign(t *testing.T) {
+	key := testKey()
+	request := NewQuery("example.com", RecordTypeAXFR)
+	request.Header.Id = 0x1234
+	unsigned := mustPack(t, request)
+
+	signed, mac, err := key.Sign(&request, nil, signTime)
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	variables := []byte("\x03key\x07example\x00\x00\xff\x00\x00\x00\x00" +
+		"\x0bhmac-sha256\x00\x00\x00\x65\x53\xf1\x00\x01\x2c\x00\x00\x00\x00")
+	expected := hmac.New(sha256.New, []byte("secret key bytes"))
+	expected.Write(unsigned)
+	expected.Write(variables)
+	if (!bytes.Equal(mac, expected.Sum(nil))) {
+		t.Errorf("Unexpected MAC: %x", mac)
+	}
+	if (!bytes.Equal(signed[:10], unsigned[:10]) || signed[11] != 1 ||
+		!bytes.Equal(signed[12:len(unsigned)], unsigned[12:])) {
+		t.Errorf("Unexpected message: % x", signed)
+	}
+
+	message := Message{}
+	_, err = message.Unpack(signed)
+	if (err != nil || message.TSIG == nil || len(message.AdditionalRR) != 0) {
+		t.Fatal("Unpacking error: ", err)
+	}
+	tsig := message.TSIG.Data.(*RDataTSIG)
+	if (message.TSIG.Name != "key.example" || tsig.Algorithm != "hmac-sha256" ||
+		tsig.TimeSigned != 1700000000 || tsig.Fudge != TSIGDefaultFudge ||
+		tsig.OriginalId != 0x1234 || !bytes.Equal(tsig.MAC, mac)) {
+		t.Error("Unexpected TSIG: ", message.TSIG)
+	}
+
+	// Servers verify requests without any prior MAC
+	err = key.Verify(signed, nil, signTime)
+	if (err != nil) {
+		t.Error("Verification error: ", err)
+	}
+}
+
+// Signed reply to the request, with the error + MAC adjusted, if required
+func signedReply(t *testing.T, key *TSIGKey, requestMAC []byte,
+	now time.Time, tsigError uint16) []byte {
+	request := NewQuery("example.com", RecordTypeA)
+	reply := NewReply(request, RcodeNoError)
+	reply.Header.Id = 0xbeef
+	signed, _, err := key.Sign(&reply, requestMAC, now)
+	if (err != nil) {
+		t.Fatal("Signing error: ", err)
+	}
+	if (tsigError != 0) {
+		reply.TSIG.Data.(*RDataTSIG).Error = tsigError
+		reply.TSIG.Data.(*RDataTSIG).MAC = nil
+		signed = mustPack(t, reply)
+	}
+	return signed
+}
+
+//
+// Validate the distinct failures when verifying a reply
+//
+func TestTSIGVerify(t *testing.T) {
+	key := testKey()
+	requestMAC := bytes.Repeat([]byte{ 0xaa }, 32)
+	other := *key
+	other.Name = "other.example"
+	sha512Key, _ := ParseTSIGKey("key.example:hmac-sha512:c2VjcmV0IGtleSBieXRlcw==")
+
+	tampered := signedReply(t, key, requestMAC, signTime, 0)
+	tampered[2] ^= 0x04
+	unsigned := NewReply(NewQuery("example.com", RecordTypeA), RcodeNoError)
+
+	testCases := []struct{
+		reply		[]byte
+		now			time.Time
+		err			error
+	}{
+		{ signedReply(t, key, requestMAC, signTime, 0), signTime, nil },
+		{ signedReply(t, key, requestMAC, signTime, 0),
+			signTime.Add(299 * time.Second), nil },
+		{ signedReply(t, key, requestMAC, signTime, 0),
+			signTime.Add(301 * time.Second), ErrBadTime },
+		{ signedReply(t, key, requestMAC, signTime, 0),
+			signTime.Add(-301 * time.Second), ErrBadTime },
+		{ signedReply(t, key, nil, signTime, 0), signTime, ErrBadSig },
+		{ tampered, signTime, ErrBadSig },
+		{ signedReply(t, &other, requestMAC, signTime, 0), signTime, ErrBadKey },
+		{ signedReply(t, sha512Key, requestMAC, signTime, 0), signTime, ErrBadKey },
+		{ signedReply(t, key, requestMAC, signTime, TSIGErrorBadKey), signTime,
+			ErrBadKey },
+		{ signedReply(t, key, requestMAC, signTime, TSIGErrorBadTime), signTime,
+			ErrBadTime },
+		{ mustPack(t, unsigned), signTime, ErrTSIGMissing },
+	}
+	for i, test := range testCases {
+		err := key.Verify(test.reply, requestMAC, test.now)
+		if (!errors.Is(err, test.err) || (test.err == nil && err != nil)) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+	}
+
+	// A TSIG anywhere but last is malformed
+	reply := NewReply(NewQuery("example.com", RecordTypeA), RcodeNoError)
+	reply.AddAdditional(ResourceRecord{ Name: "key.example",
+		Type: RecordTypeTSIG, Class: RecordClassANY, Data: &RDataTSIG{} })
+	reply.AddAdditional(ResourceRecord{ Name: "example.com",
+		Type: RecordTypeA, Class: RecordClassIN,
+		Data: &RDataA{ Address: []byte{ 192, 0, 2, 1 } } })
+	message := Message{}
+	_, err := message.Unpack(mustPack(t, reply))
+	if (!errors.Is(err, ErrMisplacedTSIG)) {
+		t.Error("Expected a misplaced TSIG: ", err)
+	}
+	if (!errors.Is(key.Verify(mustPack(t, reply), requestMAC, signTime),
+		ErrMisplacedTSIG)) {
+		t.Error("Expected a misplaced TSIG")
+	}
+}
+
+//
+// Validate the MAC chaining across a stream of replies, some unsigned
+//
+func TestTSIGStream(t *testing.T) {
+	key := testKey()
+	requestMAC := bytes.Repeat([]byte{ 0xaa }, 32)
+	first := signedReply(t, key, requestMAC, signTime, 0)
+	message := Message{}
+	message.Unpack(first)
+	priorMAC := message.TSIG.Data.(*RDataTSIG).MAC
+
+	// Unsigned middle message, then a signed one over both, with the timers
+	// alone in place of the variables (RFC 8945, 5.3.1)
+	middle := NewReply(NewQuery("example.com", RecordTypeA), RcodeNoError)
+	middle.Header.Id = 0xbeef
+	middleBytes := mustPack(t, middle)
+	last := NewReply(NewQuery("example.com", RecordTypeA), RcodeNoError)
+	last.Header.Id = 0xbeef
+	lastBytes := mustPack(t, last)
+	tsig := &RDataTSIG{ Algorithm: "hmac-sha256", TimeSigned: 1700000001,
+		Fudge: 300, OriginalId: 0xbeef }
+	mac := hmac.New(sha256.New, key.Secret)
+	binary.Write(mac, binary.BigEndian, uint16(len(priorMAC)))
+	mac.Write(priorMAC)
+	mac.Write(middleBytes)
+	mac.Write(lastBytes)
+	mac.Write(tsig.timers())
+	tsig.MAC = mac.Sum(nil)
+	last.TSIG = &ResourceRecord{ Name: "key.example", Type: RecordTypeTSIG,
+		Class: RecordClassANY, Data: tsig }
+
+	stream := key.NewStream(requestMAC)
+	for i, reply := range [][]byte{ first, middleBytes, mustPack(t, last) } {
+		err := stream.Verify(reply, signTime)
+		if (err != nil) {
+			t.Fatalf("%d: verification error: %v", i, err)
+		}
+		if (stream.Signed() != (i != 1)) {
+			t.Errorf("%d: unexpected signed state", i)
+		}
+	}
+
+	// Out of order, the chain breaks
+	stream = key.NewStream(requestMAC)
+	stream.Verify(first, signTime)
+	err := stream.Verify(mustPack(t, last), signTime)
+	if (err != ErrBadSig) {
+		t.Error("Expected a signature mismatch: ", err)
+	}
+
+	// The first message must be signed
+	stream = key.NewStream(requestMAC)
+	err = stream.Verify(middleBytes, signTime)
+	if (err != ErrTSIGMissing) {
+		t.Error("Expected a missing signature: ", err)
+	}
+}
+
+func TestTSIGKeys(t *testing.T) {
+	for _, spec := range []string{ "key", "key:hmac-sha256", ":hmac-sha256:c2VjcmV0",
+		"key:hmac-md5:c2VjcmV0", "key:hmac-sha256:not base64!" } {
+		_, err := ParseTSIGKey(spec)
+		if (!errors.Is(err, ErrTSIGKey) && !errors.Is(err, ErrTSIGAlgorithm)) {
+			t.Errorf("%s: expected an error: %v", spec, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "key.conf")
+	os.WriteFile(path, []byte("key \"key.example\" {\n" +
+		"\talgorithm hmac-sha512;\n\tsecret \"c2VjcmV0\";\n};\n"), 0600)
+	key, err := LoadTSIGKey(path)
+	if (err != nil || key.Name != "key.example" ||
+		key.Algorithm != TSIGAlgorithmHMACSHA512 ||
+		string(key.Secret) != "secret") {
+		t.Error("Unexpected key: ", key, err)
+	}
+}
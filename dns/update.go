@@ -0,0 +1,133 @@
+//
+// Dynamic updates, as defined by RFC 2136.  UPDATE messages reuse the four
+// sections of a query under different names: the zone (question),
+// prerequisite (answer), update (authority) and additional sections.  The
+// class + TTL of each record select what it means, e.g. class ANY with no
+// RDATA deletes an entire RRset.
+//
+
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+
+// Create a new UPDATE request for the zone, with a random message id
+func NewUpdate(zone string) Message {
+	message := NewQuery(zone, RecordTypeSOA)
+	message.Header.SetOpcode(OpcodeUpdate)
+	return message
+}
+
+
+//
+// Prerequisites (RFC 2136, 2.4)
+//
+
+// Name has at least one RR, of any type
+func (message *Message) RequireName(name string) {
+	message.AddAnswer(updateRecord(name, RecordTypeALL, RecordClassANY))
+}
+
+// Name has no RRs at all
+func (message *Message) RequireNoName(name string) {
+	message.AddAnswer(updateRecord(name, RecordTypeALL, RecordClassNONE))
+}
+
+// RRset exists, with any values
+func (message *Message) RequireRRset(name string, rtype uint16) {
+	message.AddAnswer(updateRecord(name, rtype, RecordClassANY))
+}
+
+// RRset does not exist
+func (message *Message) RequireNoRRset(name string, rtype uint16) {
+	message.AddAnswer(updateRecord(name, rtype, RecordClassNONE))
+}
+
+// RRset exists with exactly these values.  Every RR of the set must be
+// given, each via its own call
+func (message *Message) RequireRR(rr ResourceRecord) {
+	rr.Class = RecordClassIN
+	rr.TTL = 0
+	message.AddAnswer(rr)
+}
+
+
+//
+// Updates (RFC 2136, 2.5)
+//
+
+// Add the RR to its RRset
+func (message *Message) AddRR(rr ResourceRecord) {
+	rr.Class = RecordClassIN
+	message.AddNameserver(rr)
+}
+
+// Delete the entire RRset
+func (message *Message) DeleteRRset(name string, rtype uint16) {
+	message.AddNameserver(updateRecord(name, rtype, RecordClassANY))
+}
+
+// Delete every RRset of the name
+func (message *Message) DeleteName(name string) {
+	message.AddNameserver(updateRecord(name, RecordTypeALL, RecordClassANY))
+}
+
+// Delete the one RR from its RRset
+func (message *Message) DeleteRR(rr ResourceRecord) {
+	rr.Class = RecordClassNONE
+	rr.TTL = 0
+	message.AddNameserver(rr)
+}
+
+// Record without any RDATA, as both prerequisites + deletions use
+func updateRecord(name string, rtype uint16, class uint16) ResourceRecord {
+	return ResourceRecord{ Name: name, Type: rtype, Class: class }
+}
+
+
+// Parse "name [type [rdata]]" in zone file syntax, relative to the origin,
+// as a partial record for prerequisites + deletions.  The type is ALL if
+// omitted, and the typed RDATA is nil if omitted
+func ParseUpdateRecord(spec string, origin string) (ResourceRecord, error) {
+	entries, err := tokenizeZone(spec)
+	if (err == nil && len(entries) != 1) {
+		err = fmt.Errorf("%w: expected a single name [type [rdata]]",
+			ErrZoneSyntax)
+	}
+	if (err != nil) {
+		return ResourceRecord{}, err
+	}
+
+	fields := entries[0].fields
+	origin = strings.TrimSuffix(origin, ".")
+	rr := ResourceRecord{
+		Name:	AbsoluteName(fields[0].text, origin),
+		Type:	RecordTypeALL,
+		Class:	RecordClassIN,
+	}
+	if (len(fields) < 2) {
+		return rr, nil
+	}
+	rr.Type = parseType(strings.ToUpper(fields[1].text))
+	if (rr.Type == 0) {
+		return rr, fmt.Errorf("%w: unsupported type %s", ErrZoneSyntax,
+			fields[1].text)
+	}
+	if (len(fields) < 3) {
+		return rr, nil
+	}
+
+	text := make([]string, len(fields) - 2)
+	for i, field := range fields[2:] {
+		text[i] = field.text
+	}
+	rr.Data = NewRData(rr.Type)
+	err = rr.Data.Parse(text, origin)
+	if (err != nil) {
+		return rr, fmt.Errorf("%s: %w", RecordTypeString(rr.Type), err)
+	}
+	return rr, nil
+}
@@ -0,0 +1,109 @@
+package dns
+
+import(
+	"errors"
+	"testing"
+	)
+
+//
+// Validate the class, TTL + RDATA of each kind of prerequisite and update,
+// through a round trip on the wire
+//
+func TestUpdatePacking(t *testing.T) {
+	address := ResourceRecord{
+		Name:	"www.example.com",
+		Type:	RecordTypeA,
+		Class:	RecordClassIN,
+		TTL:	300,
+		Data:	&RDataA{ Address: []byte{ 192, 0, 2, 1 } },
+	}
+	update := NewUpdate("example.com")
+	update.RequireName("a.example.com")
+	update.RequireNoName("b.example.com")
+	update.RequireRRset("c.example.com", RecordTypeMX)
+	update.RequireNoRRset("d.example.com", RecordTypeTXT)
+	update.RequireRR(address)
+	update.AddRR(address)
+	update.DeleteRRset("e.example.com", RecordTypeA)
+	update.DeleteName("f.example.com")
+	update.DeleteRR(address)
+
+	message := Message{}
+	_, err := message.Unpack(mustPack(t, update))
+	if (err != nil) {
+		t.Fatal("Unpacking error: ", err)
+	}
+	if (message.Header.Opcode() != OpcodeUpdate ||
+		message.Header.String()[:31] != "flags 0x2800 (OPCODE:UPDATE), Q") {
+		t.Error("Unexpected header: ", message.Header)
+	}
+	if (len(message.Questions) != 1 ||
+		message.Questions[0].Type != RecordTypeSOA) {
+		t.Fatal("Unexpected zone section: ", message.Questions)
+	}
+
+	expected := []struct{
+		section	[]ResourceRecord
+		index	int
+		name	string
+		rtype	uint16
+		class	uint16
+		ttl		int32
+		rdata	bool
+	}{
+		{ message.Answers, 0, "a.example.com", RecordTypeALL, RecordClassANY, 0, false },
+		{ message.Answers, 1, "b.example.com", RecordTypeALL, RecordClassNONE, 0, false },
+		{ message.Answers, 2, "c.example.com", RecordTypeMX, RecordClassANY, 0, false },
+		{ message.Answers, 3, "d.example.com", RecordTypeTXT, RecordClassNONE, 0, false },
+		{ message.Answers, 4, "www.example.com", RecordTypeA, RecordClassIN, 0, true },
+		{ message.Nameservers, 0, "www.example.com", RecordTypeA, RecordClassIN, 300, true },
+		{ message.Nameservers, 1, "e.example.com", RecordTypeA, RecordClassANY, 0, false },
+		{ message.Nameservers, 2, "f.example.com", RecordTypeALL, RecordClassANY, 0, false },
+		{ message.Nameservers, 3, "www.example.com", RecordTypeA, RecordClassNONE, 0, true },
+	}
+	if (len(message.Answers) != 5 || len(message.Nameservers) != 4) {
+		t.Fatal("Unexpected sections: ", message)
+	}
+	for i, test := range expected {
+		rr := test.section[test.index]
+		if (rr.Name != test.name || rr.Type != test.rtype ||
+			rr.Class != test.class || rr.TTL != test.ttl ||
+			(rr.Data != nil) != test.rdata) {
+			t.Errorf("%d: unexpected record %s, class %d", i, rr, rr.Class)
+		}
+	}
+}
+
+func TestParseUpdateRecord(t *testing.T) {
+	testCases := []struct{
+		spec	string
+		name	string
+		rtype	uint16
+		rdata	string
+	}{
+		{ "www", "www.example.com", RecordTypeALL, "" },
+		{ "@ mx", "example.com", RecordTypeMX, "" },
+		{ "www.example.net. A 192.0.2.1", "www.example.net", RecordTypeA,
+			"192.0.2.1" },
+		{ `txt TXT "two words"`, "txt.example.com", RecordTypeTXT,
+			`"two words"` },
+	}
+	for _, test := range testCases {
+		rr, err := ParseUpdateRecord(test.spec, "example.com.")
+		rdata := ""
+		if (rr.Data != nil) {
+			rdata = rr.Data.String()
+		}
+		if (err != nil || rr.Name != test.name || rr.Type != test.rtype ||
+			rdata != test.rdata) {
+			t.Errorf("%s: unexpected record %s: %v", test.spec, rr, err)
+		}
+	}
+
+	for _, spec := range []string{ "", "www BOGUS", "www A not-an-address" } {
+		_, err := ParseUpdateRecord(spec, "example.com")
+		if (!errors.Is(err, ErrZoneSyntax) && !errors.Is(err, ErrRDataSyntax)) {
+			t.Errorf("%s: expected a syntax error: %v", spec, err)
+		}
+	}
+}
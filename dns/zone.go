@@ -0,0 +1,662 @@
+//
+// Zone (master) files, as defined by RFC 1035, 5.  Each record is a single
+// line, or several lines within parentheses, of whitespace-separated fields:
+// owner, optional TTL + class in either order, type and the RDATA in
+// presentation format.  Also supports the $ORIGIN, $INCLUDE, $TTL (RFC 2308,
+// 4) and $GENERATE (as in BIND) directives, @ for the origin, names relative
+// to the origin, blank owners for the previous owner, comments, quoted
+// character-strings and escapes.  WriteZone emits the same format.
+//
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+
+//
+// Name + TTL fields
+//
+
+// Absolute form of a name, without the trailing dot.  Names without a
+// trailing dot are relative to the origin, and @ is the origin itself
+func AbsoluteName(name string, origin string) string {
+	if (name == "@") {
+		return origin
+	}
+	if (strings.HasSuffix(name, ".")) {
+		return strings.TrimSuffix(name, ".")
+	}
+	if (origin == "") {
+		return name
+	}
+	return name + "." + origin
+}
+
+// Parse a TTL, either as plain seconds or with units, e.g. 1h30m or 2W
+func ParseTTL(field string) (uint32, error) {
+	units := map[byte]uint64{
+		's': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800,
+	}
+
+	if (field == "") {
+		return 0, ErrZoneSyntax
+	}
+	var total, value uint64
+	digits := false
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if (c >= '0' && c <= '9') {
+			value = value * 10 + uint64(c - '0')
+			digits = true
+		} else if unit, ok := units[c | 0x20]; ok && digits {
+			total += value * unit
+			value = 0
+			digits = false
+		} else {
+			return 0, ErrZoneSyntax
+		}
+		if (value > 1 << 32 || total > 1 << 32) {
+			return 0, ErrZoneSyntax
+		}
+	}
+	total += value
+	if (total > 0x7FFFFFFF) {
+		return 0, ErrZoneSyntax // RFC 2181, 8
+	}
+	return uint32(total), nil
+}
+
+
+//
+// Tokenizer.  Splits the file into entries, one per record or directive,
+// each with its list of fields
+//
+type zoneField struct {
+	text	string	// Escapes already decoded
+	raw		string	// As in the file, including any quotes
+	quoted	bool
+}
+
+type zoneEntry struct {
+	line		int		// Where the entry starts, for errors
+	blankOwner	bool	// Entry starts with whitespace
+	fields		[]zoneField
+}
+
+func tokenizeZone(input string) ([]zoneEntry, error) {
+	var entries []zoneEntry
+	entry := zoneEntry{ line: 1 }
+	line := 1
+	depth := 0
+
+	endEntry := func() {
+		if (len(entry.fields) > 0) {
+			entries = append(entries, entry)
+		}
+		entry = zoneEntry{ line: line }
+	}
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+			case c == '\n':
+				line++
+				i++
+				if (depth == 0) {
+					endEntry()
+					if (i < len(input) && (input[i] == ' ' || input[i] == '\t')) {
+						entry.blankOwner = true
+					}
+				}
+
+			case c == ' ' || c == '\t' || c == '\r':
+				if (i == 0) {
+					entry.blankOwner = true
+				}
+				i++
+
+			case c == ';':
+				for (i < len(input) && input[i] != '\n') {
+					i++
+				}
+
+			case c == '(':
+				depth++
+				i++
+
+			case c == ')':
+				if (depth == 0) {
+					return nil, fmt.Errorf("line %d: %w: unbalanced )",
+						line, ErrZoneSyntax)
+				}
+				depth--
+				i++
+
+			case c == '"':
+				// Quoted character-string, which may include whitespace
+				start := line
+				offset := i
+				var builder strings.Builder
+				i++
+				for (i < len(input) && input[i] != '"') {
+					if (input[i] == '\n') {
+						line++
+					}
+					var err error
+					i, err = unescapeTo(&builder, input, i)
+					if (err != nil) {
+						return nil, fmt.Errorf("line %d: %w: %s", line,
+							ErrZoneSyntax, err)
+					}
+				}
+				if (i >= len(input)) {
+					return nil, fmt.Errorf("line %d: %w: unterminated string",
+						start, ErrZoneSyntax)
+				}
+				i++
+				entry.fields = append(entry.fields, zoneField{
+					text:	builder.String(),
+					raw:	input[offset:i],
+					quoted:	true,
+				})
+
+			default:
+				// The generic RDATA marker keeps its backslash (RFC 3597, 5)
+				var builder strings.Builder
+				start := i
+				for (i < len(input) && !strings.ContainsRune(" \t\r\n;()\"",
+					rune(input[i]))) {
+					var err error
+					i, err = unescapeTo(&builder, input, i)
+					if (err != nil) {
+						return nil, fmt.Errorf("line %d: %w: %s", line,
+							ErrZoneSyntax, err)
+					}
+				}
+				text := builder.String()
+				if (input[start:i] == "\\#") {
+					text = "\\#"
+				}
+				entry.fields = append(entry.fields,
+					zoneField{ text: text, raw: input[start:i] })
+		}
+	}
+
+	if (depth > 0) {
+		return nil, fmt.Errorf("line %d: %w: unbalanced (", entry.line,
+			ErrZoneSyntax)
+	}
+	endEntry()
+	return entries, nil
+}
+
+// Append the next character to the builder, decoding any \X or \DDD escape
+// (RFC 1035, 5.1).  Returns the index of the following character.  A \DDD
+// escape must stand for a single octet, so \256 and above are errors
+func unescapeTo(builder *strings.Builder, input string, i int) (int, error) {
+	if (input[i] != '\\' || i + 1 >= len(input)) {
+		builder.WriteByte(input[i])
+		return i + 1, nil
+	}
+	if (i + 3 < len(input)) {
+		value, err := strconv.ParseUint(input[i + 1:i + 4], 10, 16)
+		if (err == nil && value > 255) {
+			return i, fmt.Errorf("escape %s out of range", input[i:i + 4])
+		}
+		if (err == nil) {
+			builder.WriteByte(byte(value))
+			return i + 4, nil
+		}
+	}
+	builder.WriteByte(input[i + 1])
+	return i + 2, nil
+}
+
+// Text of a field that holds a name.  Names are held as dotted strings, so an
+// escape may stand for any byte but a dot within a label
+func nameText(field zoneField) (string, error) {
+	if (field.quoted) {
+		return "", fmt.Errorf("quoted name %s", field.raw)
+	}
+	for i := 0; i < len(field.raw); {
+		if (field.raw[i] != '\\') {
+			i++
+			continue
+		}
+		var builder strings.Builder
+		var err error
+		i, err = unescapeTo(&builder, field.raw, i)
+		if (err != nil) {
+			return "", err
+		}
+		if (builder.String() == ".") {
+			return "", fmt.Errorf("unsupported escape in name %s", field.raw)
+		}
+	}
+	return field.text, nil
+}
+
+
+//
+// Parser
+//
+const MaxIncludeDepth	= 8
+const MaxGenerate		= 65536 // Records per $GENERATE directive
+
+type zoneParser struct {
+	file		string	// For errors, and relative $INCLUDE paths
+	origin		string
+	owner		string	// Of the previous record
+	hasOwner	bool
+	defaultTTL	int64	// From $TTL, else -1
+	lastTTL		int64	// Of the previous record, without $TTL (RFC 1035)
+	includes	int		// Nesting depth of $INCLUDE
+	records		[]ResourceRecord
+}
+
+// Parse the records of a zone file.  The origin applies until the first
+// $ORIGIN directive, if any.  The file name appears in errors, and any
+// relative $INCLUDE paths are relative to the directory of the file
+func ParseZone(reader io.Reader, origin string, file string) ([]ResourceRecord, error) {
+	input, err := io.ReadAll(reader)
+	if (err != nil) {
+		return nil, err
+	}
+
+	parser := &zoneParser{
+		file:		file,
+		origin:		strings.TrimSuffix(origin, "."),
+		defaultTTL:	-1,
+		lastTTL:	-1,
+	}
+	err = parser.parse(string(input))
+	if (err != nil) {
+		return nil, err
+	}
+	return parser.records, nil
+}
+
+// Parse a zone file from disk
+func LoadZone(path string, origin string) ([]ResourceRecord, error) {
+	file, err := os.Open(path)
+	if (err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseZone(file, origin, path)
+}
+
+func (parser *zoneParser) parse(input string) error {
+	entries, err := tokenizeZone(input)
+	if (err != nil) {
+		return fmt.Errorf("%s: %w", parser.file, err)
+	}
+	for _, entry := range entries {
+		if (!entry.blankOwner && !entry.fields[0].quoted &&
+			strings.HasPrefix(entry.fields[0].text, "$")) {
+			err = parser.directive(entry)
+		} else {
+			err = parser.record(entry)
+		}
+		if (err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (parser *zoneParser) fail(entry zoneEntry, format string,
+	args ...any) error {
+	return fmt.Errorf("%s:%d: %w: %s", parser.file, entry.line, ErrZoneSyntax,
+		fmt.Sprintf(format, args...))
+}
+
+func (parser *zoneParser) directive(entry zoneEntry) error {
+	fields := entry.fields
+	switch strings.ToUpper(fields[0].text) {
+		case "$ORIGIN":
+			if (len(fields) != 2) {
+				return parser.fail(entry, "expected $ORIGIN name")
+			}
+			origin, err := parser.name(fields[1])
+			if (err != nil) {
+				return parser.fail(entry, "%s", err)
+			}
+			parser.origin = origin
+
+		case "$TTL":
+			if (len(fields) != 2) {
+				return parser.fail(entry, "expected $TTL ttl")
+			}
+			ttl, err := ParseTTL(fields[1].text)
+			if (err != nil) {
+				return parser.fail(entry, "invalid TTL %s", fields[1].text)
+			}
+			parser.defaultTTL = int64(ttl)
+
+		case "$INCLUDE":
+			if (len(fields) != 2 && len(fields) != 3) {
+				return parser.fail(entry, "expected $INCLUDE path [origin]")
+			}
+			return parser.include(entry)
+
+		case "$GENERATE":
+			if (len(fields) < 5) {
+				return parser.fail(entry,
+					"expected $GENERATE range owner [ttl] [class] type rdata")
+			}
+			return parser.generate(entry)
+
+		default:
+			return parser.fail(entry, "unsupported directive %s", fields[0].text)
+	}
+	return nil
+}
+
+// Parse another file in place of the directive.  Any origin applies only
+// within that file, and neither the origin nor the owner of the previous
+// record carry over after it (RFC 1035, 5.1)
+func (parser *zoneParser) include(entry zoneEntry) error {
+	if (parser.includes >= MaxIncludeDepth) {
+		return parser.fail(entry, "too many nested $INCLUDEs")
+	}
+	path := entry.fields[1].text
+	if (!filepath.IsAbs(path)) {
+		path = filepath.Join(filepath.Dir(parser.file), path)
+	}
+	input, err := os.ReadFile(path)
+	if (err != nil) {
+		return fmt.Errorf("%s:%d: %w", parser.file, entry.line, err)
+	}
+
+	saved := *parser
+	if (len(entry.fields) == 3) {
+		origin, err := parser.name(entry.fields[2])
+		if (err != nil) {
+			return parser.fail(entry, "%s", err)
+		}
+		parser.origin = origin
+	}
+	parser.file = path
+	parser.includes++
+	err = parser.parse(string(input))
+
+	parser.file		= saved.file
+	parser.origin	= saved.origin
+	parser.owner	= saved.owner
+	parser.hasOwner	= saved.hasOwner
+	parser.includes	= saved.includes
+	return err
+}
+
+// Expand a range of similar records, as in BIND: $GENERATE start-stop[/step]
+// followed by a record template, in which $ is replaced by each value
+func (parser *zoneParser) generate(entry zoneEntry) error {
+	var start, stop, step uint64
+	step = 1
+	bounds, stride, strided := strings.Cut(entry.fields[1].text, "/")
+	first, last, ok := strings.Cut(bounds, "-")
+	var err error
+	if (ok) {
+		start, err = strconv.ParseUint(first, 10, 32)
+		if (err == nil) {
+			stop, err = strconv.ParseUint(last, 10, 32)
+		}
+	}
+	if (err == nil && strided) {
+		step, err = strconv.ParseUint(stride, 10, 32)
+	}
+	if (!ok || err != nil || stop < start || step == 0 ||
+		(stop - start) / step >= MaxGenerate) {
+		return parser.fail(entry, "invalid range %s", entry.fields[1].text)
+	}
+
+	var template []string
+	for _, field := range entry.fields[2:] {
+		template = append(template, field.raw)
+	}
+	for value := start; value <= stop; value += step {
+		line, err := expandTemplate(strings.Join(template, " "), int64(value))
+		if (err != nil) {
+			return parser.fail(entry, "%s", err)
+		}
+		generated, err := tokenizeZone(line)
+		if (err != nil) {
+			return parser.fail(entry, "%s", err)
+		}
+		generated[0].line = entry.line
+		err = parser.record(generated[0])
+		if (err != nil) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace each $ in the template with the value, or \$ with a literal $.
+// The ${offset,width,base} form also adjusts + formats the value, with a
+// base of d, o, x or X
+func expandTemplate(template string, value int64) (string, error) {
+	var builder strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if (c == '\\' && i + 1 < len(template)) {
+			builder.WriteString(template[i:i + 2])
+			i++
+			continue
+		}
+		if (c != '$') {
+			builder.WriteByte(c)
+			continue
+		}
+
+		offset, width, verb := int64(0), uint64(0), "d"
+		if (i + 1 < len(template) && template[i + 1] == '{') {
+			end := strings.IndexByte(template[i:], '}')
+			if (end < 0) {
+				return "", ErrZoneSyntax
+			}
+			modifiers := strings.Split(template[i + 2:i + end], ",")
+			var err error
+			offset, err = strconv.ParseInt(modifiers[0], 10, 32)
+			if (err == nil && len(modifiers) > 1) {
+				width, err = strconv.ParseUint(modifiers[1], 10, 8)
+			}
+			if (err == nil && len(modifiers) > 2) {
+				verb = modifiers[2]
+				if (verb != "d" && verb != "o" && verb != "x" && verb != "X") {
+					err = ErrZoneSyntax
+				}
+			}
+			if (err != nil || len(modifiers) > 3) {
+				return "", fmt.Errorf("invalid modifier %s",
+					template[i:i + end + 1])
+			}
+			i += end
+		}
+		fmt.Fprintf(&builder, "%0*" + verb, int(width), value + offset)
+	}
+	return builder.String(), nil
+}
+
+// Absolute form of a name field, relative to the current origin.  The name
+// must fit in a message, as must those in the RDATA (see parseRData)
+func (parser *zoneParser) name(field zoneField) (string, error) {
+	name, err := nameText(field)
+	if (err != nil) {
+		return "", err
+	}
+	name = AbsoluteName(name, parser.origin)
+	return name, checkName(name)
+}
+
+func (parser *zoneParser) record(entry zoneEntry) error {
+	fields := entry.fields
+
+	// Owner, which may be omitted in favour of the previous one
+	if (!entry.blankOwner) {
+		owner, err := parser.name(fields[0])
+		if (err != nil) {
+			return parser.fail(entry, "%s", err)
+		}
+		parser.owner = owner
+		parser.hasOwner = true
+		fields = fields[1:]
+	} else if (!parser.hasOwner) {
+		return parser.fail(entry, "missing owner")
+	}
+
+	// Optional TTL + class, in either order, then the type
+	rr := ResourceRecord{ Name: parser.owner }
+	ttl := int64(-1)
+	for (len(fields) > 0 && rr.Type == 0) {
+		field := strings.ToUpper(fields[0].text)
+		fields = fields[1:]
+		class := parseClass(field)
+		if (class != 0 && rr.Class == 0) {
+			rr.Class = class
+			continue
+		}
+		if (field != "" && field[0] >= '0' && field[0] <= '9' && ttl < 0) {
+			value, err := ParseTTL(field)
+			if (err != nil) {
+				return parser.fail(entry, "invalid TTL %s", field)
+			}
+			ttl = int64(value)
+			continue
+		}
+		rr.Type = parseType(field)
+		if (rr.Type == 0) {
+			return parser.fail(entry, "unsupported type or class %s", field)
+		}
+	}
+	if (rr.Type == 0) {
+		return parser.fail(entry, "missing type")
+	}
+	if (rr.Class == 0) {
+		rr.Class = RecordClassIN
+	}
+
+	var err error
+	rr.Data, err = parseRData(rr.Type, fields, parser.origin)
+	if (err != nil) {
+		return parser.fail(entry, "%s: %s", RecordTypeString(rr.Type), err)
+	}
+
+	// TTL, falling back to $TTL, then the previous record
+	if (ttl < 0) {
+		ttl = parser.defaultTTL
+	} else if (parser.defaultTTL < 0) {
+		parser.lastTTL = ttl
+	}
+	if (ttl < 0) {
+		ttl = parser.lastTTL
+	}
+	if (ttl < 0) {
+		return parser.fail(entry, "missing TTL, without $TTL")
+	}
+	rr.TTL = int32(ttl)
+	parser.records = append(parser.records, rr)
+	return nil
+}
+
+// RDATA of the given type, possibly in the generic encoding even for known
+// types.  Only TXT has character-strings, so the fields of any other type
+// are held to the same escapes as names.  Any names must also fit in a
+// message, which packing the RDATA checks
+func parseRData(rtype uint16, fields []zoneField, origin string) (RData, error) {
+	generic := (len(fields) > 0 && fields[0].text == "\\#" &&
+		!fields[0].quoted)
+	text := make([]string, len(fields))
+	for i, field := range fields {
+		text[i] = field.text
+		if (rtype != RecordTypeTXT && !generic) {
+			_, err := nameText(field)
+			if (err != nil) {
+				return nil, err
+			}
+		}
+	}
+	var err error
+	rdata := NewRData(rtype)
+	if (generic) {
+		unknown := &RDataUnknown{}
+		err = unknown.Parse(text, origin)
+		if (err == nil) {
+			err = rdata.Unpack(unknown.Bytes, 0, len(unknown.Bytes))
+		}
+	} else {
+		err = rdata.Parse(text, origin)
+		if (err == nil) {
+			err = rdata.Pack(new(bytes.Buffer), nil)
+		}
+	}
+	return rdata, err
+}
+
+
+//
+// Writer
+//
+
+// Write the records in canonical presentation format: one line per record,
+// with absolute owner names, explicit TTLs + classes, and names in RDATA
+// relative to the root via $ORIGIN
+func WriteZone(writer io.Writer, records []ResourceRecord) error {
+	_, err := fmt.Fprintln(writer, "$ORIGIN .")
+	for _, rr := range records {
+		if (err != nil) {
+			break
+		}
+		_, err = fmt.Fprintln(writer, ZoneString(rr))
+	}
+	return err
+}
+
+// Single record in presentation format, as in a zone file.  Names are escaped
+// where needed, in the owner + RDATA alike
+func ZoneString(rr ResourceRecord) string {
+	rdata := ""
+	if (rr.Data != nil) {
+		rdata = rr.Data.String()
+	} else {
+		rdata = (&RDataUnknown{ Bytes: rr.RData }).String()
+	}
+	return fmt.Sprintf("%s.\t%d\t%s\t%s\t%s",
+		escapeName(strings.TrimSuffix(rr.Name, ".")), rr.TTL, RecordClassString(rr.Class), RecordTypeString(rr.Type), rdata)
+}
+
+// Record type by mnemonic, or in the generic TYPEnnn form (RFC 3597, 5).
+// Zero if unknown, or only valid in questions
+func parseType(field string) uint16 {
+	if (strings.HasPrefix(field, "TYPE")) {
+		value, err := strconv.ParseUint(field[4:], 10, 16)
+		if (err == nil) {
+			return uint16(value)
+		}
+	}
+	rtype := RecordTypeMapToType[field]
+	if (rtype == RecordTypeALL || rtype == RecordTypeAXFR ||
+		rtype == RecordTypeIXFR) {
+		return 0
+	}
+	return rtype
+}
+
+// Record class by mnemonic, or in the generic CLASSnnn form (RFC 3597, 5).
+// Zero if unknown, or only valid in questions + updates
+func parseClass(field string) uint16 {
+	class := parseMnemonic(field, "CLASS", RecordClassMapToString)
+	if (class == RecordClassNONE || class == RecordClassANY) {
+		return 0
+	}
+	return class
+}
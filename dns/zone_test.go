@@ -0,0 +1,303 @@
+package dns
+
+import(
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	)
+
+//
+// Validate the directives, relative names, owner + TTL inheritance, and
+// multi-line records of a complete zone file
+//
+func TestParseZone(t *testing.T) {
+	zone := `
+$TTL 1h
+@	IN	SOA	ns1 hostmaster (
+			2024010101	; serial
+			2h 30m 2w 5m )
+	IN	NS	ns1
+	IN	NS	ns1.example.net.
+ns1		A	192.0.2.1
+www	300	IN	A	192.0.2.2
+		IN 600	AAAA	2001:db8::2
+mail	MX	10 @
+txt		TXT	"v=spf1 -all" "semi;colon" "esc\"aped\032" plain
+$ORIGIN sub.example.com.
+*		TXT	"wildcard"
+opaque	TYPE65280	\# 2 dead
+a		A	\# 4 c0000203
+`
+	records, err := ParseZone(strings.NewReader(zone), "example.com.", "test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+
+	expected := []struct{
+		name	string
+		rtype	uint16
+		ttl		int32
+		data	string
+	}{
+		{ "example.com", RecordTypeSOA, 3600,
+			"ns1.example.com hostmaster.example.com 2024010101 7200 1800 1209600 300" },
+		{ "example.com", RecordTypeNS, 3600, "ns1.example.com" },
+		{ "example.com", RecordTypeNS, 3600, "ns1.example.net" },
+		{ "ns1.example.com", RecordTypeA, 3600, "192.0.2.1" },
+		{ "www.example.com", RecordTypeA, 300, "192.0.2.2" },
+		{ "www.example.com", RecordTypeAAAA, 600, "2001:db8::2" },
+		{ "mail.example.com", RecordTypeMX, 3600, "10 example.com" },
+		{ "txt.example.com", RecordTypeTXT, 3600,
+			`"v=spf1 -all" "semi;colon" "esc\"aped " "plain"` },
+		{ "*.sub.example.com", RecordTypeTXT, 3600, `"wildcard"` },
+		{ "opaque.sub.example.com", 65280, 3600, `\# 2 dead` },
+		{ "a.sub.example.com", RecordTypeA, 3600, "192.0.2.3" },
+	}
+	if (len(records) != len(expected)) {
+		t.Fatal("Unexpected record count: ", len(records))
+	}
+	for i, rr := range records {
+		if (rr.Name != expected[i].name || rr.Type != expected[i].rtype ||
+			rr.TTL != expected[i].ttl || rr.Class != RecordClassIN ||
+			rr.Data.String() != expected[i].data) {
+			t.Error("Unexpected record: ", rr)
+		}
+	}
+	if !reflect.DeepEqual(records[3].Data, &RDataA{ net.ParseIP("192.0.2.1").To4() }) {
+		t.Error("Unexpected address: ", records[3].Data)
+	}
+}
+
+
+//
+// Validate TTLs with + without units
+//
+func TestParseTTL(t *testing.T) {
+	testCases := []struct{
+		field	string
+		ttl		uint32
+		ok		bool
+	}{
+		{ "0", 0, true },
+		{ "3600", 3600, true },
+		{ "1h30m", 5400, true },
+		{ "1W2D", 777600, true },
+		{ "2147483647", 2147483647, true },
+		{ "2147483648", 0, false },
+		{ "h", 0, false },
+		{ "1x", 0, false },
+		{ "", 0, false },
+	}
+
+	for _, test := range testCases {
+		ttl, err := ParseTTL(test.field)
+		if ((err == nil) != test.ok || ttl != test.ttl) {
+			t.Errorf("%q: unexpected TTL %d, %v", test.field, ttl, err)
+		}
+	}
+}
+
+
+//
+// Validate the reporting of malformed zone files
+//
+func TestParseZoneErrors(t *testing.T) {
+	testCases := []string{
+		"@ 3600 SOA ns1 hostmaster ( 1 2 3 4 5",
+		"@ 3600 SOA ns1 hostmaster 1 2 3 4 5 )",
+		"@ 3600 TXT \"unterminated",
+		"@ A 192.0.2.1",
+		"@ 3600 A 2001:db8::1",
+		"@ 3600 BOGUS data",
+		"@ 3600 IN CH A 192.0.2.1",
+		"@ 3600 ANY A 192.0.2.1",
+		"@ 3600 CLASS65536 A 192.0.2.1",
+		"@ 3600 MX mail",
+		"@ 3600 TYPE65280 \\# 3 dead",
+		"\t3600 A 192.0.2.1",
+		"$INCLUDE",
+		"$INCLUDE missing.zone",
+		"$BOGUS",
+		"$GENERATE 5-1 host-$ A 192.0.2.$",
+		"$GENERATE 1-5/0 host-$ A 192.0.2.$",
+		"$GENERATE 0-100000 host-$ A 192.0.2.1",
+		"$GENERATE 1-5 host-${1,2,q} 3600 A 192.0.2.$",
+		"$GENERATE 1-5 host-$ 3600 A 192.0.2.${300}",
+		"a\\.b 3600 A 192.0.2.1",
+		"\"a b\" 3600 A 192.0.2.1",
+		"@ 3600 CNAME a\\.b",
+		"@ 3600 SOA ns1 host\\.master 1 2 3 4 5",
+		"$ORIGIN a\\.b.",
+		"a\\256b 3600 A 192.0.2.1",
+		"@ 3600 TXT \"a\\300b\"",
+		"@ 3600 TXT a\\999",
+		"a..b 3600 A 192.0.2.1",
+		"a.. 3600 A 192.0.2.1",
+		"$ORIGIN a..b.",
+		"@ 3600 CNAME a..b",
+		"@ 3600 MX 10 .a",
+		"@ 3600 NS " + strings.Repeat("a", 64),
+		strings.Repeat("a", 64) + " 3600 A 192.0.2.1",
+		strings.Repeat(strings.Repeat("a", 63) + ".", 4) + " 3600 A 192.0.2.1",
+		"@ 3600 SOA " + strings.Repeat("a.", 128) + " host 1 2 3 4 5",
+	}
+
+	for _, test := range testCases {
+		_, err := ParseZone(strings.NewReader(test), "example.com", "test")
+		if (err == nil || (!errors.Is(err, ErrZoneSyntax) &&
+			!errors.Is(err, os.ErrNotExist))) {
+			t.Errorf("%q: expected a syntax error: %v", test, err)
+		}
+	}
+}
+
+
+//
+// Validate $INCLUDE + $GENERATE, relative to the including file
+//
+func TestZoneDirectives(t *testing.T) {
+	records, err := LoadZone("testdata/example.com.zone", "")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+
+	var lines []string
+	for _, rr := range records[8:] {
+		lines = append(lines, ZoneString(rr))
+	}
+	expected := []string{
+		"hosts.example.com.\t86400\tIN\tA\t192.0.2.10",
+		"web.hosts.example.com.\t86400\tIN\tA\t192.0.2.11",
+		"after.example.com.\t86400\tIN\tA\t192.0.2.100",
+		"host-1.example.com.\t86400\tIN\tCNAME\thost-011.hosts.example.com",
+		"host-2.example.com.\t86400\tIN\tCNAME\thost-012.hosts.example.com",
+		"host-3.example.com.\t86400\tIN\tCNAME\thost-013.hosts.example.com",
+		"08.rev.example.com.\t86400\tIN\tPTR\thost-8.hosts.example.com",
+		"0c.rev.example.com.\t86400\tIN\tPTR\thost-12.hosts.example.com",
+		"10.rev.example.com.\t86400\tIN\tPTR\thost-16.hosts.example.com",
+	}
+	if (strings.Join(lines, "\n") != strings.Join(expected, "\n")) {
+		t.Error("Unexpected records:\n", strings.Join(lines, "\n"))
+	}
+}
+
+
+//
+// Validate that written zones parse back into the same records
+//
+func TestWriteZone(t *testing.T) {
+	records, err := LoadZone("testdata/example.com.zone", "")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+
+	var buffer bytes.Buffer
+	err = WriteZone(&buffer, records)
+	if (err != nil) {
+		t.Fatal("Writing error: ", err)
+	}
+	written := buffer.String()
+	parsed, err := ParseZone(strings.NewReader(written), "unrelated.org",
+		"written")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err, "\n", written)
+	}
+	if !reflect.DeepEqual(records, parsed) {
+		t.Error("Round trip mismatch:\n", written)
+	}
+
+	// Check the tricky cases in the canonical form
+	for _, line := range []string{
+		"example.com.\t86400\tIN\tMX\t0 .",
+		"txt.example.com.\t86400\tIN\tTXT\t\"quoted \\\"string\\\"\" " +
+			"\"tab\\009 and \\\\backslash\" \"unquoted;semicolon\"",
+		"empty.example.com.\t86400\tIN\tTXT\t\"\"",
+		"opaque.example.com.\t86400\tIN\tTYPE65280\t\\# 4 deadbeef",
+	} {
+		if (!strings.Contains(written, line + "\n")) {
+			t.Error("Expected ", line, " in:\n", written)
+		}
+	}
+
+	// Writing again changes nothing
+	var again bytes.Buffer
+	WriteZone(&again, parsed)
+	if (again.String() != written) {
+		t.Error("Unstable output:\n", again.String())
+	}
+}
+
+
+//
+// Validate that escapes in names survive a round trip, whether or not they
+// stand for characters that need escaping, and that TXT keeps the rest
+//
+func TestZoneNameEscapes(t *testing.T) {
+	zone := `
+\065\-b.example.com.	300	IN	CNAME	c\097t
+txt			300	IN	TXT		a\.b c\032d
+a\ b\;c\(d\)\"e\\f\255	300	IN	MX		10 x\009y\(z
+`
+	records, err := ParseZone(strings.NewReader(zone), "example.com", "test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	expected := []string{
+		"A-b.example.com.\t300\tIN\tCNAME\tcat.example.com",
+		"txt.example.com.\t300\tIN\tTXT\t\"a.b\" \"c d\"",
+		"a\\032b\\;c\\(d\\)\\\"e\\\\f\\255.example.com.\t300\tIN\tMX\t" +
+			"10 x\\009y\\(z.example.com",
+	}
+	var written bytes.Buffer
+	for i, rr := range records {
+		line := ZoneString(rr)
+		if (line != expected[i]) {
+			t.Errorf("Unexpected record %q, expected %q", line, expected[i])
+		}
+		written.WriteString(line + "\n")
+	}
+
+	parsed, err := ParseZone(&written, "", "written")
+	if (err != nil || !reflect.DeepEqual(records, parsed)) {
+		t.Error("Round trip mismatch: ", parsed, err)
+	}
+}
+
+
+//
+// Validate the class mnemonics + the generic CLASSnnn form, which the writer
+// keeps
+//
+func TestZoneClasses(t *testing.T) {
+	zone := `
+version.bind.	0	CH	TXT	"ddnsr"
+host			hs	300	TXT	"hesiod"
+opaque			300	CLASS32	A	192.0.2.1
+`
+	records, err := ParseZone(strings.NewReader(zone), "example.com", "test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	expected := []string{
+		"version.bind.\t0\tCH\tTXT\t\"ddnsr\"",
+		"host.example.com.\t300\tHS\tTXT\t\"hesiod\"",
+		"opaque.example.com.\t300\tCLASS32\tA\t192.0.2.1",
+	}
+	var written bytes.Buffer
+	for i, rr := range records {
+		line := ZoneString(rr)
+		if (line != expected[i]) {
+			t.Errorf("Unexpected record %q, expected %q", line, expected[i])
+		}
+		written.WriteString(line + "\n")
+	}
+
+	parsed, err := ParseZone(&written, "", "written")
+	if (err != nil || !reflect.DeepEqual(records, parsed)) {
+		t.Error("Round trip mismatch: ", parsed, err)
+	}
+}
@@ -0,0 +1,333 @@
+//
+// In-memory response cache.  Answers are cached per question (name, type,
+// class) + DO bit + CD flag for the lifetime of their shortest TTL, and the
+// TTLs decay while cached.  Negative answers (NXDOMAIN + NODATA) are cached
+// for the SOA minimum, per RFC 2308.  The least-recently used entries are
+// evicted once the cache is full.
+//
+
+package resolver
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+const DefaultCacheSize			= 10000
+const DefaultCacheMaxTTL		= 24 * time.Hour
+const DefaultCacheMaxNegativeTTL = 3 * time.Hour // RFC 2308, 5
+
+
+type CacheKey struct {
+	Name	string	// Canonical, see dns.CanonicalName
+	Type	uint16
+	Class	uint16
+	DNSSEC	bool	// DO bit, as only DO queries have RRSIGs in their answers
+	CD		bool	// CD flag, as only CD queries may have bogus answers
+}
+
+func messageKey(message *dns.Message) CacheKey {
+	question := message.Questions[0]
+	return CacheKey{
+		Name:	dns.CanonicalName(question.Name),
+		Type:	question.Type,
+		Class:	question.Class,
+		DNSSEC:	(message.EDNS != nil && message.EDNS.DNSSECOK),
+		CD:		(message.Header.Flags &
+			dns.MessageHeaderFlagCheckingDisabled != 0),
+	}
+}
+
+type cacheEntry struct {
+	key			CacheKey
+	flags		uint16					// Including the response code
+	answers		[]dns.ResourceRecord
+	authority	[]dns.ResourceRecord	// SOA + proof, for negative answers
+	stored		time.Time
+	expires		time.Time
+}
+
+type CacheStats struct {
+	Entries		int
+	Hits		uint64
+	Misses		uint64
+	Evictions	uint64
+}
+
+
+//
+// Response cache.  The zero value is ready for use with the default limits.
+// A Cache is safe for concurrent use as long as its fields are not modified
+//
+type Cache struct {
+	MaxEntries		int				// Defaults to DefaultCacheSize
+	MinTTL			time.Duration	// Optional lower bound on TTLs
+	MaxTTL			time.Duration	// Defaults to DefaultCacheMaxTTL
+	MaxNegativeTTL	time.Duration	// Defaults to DefaultCacheMaxNegativeTTL
+
+	// Current time, for testing.  Defaults to time.Now
+	Now				func() time.Time
+
+	lock			sync.Mutex
+	entries			map[CacheKey]*list.Element
+	lru				list.List	// Most recently used first
+	stats			CacheStats
+}
+
+func (cache *Cache) now() time.Time {
+	if (cache.Now != nil) {
+		return cache.Now()
+	}
+	return time.Now()
+}
+
+// Lifetime for a set of records, clamped to the cache limits
+func (cache *Cache) lifetime(ttl time.Duration, negative bool) time.Duration {
+	maxTTL := cache.MaxTTL
+	if (maxTTL == 0) {
+		maxTTL = DefaultCacheMaxTTL
+	}
+	if (negative) {
+		maxTTL = cache.MaxNegativeTTL
+		if (maxTTL == 0) {
+			maxTTL = DefaultCacheMaxNegativeTTL
+		}
+	}
+
+	if (ttl < cache.MinTTL) {
+		ttl = cache.MinTTL
+	}
+	if (ttl > maxTTL) {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+func ttlDuration(ttl int32) time.Duration {
+	if (ttl < 0) {
+		return 0
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+
+// Store the reply, if cacheable.  Positive answers are cached for the
+// smallest TTL of their records; negative answers for the smaller of the SOA
+// TTL + SOA minimum (RFC 2308, 5), along with any CNAME/DNAME chain that led
+// to them.  Negative answers without an SOA are not cached, nor are truncated
+// replies or server failures.  The reply is cached
+// for DO queries if it has the DO bit, which servers copy from the query
+// (RFC 3225, 3), and likewise for CD queries if it has the CD flag
+func (cache *Cache) Put(reply *dns.Message) {
+	if (len(reply.Questions) != 1) {
+		return
+	}
+	cache.put(messageKey(reply), reply)
+}
+
+// Whether the answers hold records of the type asked for, rather than just a
+// CNAME/DNAME chain that ends in NODATA (RFC 2308, 2.2)
+func answersQuestion(answers []dns.ResourceRecord,
+	question dns.Question) bool {
+	for _, rr := range answers {
+		if (rr.Type == question.Type || question.Type == dns.RecordTypeALL) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cache *Cache) put(key CacheKey, reply *dns.Message) {
+	if (reply.Header.Flags & dns.MessageHeaderFlagTruncation != 0) {
+		return
+	}
+	rcode := reply.Rcode()
+	if (rcode != dns.RcodeNoError && rcode != dns.RcodeNameError) {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:	key,
+		flags:	reply.Header.Flags &^ dns.MessageHeaderFlagAuthoritative,
+	}
+
+	var ttl time.Duration
+	negative := (rcode == dns.RcodeNameError ||
+		!answersQuestion(reply.Answers, reply.Questions[0]))
+	if (negative) {
+		for _, rr := range reply.Nameservers {
+			soa, ok := rr.Data.(*dns.RDataSOA)
+			if (!ok) {
+				continue
+			}
+			ttl = ttlDuration(soa.NegativeTTL(rr.TTL))
+			entry.authority = []dns.ResourceRecord{ rr }
+			break
+		}
+		if (entry.authority == nil) {
+			return
+		}
+
+		// Along with the proof of non-existence, for DNSSEC validation
+		for _, rr := range reply.Nameservers {
+			switch (rr.Type) {
+				case dns.RecordTypeRRSIG, dns.RecordTypeNSEC,
+					dns.RecordTypeNSEC3:
+					entry.authority = append(entry.authority, rr)
+			}
+		}
+
+		// The chain expires along with the answer that it leads to
+		for _, rr := range reply.Answers {
+			if (ttlDuration(rr.TTL) < ttl) {
+				ttl = ttlDuration(rr.TTL)
+			}
+		}
+		entry.answers = reply.Answers
+	} else {
+		ttl = ttlDuration(reply.Answers[0].TTL)
+		for _, rr := range reply.Answers {
+			if (ttlDuration(rr.TTL) < ttl) {
+				ttl = ttlDuration(rr.TTL)
+			}
+		}
+		entry.answers = reply.Answers
+	}
+
+	ttl = cache.lifetime(ttl, negative)
+	if (ttl <= 0) {
+		return
+	}
+	entry.stored = cache.now()
+	entry.expires = entry.stored.Add(ttl)
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if (cache.entries == nil) {
+		cache.entries = map[CacheKey]*list.Element{}
+	}
+	if element, ok := cache.entries[entry.key]; ok {
+		cache.lru.Remove(element)
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+
+	maxEntries := cache.MaxEntries
+	if (maxEntries == 0) {
+		maxEntries = DefaultCacheSize
+	}
+	for cache.lru.Len() > maxEntries {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cacheEntry).key)
+		cache.stats.Evictions++
+	}
+}
+
+// Find a cached reply for the request, if any.  The reply carries the same
+// id + question as the request, and the TTLs of its records reflect the time
+// spent in the cache
+func (cache *Cache) Lookup(request *dns.Message) (*dns.Message, bool) {
+	if (len(request.Questions) != 1) {
+		return nil, false
+	}
+	key := messageKey(request)
+	now := cache.now()
+
+	cache.lock.Lock()
+	element, ok := cache.entries[key]
+	if (ok && !now.Before(element.Value.(*cacheEntry).expires)) {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+		ok = false
+	}
+	if (!ok) {
+		cache.stats.Misses++
+		cache.lock.Unlock()
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+	cache.stats.Hits++
+	entry := element.Value.(*cacheEntry)
+	cache.lock.Unlock()
+
+	reply := &dns.Message{}
+	reply.Header.Id = request.Header.Id
+	reply.Header.Flags = entry.flags |
+		(request.Header.Flags & dns.MessageHeaderFlagRecursionDesired)
+	reply.AddQuestion(request.Questions[0])
+
+	// Each record expires no later than the entry itself
+	remaining := int32(entry.expires.Sub(now) / time.Second)
+	elapsed := int32(now.Sub(entry.stored) / time.Second)
+	decay := func(rr dns.ResourceRecord) dns.ResourceRecord {
+		rr.TTL -= elapsed
+		if (rr.TTL > remaining) {
+			rr.TTL = remaining
+		}
+		if (rr.TTL < 0) {
+			rr.TTL = 0
+		}
+		return rr
+	}
+	for _, rr := range entry.answers {
+		reply.AddAnswer(decay(rr))
+	}
+	for _, rr := range entry.authority {
+		reply.AddNameserver(decay(rr))
+	}
+	if (request.EDNS != nil) {
+		reply.SetEDNS(dns.OPTRecord{
+			UDPSize:	dns.EDNSDefaultUDPSize,
+			Version:	dns.EDNSVersion,
+			DNSSECOK:	key.DNSSEC,
+		})
+	}
+	return reply, true
+}
+
+func (stats CacheStats) String() string {
+	return fmt.Sprintf("Cache: %d entries, %d hits, %d misses, %d evictions",
+		stats.Entries, stats.Hits, stats.Misses, stats.Evictions)
+}
+
+func (cache *Cache) Stats() CacheStats {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	stats := cache.stats
+	stats.Entries = cache.lru.Len()
+	return stats
+}
+
+// Answer requests from the cache where possible, and cache the replies to
+// any others, under the DO bit + CD flag of the request, whether or not the
+// server copied them
+func (cache *Cache) Wrap(exchange ExchangeFunc) ExchangeFunc {
+	return func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		reply, ok := cache.Lookup(request)
+		if (ok) {
+			if (reply.Rcode() != dns.RcodeNoError) {
+				return reply, &dns.RcodeError{ Rcode: reply.Rcode() }
+			}
+			return reply, nil
+		}
+
+		reply, err := exchange(ctx, request)
+		var rcodeErr *dns.RcodeError
+		if (reply != nil && (err == nil || errors.As(err, &rcodeErr))) {
+			if (len(reply.Questions) == 1) {
+				cache.put(messageKey(request), reply)
+			}
+		}
+		return reply, err
+	}
+}
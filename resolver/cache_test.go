@@ -0,0 +1,387 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake clock, advanced manually
+//
+type fakeClock struct {
+	now		time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+func newCache() (*Cache, *fakeClock) {
+	clock := &fakeClock{ now: time.Unix(1700000000, 0) }
+	return &Cache{ Now: clock.Now }, clock
+}
+
+func soa(zone string, ttl int32, minimum uint32) dns.ResourceRecord {
+	return dns.ResourceRecord{
+		Name:	zone,
+		Type:	dns.RecordTypeSOA,
+		Class:	dns.RecordClassIN,
+		TTL:	ttl,
+		Data:	&dns.RDataSOA{
+			MName:		"ns." + zone,
+			RName:		"hostmaster." + zone,
+			Serial:		1,
+			Minimum:	minimum,
+		},
+	}
+}
+
+func negativeReply(request *dns.Message, rcode uint16,
+	authority ...dns.ResourceRecord) *dns.Message {
+	reply := newReply(*request)
+	reply.Header.Flags |= rcode
+	for _, rr := range authority {
+		reply.AddNameserver(rr)
+	}
+	return &reply
+}
+
+
+//
+// Validate positive caching, and the decay of TTLs while cached
+//
+func TestCachePositive(t *testing.T) {
+	cache, clock := newCache()
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	reply := newReply(request)
+	short := address("a.com", "192.0.2.1")
+	short.TTL = 60
+	reply.AddAnswer(short)
+	reply.AddAnswer(address("a.com", "192.0.2.2"))
+	cache.Put(&reply)
+
+	clock.Advance(20 * time.Second)
+	request.Header.Id = 1234
+	request.Questions[0].Name = "A.COM."
+	cached, ok := cache.Lookup(&request)
+	if (!ok) {
+		t.Fatal("Expected a cached reply")
+	}
+	if (cached.Header.Id != 1234 || cached.Questions[0].Name != "A.COM." ||
+		cached.Header.AnswerCount != 2) {
+		t.Error("Unexpected reply: ", cached)
+	}
+	if (cached.Answers[0].TTL != 40 || cached.Answers[1].TTL != 40) {
+		t.Error("Unexpected TTLs: ", cached.Answers[0].TTL,
+			cached.Answers[1].TTL)
+	}
+	if (cached.Validate(request) != nil) {
+		t.Error("Invalid reply: ", cached.Validate(request))
+	}
+
+	// Other types + classes are distinct
+	other := dns.NewQuery("a.com", dns.RecordTypeAAAA)
+	if _, ok := cache.Lookup(&other); ok {
+		t.Error("Unexpected reply for AAAA")
+	}
+
+	clock.Advance(40 * time.Second)
+	if _, ok := cache.Lookup(&request); ok {
+		t.Error("Unexpected reply after expiry")
+	}
+	stats := cache.Stats()
+	if (stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 0) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+
+//
+// Validate caching of NXDOMAIN + NODATA answers for the SOA minimum
+//
+func TestCacheNegative(t *testing.T) {
+	testCases := []struct{
+		rcode		uint16
+		authority	[]dns.ResourceRecord
+		lifetime	time.Duration
+	}{
+		{ dns.RcodeNameError, []dns.ResourceRecord{ soa("a.com", 3600, 300) },
+			300 * time.Second },
+		{ dns.RcodeNoError, []dns.ResourceRecord{ soa("a.com", 120, 300) },
+			120 * time.Second },
+		{ dns.RcodeNameError,
+			[]dns.ResourceRecord{ soa("a.com", 3600, 0xFFFFFFFF) },
+			3600 * time.Second },
+		{ dns.RcodeNameError, nil, 0 },
+		{ dns.RcodeServerFailure, []dns.ResourceRecord{ soa("a.com", 60, 60) },
+			0 },
+	}
+
+	for _, test := range testCases {
+		cache, clock := newCache()
+		request := dns.NewQuery("missing.a.com", dns.RecordTypeA)
+		cache.Put(negativeReply(&request, test.rcode, test.authority...))
+
+		cached, ok := cache.Lookup(&request)
+		if (test.lifetime == 0) {
+			if (ok) {
+				t.Errorf("Rcode %d: unexpected reply", test.rcode)
+			}
+			continue
+		}
+		if (!ok || cached.Rcode() != test.rcode ||
+			cached.Header.NameserverCount != 1) {
+			t.Errorf("Rcode %d: unexpected reply: %v", test.rcode, cached)
+			continue
+		}
+		if (time.Duration(cached.Nameservers[0].TTL) * time.Second !=
+			test.lifetime) {
+			t.Errorf("Rcode %d: unexpected TTL: %d", test.rcode,
+				cached.Nameservers[0].TTL)
+		}
+
+		clock.Advance(test.lifetime)
+		if _, ok := cache.Lookup(&request); ok {
+			t.Errorf("Rcode %d: unexpected reply after expiry", test.rcode)
+		}
+	}
+}
+
+
+//
+// Validate that NXDOMAIN + NODATA answers reached via a CNAME keep the CNAME,
+// and expire with it if it is shorter-lived than the SOA minimum
+//
+func TestCacheNegativeChain(t *testing.T) {
+	for _, rcode := range []uint16{ dns.RcodeNameError, dns.RcodeNoError } {
+		cache, clock := newCache()
+		request := dns.NewQuery("www.a.com", dns.RecordTypeA)
+		reply := negativeReply(&request, rcode, soa("b.com", 3600, 300))
+		alias := cname("www.a.com", "www.b.com")
+		alias.TTL = 60
+		reply.AddAnswer(alias)
+		cache.Put(reply)
+
+		cached, ok := cache.Lookup(&request)
+		if (!ok || cached.Rcode() != rcode ||
+			cached.Header.AnswerCount != 1 ||
+			cached.Header.NameserverCount != 1) {
+			t.Errorf("Rcode %d: unexpected reply: %v", rcode, cached)
+			continue
+		}
+		if (cached.Answers[0].Type != dns.RecordTypeCNAME ||
+			cached.Answers[0].TTL != 60 || cached.Nameservers[0].TTL != 60) {
+			t.Errorf("Rcode %d: unexpected records: %v", rcode, cached)
+		}
+
+		clock.Advance(60 * time.Second)
+		if _, ok := cache.Lookup(&request); ok {
+			t.Errorf("Rcode %d: unexpected reply after expiry", rcode)
+		}
+	}
+}
+
+
+//
+// Validate the TTL clamps
+//
+func TestCacheClamps(t *testing.T) {
+	cache, clock := newCache()
+	cache.MinTTL = 30 * time.Second
+	cache.MaxTTL = time.Minute
+	cache.MaxNegativeTTL = 10 * time.Second
+
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	reply := newReply(request)
+	long := address("a.com", "192.0.2.1")
+	long.TTL = 86400
+	reply.AddAnswer(long)
+	cache.Put(&reply)
+
+	zero := dns.NewQuery("zero.com", dns.RecordTypeA)
+	reply = newReply(zero)
+	reply.AddAnswer(address("zero.com", "192.0.2.1"))
+	reply.Answers[0].TTL = 0
+	cache.Put(&reply)
+
+	missing := dns.NewQuery("missing.com", dns.RecordTypeA)
+	cache.Put(negativeReply(&missing, dns.RcodeNameError,
+		soa("com", 900, 900)))
+
+	clock.Advance(15 * time.Second)
+	if _, ok := cache.Lookup(&zero); !ok {
+		t.Error("Expected the minimum TTL")
+	}
+	if _, ok := cache.Lookup(&missing); ok {
+		t.Error("Expected the maximum negative TTL")
+	}
+	cached, ok := cache.Lookup(&request)
+	if (!ok || cached.Answers[0].TTL != 45) {
+		t.Error("Expected the maximum TTL: ", cached)
+	}
+}
+
+
+//
+// Validate the eviction of the least-recently used entries
+//
+func TestCacheEviction(t *testing.T) {
+	cache, _ := newCache()
+	cache.MaxEntries = 2
+
+	put := func(name string) *dns.Message {
+		request := dns.NewQuery(name, dns.RecordTypeA)
+		reply := newReply(request)
+		reply.AddAnswer(address(name, "192.0.2.1"))
+		cache.Put(&reply)
+		return &request
+	}
+	a := put("a.com")
+	b := put("b.com")
+	cache.Lookup(a)
+	c := put("c.com")
+
+	if _, ok := cache.Lookup(b); ok {
+		t.Error("Expected b.com to be evicted")
+	}
+	for _, request := range []*dns.Message{ a, c } {
+		if _, ok := cache.Lookup(request); !ok {
+			t.Error("Expected a cached reply for ", request.Questions[0].Name)
+		}
+	}
+	stats := cache.Stats()
+	if (stats.Entries != 2 || stats.Evictions != 1) {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+
+//
+// Validate the caching wrapper around an exchange
+//
+func TestCacheWrap(t *testing.T) {
+	queries := 0
+	exchange := func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		queries++
+		switch request.Questions[0].Name {
+			case "a.com":
+				reply := newReply(*request)
+				reply.AddAnswer(address("a.com", "192.0.2.1"))
+				return &reply, nil
+			case "missing.com":
+				return negativeReply(request, dns.RcodeNameError,
+						soa("com", 900, 900)),
+					&dns.RcodeError{ Rcode: dns.RcodeNameError }
+		}
+		return negativeReply(request, dns.RcodeServerFailure),
+			&dns.RcodeError{ Rcode: dns.RcodeServerFailure }
+	}
+
+	cache, _ := newCache()
+	cached := cache.Wrap(exchange)
+	for _, name := range []string{ "a.com", "missing.com", "broken.com" } {
+		for i := 0; i < 2; i++ {
+			request := dns.NewQuery(name, dns.RecordTypeA)
+			reply, err := cached(context.Background(), &request)
+			if (reply == nil) {
+				t.Fatal(name, ": no reply: ", err)
+			}
+			var rcodeErr *dns.RcodeError
+			if (reply.Rcode() != dns.RcodeNoError &&
+				(!errors.As(err, &rcodeErr) || rcodeErr.Rcode != reply.Rcode())) {
+				t.Error(name, ": expected an rcode error: ", err)
+			}
+		}
+	}
+
+	// Server failures are never cached
+	if (queries != 4) {
+		t.Error("Unexpected query count: ", queries)
+	}
+}
+
+
+//
+// Validate that answers to DO queries, with their RRSIGs, are cached apart
+// from the others, whether or not the server copied the DO bit
+//
+func TestCacheDO(t *testing.T) {
+	exchange := func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		reply := newReply(*request)
+		reply.AddAnswer(address("a.com", "192.0.2.1"))
+		if (request.EDNS != nil && request.EDNS.DNSSECOK) {
+			reply.AddAnswer(dns.ResourceRecord{ Name: "a.com",
+				Type: dns.RecordTypeRRSIG, Class: dns.RecordClassIN, TTL: 300,
+				Data: &dns.RDataRRSIG{ RRSIGHeader: dns.RRSIGHeader{
+					TypeCovered: dns.RecordTypeA } } })
+		}
+		return &reply, nil
+	}
+
+	cache, _ := newCache()
+	cached := cache.Wrap(exchange)
+	for i, dnssec := range []bool{ false, true, false, true } {
+		request := dns.NewQuery("a.com", dns.RecordTypeA)
+		request.SetEDNS(dns.OPTRecord{ UDPSize: dns.EDNSDefaultUDPSize,
+			DNSSECOK: dnssec })
+		reply, err := cached(context.Background(), &request)
+		expected := 1
+		if (dnssec) {
+			expected = 2
+		}
+		hit := (i >= 2)
+		if (err != nil || len(reply.Answers) != expected ||
+			(hit && (reply.EDNS == nil || reply.EDNS.DNSSECOK != dnssec))) {
+			t.Errorf("DO %t: unexpected reply: %s, %v", dnssec, reply, err)
+		}
+	}
+	if (cache.Stats().Entries != 2 || cache.Stats().Hits != 2) {
+		t.Errorf("Unexpected cache stats: %+v", cache.Stats())
+	}
+}
+
+
+//
+// Validate that cached negative answers keep their DNSSEC proof, and that
+// the referrals of the authority section do not
+//
+func TestCacheDNSSEC(t *testing.T) {
+	cache, _ := newCache()
+	cache.Now = func() time.Time { return testValidationTime }
+	exchange := cache.Wrap(signedExchange(loadTestZones(t), nil))
+	validator := newTestValidator(t, exchange)
+
+	for i := 0; i < 2; i++ {
+		reply, validation := validate(validator, exchange, "missing.example",
+			dns.RecordTypeA)
+		if (validation.Security != SecuritySecure) {
+			t.Errorf("Pass %d: expected a secure answer, got %s", i,
+				validation)
+		}
+		if (len(reply.Nameservers) != 6) {
+			t.Errorf("Pass %d: unexpected authority: %v", i,
+				reply.Nameservers)
+		}
+	}
+	if (cache.Stats().Hits == 0) {
+		t.Error("Expected cache hits")
+	}
+
+	request := dns.NewQuery("missing.a.com", dns.RecordTypeA)
+	cache.Put(negativeReply(&request, dns.RcodeNameError,
+		soa("a.com", 300, 300), cname("a.com", "b.com")))
+	cached, _ := cache.Lookup(&request)
+	if (cached == nil || len(cached.Nameservers) != 1) {
+		t.Error("Unexpected reply: ", cached)
+	}
+}
@@ -0,0 +1,187 @@
+//
+// CNAME + DNAME chain following.  If the answer for a name is an alias, but
+// the reply does not include the records for the alias target (e.g. because
+// the target lies in a different zone), then query the target in turn.
+//
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"ddnsr/dns"
+)
+
+
+var ErrChainLoop		= errors.New("CNAME/DNAME chain loop")
+var ErrChainTooLong		= errors.New("CNAME/DNAME chain too long")
+
+const DefaultMaxChain	= 16
+
+
+// Either Client.Exchange or Iterator.Resolve
+type ExchangeFunc func(ctx context.Context,
+	request *dns.Message) (*dns.Message, error)
+
+
+//
+// Result of following a chain of aliases
+//
+type Chain struct {
+	// CNAME + DNAME records, in the order followed.  The CNAMEs synthesized
+	// from DNAMEs are included, after the corresponding DNAME
+	Links		[]dns.ResourceRecord
+
+	Answers		[]dns.ResourceRecord	// For the final name in the chain
+	Reply		*dns.Message			// The last reply
+	Replies		[]*dns.Message			// Every reply, in order
+	Queries		int						// Number of requests sent
+}
+
+
+// Send the request, and follow any aliases in the reply until reaching the
+// final answer.  If maxLength is zero, then DefaultMaxChain applies.  The
+// last reply is returned as part of the chain, even on error
+func FollowChain(ctx context.Context, exchange ExchangeFunc,
+	request *dns.Message, maxLength int) (*Chain, error) {
+	if (maxLength == 0) {
+		maxLength = DefaultMaxChain
+	}
+	if (len(request.Questions) != 1) {
+		return nil, ErrQuestionCount
+	}
+	question := request.Questions[0]
+
+	chain := &Chain{}
+	name := question.Name
+	seen := map[string]bool{ dns.CanonicalName(name): true }
+	for {
+		// An error response code still leaves the chain in the reply intact,
+		// but then applies to the last name in the chain (RFC 6604, 3)
+		reply, err := exchange(ctx, request)
+		chain.Queries++
+		chain.Reply = reply
+		if (reply != nil) {
+			chain.Replies = append(chain.Replies, reply)
+		}
+		var rcodeErr *dns.RcodeError
+		if (err != nil && (reply == nil || !errors.As(err, &rcodeErr))) {
+			return chain, err
+		}
+
+		// Walk the answer section as far as the chain goes.  Any records
+		// unrelated to the chain are ignored
+		progress := false
+		for {
+			chain.Answers = answersFor(reply, name, question.Type)
+			if (len(chain.Answers) > 0) {
+				return chain, nil
+			}
+
+			links, target := nextLink(reply, name, question.Type)
+			if (links == nil) {
+				break
+			}
+			chain.Links = append(chain.Links, links...)
+			if (seen[dns.CanonicalName(target)]) {
+				return chain, ErrChainLoop
+			}
+			seen[dns.CanonicalName(target)] = true
+			if (len(seen) - 1 > maxLength) {
+				return chain, ErrChainTooLong
+			}
+			name = target
+			progress = true
+		}
+
+		// The reply leads nowhere further, e.g. NODATA for the final name
+		if (err != nil || !progress) {
+			return chain, err
+		}
+
+		// Otherwise, ask about the latest alias target
+		next := dns.NewQuery(name, question.Type)
+		next.Header.Flags = request.Header.Flags
+		next.EDNS = request.EDNS
+		request = &next
+	}
+}
+
+// Records of the requested type for the name.  Queries for the alias types
+// themselves are answered by the alias record, without following it
+func answersFor(reply *dns.Message, name string,
+	rtype uint16) []dns.ResourceRecord {
+	var answers []dns.ResourceRecord
+	for _, rr := range reply.Answers {
+		if (dns.CanonicalName(rr.Name) != dns.CanonicalName(name)) {
+			continue
+		}
+		if (rr.Type == rtype || rtype == dns.RecordTypeALL) {
+			answers = append(answers, rr)
+		}
+	}
+	return answers
+}
+
+// Next link(s) in the chain from the name, if any, and the new target name.
+// A DNAME at an ancestor takes precedence, since it applies to the whole
+// subtree; the corresponding CNAME is synthesized unless the server already
+// did so (RFC 6672, 3.1)
+func nextLink(reply *dns.Message, name string,
+	rtype uint16) ([]dns.ResourceRecord, string) {
+	if (rtype != dns.RecordTypeDNAME) {
+		for _, rr := range reply.Answers {
+			dname, ok := rr.Data.(*dns.RDataDNAME)
+			owner := dns.CanonicalName(rr.Name)
+			if (!ok || owner == dns.CanonicalName(name) ||
+				!dns.IsSubdomain(name, owner)) {
+				continue
+			}
+
+			// Replace the owner suffix of the name with the DNAME target
+			prefix := strings.TrimSuffix(dns.CanonicalName(name), owner)
+			target := prefix + dns.CanonicalName(dname.Target)
+			if (owner == "") {
+				target = prefix + "." + dns.CanonicalName(dname.Target)
+			}
+			target = strings.TrimSuffix(target, ".")
+
+			synthesized := dns.ResourceRecord{
+				Name:	name,
+				Type:	dns.RecordTypeCNAME,
+				Class:	rr.Class,
+				TTL:	rr.TTL,
+				Data:	&dns.RDataCNAME{ Target: target },
+			}
+			for _, cname := range cnamesFor(reply, name) {
+				if (dns.CanonicalName(cname.Data.(*dns.RDataCNAME).Target) ==
+					target) {
+					synthesized = cname
+				}
+			}
+			return []dns.ResourceRecord{ rr, synthesized }, target
+		}
+	}
+
+	if (rtype != dns.RecordTypeCNAME) {
+		cnames := cnamesFor(reply, name)
+		if (len(cnames) > 0) {
+			return cnames[:1], cnames[0].Data.(*dns.RDataCNAME).Target
+		}
+	}
+
+	return nil, ""
+}
+
+func cnamesFor(reply *dns.Message, name string) []dns.ResourceRecord {
+	var cnames []dns.ResourceRecord
+	for _, rr := range reply.Answers {
+		_, ok := rr.Data.(*dns.RDataCNAME)
+		if (ok && dns.CanonicalName(rr.Name) == dns.CanonicalName(name)) {
+			cnames = append(cnames, rr)
+		}
+	}
+	return cnames
+}
@@ -0,0 +1,209 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake exchange, answering each name with a fixed list of records, and an
+// optional response code
+//
+type fakeAnswer struct {
+	records	[]dns.ResourceRecord
+	rcode	uint16
+}
+
+func fakeExchange(answers map[string]fakeAnswer) ExchangeFunc {
+	return func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		reply := newReply(*request)
+		answer := answers[dns.CanonicalName(request.Questions[0].Name)]
+		for _, rr := range answer.records {
+			reply.AddAnswer(rr)
+		}
+		if (answer.rcode != dns.RcodeNoError) {
+			reply.Header.Flags |= answer.rcode
+			return &reply, &dns.RcodeError{ Rcode: answer.rcode }
+		}
+		return &reply, nil
+	}
+}
+
+func cname(name string, target string) dns.ResourceRecord {
+	return dns.ResourceRecord{
+		Name:	name,
+		Type:	dns.RecordTypeCNAME,
+		Class:	dns.RecordClassIN,
+		TTL:	300,
+		Data:	&dns.RDataCNAME{ Target: target },
+	}
+}
+
+func dname(name string, target string) dns.ResourceRecord {
+	return dns.ResourceRecord{
+		Name:	name,
+		Type:	dns.RecordTypeDNAME,
+		Class:	dns.RecordClassIN,
+		TTL:	300,
+		Data:	&dns.RDataDNAME{ Target: target },
+	}
+}
+
+func address(name string, ip string) dns.ResourceRecord {
+	return dns.ResourceRecord{
+		Name:	name,
+		Type:	dns.RecordTypeA,
+		Class:	dns.RecordClassIN,
+		TTL:	300,
+		Data:	&dns.RDataA{ Address: net.ParseIP(ip).To4() },
+	}
+}
+
+func follow(answers map[string]fakeAnswer, name string,
+	rtype uint16) (*Chain, error) {
+	request := dns.NewQuery(name, rtype)
+	return FollowChain(context.Background(), fakeExchange(answers),
+		&request, 0)
+}
+
+func chainString(chain *Chain) string {
+	result := ""
+	for _, rr := range chain.Links {
+		result += fmt.Sprintf("%s %s %s; ", rr.Name,
+			dns.RecordTypeMapToString[rr.Type], rr.Data)
+	}
+	for _, rr := range chain.Answers {
+		result += fmt.Sprintf("%s %s", rr.Name, rr.Data)
+	}
+	return result
+}
+
+
+//
+// Validate CNAME chains, both complete and spread across several replies
+//
+func TestFollowCNAME(t *testing.T) {
+	answers := map[string]fakeAnswer{
+		"a.com":	{ records: []dns.ResourceRecord{ cname("a.com", "b.net") } },
+		"b.net":	{ records: []dns.ResourceRecord{
+			cname("b.net", "c.org"),
+			address("c.org", "192.0.2.1"),
+			address("unrelated.org", "192.0.2.99"),
+		} },
+	}
+
+	chain, err := follow(answers, "a.com", dns.RecordTypeA)
+	if (err != nil) {
+		t.Fatal("Chain error: ", err)
+	}
+	expected := "a.com CNAME b.net; b.net CNAME c.org; c.org 192.0.2.1"
+	if (chainString(chain) != expected) {
+		t.Error("Unexpected chain: ", chainString(chain))
+	}
+	if (chain.Queries != 2) {
+		t.Error("Unexpected query count: ", chain.Queries)
+	}
+
+	// Complete in the first reply
+	chain, err = follow(answers, "b.net", dns.RecordTypeA)
+	if (err != nil || chain.Queries != 1 || len(chain.Answers) != 1) {
+		t.Error("Unexpected chain: ", chain, err)
+	}
+
+	// Queries for the CNAME itself are not followed
+	chain, err = follow(answers, "a.com", dns.RecordTypeCNAME)
+	if (err != nil || len(chain.Links) != 0 || len(chain.Answers) != 1) {
+		t.Error("Unexpected chain: ", chain, err)
+	}
+}
+
+
+//
+// Validate CNAME synthesis from DNAME records
+//
+func TestFollowDNAME(t *testing.T) {
+	answers := map[string]fakeAnswer{
+		"www.old.com":	{ records: []dns.ResourceRecord{
+			dname("old.com", "new.net"),
+		} },
+		"www.new.net":	{ records: []dns.ResourceRecord{
+			address("www.new.net", "192.0.2.2"),
+		} },
+		"mail.old.com":	{ records: []dns.ResourceRecord{
+			dname("old.com", "new.net"),
+			cname("mail.old.com", "mail.new.net"),
+			address("mail.new.net", "192.0.2.3"),
+		} },
+	}
+
+	chain, err := follow(answers, "www.old.com", dns.RecordTypeA)
+	if (err != nil) {
+		t.Fatal("Chain error: ", err)
+	}
+	expected := "old.com DNAME new.net; www.old.com CNAME www.new.net; " +
+		"www.new.net 192.0.2.2"
+	if (chainString(chain) != expected) {
+		t.Error("Unexpected chain: ", chainString(chain))
+	}
+
+	// Server-synthesized CNAME, in a single reply
+	chain, err = follow(answers, "mail.old.com", dns.RecordTypeA)
+	if (err != nil || chain.Queries != 1 || len(chain.Links) != 2) {
+		t.Error("Unexpected chain: ", chainString(chain), err)
+	}
+}
+
+
+//
+// Validate detection of loops + excessive chains
+//
+func TestChainLimits(t *testing.T) {
+	answers := map[string]fakeAnswer{
+		"a.com":	{ records: []dns.ResourceRecord{ cname("a.com", "b.com") } },
+		"b.com":	{ records: []dns.ResourceRecord{ cname("b.com", "A.com.") } },
+	}
+	_, err := follow(answers, "a.com", dns.RecordTypeA)
+	if (err != ErrChainLoop) {
+		t.Error("Expected a loop: ", err)
+	}
+
+	long := map[string]fakeAnswer{}
+	for i := 0; i < DefaultMaxChain + 1; i++ {
+		name := fmt.Sprintf("%d.com", i)
+		long[name] = fakeAnswer{ records: []dns.ResourceRecord{
+			cname(name, fmt.Sprintf("%d.com", i + 1)),
+		} }
+	}
+	_, err = follow(long, "0.com", dns.RecordTypeA)
+	if (err != ErrChainTooLong) {
+		t.Error("Expected an excessive chain: ", err)
+	}
+}
+
+
+//
+// Validate error response codes for the final name in the chain
+//
+func TestChainNameError(t *testing.T) {
+	answers := map[string]fakeAnswer{
+		"a.com":	{
+			records:	[]dns.ResourceRecord{ cname("a.com", "missing.com") },
+			rcode:		dns.RcodeNameError,
+		},
+	}
+
+	chain, err := follow(answers, "a.com", dns.RecordTypeA)
+	var rcodeErr *dns.RcodeError
+	if (!errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError) {
+		t.Error("Expected NXDOMAIN: ", err)
+	}
+	if (len(chain.Links) != 1 || chain.Queries != 1) {
+		t.Error("Unexpected chain: ", chainString(chain))
+	}
+}
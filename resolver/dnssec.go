@@ -0,0 +1,1102 @@
+//
+// DNSSEC validation, as defined by RFC 4033-4035 + RFC 5155.  The records in
+// an answer are only trusted if an RRSIG over them verifies against a DNSKEY
+// of the signer zone; that zone's DNSKEYs are only trusted if they match a
+// DS record in the parent zone, which is itself signed, and so on up to a
+// trust anchor.  Negative answers carry NSEC or NSEC3 records that prove the
+// name or type does not exist, and the same proofs show where the chain of
+// trust ends at an unsigned delegation.
+//
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+var ErrTrustAnchor		= errors.New("Trust anchors must be DS or DNSKEY records")
+var ErrNoTrustAnchors	= errors.New("No trust anchors")
+
+// NSEC3 chains with more iterations than this are treated as insecure, per
+// RFC 9276, 3.2
+const NSEC3MaxIterations	= 150
+
+
+//
+// Security status of an answer (RFC 4033, 5)
+//
+type Security int
+
+const (
+	SecuritySecure		Security = iota	// Chain of trust from an anchor
+	SecurityInsecure					// Provably beneath an unsigned delegation
+	SecurityIndeterminate				// Unable to tell either way
+	SecurityBogus						// Should be signed, but is not valid
+)
+
+var securityStrings = map[Security]string{
+		SecuritySecure:			"Secure",
+		SecurityInsecure:		"Insecure",
+		SecurityIndeterminate:	"Indeterminate",
+		SecurityBogus:			"Bogus",
+	}
+
+func (security Security) String() string {
+	return securityStrings[security]
+}
+
+// Outcome of validating an answer, and the reason for it
+type Validation struct {
+	Security	Security
+	Reason		string
+}
+
+func (validation Validation) String() string {
+	return fmt.Sprintf("%s (%s)", validation.Security, validation.Reason)
+}
+
+func secure(format string, args ...any) Validation {
+	return Validation{ SecuritySecure, fmt.Sprintf(format, args...) }
+}
+
+func insecure(format string, args ...any) Validation {
+	return Validation{ SecurityInsecure, fmt.Sprintf(format, args...) }
+}
+
+func indeterminate(format string, args ...any) Validation {
+	return Validation{ SecurityIndeterminate, fmt.Sprintf(format, args...) }
+}
+
+func bogus(format string, args ...any) Validation {
+	return Validation{ SecurityBogus, fmt.Sprintf(format, args...) }
+}
+
+// Combine the validations of several parts of an answer: the least secure
+// part determines the whole, e.g. a CNAME into an unsigned zone
+func combine(a Validation, b Validation) Validation {
+	if (a.Reason == "") {
+		return b
+	}
+	if (b.Security > a.Security) {
+		return b
+	}
+	if (b.Security == a.Security && b.Reason != "" &&
+		!strings.Contains(a.Reason, b.Reason)) {
+		a.Reason += "; " + b.Reason
+	}
+	return a
+}
+
+
+//
+// Trust anchors
+//
+
+// Root zone KSKs, KSK-2017 + KSK-2024, as published at
+// https://data.iana.org/root-anchors/root-anchors.xml
+var RootAnchors = []dns.ResourceRecord{
+	rootAnchor(20326, "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+	rootAnchor(38696, "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"),
+}
+
+func rootAnchor(keyTag uint16, digest string) dns.ResourceRecord {
+	decoded, _ := hex.DecodeString(digest)
+	return dns.ResourceRecord{
+		Name:	"",
+		Type:	dns.RecordTypeDS,
+		Class:	dns.RecordClassIN,
+		Data:	&dns.RDataDS{
+			KeyTag:		keyTag,
+			Algorithm:	dns.AlgorithmRSASHA256,
+			DigestType:	dns.DigestSHA256,
+			Digest:		decoded,
+		},
+	}
+}
+
+// Load trust anchors from a zone file of DS and/or DNSKEY records, e.g. as
+// written by unbound-anchor.  Names are relative to the root
+func LoadTrustAnchors(path string) ([]dns.ResourceRecord, error) {
+	records, err := dns.LoadZone(path, "")
+	if (err != nil) {
+		return nil, err
+	}
+	for _, rr := range records {
+		if (rr.Type != dns.RecordTypeDS && rr.Type != dns.RecordTypeDNSKEY) {
+			return nil, fmt.Errorf("%w: %s", ErrTrustAnchor, dns.ZoneString(rr))
+		}
+	}
+	if (len(records) == 0) {
+		return nil, ErrNoTrustAnchors
+	}
+	return records, nil
+}
+
+
+//
+// Validator.  Looks up the DNSKEY + DS records of each zone along the chain
+// of trust as needed, and remembers the outcome for each zone.  A Validator
+// is safe for concurrent use as long as its fields are not modified
+//
+type Validator struct {
+	Exchange	ExchangeFunc			// For the DNSKEY + DS lookups
+	Anchors		[]dns.ResourceRecord	// DS or DNSKEY, defaults to RootAnchors
+
+	// Current time, for the signature validity periods.  Defaults to
+	// time.Now
+	Now			func() time.Time
+
+	lock		sync.Mutex
+	zones		map[string]*zoneTrust	// By name; nil if not a zone cut
+}
+
+// Trust in a zone: either secure, with its DNSKEYs, or the reason why not
+type zoneTrust struct {
+	zone		string
+	keys		[]*dns.RDataDNSKEY
+	validation	Validation
+}
+
+func (validator *Validator) now() time.Time {
+	if (validator.Now != nil) {
+		return validator.Now()
+	}
+	return time.Now()
+}
+
+func (validator *Validator) anchors() []dns.ResourceRecord {
+	if (validator.Anchors == nil) {
+		return RootAnchors
+	}
+	return validator.Anchors
+}
+
+// Request for DNSSEC records.  Checking is disabled, so that an upstream
+// validator returns bogus records too, rather than just SERVFAIL
+func NewDNSSECQuery(name string, rtype uint16) dns.Message {
+	request := dns.NewQuery(name, rtype)
+	request.Header.Flags |= dns.MessageHeaderFlagRecursionDesired |
+		dns.MessageHeaderFlagCheckingDisabled
+	request.SetEDNS(dns.OPTRecord{
+		UDPSize:	dns.EDNSDefaultUDPSize,
+		Version:	dns.EDNSVersion,
+		DNSSECOK:	true,
+	})
+	return request
+}
+
+func (validator *Validator) lookup(ctx context.Context, name string,
+	rtype uint16) (*dns.Message, error) {
+	request := NewDNSSECQuery(name, rtype)
+	reply, err := validator.Exchange(ctx, &request)
+	var rcodeErr *dns.RcodeError
+	if (errors.As(err, &rcodeErr) && rcodeErr.Rcode == dns.RcodeNameError) {
+		err = nil
+	}
+	if (err == nil && reply == nil) {
+		err = ErrNoServers
+	}
+	return reply, err
+}
+
+
+//
+// Answers
+//
+
+// Validate every reply along the chain of aliases
+func (validator *Validator) ValidateChain(ctx context.Context,
+	chain *Chain) Validation {
+	validation := indeterminate("no replies")
+	for i, reply := range chain.Replies {
+		if (i == 0) {
+			validation = validator.Validate(ctx, reply)
+		} else {
+			validation = combine(validation, validator.Validate(ctx, reply))
+		}
+	}
+	return validation
+}
+
+// Validate the answer to the single question of the reply: each RRset of the
+// answer section, and the proof of non-existence if the name or type does
+// not exist
+func (validator *Validator) Validate(ctx context.Context,
+	reply *dns.Message) Validation {
+	if (len(reply.Questions) != 1) {
+		return indeterminate("%s", ErrQuestionCount)
+	}
+	question := reply.Questions[0]
+	rcode := reply.Rcode()
+	if (rcode != dns.RcodeNoError && rcode != dns.RcodeNameError) {
+		return indeterminate("response code %s", dns.RcodeString(rcode))
+	}
+
+	var validation Validation
+	var dnames []dns.ResourceRecord
+	for _, set := range groupRRsets(reply.Answers) {
+		// CNAMEs synthesized from a DNAME are not signed (RFC 6672, 5.3.1)
+		if (len(set.sigs) == 0 && synthesized(set.records, dnames)) {
+			continue
+		}
+		result, rrsig := validator.validateRRset(ctx, set.records, set.sigs)
+
+		// A wildcard expansion also needs proof that there was no closer
+		// match for the name (RFC 4035, 5.3.4)
+		if (result.Security == SecuritySecure &&
+			int(rrsig.Labels) < dns.LabelCount(set.records[0].Name)) {
+			result = combine(result, validator.validateDenial(ctx, reply,
+				func(records []dns.ResourceRecord, zone string) error {
+					return wildcardProof(records, zone, set.records[0].Name,
+						int(rrsig.Labels))
+				}))
+		}
+		if (set.records[0].Type == dns.RecordTypeDNAME &&
+			result.Security == SecuritySecure) {
+			dnames = append(dnames, set.records...)
+		}
+		validation = combine(validation, result)
+	}
+
+	// Negative answers, for the name at the end of any chain of aliases
+	name, answered := chainEnd(reply.Answers, question.Name, question.Type)
+	if (rcode == dns.RcodeNameError || (!answered &&
+		(len(reply.Answers) == 0 || hasSOA(reply.Nameservers)))) {
+		nxdomain := (rcode == dns.RcodeNameError)
+		validation = combine(validation, validator.validateDenial(ctx, reply,
+			func(records []dns.ResourceRecord, zone string) error {
+				if (nxdomain) {
+					return nameErrorProof(records, zone, name)
+				}
+				_, _, err := noDataProof(records, zone, name, question.Type)
+				return err
+			}))
+	}
+	if (validation.Reason == "") {
+		return indeterminate("nothing to validate")
+	}
+	return validation
+}
+
+// Validate the NSEC or NSEC3 records of the authority section, then check
+// that they prove what they need to
+func (validator *Validator) validateDenial(ctx context.Context,
+	reply *dns.Message,
+	proof func(records []dns.ResourceRecord, zone string) error) Validation {
+	var validation Validation
+	var records []dns.ResourceRecord
+	zone := ""
+	for _, set := range groupRRsets(reply.Nameservers) {
+		rtype := set.records[0].Type
+		if (rtype != dns.RecordTypeNSEC && rtype != dns.RecordTypeNSEC3) {
+			continue
+		}
+		result, rrsig := validator.validateRRset(ctx, set.records, set.sigs)
+		validation = combine(validation, result)
+		if (result.Security == SecuritySecure) {
+			zone = dns.CanonicalName(rrsig.SignerName)
+		}
+		records = append(records, set.records...)
+	}
+
+	// Without any proof at all, the answer is only acceptable from an
+	// unsigned zone
+	if (len(records) == 0) {
+		trust := validator.trust(ctx, reply.Questions[0].Name)
+		if (trust.validation.Security == SecuritySecure) {
+			return bogus("no NSEC or NSEC3 records for the negative answer " +
+				"from %s", zoneString(trust.zone))
+		}
+		return trust.validation
+	}
+	if (validation.Security != SecuritySecure) {
+		return validation
+	}
+
+	err := proof(records, zone)
+	if (errors.Is(err, errOptOut) || errors.Is(err, errIterations)) {
+		return insecure("%s", err)
+	}
+	if (err != nil) {
+		return bogus("%s", err)
+	}
+	return secure("nonexistence proven by %s",
+		dns.RecordTypeString(records[0].Type))
+}
+
+// Validate the signatures over a single RRset.  Any of the signatures will do,
+// as long as it verifies against a trusted key.  Unsigned records are only
+// acceptable beneath an unsigned delegation
+func (validator *Validator) validateRRset(ctx context.Context,
+	records []dns.ResourceRecord,
+	sigs []*dns.RDataRRSIG) (Validation, *dns.RDataRRSIG) {
+	owner := dns.CanonicalName(records[0].Name)
+	if (len(sigs) == 0) {
+		trust := validator.trust(ctx, owner)
+		if (trust.validation.Security == SecuritySecure) {
+			return bogus("no RRSIG for %s %s, in signed zone %s",
+				nameString(owner), dns.RecordTypeString(records[0].Type),
+				zoneString(trust.zone)), nil
+		}
+		return trust.validation, nil
+	}
+
+	var validation Validation
+	for _, rrsig := range sigs {
+		signer := dns.CanonicalName(rrsig.SignerName)
+		if (!dns.IsSubdomain(owner, signer)) {
+			validation = combine(validation, bogus("RRSIG for %s by %s, " +
+				"outside of its zone", nameString(owner), zoneString(signer)))
+			continue
+		}
+		trust := validator.trust(ctx, signer)
+		if (trust.validation.Security != SecuritySecure) {
+			validation = combine(validation, trust.validation)
+			continue
+		}
+		if (trust.zone != signer) {
+			validation = combine(validation, bogus("signer %s is not a " +
+				"signed zone", zoneString(signer)))
+			continue
+		}
+		result := verifyRRset(trust, records, rrsig, validator.now())
+		if (result.Security == SecuritySecure) {
+			return result, rrsig
+		}
+		validation = combine(validation, result)
+	}
+	return validation, nil
+}
+
+// Verify the RRset against the zone, via any of its signatures
+func verifySet(trust *zoneTrust, set *signedRRset, now time.Time) Validation {
+	validation := bogus("no RRSIG for %s %s", nameString(set.records[0].Name),
+		dns.RecordTypeString(set.records[0].Type))
+	for _, rrsig := range set.sigs {
+		validation = verifyRRset(trust, set.records, rrsig, now)
+		if (validation.Security == SecuritySecure) {
+			break
+		}
+	}
+	return validation
+}
+
+// Verify the RRset against any of the keys of the zone
+func verifyRRset(trust *zoneTrust, records []dns.ResourceRecord,
+	rrsig *dns.RDataRRSIG, now time.Time) Validation {
+	description := fmt.Sprintf("%s %s", nameString(records[0].Name),
+		dns.RecordTypeString(records[0].Type))
+	if (dns.CanonicalName(rrsig.SignerName) != trust.zone) {
+		return bogus("%s signed by %s, rather than %s", description,
+			zoneString(rrsig.SignerName), zoneString(trust.zone))
+	}
+	err := fmt.Errorf("no DNSKEY with key tag %d", rrsig.KeyTag)
+	for _, key := range trust.keys {
+		if (key.KeyTag() != rrsig.KeyTag || key.Algorithm != rrsig.Algorithm) {
+			continue
+		}
+		err = rrsig.Verify(records, key, now)
+		if (err == nil) {
+			return secure("signed by %s, key tag %d", zoneString(trust.zone),
+				rrsig.KeyTag)
+		}
+	}
+	return bogus("%s: %s", description, err)
+}
+
+
+//
+// Chain of trust
+//
+
+// Trust in the deepest zone that encloses the name, found by walking down
+// from the closest trust anchor one label at a time, looking for the DS
+// records at each zone cut.  Stops short at anything other than a secure
+// zone, e.g. at an unsigned delegation
+func (validator *Validator) trust(ctx context.Context, name string) *zoneTrust {
+	name = dns.CanonicalName(name)
+	anchor := ""
+	found := false
+	for _, rr := range validator.anchors() {
+		owner := dns.CanonicalName(rr.Name)
+		if (dns.IsSubdomain(name, owner) &&
+			(!found || dns.LabelCount(owner) > dns.LabelCount(anchor))) {
+			anchor = owner
+			found = true
+		}
+	}
+	if (!found) {
+		return &zoneTrust{ zone: name,
+			validation: indeterminate("no trust anchor for %s",
+				nameString(name)) }
+	}
+
+	current := validator.cached(anchor, func() (*zoneTrust, bool) {
+		return validator.anchorTrust(ctx, anchor), true
+	})
+	for _, child := range namesBelow(anchor, name) {
+		if (current.validation.Security != SecuritySecure) {
+			break
+		}
+		parent := current
+		next := validator.cached(child, func() (*zoneTrust, bool) {
+			return validator.delegation(ctx, parent, child)
+		})
+		if (next != nil) {
+			current = next
+		}
+	}
+	return current
+}
+
+// Trust for the name, from the cache, or else as computed + cached.  Trust
+// that is merely indeterminate, e.g. after a timeout, is not cached
+func (validator *Validator) cached(name string,
+	compute func() (*zoneTrust, bool)) *zoneTrust {
+	validator.lock.Lock()
+	trust, ok := validator.zones[name]
+	validator.lock.Unlock()
+	if (ok) {
+		return trust
+	}
+
+	trust, cut := compute()
+	if (!cut) {
+		trust = nil
+	}
+	if (trust == nil ||
+		trust.validation.Security != SecurityIndeterminate) {
+		validator.lock.Lock()
+		if (validator.zones == nil) {
+			validator.zones = map[string]*zoneTrust{}
+		}
+		validator.zones[name] = trust
+		validator.lock.Unlock()
+	}
+	return trust
+}
+
+// Names strictly below the zone, down to + including the name itself, e.g.
+// com, example.com and www.example.com for www.example.com beneath the root
+func namesBelow(zone string, name string) []string {
+	labels := strings.Split(name, ".")
+	if (name == "") {
+		labels = nil
+	}
+	var names []string
+	for i := len(labels) - dns.LabelCount(zone) - 1; i >= 0; i-- {
+		names = append(names, strings.Join(labels[i:], "."))
+	}
+	return names
+}
+
+// The DNSKEYs of the anchor zone, as trusted via the anchor records
+func (validator *Validator) anchorTrust(ctx context.Context,
+	zone string) *zoneTrust {
+	var anchors []dns.ResourceRecord
+	for _, rr := range validator.anchors() {
+		if (dns.CanonicalName(rr.Name) == zone) {
+			anchors = append(anchors, rr)
+		}
+	}
+	return validator.zoneKeys(ctx, zone, anchors)
+}
+
+// The DNSKEYs of the zone, as trusted via DS (or DNSKEY) records.  At least
+// one of the records must be for a supported algorithm + digest, or else the
+// zone is effectively unsigned (RFC 4035, 5.2)
+func (validator *Validator) zoneKeys(ctx context.Context, zone string,
+	anchors []dns.ResourceRecord) *zoneTrust {
+	trust := &zoneTrust{ zone: zone }
+	supported := false
+	for _, rr := range anchors {
+		switch data := rr.Data.(type) {
+			case *dns.RDataDS:
+				supported = (supported ||
+					(dns.SupportedAlgorithm(data.Algorithm) &&
+					dns.SupportedDigest(data.DigestType)))
+			case *dns.RDataDNSKEY:
+				supported = (supported ||
+					dns.SupportedAlgorithm(data.Algorithm))
+		}
+	}
+	if (!supported) {
+		trust.validation = insecure("no DS for %s with a supported " +
+			"algorithm", zoneString(zone))
+		return trust
+	}
+
+	reply, err := validator.lookup(ctx, zone, dns.RecordTypeDNSKEY)
+	if (err != nil) {
+		trust.validation = indeterminate("DNSKEY lookup for %s: %s",
+			zoneString(zone), err)
+		return trust
+	}
+	var keys []dns.ResourceRecord
+	var sigs []*dns.RDataRRSIG
+	for _, set := range groupRRsets(reply.Answers) {
+		if (dns.CanonicalName(set.records[0].Name) == zone &&
+			set.records[0].Type == dns.RecordTypeDNSKEY) {
+			keys = set.records
+			sigs = set.sigs
+		}
+	}
+	if (len(keys) == 0) {
+		trust.validation = bogus("no DNSKEY records for %s", zoneString(zone))
+		return trust
+	}
+
+	// The key set must be signed by one of the keys vouched for by the
+	// parent, or the anchor; then all of the keys are trusted
+	trust.validation = bogus("DNSKEY set for %s is not signed by a key " +
+		"with a DS record", zoneString(zone))
+	for _, rr := range keys {
+		key := rr.Data.(*dns.RDataDNSKEY)
+		if (!vouchedFor(zone, key, anchors)) {
+			continue
+		}
+		for _, rrsig := range sigs {
+			keyTrust := &zoneTrust{ zone: zone,
+				keys: []*dns.RDataDNSKEY{ key } }
+			result := verifyRRset(keyTrust, keys, rrsig, validator.now())
+			if (result.Security == SecuritySecure) {
+				for _, rr := range keys {
+					trust.keys = append(trust.keys, rr.Data.(*dns.RDataDNSKEY))
+				}
+				trust.validation = result
+				return trust
+			}
+			if (rrsig.KeyTag == key.KeyTag()) {
+				trust.validation = result
+			}
+		}
+	}
+	return trust
+}
+
+func vouchedFor(zone string, key *dns.RDataDNSKEY,
+	anchors []dns.ResourceRecord) bool {
+	for _, rr := range anchors {
+		switch data := rr.Data.(type) {
+			case *dns.RDataDS:
+				if (key.Matches(zone, data)) {
+					return true
+				}
+			case *dns.RDataDNSKEY:
+				if (key.Flags == data.Flags &&
+					key.Algorithm == data.Algorithm &&
+					bytes.Equal(key.PublicKey, data.PublicKey)) {
+					return true
+				}
+		}
+	}
+	return false
+}
+
+// Trust in the child, given the trust in its parent zone, if the child is a
+// zone cut.  Either the signed DS records lead to the child's DNSKEYs, or
+// the parent proves there are none: for a delegation, the child is then
+// unsigned; otherwise, the child is not a zone cut at all
+func (validator *Validator) delegation(ctx context.Context, parent *zoneTrust,
+	child string) (*zoneTrust, bool) {
+	reply, err := validator.lookup(ctx, child, dns.RecordTypeDS)
+	if (err != nil) {
+		return &zoneTrust{ zone: child,
+			validation: indeterminate("DS lookup for %s: %s", child, err) },
+			true
+	}
+
+	var denial []dns.ResourceRecord
+	for _, set := range groupRRsets(reply.Nameservers) {
+		rtype := set.records[0].Type
+		if (rtype != dns.RecordTypeNSEC && rtype != dns.RecordTypeNSEC3) {
+			continue
+		}
+		validation := verifySet(parent, set, validator.now())
+		if (validation.Security != SecuritySecure) {
+			return &zoneTrust{ zone: child, validation: validation }, true
+		}
+		denial = append(denial, set.records...)
+	}
+
+	for _, set := range groupRRsets(reply.Answers) {
+		if (dns.CanonicalName(set.records[0].Name) != child ||
+			set.records[0].Type != dns.RecordTypeDS) {
+			continue
+		}
+		validation := verifySet(parent, set, validator.now())
+		if (validation.Security != SecuritySecure) {
+			return &zoneTrust{ zone: child, validation: validation }, true
+		}
+		return validator.zoneKeys(ctx, child, set.records), true
+	}
+
+	// No DS records, so the parent must prove it, or else prove that the
+	// name does not exist at all
+	if (len(denial) == 0) {
+		return &zoneTrust{ zone: child, validation: bogus("no DS records " +
+			"for %s, nor any proof that there are none", child) }, true
+	}
+	if (reply.Rcode() == dns.RcodeNameError) {
+		err := nameErrorProof(denial, parent.zone, child)
+		if (errors.Is(err, errOptOut) || errors.Is(err, errIterations)) {
+			return &zoneTrust{ zone: child, validation: insecure("%s", err) },
+				true
+		}
+		if (err != nil) {
+			return &zoneTrust{ zone: child, validation: bogus("%s", err) },
+				true
+		}
+		return nil, false
+	}
+	types, optOut, err := noDataProof(denial, parent.zone, child,
+		dns.RecordTypeDS)
+	if (errors.Is(err, errIterations)) {
+		return &zoneTrust{ zone: child, validation: insecure("%s", err) },
+			true
+	}
+	if (err != nil) {
+		return &zoneTrust{ zone: child, validation: bogus("%s", err) }, true
+	}
+	if (optOut) {
+		return &zoneTrust{ zone: child, validation: insecure("no DS for " +
+			"%s, within an NSEC3 opt-out span of %s", child,
+			zoneString(parent.zone)) }, true
+	}
+	if (hasType(types, dns.RecordTypeNS)) {
+		return &zoneTrust{ zone: child, validation: insecure("unsigned " +
+			"delegation to %s from %s", child, zoneString(parent.zone)) },
+			true
+	}
+	return nil, false
+}
+
+
+//
+// Denial of existence.  Each of these takes the (already validated) NSEC or
+// NSEC3 records of the answer, and the zone that signed them
+//
+
+var errOptOut		= errors.New("NSEC3 opt-out span, which may hide unsigned delegations")
+var errIterations	= fmt.Errorf("NSEC3 iterations above %d", NSEC3MaxIterations)
+
+// Proof that the name does not exist, nor any wildcard that would match it:
+// RFC 4035, 5.4 for NSEC, or RFC 5155, 8.4 for NSEC3
+func nameErrorProof(records []dns.ResourceRecord, zone string,
+	name string) error {
+	if (records[0].Type == dns.RecordTypeNSEC3) {
+		chain, err := newNSEC3Chain(records, zone)
+		if (err != nil) {
+			return err
+		}
+		encloser, covering, err := chain.closestEncloser(name)
+		if (err != nil) {
+			return err
+		}
+		if (chain.covering("*." + encloser) == nil) {
+			return fmt.Errorf("no NSEC3 covering the wildcard *.%s", encloser)
+		}
+
+		// An opt-out span may hide an unsigned delegation of the next
+		// closer name (RFC 5155, 9.2)
+		if (covering.Flags & dns.NSEC3FlagOptOut != 0) {
+			return errOptOut
+		}
+		return nil
+	}
+
+	covering := nsecCovering(records, zone, name)
+	if (covering == nil) {
+		return fmt.Errorf("no NSEC proves that %s does not exist",
+			nameString(name))
+	}
+	encloser := closestEncloser(name, covering)
+	if (nsecCovering(records, zone, wildcardName(encloser)) == nil) {
+		return fmt.Errorf("no NSEC proves that %s does not exist",
+			wildcardName(encloser))
+	}
+	return nil
+}
+
+// Proof that the name exists, but without records of the type, or at least
+// that no wildcard would supply them: RFC 4035, 5.4 for NSEC, or RFC 5155,
+// 8.5-8.7 for NSEC3.  Returns the types that do exist at the name, if known,
+// and whether an NSEC3 opt-out span is all that covers the name, as may be
+// the case for an unsigned delegation
+func noDataProof(records []dns.ResourceRecord, zone string, name string,
+	rtype uint16) ([]uint16, bool, error) {
+	missing := func(types []uint16) error {
+		if (hasType(types, rtype) || (hasType(types, dns.RecordTypeCNAME) &&
+			rtype != dns.RecordTypeCNAME)) {
+			return fmt.Errorf("%s has %s records, per its %s",
+				nameString(name), dns.RecordTypeString(rtype),
+				dns.RecordTypeString(records[0].Type))
+		}
+		// The parent side of a delegation only speaks for the DS records
+		// (RFC 6840, 4.1)
+		if (rtype != dns.RecordTypeDS && hasType(types, dns.RecordTypeNS) &&
+			!hasType(types, dns.RecordTypeSOA)) {
+			return fmt.Errorf("%s for %s is from the parent side of a " +
+				"delegation", dns.RecordTypeString(records[0].Type),
+				nameString(name))
+		}
+		return nil
+	}
+
+	if (records[0].Type == dns.RecordTypeNSEC3) {
+		chain, err := newNSEC3Chain(records, zone)
+		if (err != nil) {
+			return nil, false, err
+		}
+		matching := chain.matching(name)
+		if (matching != nil) {
+			return matching.Types, false, missing(matching.Types)
+		}
+		encloser, covering, err := chain.closestEncloser(name)
+		if (err != nil) {
+			return nil, false, err
+		}
+		if (rtype == dns.RecordTypeDS &&
+			covering.Flags & dns.NSEC3FlagOptOut != 0) {
+			return nil, true, nil
+		}
+		wildcard := chain.matching("*." + encloser)
+		if (wildcard == nil) {
+			return nil, false, fmt.Errorf("no NSEC3 matches %s", nameString(name))
+		}
+		return nil, false, missing(wildcard.Types)
+	}
+
+	for _, rr := range records {
+		nsec := rr.Data.(*dns.RDataNSEC)
+		if (dns.CanonicalName(rr.Name) == dns.CanonicalName(name)) {
+			return nsec.Types, false, missing(nsec.Types)
+		}
+	}
+
+	// An empty non-terminal has no NSEC of its own, but the NSEC before it
+	// leads to a name below it (RFC 4035, 3.1.3.2)
+	covering := nsecCovering(records, zone, name)
+	if (covering == nil) {
+		return nil, false, fmt.Errorf("no NSEC matches %s", nameString(name))
+	}
+	next := covering.Data.(*dns.RDataNSEC).NextDomain
+	if (dns.IsSubdomain(next, name)) {
+		return nil, false, nil
+	}
+
+	// Otherwise, the name only exists via a wildcard, without the type
+	wildcard := wildcardName(closestEncloser(name, covering))
+	for _, rr := range records {
+		if (dns.CanonicalName(rr.Name) == wildcard) {
+			types := rr.Data.(*dns.RDataNSEC).Types
+			return nil, false, missing(types)
+		}
+	}
+	return nil, false, fmt.Errorf("no NSEC matches %s", nameString(name))
+}
+
+// Proof that the name did not exist, so that the wildcard with the given
+// number of labels applied (RFC 4035, 5.3.4 + RFC 5155, 8.8)
+func wildcardProof(records []dns.ResourceRecord, zone string, name string,
+	labels int) error {
+	if (len(records) == 0) {
+		return fmt.Errorf("no proof that %s does not exist, for the " +
+			"wildcard", nameString(name))
+	}
+	if (records[0].Type == dns.RecordTypeNSEC3) {
+		chain, err := newNSEC3Chain(records, zone)
+		if (err != nil) {
+			return err
+		}
+		nameLabels := strings.Split(dns.CanonicalName(name), ".")
+		nextCloser := strings.Join(nameLabels[len(nameLabels) - labels - 1:],
+			".")
+		if (chain.covering(nextCloser) == nil) {
+			return fmt.Errorf("no NSEC3 proves that %s does not exist",
+				nextCloser)
+		}
+		return nil
+	}
+	if (nsecCovering(records, zone, name) == nil) {
+		return fmt.Errorf("no NSEC proves that %s does not exist",
+			nameString(name))
+	}
+	return nil
+}
+
+// NSEC that covers the name: the name falls strictly between the owner +
+// the next name, in canonical order.  The last NSEC of the zone wraps around
+// to the apex
+func nsecCovering(records []dns.ResourceRecord, zone string,
+	name string) *dns.ResourceRecord {
+	for i, rr := range records {
+		nsec, ok := rr.Data.(*dns.RDataNSEC)
+		if (!ok || !dns.IsSubdomain(name, zone)) {
+			continue
+		}
+		afterOwner := (dns.CompareNames(rr.Name, name) < 0)
+		beforeNext := (dns.CompareNames(name, nsec.NextDomain) < 0 ||
+			dns.CompareNames(nsec.NextDomain, rr.Name) <= 0)
+		if (!afterOwner || !beforeNext) {
+			continue
+		}
+
+		// Nothing below a delegation, or a DNAME, is covered by the zone
+		// (RFC 6840, 4.1)
+		if (dns.IsSubdomain(name, rr.Name) &&
+			((hasType(nsec.Types, dns.RecordTypeNS) &&
+			!hasType(nsec.Types, dns.RecordTypeSOA)) ||
+			hasType(nsec.Types, dns.RecordTypeDNAME))) {
+			continue
+		}
+		return &records[i]
+	}
+	return nil
+}
+
+// Closest encloser of a name that does not exist, from the NSEC that covers
+// it: the longest ancestor the name shares with either end of the NSEC
+func closestEncloser(name string, covering *dns.ResourceRecord) string {
+	next := covering.Data.(*dns.RDataNSEC).NextDomain
+	encloser := commonAncestor(name, covering.Name)
+	other := commonAncestor(name, next)
+	if (dns.LabelCount(other) > dns.LabelCount(encloser)) {
+		encloser = other
+	}
+	return encloser
+}
+
+func commonAncestor(a string, b string) string {
+	for {
+		if (dns.IsSubdomain(a, b)) {
+			return dns.CanonicalName(b)
+		}
+		_, b, _ = strings.Cut(dns.CanonicalName(b), ".")
+	}
+}
+
+func wildcardName(encloser string) string {
+	if (encloser == "") {
+		return "*"
+	}
+	return "*." + encloser
+}
+
+
+//
+// NSEC3 chain, as returned in an answer
+//
+type nsec3Chain struct {
+	zone		string
+	records		[]dns.ResourceRecord
+}
+
+func newNSEC3Chain(records []dns.ResourceRecord,
+	zone string) (*nsec3Chain, error) {
+	for _, rr := range records {
+		nsec3 := rr.Data.(*dns.RDataNSEC3)
+		if (nsec3.HashAlgorithm != dns.NSEC3HashSHA1) {
+			return nil, fmt.Errorf("unsupported NSEC3 hash algorithm %d",
+				nsec3.HashAlgorithm)
+		}
+		if (nsec3.Iterations > NSEC3MaxIterations) {
+			return nil, errIterations
+		}
+	}
+	return &nsec3Chain{ zone: zone, records: records }, nil
+}
+
+// Hashed owner name of the NSEC3, if it is in the zone
+func (chain *nsec3Chain) ownerHash(rr dns.ResourceRecord) (string, bool) {
+	hash, zone, _ := strings.Cut(dns.CanonicalName(rr.Name), ".")
+	return hash, (zone == chain.zone)
+}
+
+func (chain *nsec3Chain) matching(name string) *dns.RDataNSEC3 {
+	for _, rr := range chain.records {
+		nsec3 := rr.Data.(*dns.RDataNSEC3)
+		hash, ok := chain.ownerHash(rr)
+		if (ok && hash == nsec3.HashName(name)) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+// NSEC3 whose owner + next hashes enclose the hash of the name.  The hashes
+// are base32hex, which sorts in the same order as the hashes themselves
+func (chain *nsec3Chain) covering(name string) *dns.RDataNSEC3 {
+	for _, rr := range chain.records {
+		nsec3 := rr.Data.(*dns.RDataNSEC3)
+		owner, ok := chain.ownerHash(rr)
+		if (!ok) {
+			continue
+		}
+		hash := nsec3.HashName(name)
+		next := nsec3.NextHash()
+		if ((owner < hash && hash < next) ||
+			(next <= owner && (hash > owner || hash < next))) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+// Closest encloser proof (RFC 5155, 8.3): the longest existing ancestor of
+// the name, which has a matching NSEC3, and the covering NSEC3 for the next
+// closer name, one label longer
+func (chain *nsec3Chain) closestEncloser(name string) (string,
+	*dns.RDataNSEC3, error) {
+	name = dns.CanonicalName(name)
+	nextCloser := name
+	encloser := name
+	for (encloser != chain.zone && dns.IsSubdomain(encloser, chain.zone)) {
+		nextCloser = encloser
+		_, encloser, _ = strings.Cut(encloser, ".")
+		if (chain.matching(encloser) == nil) {
+			continue
+		}
+		covering := chain.covering(nextCloser)
+		if (covering == nil) {
+			return "", nil, fmt.Errorf("no NSEC3 proves that %s does not " +
+				"exist", nextCloser)
+		}
+		return encloser, covering, nil
+	}
+	return "", nil, fmt.Errorf("no NSEC3 closest encloser for %s",
+		nameString(name))
+}
+
+
+//
+// RRsets + their signatures
+//
+type signedRRset struct {
+	records		[]dns.ResourceRecord
+	sigs		[]*dns.RDataRRSIG
+}
+
+// Group the records by owner, type + class, in order of appearance, with
+// the RRSIGs that cover each set
+func groupRRsets(records []dns.ResourceRecord) []*signedRRset {
+	type key struct {
+		name	string
+		rtype	uint16
+		class	uint16
+	}
+	var sets []*signedRRset
+	byKey := map[key]*signedRRset{}
+	for _, rr := range records {
+		if (rr.Type == dns.RecordTypeRRSIG || rr.Data == nil) {
+			continue
+		}
+		k := key{ dns.CanonicalName(rr.Name), rr.Type, rr.Class }
+		if (byKey[k] == nil) {
+			byKey[k] = &signedRRset{}
+			sets = append(sets, byKey[k])
+		}
+		byKey[k].records = append(byKey[k].records, rr)
+	}
+	for _, rr := range records {
+		rrsig, ok := rr.Data.(*dns.RDataRRSIG)
+		if (!ok) {
+			continue
+		}
+		set := byKey[key{ dns.CanonicalName(rr.Name), rrsig.TypeCovered,
+			rr.Class }]
+		if (set != nil) {
+			set.sigs = append(set.sigs, rrsig)
+		}
+	}
+	return sets
+}
+
+// Is the CNAME one that a validated DNAME implies?
+func synthesized(records []dns.ResourceRecord,
+	dnames []dns.ResourceRecord) bool {
+	cname, ok := records[0].Data.(*dns.RDataCNAME)
+	if (!ok || len(records) != 1) {
+		return false
+	}
+	for _, rr := range dnames {
+		owner := dns.CanonicalName(rr.Name)
+		name := dns.CanonicalName(records[0].Name)
+		if (name == owner || !dns.IsSubdomain(name, owner)) {
+			continue
+		}
+		prefix := strings.TrimSuffix(name, owner)
+		target := dns.CanonicalName(rr.Data.(*dns.RDataDNAME).Target)
+		if (dns.CanonicalName(prefix + target) ==
+			dns.CanonicalName(cname.Target)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Final name of any chain of aliases in the answers, starting from the name,
+// and whether there are records of the type for it
+func chainEnd(answers []dns.ResourceRecord, name string,
+	rtype uint16) (string, bool) {
+	name = dns.CanonicalName(name)
+	for range answers {
+		next := ""
+		for _, rr := range answers {
+			if (dns.CanonicalName(rr.Name) != name) {
+				continue
+			}
+			if (rr.Type == rtype || rtype == dns.RecordTypeALL) {
+				return name, true
+			}
+			cname, ok := rr.Data.(*dns.RDataCNAME)
+			if (ok) {
+				next = dns.CanonicalName(cname.Target)
+			}
+		}
+		if (next == "") {
+			break
+		}
+		name = next
+	}
+	return name, false
+}
+
+func hasSOA(records []dns.ResourceRecord) bool {
+	for _, rr := range records {
+		if (rr.Type == dns.RecordTypeSOA) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasType(types []uint16, rtype uint16) bool {
+	for _, present := range types {
+		if (present == rtype) {
+			return true
+		}
+	}
+	return false
+}
+
+func nameString(name string) string {
+	if (name == "") {
+		return "."
+	}
+	return name
+}
+
+func zoneString(zone string) string {
+	return nameString(dns.CanonicalName(zone))
+}
@@ -0,0 +1,594 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Signed test zones, in testdata/dnssec.  The root (RSA/SHA-256, separate
+// KSK + ZSK) delegates securely to example (ECDSA P-256, NSEC), hashed
+// (Ed25519, NSEC3 with opt-out) and other (ECDSA P-384), and insecurely to
+// insecure; hashed delegates insecurely to child.hashed.  The signatures
+// are valid from 2025 to 2035
+//
+var testZoneFiles = map[string]string{
+		"":				"root.zone",
+		"example":		"example.zone",
+		"hashed":		"hashed.zone",
+		"child.hashed":	"child.hashed.zone",
+		"other":		"other.zone",
+		"insecure":		"insecure.zone",
+	}
+
+var testValidationTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testZone struct {
+	origin	string
+	records	[]dns.ResourceRecord
+}
+
+func loadTestZones(t *testing.T) []*testZone {
+	var zones []*testZone
+	for origin, file := range testZoneFiles {
+		records, err := dns.LoadZone(filepath.Join("testdata", "dnssec", file),
+			origin)
+		if (err != nil) {
+			t.Fatalf("Unable to load %s: %s", file, err)
+		}
+		zones = append(zones, &testZone{ origin, records })
+	}
+	return zones
+}
+
+func testAnchors(t *testing.T) []dns.ResourceRecord {
+	anchors, err := LoadTrustAnchors(filepath.Join("testdata", "dnssec",
+		"anchor.key"))
+	if (err != nil) {
+		t.Fatal("Unable to load the trust anchor: ", err)
+	}
+	return anchors
+}
+
+// Records of the type at the name, along with their RRSIGs
+func (zone *testZone) rrset(name string, rtype uint16) []dns.ResourceRecord {
+	var records []dns.ResourceRecord
+	for _, rr := range zone.records {
+		if (dns.CanonicalName(rr.Name) != name) {
+			continue
+		}
+		rrsig, ok := rr.Data.(*dns.RDataRRSIG)
+		if (rr.Type == rtype || (ok && rrsig.TypeCovered == rtype)) {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// Does the name exist, possibly as an empty non-terminal?
+func (zone *testZone) exists(name string) bool {
+	for _, rr := range zone.records {
+		if (rr.Type != dns.RecordTypeNSEC3 && dns.IsSubdomain(rr.Name, name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NSEC or NSEC3 (+ RRSIG) that matches or covers the name: the last one in
+// the chain, in order, that is not after the name.  None for unsigned zones
+func (zone *testZone) denial(name string) []dns.ResourceRecord {
+	var owners []string
+	hashed := map[string]string{}
+	var params *dns.NSEC3Params
+	for _, rr := range zone.records {
+		if (rr.Type == dns.RecordTypeNSEC) {
+			owners = append(owners, dns.CanonicalName(rr.Name))
+		} else if (rr.Type == dns.RecordTypeNSEC3) {
+			owner := dns.CanonicalName(rr.Name)
+			hash, _, _ := strings.Cut(owner, ".")
+			hashed[hash] = owner
+			owners = append(owners, hash)
+			params = &rr.Data.(*dns.RDataNSEC3).NSEC3Params
+		}
+	}
+
+	if (len(owners) == 0) {
+		return nil
+	}
+	position := name
+	if (params != nil) {
+		position = params.HashName(name)
+		sort.Strings(owners)
+	} else {
+		sort.Slice(owners, func(i, j int) bool {
+			return dns.CompareNames(owners[i], owners[j]) < 0
+		})
+	}
+	found := owners[len(owners) - 1]
+	for _, owner := range owners {
+		if ((params != nil && owner <= position) ||
+			(params == nil && dns.CompareNames(owner, position) <= 0)) {
+			found = owner
+		}
+	}
+	if (params != nil) {
+		return zone.rrset(hashed[found], dns.RecordTypeNSEC3)
+	}
+	return zone.rrset(found, dns.RecordTypeNSEC)
+}
+
+// Answer the question from the zone, as a signed authoritative server would
+func (zone *testZone) answer(reply *dns.Message, name string,
+	rtype uint16) {
+	for _, rr := range zone.answerRecords(name, rtype) {
+		reply.AddAnswer(rr)
+	}
+	if (len(reply.Answers) > 0 && !zone.wildcard(name)) {
+		return
+	}
+
+	// Negative answers, and wildcard expansions, need proof that there is
+	// nothing closer
+	if (len(reply.Answers) == 0) {
+		for _, rr := range zone.rrset(zone.origin, dns.RecordTypeSOA) {
+			reply.AddNameserver(rr)
+		}
+	}
+	proof := zone.denial(name)
+	encloser := zone.encloser(name)
+	if (zone.nsec3()) {
+		// Closest encloser proof, for names without an NSEC3 of their own,
+		// including opt-out delegations
+		for (!zone.hashed(encloser)) {
+			_, encloser, _ = strings.Cut(encloser, ".")
+		}
+		if (encloser != name) {
+			labels := strings.Split(name, ".")
+			nextCloser := strings.Join(labels[len(labels) -
+				dns.LabelCount(encloser) - 1:], ".")
+			proof = append(zone.denial(encloser), zone.denial(nextCloser)...)
+		}
+	}
+	if (!zone.exists(name) && len(reply.Answers) == 0) {
+		reply.Header.Flags |= dns.RcodeNameError
+		proof = append(proof, zone.denial("*." + encloser)...)
+	}
+	seen := map[string]bool{}
+	for _, rr := range proof {
+		if (!seen[dns.ZoneString(rr)]) {
+			reply.AddNameserver(rr)
+			seen[dns.ZoneString(rr)] = true
+		}
+	}
+}
+
+func (zone *testZone) answerRecords(name string,
+	rtype uint16) []dns.ResourceRecord {
+	records := zone.rrset(name, rtype)
+	if (len(records) > 0) {
+		return records
+	}
+	cnames := zone.rrset(name, dns.RecordTypeCNAME)
+	for _, rr := range cnames {
+		cname, ok := rr.Data.(*dns.RDataCNAME)
+		if (ok && dns.IsSubdomain(cname.Target, zone.origin)) {
+			return append(cnames, zone.answerRecords(
+				dns.CanonicalName(cname.Target), rtype)...)
+		}
+	}
+	if (len(cnames) > 0 || zone.exists(name)) {
+		return cnames
+	}
+
+	// Wildcard expansion, with the owner name replaced
+	records = zone.rrset("*." + zone.encloser(name), rtype)
+	for i := range records {
+		records[i].Name = name
+	}
+	return records
+}
+
+func (zone *testZone) wildcard(name string) bool {
+	return (!zone.exists(name) && zone.exists("*." + zone.encloser(name)))
+}
+
+// Closest existing ancestor of the name
+func (zone *testZone) encloser(name string) string {
+	for (!zone.exists(name)) {
+		_, name, _ = strings.Cut(name, ".")
+	}
+	return name
+}
+
+// Does the name have an NSEC3 record of its own?
+func (zone *testZone) hashed(name string) bool {
+	params := zone.rrset(zone.origin, dns.RecordTypeNSEC3PARAM)[0].Data
+	hash := params.(*dns.RDataNSEC3PARAM).HashName(name)
+	return (len(zone.rrset(dns.AbsoluteName(hash, zone.origin),
+		dns.RecordTypeNSEC3)) > 0)
+}
+
+func (zone *testZone) nsec3() bool {
+	return (len(zone.rrset(zone.origin, dns.RecordTypeNSEC3PARAM)) > 0)
+}
+
+// Exchange that answers from the deepest zone for the name, or for DS
+// records, from the parent zone.  The optional hook may alter the replies
+func signedExchange(zones []*testZone,
+	alter func(reply *dns.Message)) ExchangeFunc {
+	return func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		question := request.Questions[0]
+		name := dns.CanonicalName(question.Name)
+		var found *testZone
+		for _, zone := range zones {
+			if (!dns.IsSubdomain(name, zone.origin) ||
+				(question.Type == dns.RecordTypeDS && name == zone.origin &&
+				name != "")) {
+				continue
+			}
+			if (found == nil ||
+				dns.LabelCount(zone.origin) > dns.LabelCount(found.origin)) {
+				found = zone
+			}
+		}
+
+		reply := newReply(*request)
+		found.answer(&reply, name, question.Type)
+		if (alter != nil) {
+			alter(&reply)
+		}
+		if (reply.Rcode() != dns.RcodeNoError) {
+			return &reply, &dns.RcodeError{ Rcode: reply.Rcode() }
+		}
+		return &reply, nil
+	}
+}
+
+func newTestValidator(t *testing.T, exchange ExchangeFunc) *Validator {
+	return &Validator{
+		Exchange:	exchange,
+		Anchors:	testAnchors(t),
+		Now:		func() time.Time { return testValidationTime },
+	}
+}
+
+func validate(validator *Validator, exchange ExchangeFunc, name string,
+	rtype uint16) (*dns.Message, Validation) {
+	request := NewDNSSECQuery(name, rtype)
+	reply, _ := exchange(context.Background(), &request)
+	return reply, validator.Validate(context.Background(), reply)
+}
+
+
+//
+// Answers from the signed zones, positive + negative, with each algorithm
+//
+func TestValidate(t *testing.T) {
+	exchange := signedExchange(loadTestZones(t), nil)
+	validator := newTestValidator(t, exchange)
+
+	tests := []struct {
+		name		string
+		rtype		uint16
+		rcode		uint16
+		security	Security
+		reason		string
+	}{
+		// Secure, via each algorithm
+		{ "www.example", dns.RecordTypeA, dns.RcodeNoError, SecuritySecure,
+			"signed by example, key tag" },
+		{ "host.hashed", dns.RecordTypeA, dns.RcodeNoError, SecuritySecure,
+			"signed by hashed, key tag" },
+		{ "www.other", dns.RecordTypeA, dns.RcodeNoError, SecuritySecure,
+			"signed by other, key tag" },
+		{ "", dns.RecordTypeSOA, dns.RcodeNoError, SecuritySecure,
+			"signed by ., key tag" },
+
+		// Canonical form of names in RDATA, and CNAMEs within the zone
+		{ "mail.example", dns.RecordTypeMX, dns.RcodeNoError, SecuritySecure,
+			"signed by example" },
+		{ "alias.example", dns.RecordTypeA, dns.RcodeNoError, SecuritySecure,
+			"signed by example" },
+
+		// Wildcard expansion, with proof that there was no closer match
+		{ "anything.wild.example", dns.RecordTypeTXT, dns.RcodeNoError,
+			SecuritySecure, "nonexistence proven by NSEC" },
+
+		// NXDOMAIN + NODATA, via NSEC
+		{ "missing.example", dns.RecordTypeA, dns.RcodeNameError,
+			SecuritySecure, "nonexistence proven by NSEC" },
+		{ "www.example", dns.RecordTypeMX, dns.RcodeNoError, SecuritySecure,
+			"nonexistence proven by NSEC" },
+		{ "wild.example", dns.RecordTypeA, dns.RcodeNoError, SecuritySecure,
+			"nonexistence proven by NSEC" },
+		{ "missing", dns.RecordTypeA, dns.RcodeNameError, SecuritySecure,
+			"nonexistence proven by NSEC" },
+
+		// NODATA, via NSEC3
+		{ "host.hashed", dns.RecordTypeTXT, dns.RcodeNoError, SecuritySecure,
+			"nonexistence proven by NSEC3" },
+
+		// Unsigned delegations, proven by NSEC + NSEC3 opt-out
+		{ "www.insecure", dns.RecordTypeA, dns.RcodeNoError, SecurityInsecure,
+			"unsigned delegation to insecure from ." },
+		{ "missing.insecure", dns.RecordTypeA, dns.RcodeNameError,
+			SecurityInsecure, "unsigned delegation to insecure from ." },
+		{ "www.child.hashed", dns.RecordTypeA, dns.RcodeNoError,
+			SecurityInsecure, "no DS for child.hashed, within an NSEC3 " +
+			"opt-out span of hashed" },
+
+		// NXDOMAIN within an NSEC3 opt-out span, which may hide an
+		// unsigned delegation
+		{ "missing.hashed", dns.RecordTypeA, dns.RcodeNameError,
+			SecurityInsecure, "NSEC3 opt-out span" },
+		{ "www.missing.hashed", dns.RecordTypeA, dns.RcodeNameError,
+			SecurityInsecure, "NSEC3 opt-out span" },
+	}
+
+	for _, test := range tests {
+		reply, validation := validate(validator, exchange, test.name,
+			test.rtype)
+		if (reply.Rcode() != test.rcode) {
+			t.Errorf("%s %s: expected %s, got %s", test.name,
+				dns.RecordTypeString(test.rtype), dns.RcodeString(test.rcode),
+				dns.RcodeString(reply.Rcode()))
+		}
+		if (validation.Security != test.security ||
+			!strings.Contains(validation.Reason, test.reason)) {
+			t.Errorf("%s %s: expected %s (%s...), got %s", test.name,
+				dns.RecordTypeString(test.rtype), test.security, test.reason,
+				validation)
+		}
+	}
+}
+
+//
+// Answers that have been tampered with, or that cannot be validated
+//
+func TestValidateBogus(t *testing.T) {
+	zones := loadTestZones(t)
+
+	// Drop every record of the type from replies to questions of another
+	strip := func(rtype uint16, qtype uint16) func(reply *dns.Message) {
+		return func(reply *dns.Message) {
+			if (reply.Questions[0].Type != qtype) {
+				return
+			}
+			filter := func(records []dns.ResourceRecord) []dns.ResourceRecord {
+				var kept []dns.ResourceRecord
+				for _, rr := range records {
+					if (rr.Type != rtype) {
+						kept = append(kept, rr)
+					}
+				}
+				return kept
+			}
+			reply.Answers = filter(reply.Answers)
+			reply.Nameservers = filter(reply.Nameservers)
+		}
+	}
+
+	tests := []struct {
+		description	string
+		alter		func(reply *dns.Message)
+		name		string
+		rtype		uint16
+		now			time.Time
+		security	Security
+		reason		string
+	}{
+		{ "Altered address",
+			func(reply *dns.Message) {
+				for i, rr := range reply.Answers {
+					if (rr.Type == dns.RecordTypeA) {
+						reply.Answers[i].Data = &dns.RDataA{
+							Address: net.ParseIP("192.0.2.99").To4() }
+					}
+				}
+			}, "www.example", dns.RecordTypeA, testValidationTime,
+			SecurityBogus, "www.example A: DNSSEC signature does not verify" },
+		{ "Missing RRSIG", strip(dns.RecordTypeRRSIG, dns.RecordTypeA),
+			"www.example", dns.RecordTypeA, testValidationTime, SecurityBogus,
+			"no RRSIG for www.example A, in signed zone example" },
+		{ "Missing NSEC", strip(dns.RecordTypeNSEC, dns.RecordTypeA),
+			"missing.example", dns.RecordTypeA, testValidationTime, SecurityBogus,
+			"no NSEC or NSEC3 records" },
+		{ "Missing NSEC3", strip(dns.RecordTypeNSEC3, dns.RecordTypeTXT),
+			"host.hashed", dns.RecordTypeTXT, testValidationTime, SecurityBogus,
+			"no NSEC or NSEC3 records" },
+		{ "Missing DS", strip(dns.RecordTypeDS, dns.RecordTypeDS),
+			"www.other", dns.RecordTypeA, testValidationTime, SecurityBogus,
+			"no DS records for other, nor any proof" },
+		{ "Missing DNSKEY", strip(dns.RecordTypeDNSKEY,
+			dns.RecordTypeDNSKEY), "www.example", dns.RecordTypeA, testValidationTime, SecurityBogus,
+			"no DNSKEY records for ." },
+		{ "Expired", nil, "www.example", dns.RecordTypeA,
+			time.Date(2036, 1, 1, 0, 0, 0, 0, time.UTC), SecurityBogus,
+			"DNSSEC signature has expired: at 20350101000000" },
+		{ "Not yet valid", nil, "host.hashed", dns.RecordTypeA,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), SecurityBogus,
+			"DNSSEC signature is not yet valid: until 20250101000000" },
+	}
+
+	for _, test := range tests {
+		exchange := signedExchange(zones, test.alter)
+		validator := newTestValidator(t, exchange)
+		now := test.now
+		validator.Now = func() time.Time { return now }
+		_, validation := validate(validator, exchange, test.name, test.rtype)
+		if (validation.Security != test.security ||
+			!strings.Contains(validation.Reason, test.reason)) {
+			t.Errorf("%s: expected %s (%s...), got %s", test.description,
+				test.security, test.reason, validation)
+		}
+	}
+
+	// A wildcard expansion, without proof that the name did not exist
+	exchange := signedExchange(zones,
+		strip(dns.RecordTypeNSEC, dns.RecordTypeTXT))
+	_, validation := validate(newTestValidator(t, exchange), exchange,
+		"anything.wild.example", dns.RecordTypeTXT)
+	if (validation.Security != SecurityBogus) {
+		t.Error("Expected an unproven wildcard to be bogus, got ", validation)
+	}
+
+	// NODATA, where the NSEC shows that the type does exist
+	exchange = signedExchange(zones, func(reply *dns.Message) {
+		if (reply.Questions[0].Type == dns.RecordTypeA) {
+			reply.Answers = nil
+			reply.Nameservers = append(reply.Nameservers,
+				zones[0].rrset("", dns.RecordTypeSOA)...)
+		}
+	})
+	_, validation = validate(newTestValidator(t, exchange), exchange,
+		"www.example", dns.RecordTypeA)
+	if (validation.Security != SecurityBogus) {
+		t.Error("Expected missing records to be bogus, got ", validation)
+	}
+}
+
+//
+// Trust anchors other than the root, as DNSKEY records, and lookup failures
+//
+func TestValidateAnchors(t *testing.T) {
+	zones := loadTestZones(t)
+	exchange := signedExchange(zones, nil)
+	request := NewDNSSECQuery("other", dns.RecordTypeDNSKEY)
+	reply, _ := exchange(context.Background(), &request)
+	var anchors []dns.ResourceRecord
+	for _, rr := range reply.Answers {
+		if (rr.Type == dns.RecordTypeDNSKEY) {
+			anchors = append(anchors, rr)
+		}
+	}
+
+	validator := newTestValidator(t, exchange)
+	validator.Anchors = anchors
+	_, validation := validate(validator, exchange, "www.other",
+		dns.RecordTypeA)
+	if (validation.Security != SecuritySecure) {
+		t.Error("Expected a DNSKEY anchor to be trusted, got ", validation)
+	}
+	_, validation = validate(validator, exchange, "www.example",
+		dns.RecordTypeA)
+	if (validation.Security != SecurityIndeterminate ||
+		validation.Reason != "no trust anchor for example") {
+		t.Error("Expected no anchor to be indeterminate, got ", validation)
+	}
+
+	// An anchor that does not match the zone's keys
+	validator = newTestValidator(t, exchange)
+	validator.Anchors = []dns.ResourceRecord{ testAnchors(t)[0] }
+	validator.Anchors[0].Name = "example"
+	_, validation = validate(validator, exchange, "www.example",
+		dns.RecordTypeA)
+	if (validation.Security != SecurityBogus) {
+		t.Error("Expected a mismatched anchor to be bogus, got ", validation)
+	}
+
+	// The key lookups fail
+	failing := func(ctx context.Context,
+		request *dns.Message) (*dns.Message, error) {
+		return nil, context.DeadlineExceeded
+	}
+	validator = newTestValidator(t, failing)
+	_, validation = validate(validator, exchange, "www.example",
+		dns.RecordTypeA)
+	if (validation.Security != SecurityIndeterminate ||
+		!strings.Contains(validation.Reason, "DNSKEY lookup for .")) {
+		t.Error("Expected a failed lookup to be indeterminate, got ",
+			validation)
+	}
+
+	// Anchor files must only hold DS + DNSKEY records
+	_, err := LoadTrustAnchors(filepath.Join("testdata", "dnssec",
+		"insecure.zone"))
+	if (!errors.Is(err, ErrTrustAnchor)) {
+		t.Error("Expected ErrTrustAnchor, got ", err)
+	}
+}
+
+//
+// Chains of aliases, as followed across several queries
+//
+func TestValidateChain(t *testing.T) {
+	zones := loadTestZones(t)
+	exchange := signedExchange(zones, func(reply *dns.Message) {
+		// Answer only with the CNAME itself, as if for a different zone
+		if (reply.Questions[0].Name == "alias.example") {
+			reply.Answers = reply.Answers[:2]
+		}
+	})
+	validator := newTestValidator(t, exchange)
+
+	request := NewDNSSECQuery("alias.example", dns.RecordTypeA)
+	chain, err := FollowChain(context.Background(), exchange, &request, 0)
+	if (err != nil) {
+		t.Fatal("Unable to follow the chain: ", err)
+	}
+	if (len(chain.Replies) != 2) {
+		t.Fatalf("Expected 2 replies, got %d", len(chain.Replies))
+	}
+	validation := validator.ValidateChain(context.Background(), chain)
+	if (validation.Security != SecuritySecure) {
+		t.Error("Expected a secure chain, got ", validation)
+	}
+}
+
+//
+// Zones as signed by dns.SignZone, with either NSEC or NSEC3, validate
+//
+func TestValidateSignedZone(t *testing.T) {
+	records, err := dns.LoadZone(filepath.Join("testdata", "dnssec",
+		"insecure.zone"), "insecure")
+	if (err != nil) {
+		t.Fatal("Unable to load the zone: ", err)
+	}
+	ksk, _ := dns.GenerateSigningKey("insecure", dns.AlgorithmED25519,
+		dns.DNSKEYFlagSEP, 0)
+	zsk, _ := dns.GenerateSigningKey("insecure",
+		dns.AlgorithmECDSAP384SHA384, 0, 0)
+
+	for _, params := range []*dns.NSEC3Params{ nil, { Iterations: 2,
+		HashAlgorithm: dns.NSEC3HashSHA1, Salt: []byte{ 1 } } } {
+		signed, err := dns.SignZone(records, "insecure",
+			[]*dns.SigningKey{ ksk, zsk }, dns.SigningOptions{
+				Inception:	uint32(testValidationTime.Unix() - 3600),
+				Expiration:	uint32(testValidationTime.Unix() + 3600),
+				NSEC3:		params,
+			})
+		if (err != nil) {
+			t.Fatal("Signing error: ", err)
+		}
+		exchange := signedExchange([]*testZone{ { "insecure", signed } }, nil)
+		validator := newTestValidator(t, exchange)
+		validator.Anchors = []dns.ResourceRecord{ ksk.Record(3600) }
+
+		for _, question := range []struct{
+			name	string
+			rtype	uint16
+		}{
+			{ "www.insecure", dns.RecordTypeA },
+			{ "www.insecure", dns.RecordTypeMX },
+			{ "missing.insecure", dns.RecordTypeA },
+		} {
+			_, validation := validate(validator, exchange, question.name,
+				question.rtype)
+			if (validation.Security != SecuritySecure) {
+				t.Errorf("NSEC3 %t, %s %s: expected Secure, got %s",
+					(params != nil), question.name,
+					dns.RecordTypeString(question.rtype), validation)
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+//
+// DNS-over-HTTPS transport (RFC 8484).  Each request is sent to the server
+// URL as an application/dns-message, via either POST or GET, over a shared
+// HTTP client so that HTTP/2 connections are reused across requests.
+//
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+const DoHContentType = "application/dns-message"
+
+var ErrHTTPStatus	= errors.New("Unexpected HTTP status")
+var ErrContentType	= errors.New("Unexpected HTTP content type")
+
+
+// Valid DoH servers are absolute https URLs, optionally with the "{?dns}"
+// URI template suffix (RFC 8484, 4.1)
+func ValidURL(server string) bool {
+	parsed, err := url.Parse(strings.TrimSuffix(server, "{?dns}"))
+	return (err == nil && parsed.Scheme == "https" && parsed.Host != "")
+}
+
+func (client *Client) httpClient() *http.Client {
+	client.connsLock.Lock()
+	defer client.connsLock.Unlock()
+
+	if (client.http == nil) {
+		client.http = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:	client.TLSConfig,
+				ForceAttemptHTTP2:	true,
+				IdleConnTimeout:	90 * time.Second,
+			},
+		}
+	}
+	return client.http
+}
+
+// Send the request via DoH.  Returns the reply bytes as received, apart from
+// the message id, along with the HTTP response headers for adjustTTLs
+func (client *Client) exchangeHTTPS(ctx context.Context, address string,
+	requestBytes []byte) ([]byte, http.Header, error) {
+	// Use a zero message id, so identical requests are cacheable (RFC 8484,
+	// 4.1).  The original id is restored in the reply
+	id := messageId(requestBytes)
+	requestBytes = withMessageId(requestBytes, 0)
+
+	// Either encode the request in the URL, or send it as the body
+	var httpRequest *http.Request
+	var err error
+	address = strings.TrimSuffix(address, "{?dns}")
+	if (client.HTTPGet) {
+		var target *url.URL
+		target, err = url.Parse(address)
+		if (err != nil) {
+			return nil, nil, err
+		}
+		query := target.Query()
+		query.Set("dns", base64.RawURLEncoding.EncodeToString(requestBytes))
+		target.RawQuery = query.Encode()
+		httpRequest, err = http.NewRequestWithContext(ctx, http.MethodGet,
+			target.String(), nil)
+	} else {
+		httpRequest, err = http.NewRequestWithContext(ctx, http.MethodPost,
+			address, bytes.NewReader(requestBytes))
+		if (err == nil) {
+			httpRequest.Header.Set("Content-Type", DoHContentType)
+		}
+	}
+	if (err != nil) {
+		return nil, nil, err
+	}
+	httpRequest.Header.Set("Accept", DoHContentType)
+
+	response, err := client.httpClient().Do(httpRequest)
+	if (err != nil) {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	if (response.StatusCode != http.StatusOK) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrHTTPStatus, response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	if (contentType != DoHContentType) {
+		return nil, nil, fmt.Errorf("%w: %s", ErrContentType, contentType)
+	}
+
+	// The reply is bounded by the maximum DNS message size, regardless of
+	// transport
+	replyBytes, err := io.ReadAll(io.LimitReader(response.Body,
+		TCPMaxMessageSize + 1))
+	if (err != nil) {
+		return nil, nil, err
+	}
+	if (len(replyBytes) > TCPMaxMessageSize) {
+		return nil, nil, ErrMessageTooLarge
+	}
+
+	return withMessageId(replyBytes, id), response.Header, nil
+}
+
+
+//
+// HTTP caching.  A reply may have been served from an HTTP cache, so the
+// record TTLs are reduced by the Age of the response (RFC 8484, 5.1), and
+// also capped to the remaining HTTP freshness lifetime, if any.  This only
+// applies to the unpacked reply, after any TSIG verification, since the MAC
+// covers the TTLs as received.  Directives such as no-cache + no-store only
+// restrict HTTP caches, and say nothing about the lifetime of the records
+//
+func httpFreshness(header http.Header) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch (strings.ToLower(name)) {
+			case "max-age":
+				seconds, err := strconv.Atoi(value)
+				if (err == nil && seconds >= 0) {
+					return time.Duration(seconds) * time.Second, true
+				}
+		}
+	}
+
+	// Without max-age, fall back to Expires relative to Date
+	expires, err := http.ParseTime(header.Get("Expires"))
+	if (err != nil) {
+		return 0, false
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if (err != nil) {
+		date = time.Now()
+	}
+	if (expires.Before(date)) {
+		return 0, true
+	}
+	return expires.Sub(date), true
+}
+
+func adjustTTLs(reply *dns.Message, header http.Header) {
+	age, _ := strconv.Atoi(header.Get("Age"))
+	if (age < 0) {
+		age = 0
+	}
+	freshness, cacheable := httpFreshness(header)
+	if (age == 0 && !cacheable) {
+		return
+	}
+
+	adjust := func(records []dns.ResourceRecord) {
+		for i := range records {
+			ttl := int64(records[i].TTL) - int64(age)
+			if (cacheable) {
+				remaining := int64(freshness / time.Second) - int64(age)
+				if (remaining < ttl) {
+					ttl = remaining
+				}
+			}
+			if (ttl < 0) {
+				ttl = 0
+			}
+			records[i].TTL = int32(ttl)
+		}
+	}
+	adjust(reply.Answers)
+	adjust(reply.Nameservers)
+	adjust(reply.AdditionalRR)
+}
+
+
+// Message id of a packed message, without unpacking the whole message
+func messageId(messageBytes []byte) uint16 {
+	if (len(messageBytes) < 2) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(messageBytes)
+}
+
+// Copy of a packed message, with a different message id
+func withMessageId(messageBytes []byte, id uint16) []byte {
+	if (len(messageBytes) < 2) {
+		return messageBytes
+	}
+	copied := append([]byte{}, messageBytes...)
+	binary.BigEndian.PutUint16(copied, id)
+	return copied
+}
@@ -0,0 +1,245 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake DoH server, wrapping a local responder.  Records the details of the
+// last request, and counts the accepted connections
+//
+type dohServer struct {
+	*httptest.Server
+	accepts		int32
+	method		string
+	proto		int
+	id			uint16
+	headers		http.Header	// Extra reply headers
+}
+
+func startHTTPSUpstream(t *testing.T, handler upstreamHandler) *dohServer {
+	upstream := &dohServer{ headers: http.Header{} }
+	upstream.Server = httptest.NewUnstartedServer(http.HandlerFunc(
+		func(writer http.ResponseWriter, httpRequest *http.Request) {
+			upstream.method = httpRequest.Method
+			upstream.proto = httpRequest.ProtoMajor
+
+			var requestBytes []byte
+			var err error
+			if (httpRequest.Method == http.MethodGet) {
+				requestBytes, err = base64.RawURLEncoding.DecodeString(
+					httpRequest.URL.Query().Get("dns"))
+			} else if (httpRequest.Header.Get("Content-Type") == DoHContentType) {
+				requestBytes, err = io.ReadAll(httpRequest.Body)
+			} else {
+				err = ErrContentType
+			}
+			request := dns.Message{}
+			if (err == nil) {
+				_, err = request.Unpack(requestBytes)
+			}
+			if (err != nil) {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			upstream.id = request.Header.Id
+
+			for name, values := range upstream.headers {
+				writer.Header()[name] = values
+			}
+			writer.Header().Set("Content-Type", DoHContentType)
+			replyBytes, _ := handler(request, true).Pack()
+			writer.Write(replyBytes)
+		}))
+	upstream.EnableHTTP2 = true
+	upstream.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if (state == http.StateNew) {
+			atomic.AddInt32(&upstream.accepts, 1)
+		}
+	}
+	upstream.StartTLS()
+	t.Cleanup(upstream.Close)
+
+	return upstream
+}
+
+func newHTTPSClient(t *testing.T, upstream *dohServer) *Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+
+	client := newClient(upstream.URL + "/dns-query")
+	client.Transport = TransportHTTPS
+	client.TLSConfig = &tls.Config{ RootCAs: roots }
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newTTLAnswer(request dns.Message) dns.Message {
+	reply := newAnswer(request)
+	reply.Answers[0].TTL = 300
+	return reply
+}
+
+
+//
+// Validate POST + GET requests, over a single HTTP/2 connection
+//
+func TestHTTPSExchange(t *testing.T) {
+	upstream := startHTTPSUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			return newAnswer(request)
+		})
+	client := newHTTPSClient(t, upstream)
+
+	for _, get := range []bool{ false, true, false, true } {
+		client.HTTPGet = get
+		request := newQuery()
+		reply, err := client.Exchange(context.Background(), request)
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (len(reply.Answers) != 1 || reply.Header.Id != request.Header.Id) {
+			t.Error("Unexpected reply: ", reply)
+		}
+
+		expected := http.MethodPost
+		if (get) {
+			expected = http.MethodGet
+		}
+		if (upstream.method != expected) {
+			t.Error("Unexpected HTTP method: ", upstream.method)
+		}
+		if (upstream.proto != 2) {
+			t.Error("Expected HTTP/2: ", upstream.proto)
+		}
+		if (upstream.id != 0) {
+			t.Error("Expected a zero message id: ", upstream.id)
+		}
+	}
+	if (atomic.LoadInt32(&upstream.accepts) != 1) {
+		t.Error("Expected a single connection: ", upstream.accepts)
+	}
+}
+
+
+//
+// Validate the TTL adjustments for HTTP cache headers
+//
+func TestHTTPSCacheHeaders(t *testing.T) {
+	upstream := startHTTPSUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			return newTTLAnswer(request)
+		})
+	client := newHTTPSClient(t, upstream)
+
+	tests := []struct {
+		headers	map[string]string
+		ttl		int32
+	}{
+		{ map[string]string{}, 300 },
+		{ map[string]string{ "Cache-Control": "max-age=600" }, 300 },
+		{ map[string]string{ "Cache-Control": "public, max-age=60" }, 60 },
+		{ map[string]string{ "Age": "100" }, 200 },
+		{ map[string]string{ "Age": "20", "Cache-Control": "max-age=60" }, 40 },
+		{ map[string]string{ "Age": "400" }, 0 },
+		{ map[string]string{ "Cache-Control": "no-store" }, 300 },
+		{ map[string]string{ "Cache-Control": "no-store, max-age=60" }, 60 },
+		{ map[string]string{ "Cache-Control": "no-cache" }, 300 },
+		{ map[string]string{ "Cache-Control": "no-cache, max-age=60" }, 60 },
+		{ map[string]string{
+			"Date":		"Mon, 02 Jan 2006 15:04:05 GMT",
+			"Expires":	"Mon, 02 Jan 2006 15:06:05 GMT",
+		}, 120 },
+	}
+
+	for _, test := range tests {
+		upstream.headers = http.Header{}
+		for name, value := range test.headers {
+			upstream.headers.Set(name, value)
+		}
+		reply, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (reply.Answers[0].TTL != test.ttl) {
+			t.Errorf("%v: expected TTL %d, got %d", test.headers, test.ttl,
+				reply.Answers[0].TTL)
+		}
+	}
+}
+
+
+//
+// Validate TSIG verification of replies with cache headers, since the MAC
+// covers the TTLs as received, before any adjustment
+//
+func TestHTTPSTSIG(t *testing.T) {
+	key, _ := dns.ParseTSIGKey("key.example:hmac-sha256:c2VjcmV0IGtleSBieXRlcw==")
+	upstream := startHTTPSUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			reply := newTTLAnswer(request)
+			reply.TSIG = nil
+			if (request.TSIG != nil) {
+				key.Sign(&reply, request.TSIG.Data.(*dns.RDataTSIG).MAC,
+					time.Now())
+			}
+			return reply
+		})
+	client := newHTTPSClient(t, upstream)
+	client.TSIG = key
+
+	for _, header := range []string{ "", "max-age=60" } {
+		upstream.headers = http.Header{}
+		upstream.headers.Set("Cache-Control", header)
+		upstream.headers.Set("Age", "10")
+		reply, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatalf("%s: exchange error: %s", header, err)
+		}
+		expected := int32(290)
+		if (header != "") {
+			expected = 50
+		}
+		if (reply.Answers[0].TTL != expected) {
+			t.Errorf("%s: expected TTL %d, got %d", header, expected,
+				reply.Answers[0].TTL)
+		}
+	}
+}
+
+
+//
+// Validate the handling of HTTP errors
+//
+func TestHTTPSStatus(t *testing.T) {
+	upstream := startHTTPSUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			return newAnswer(request)
+		})
+	client := newHTTPSClient(t, upstream)
+	client.Servers = []string{ upstream.URL + "/missing{?dns}" }
+	upstream.Config.Handler = http.NotFoundHandler()
+
+	_, err := client.Exchange(context.Background(), newQuery())
+	if (!errors.Is(err, ErrHTTPStatus)) {
+		t.Error("Expected HTTP status error: ", err)
+	}
+
+	if (!ValidURL("https://dns.test/dns-query{?dns}") ||
+		ValidURL("http://dns.test/dns-query") || ValidURL("1.1.1.1")) {
+		t.Error("Unexpected URL validation")
+	}
+}
@@ -0,0 +1,292 @@
+//
+// Iterative resolution.  Rather than relying on an upstream recursive
+// server, start from the root servers and follow the referrals down to an
+// authoritative server for the name (RFC 1034, 5.3.3).
+//
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+var ErrTooManyReferrals	= errors.New("Too many referrals")
+var ErrBadReferral		= errors.New("Referral does not lead closer to the name")
+var ErrNoNameservers	= errors.New("No usable nameservers for delegation")
+var ErrMaxDepth			= errors.New("Nameserver lookups nested too deeply")
+var ErrQuestionCount	= errors.New("Expected a single question")
+
+const DefaultMaxReferrals	= 16
+const DefaultMaxDepth		= 4
+
+
+//
+// Authoritative nameserver, by name + address
+//
+type Nameserver struct {
+	Name		string
+	Address		string	// "address" or "address:port"
+}
+
+// Built-in root hints, per https://www.internic.net/domain/named.root.  Only
+// the IPv4 addresses are included, since IPv6 connectivity is not assumed
+var RootHints = []Nameserver{
+	{ "a.root-servers.net", "198.41.0.4" },
+	{ "b.root-servers.net", "170.247.170.2" },
+	{ "c.root-servers.net", "192.33.4.12" },
+	{ "d.root-servers.net", "199.7.91.13" },
+	{ "e.root-servers.net", "192.203.230.10" },
+	{ "f.root-servers.net", "192.5.5.241" },
+	{ "g.root-servers.net", "192.112.36.4" },
+	{ "h.root-servers.net", "198.97.190.53" },
+	{ "i.root-servers.net", "192.36.148.17" },
+	{ "j.root-servers.net", "192.58.128.30" },
+	{ "k.root-servers.net", "193.0.14.129" },
+	{ "l.root-servers.net", "199.7.83.42" },
+	{ "m.root-servers.net", "202.12.27.33" },
+}
+
+
+//
+// A single step of the resolution: the reply from one server for one zone
+//
+type TraceStep struct {
+	Zone		string
+	Server		Nameserver
+	Elapsed		time.Duration
+	Reply		*dns.Message
+}
+
+func (step TraceStep) String() string {
+	zone := step.Zone
+	if (zone == "") {
+		zone = "."
+	}
+	return fmt.Sprintf("Reply from %s (%s) for zone %s in %d ms",
+		step.Server.Address, step.Server.Name, zone,
+		step.Elapsed.Milliseconds())
+}
+
+
+//
+// Iterative resolver.  Each server for a zone is tried once, in turn, until
+// one of them replies
+//
+type Iterator struct {
+	Client			*Client			// Transport + timeouts; Servers is unused
+	Roots			[]Nameserver	// Defaults to RootHints
+	Port			int				// For delegated servers, defaults to DNSPort
+	MaxReferrals	int				// Defaults to DefaultMaxReferrals
+	MaxDepth		int				// Nested nameserver lookups, DefaultMaxDepth
+
+	// Optional hook for observing each step of the top-level resolution.
+	// The lookups of nameserver addresses are not traced
+	Trace			func(step TraceStep)
+}
+
+// Resolve the request's question, starting from the root.  As with
+// Client.Exchange, the reply may be returned along with a *dns.RcodeError
+func (iterator *Iterator) Resolve(ctx context.Context,
+	request *dns.Message) (*dns.Message, error) {
+	return iterator.resolve(ctx, request, 0)
+}
+
+func (iterator *Iterator) resolve(ctx context.Context, request *dns.Message,
+	depth int) (*dns.Message, error) {
+	maxDepth := iterator.MaxDepth
+	if (maxDepth == 0) {
+		maxDepth = DefaultMaxDepth
+	}
+	if (depth > maxDepth) {
+		return nil, ErrMaxDepth
+	}
+	maxReferrals := iterator.MaxReferrals
+	if (maxReferrals == 0) {
+		maxReferrals = DefaultMaxReferrals
+	}
+	if (len(request.Questions) != 1) {
+		return nil, ErrQuestionCount
+	}
+
+	// Authoritative servers are not expected to recurse
+	query := *request
+	query.Header.Flags &^= dns.MessageHeaderFlagRecursionDesired
+	name := query.Questions[0].Name
+
+	zone := ""
+	servers := iterator.Roots
+	if (len(servers) == 0) {
+		servers = RootHints
+	}
+	for step := 0; step <= maxReferrals; step++ {
+		reply, err := iterator.query(ctx, zone, servers, &query, depth)
+		if (err != nil) {
+			return reply, err
+		}
+
+		// Anything other than a referral is the final answer, even if empty
+		delegation, hosts, err := referral(reply, zone, name)
+		if (err != nil || delegation == nil) {
+			return reply, err
+		}
+
+		servers, err = iterator.nameservers(ctx, &query, zone, hosts, reply,
+			depth)
+		if (err != nil) {
+			return reply, err
+		}
+		zone = *delegation
+	}
+
+	return nil, ErrTooManyReferrals
+}
+
+// Send the query to each server for the zone in turn, until one replies
+func (iterator *Iterator) query(ctx context.Context, zone string,
+	servers []Nameserver, query *dns.Message, depth int) (*dns.Message, error) {
+	client := iterator.Client
+	if (client == nil) {
+		client = &Client{}
+	}
+
+	var reply *dns.Message
+	err := ErrNoNameservers
+	for _, server := range servers {
+		start := time.Now()
+		reply, err = client.exchangeServer(ctx, server.Address, query)
+		if (ctx.Err() != nil) {
+			return reply, ctx.Err()
+		}
+		if (err == nil || !retryable(err)) {
+			if (iterator.Trace != nil && depth == 0) {
+				iterator.Trace(TraceStep{
+					Zone:		zone,
+					Server:		server,
+					Elapsed:	time.Since(start),
+					Reply:		reply,
+				})
+			}
+			return reply, err
+		}
+	}
+
+	return reply, err
+}
+
+// If the reply is a referral, then return the delegated zone + the names of
+// its nameservers.  A valid referral always leads strictly closer to the
+// name being resolved
+func referral(reply *dns.Message, zone string,
+	name string) (*string, []string, error) {
+	if (len(reply.Answers) > 0 ||
+		reply.Header.Flags & dns.MessageHeaderFlagAuthoritative != 0) {
+		return nil, nil, nil
+	}
+
+	var delegation *string
+	var hosts []string
+	for _, rr := range reply.Nameservers {
+		ns, ok := rr.Data.(*dns.RDataNS)
+		if (!ok) {
+			continue
+		}
+		owner := dns.CanonicalName(rr.Name)
+		if (delegation == nil) {
+			delegation = &owner
+		}
+		if (owner == *delegation) {
+			hosts = append(hosts, dns.CanonicalName(ns.Host))
+		}
+	}
+	if (delegation == nil) {
+		return nil, nil, nil
+	}
+
+	if (*delegation == dns.CanonicalName(zone) ||
+		!dns.IsSubdomain(*delegation, zone) ||
+		!dns.IsSubdomain(name, *delegation)) {
+		return nil, nil, ErrBadReferral
+	}
+	return delegation, hosts, nil
+}
+
+// Addresses of the nameservers for a delegation.  Glue records are only
+// trusted if they lie within the zone of the server that supplied them;
+// other nameserver names are resolved separately, from the root
+func (iterator *Iterator) nameservers(ctx context.Context, query *dns.Message,
+	zone string, hosts []string, reply *dns.Message,
+	depth int) ([]Nameserver, error) {
+	port := iterator.Port
+	if (port == 0) {
+		port = DNSPort
+	}
+	nameserver := func(host string, address net.IP) Nameserver {
+		return Nameserver{
+			Name:		host,
+			Address:	net.JoinHostPort(address.String(), fmt.Sprint(port)),
+		}
+	}
+
+	var servers []Nameserver
+	for _, host := range hosts {
+		if (!dns.IsSubdomain(host, zone)) {
+			continue
+		}
+		for _, rr := range reply.AdditionalRR {
+			if (dns.CanonicalName(rr.Name) != host) {
+				continue
+			}
+			switch data := rr.Data.(type) {
+				case *dns.RDataA:
+					servers = append(servers, nameserver(host, data.Address))
+				case *dns.RDataAAAA:
+					servers = append(servers, nameserver(host, data.Address))
+			}
+		}
+	}
+	if (len(servers) > 0) {
+		return servers, nil
+	}
+
+	// Without any usable glue, look up each nameserver in turn until one
+	// of them has an address
+	var err error = ErrNoNameservers
+	for _, host := range hosts {
+		for _, rtype := range []uint16{ dns.RecordTypeA, dns.RecordTypeAAAA } {
+			lookup := dns.NewQuery(host, rtype)
+			lookup.EDNS = query.EDNS
+
+			var hostReply *dns.Message
+			hostReply, err = iterator.resolve(ctx, &lookup, depth + 1)
+			if (ctx.Err() != nil) {
+				return nil, ctx.Err()
+			}
+			if (err != nil) {
+				continue
+			}
+			for _, rr := range hostReply.Answers {
+				switch data := rr.Data.(type) {
+					case *dns.RDataA:
+						servers = append(servers, nameserver(host, data.Address))
+					case *dns.RDataAAAA:
+						servers = append(servers, nameserver(host, data.Address))
+				}
+			}
+			if (len(servers) > 0) {
+				return servers, nil
+			}
+		}
+	}
+	if (errors.Is(err, ErrMaxDepth)) {
+		return nil, err
+	}
+
+	return nil, ErrNoNameservers
+}
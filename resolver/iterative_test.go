@@ -0,0 +1,212 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake authoritative servers, each on its own loopback address, but all on
+// the same port:
+//   127.0.0.1	root, delegates com + test, and refers loop back to itself
+//   127.0.0.2	com, delegates example.com (with glue), oob.com (with bogus
+//				out-of-bailiwick glue) and deep.com (without glue)
+//   127.0.0.3	example.com
+//   127.0.0.4	test, including ns.hosting.test
+//   127.0.0.5	ns.hosting.test, serving oob.com
+//
+func startAuthorities(t *testing.T) int {
+	delegate := func(request dns.Message, zone string, host string,
+		glue string) dns.Message {
+		reply := newReply(request)
+		reply.AddNameserver(dns.ResourceRecord{
+			Name:	zone,
+			Type:	dns.RecordTypeNS,
+			Class:	dns.RecordClassIN,
+			TTL:	3600,
+			Data:	&dns.RDataNS{ Host: host },
+		})
+		if (glue != "") {
+			reply.AddAdditional(dns.ResourceRecord{
+				Name:	host,
+				Type:	dns.RecordTypeA,
+				Class:	dns.RecordClassIN,
+				TTL:	3600,
+				Data:	&dns.RDataA{ Address: net.ParseIP(glue).To4() },
+			})
+		}
+		return reply
+	}
+	answer := func(request dns.Message, address string) dns.Message {
+		reply := newReply(request)
+		reply.Header.Flags |= dns.MessageHeaderFlagAuthoritative
+		if (request.Questions[0].Type == dns.RecordTypeA) {
+			reply.AddAnswer(dns.ResourceRecord{
+				Name:	request.Questions[0].Name,
+				Type:	dns.RecordTypeA,
+				Class:	dns.RecordClassIN,
+				TTL:	300,
+				Data:	&dns.RDataA{ Address: net.ParseIP(address).To4() },
+			})
+		}
+		return reply
+	}
+	under := func(request dns.Message, zone string) bool {
+		return dns.IsSubdomain(request.Questions[0].Name, zone)
+	}
+
+	servers := map[string]upstreamHandler{
+		"127.0.0.1": func(request dns.Message, tcp bool) dns.Message {
+			switch {
+				case under(request, "com"):
+					return delegate(request, "com", "ns.nic.com", "127.0.0.2")
+				case under(request, "test"):
+					return delegate(request, "test", "ns.nic.test", "127.0.0.4")
+			}
+			return delegate(request, "", "ns.nic.loop", "127.0.0.1")
+		},
+		"127.0.0.2": func(request dns.Message, tcp bool) dns.Message {
+			switch {
+				case under(request, "example.com"):
+					return delegate(request, "example.com", "ns1.example.com",
+						"127.0.0.3")
+				case under(request, "oob.com"):
+					return delegate(request, "oob.com", "ns.hosting.test",
+						"127.0.0.66")
+			}
+			return delegate(request, "deep.com", "ns.deep.com", "")
+		},
+		"127.0.0.3": func(request dns.Message, tcp bool) dns.Message {
+			return answer(request, "192.0.2.1")
+		},
+		"127.0.0.4": func(request dns.Message, tcp bool) dns.Message {
+			return answer(request, "127.0.0.5")
+		},
+		"127.0.0.5": func(request dns.Message, tcp bool) dns.Message {
+			return answer(request, "192.0.2.2")
+		},
+	}
+
+	// Find a port that is free on every address
+	for attempt := 0; attempt < 10; attempt++ {
+		address, stop, err := listenUpstream("127.0.0.1:0", servers["127.0.0.1"])
+		if (err != nil) {
+			continue
+		}
+		_, port, _ := net.SplitHostPort(address)
+		stops := []func(){ stop }
+		for host, handler := range servers {
+			if (host == "127.0.0.1") {
+				continue
+			}
+			_, stop, err = listenUpstream(net.JoinHostPort(host, port), handler)
+			if (err != nil) {
+				break
+			}
+			stops = append(stops, stop)
+		}
+		t.Cleanup(func() {
+			for _, stop := range stops {
+				stop()
+			}
+		})
+		if (err == nil) {
+			number, _ := strconv.Atoi(port)
+			return number
+		}
+	}
+	t.Fatal("Unable to listen")
+	return 0
+}
+
+func newIterator(port int) *Iterator {
+	return &Iterator{
+		Client:	&Client{ Timeout: 200 * time.Millisecond },
+		Roots:	[]Nameserver{
+			{ "ns.nic.root", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)) },
+		},
+		Port:	port,
+	}
+}
+
+func iterate(iterator *Iterator, name string) (*dns.Message, error) {
+	request := dns.NewQuery(name, dns.RecordTypeA)
+	return iterator.Resolve(context.Background(), &request)
+}
+
+func answerAddress(reply *dns.Message) string {
+	if (reply == nil || len(reply.Answers) != 1) {
+		return ""
+	}
+	return reply.Answers[0].Data.String()
+}
+
+
+//
+// Validate the resolution via glue, and the trace of each step
+//
+func TestIterativeResolve(t *testing.T) {
+	iterator := newIterator(startAuthorities(t))
+	var zones []string
+	iterator.Trace = func(step TraceStep) {
+		zones = append(zones, step.Zone)
+	}
+
+	reply, err := iterate(iterator, "www.example.com")
+	if (err != nil) {
+		t.Fatal("Resolution error: ", err)
+	}
+	if (answerAddress(reply) != "192.0.2.1") {
+		t.Error("Unexpected reply: ", reply)
+	}
+	if (reply.Header.Flags & dns.MessageHeaderFlagAuthoritative == 0) {
+		t.Error("Expected an authoritative reply: ", reply)
+	}
+	if (strings.Join(zones, ",") != ",com,example.com") {
+		t.Error("Unexpected trace: ", zones)
+	}
+}
+
+
+//
+// Validate the resolution of nameservers outside the delegated zone, without
+// trusting the out-of-bailiwick glue
+//
+func TestIterativeOutOfBailiwick(t *testing.T) {
+	iterator := newIterator(startAuthorities(t))
+
+	reply, err := iterate(iterator, "www.oob.com")
+	if (err != nil) {
+		t.Fatal("Resolution error: ", err)
+	}
+	if (answerAddress(reply) != "192.0.2.2") {
+		t.Error("Unexpected reply: ", reply)
+	}
+}
+
+
+//
+// Validate the rejection of referrals that lead nowhere
+//
+func TestIterativeBadReferrals(t *testing.T) {
+	iterator := newIterator(startAuthorities(t))
+
+	_, err := iterate(iterator, "www.loop")
+	if (err != ErrBadReferral) {
+		t.Error("Expected a bad referral: ", err)
+	}
+
+	// The nameserver for deep.com lies within deep.com, without glue
+	_, err = iterate(iterator, "www.deep.com")
+	if (!errors.Is(err, ErrMaxDepth)) {
+		t.Error("Expected excessive nesting: ", err)
+	}
+}
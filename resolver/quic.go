@@ -0,0 +1,141 @@
+//
+// DNS-over-QUIC transport (RFC 9250).  Each request is sent on its own
+// bidirectional stream, over a QUIC connection that is kept open and reused
+// for subsequent requests to the same server.
+//
+
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+
+const DoQPort = 853
+const DoQALPN = "doq"
+
+// Application error codes, RFC 9250 4.3
+const (
+	DoQNoError				quic.ApplicationErrorCode = 0x0
+	DoQRequestCancelled		quic.StreamErrorCode = 0x3
+)
+
+
+//
+// Persistent connection to a single DoQ server.  The lock only guards the
+// (re)establishment of the connection; the streams themselves are
+// independent, so requests may proceed concurrently
+//
+type quicConn struct {
+	sync.Mutex
+	conn	*quic.Conn
+}
+
+func (client *Client) quicConnection(address string) *quicConn {
+	client.connsLock.Lock()
+	defer client.connsLock.Unlock()
+
+	if (client.quicConns == nil) {
+		client.quicConns = map[string]*quicConn{}
+	}
+	upstream, ok := client.quicConns[address]
+	if (!ok) {
+		upstream = &quicConn{}
+		client.quicConns[address] = upstream
+	}
+	return upstream
+}
+
+// Current connection to the server, if still open, or else a new one
+func (upstream *quicConn) connect(ctx context.Context, address string,
+	config *tls.Config, stale *quic.Conn) (*quic.Conn, bool, error) {
+	upstream.Lock()
+	defer upstream.Unlock()
+
+	if (upstream.conn != nil && upstream.conn != stale &&
+		upstream.conn.Context().Err() == nil) {
+		return upstream.conn, true, nil
+	}
+	if (upstream.conn != nil) {
+		upstream.conn.CloseWithError(DoQNoError, "")
+		upstream.conn = nil
+	}
+
+	// The "doq" ALPN token is mandatory (RFC 9250, 4.1.1)
+	if (config == nil) {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	config.NextProtos = []string{ DoQALPN }
+
+	conn, err := quic.DialAddr(ctx, address, config, nil)
+	if (err != nil) {
+		return nil, false, err
+	}
+	upstream.conn = conn
+	return conn, false, nil
+}
+
+func (client *Client) exchangeQUIC(ctx context.Context, address string,
+	requestBytes []byte, maxReplySize int) ([]byte, error) {
+	upstream := client.quicConnection(address)
+
+	// The message id must be zero (RFC 9250, 4.2.1); the stream itself
+	// matches the reply to the request.  The original id is restored in the
+	// reply
+	id := messageId(requestBytes)
+	requestBytes = withMessageId(requestBytes, 0)
+
+	// The server may have closed an idle connection in the meantime, so a
+	// failure on a reused connection warrants one more attempt on a fresh
+	// connection
+	var stale *quic.Conn
+	for {
+		conn, reused, err := upstream.connect(ctx, address,
+			client.TLSConfig, stale)
+		if (err != nil) {
+			return nil, err
+		}
+
+		replyBytes, err := exchangeQUICStream(ctx, conn, requestBytes)
+		if (err == nil) {
+			return withMessageId(replyBytes, id), nil
+		}
+		if (!reused || ctx.Err() != nil) {
+			return nil, err
+		}
+		stale = conn
+	}
+}
+
+// Send a single length-prefixed request on a new stream, and wait for the
+// reply.  The client closes its side of the stream once the request is sent
+// (RFC 9250, 4.2)
+func exchangeQUICStream(ctx context.Context, conn *quic.Conn,
+	requestBytes []byte) ([]byte, error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if (err != nil) {
+		return nil, err
+	}
+	defer watchContext(ctx, stream)()
+
+	err = WriteTCPMessage(stream, requestBytes)
+	if (err == nil) {
+		err = stream.Close()
+	}
+	var replyBytes []byte
+	if (err == nil) {
+		replyBytes, err = ReadTCPMessage(stream)
+	}
+	if (err != nil) {
+		stream.CancelRead(DoQRequestCancelled)
+		stream.CancelWrite(DoQRequestCancelled)
+		return nil, err
+	}
+
+	return replyBytes, nil
+}
@@ -0,0 +1,161 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"sync/atomic"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake DoQ server.  Answers each request on its own stream, and counts the
+// accepted connections + streams.  If oneShot is set, then each connection is
+// closed on its second request, as if idle.  Requests with a non-zero message id are
+// rejected, per RFC 9250
+//
+type doqServer struct {
+	address		string
+	accepts		int32
+	streams		int32
+	badIds		int32
+}
+
+func startQUICUpstream(t *testing.T, cert tls.Certificate, alpn string,
+	oneShot bool) *doqServer {
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates:	[]tls.Certificate{ cert },
+		NextProtos:		[]string{ alpn },
+	}, nil)
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	upstream := &doqServer{ address: listener.Addr().String() }
+	serve := func(stream *quic.Stream) {
+		defer stream.Close()
+		requestBytes, err := ReadTCPMessage(stream)
+		if (err != nil) {
+			return
+		}
+		request := dns.Message{}
+		_, err = request.Unpack(requestBytes)
+		if (err != nil) {
+			return
+		}
+		if (request.Header.Id != 0) {
+			atomic.AddInt32(&upstream.badIds, 1)
+			stream.CancelWrite(0x2) // DOQ_PROTOCOL_ERROR
+			return
+		}
+		replyBytes, _ := newAnswer(request).Pack()
+		WriteTCPMessage(stream, replyBytes)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if (err != nil) {
+				return
+			}
+			atomic.AddInt32(&upstream.accepts, 1)
+			go func() {
+				for count := 0; ; count++ {
+					stream, err := conn.AcceptStream(context.Background())
+					if (err != nil) {
+						return
+					}
+					if (oneShot && count > 0) {
+						conn.CloseWithError(0, "")
+						return
+					}
+					atomic.AddInt32(&upstream.streams, 1)
+					go serve(stream)
+				}
+			}()
+		}
+	}()
+
+	return upstream
+}
+
+func newQUICClient(t *testing.T, server string, cert tls.Certificate) *Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+
+	client := newClient(server)
+	client.Transport = TransportQUIC
+	client.TLSConfig = &tls.Config{ RootCAs: roots }
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+
+//
+// Validate several requests, each on its own stream of a single connection
+//
+func TestQUICExchange(t *testing.T) {
+	cert := newCertificate(t)
+	upstream := startQUICUpstream(t, cert, DoQALPN, false)
+	client := newQUICClient(t, upstream.address, cert)
+
+	for i := 0; i < 3; i++ {
+		request := newQuery()
+		reply, err := client.Exchange(context.Background(), request)
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (len(reply.Answers) != 1 || reply.Header.Id != request.Header.Id) {
+			t.Error("Unexpected reply: ", reply)
+		}
+	}
+	if (atomic.LoadInt32(&upstream.accepts) != 1) {
+		t.Error("Expected a single connection: ", upstream.accepts)
+	}
+	if (atomic.LoadInt32(&upstream.streams) != 3) {
+		t.Error("Expected a stream per request: ", upstream.streams)
+	}
+	if (atomic.LoadInt32(&upstream.badIds) != 0) {
+		t.Error("Expected zero message ids: ", upstream.badIds)
+	}
+}
+
+
+//
+// Validate reconnection once the server closes the connection
+//
+func TestQUICReconnect(t *testing.T) {
+	cert := newCertificate(t)
+	upstream := startQUICUpstream(t, cert, DoQALPN, true)
+	client := newQUICClient(t, upstream.address, cert)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+	}
+	if (atomic.LoadInt32(&upstream.accepts) != 3) {
+		t.Error("Expected a connection per request: ", upstream.accepts)
+	}
+}
+
+
+//
+// Validate the mandatory ALPN token
+//
+func TestQUICALPN(t *testing.T) {
+	cert := newCertificate(t)
+	upstream := startQUICUpstream(t, cert, "h3", false)
+	client := newQUICClient(t, upstream.address, cert)
+
+	_, err := client.Exchange(context.Background(), newQuery())
+	if (err == nil) {
+		t.Error("Expected ALPN mismatch")
+	}
+}
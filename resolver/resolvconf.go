@@ -0,0 +1,152 @@
+//
+// Stub resolver configuration, as read from /etc/resolv.conf.  Only the
+// directives relevant to a DNS client are supported: nameserver, search,
+// domain, and options ndots/timeout/attempts/rotate.  See resolv.conf(5).
+//
+
+package resolver
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+
+const DefaultResolvConf		= "/etc/resolv.conf"
+
+// Defaults + limits, per resolv.conf(5)
+const ResolvConfDefaultNdots	= 1
+const ResolvConfMaxNdots		= 15
+const ResolvConfDefaultTimeout	= 5 * time.Second
+const ResolvConfMaxTimeout		= 30 * time.Second
+const ResolvConfDefaultAttempts	= 2
+const ResolvConfMaxAttempts		= 5
+const ResolvConfDefaultServer	= "127.0.0.1"
+
+type ResolvConf struct {
+	Nameservers	[]string
+	Search		[]string
+	Ndots		int
+	Timeout		time.Duration
+	Attempts	int
+	Rotate		bool
+}
+
+
+func LoadResolvConf(path string) (*ResolvConf, error) {
+	file, err := os.Open(path)
+	if (err != nil) {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseResolvConf(file)
+}
+
+func ParseResolvConf(reader io.Reader) (*ResolvConf, error) {
+	conf := &ResolvConf{
+		Ndots:		ResolvConfDefaultNdots,
+		Timeout:	ResolvConfDefaultTimeout,
+		Attempts:	ResolvConfDefaultAttempts,
+	}
+
+	// Numeric options are silently clamped to their limits; malformed
+	// values are ignored, as in the system resolver
+	parseOption := func(value string, max int) (int, bool) {
+		n, err := strconv.Atoi(value)
+		if (err != nil || n < 0) {
+			return 0, false
+		}
+		if (n > max) {
+			n = max
+		}
+		return n, true
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		// Discard comments + blank lines
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if (len(fields) < 2) {
+			continue
+		}
+
+		switch (fields[0]) {
+			case "nameserver":
+				if (ValidServer(fields[1])) {
+					conf.Nameservers = append(conf.Nameservers, fields[1])
+				}
+
+			// The domain + search directives are mutually exclusive; the
+			// last one wins
+			case "domain":
+				conf.Search = []string{ fields[1] }
+			case "search":
+				conf.Search = fields[1:]
+
+			case "options":
+				for _, option := range fields[1:] {
+					name, value, _ := strings.Cut(option, ":")
+					switch (name) {
+						case "ndots":
+							n, ok := parseOption(value, ResolvConfMaxNdots)
+							if (ok) {
+								conf.Ndots = n
+							}
+						case "timeout":
+							n, ok := parseOption(value,
+								int(ResolvConfMaxTimeout / time.Second))
+							if (ok && n > 0) {
+								conf.Timeout = time.Duration(n) * time.Second
+							}
+						case "attempts":
+							n, ok := parseOption(value, ResolvConfMaxAttempts)
+							if (ok && n > 0) {
+								conf.Attempts = n
+							}
+						case "rotate":
+							conf.Rotate = true
+					}
+				}
+		}
+	}
+	err := scanner.Err()
+	if (err != nil) {
+		return nil, err
+	}
+
+	// Without any explicit nameservers, assume a local resolver
+	if (len(conf.Nameservers) == 0) {
+		conf.Nameservers = []string{ ResolvConfDefaultServer }
+	}
+
+	return conf, nil
+}
+
+// Candidate names to query for a hostname, in order.  An absolute (dotted)
+// name is used as-is.  Otherwise, names with at least ndots dots are tried
+// as-is before the search list; names with fewer are tried after
+func (conf *ResolvConf) SearchNames(name string) []string {
+	if (strings.HasSuffix(name, ".")) {
+		return []string{ name }
+	}
+
+	var expanded []string
+	for _, domain := range conf.Search {
+		expanded = append(expanded,
+			name + "." + strings.TrimSuffix(domain, "."))
+	}
+
+	if (strings.Count(name, ".") >= conf.Ndots) {
+		return append([]string{ name }, expanded...)
+	}
+	return append(expanded, name)
+}
@@ -0,0 +1,102 @@
+package resolver
+
+import(
+	"reflect"
+	"testing"
+	"time"
+	)
+
+//
+// Validate parsing of a complete resolv.conf
+//
+func TestParseResolvConf(t *testing.T) {
+	conf, err := LoadResolvConf("testdata/resolv.conf")
+	if (err != nil) {
+		t.Fatal("Unable to load resolv.conf: ", err)
+	}
+
+	nameservers := []string{ "10.0.0.53", "10.0.1.53", "fd00::53" }
+	if !reflect.DeepEqual(conf.Nameservers, nameservers) {
+		t.Error("Unexpected nameservers: ", conf.Nameservers)
+	}
+	search := []string{ "corp.example.com", "lab.example.com" }
+	if !reflect.DeepEqual(conf.Search, search) {
+		t.Error("Unexpected search list: ", conf.Search)
+	}
+	if (conf.Ndots != 2) {
+		t.Error("Unexpected ndots: ", conf.Ndots)
+	}
+	if (conf.Timeout != ResolvConfMaxTimeout) {
+		t.Error("Unexpected timeout: ", conf.Timeout)
+	}
+	if (conf.Attempts != 3) {
+		t.Error("Unexpected attempts: ", conf.Attempts)
+	}
+	if (!conf.Rotate) {
+		t.Error("Expected rotate")
+	}
+}
+
+
+//
+// Validate the precedence of the domain + search directives, and defaults
+//
+func TestResolvConfDefaults(t *testing.T) {
+	conf, err := LoadResolvConf("testdata/resolv.conf.domain")
+	if (err != nil) {
+		t.Fatal("Unable to load resolv.conf: ", err)
+	}
+	if !reflect.DeepEqual(conf.Search, []string{ "example.org" }) {
+		t.Error("Expected the last directive to win: ", conf.Search)
+	}
+
+	conf, err = LoadResolvConf("testdata/resolv.conf.empty")
+	if (err != nil) {
+		t.Fatal("Unable to load resolv.conf: ", err)
+	}
+	if !reflect.DeepEqual(conf.Nameservers,
+		[]string{ ResolvConfDefaultServer }) {
+		t.Error("Unexpected default nameservers: ", conf.Nameservers)
+	}
+	if (conf.Ndots != ResolvConfDefaultNdots ||
+		conf.Timeout != ResolvConfDefaultTimeout ||
+		conf.Attempts != ResolvConfDefaultAttempts || conf.Rotate) {
+		t.Error("Unexpected default options: ", conf)
+	}
+
+	_, err = LoadResolvConf("testdata/missing")
+	if (err == nil) {
+		t.Error("Expected error on missing file")
+	}
+}
+
+
+//
+// Validate search-list expansion
+//
+func TestSearchNames(t *testing.T) {
+	conf := &ResolvConf{
+		Search:		[]string{ "a.example", "b.example." },
+		Ndots:		1,
+		Timeout:	time.Second,
+	}
+
+	testCases := []struct{
+		name		string
+		expected	[]string
+	}{
+		{ "host",		[]string{ "host.a.example", "host.b.example", "host" } },
+		{ "www.host",	[]string{ "www.host", "www.host.a.example",
+							"www.host.b.example" } },
+		{ "host.",		[]string{ "host." } },
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			names := conf.SearchNames(test.name)
+			if !reflect.DeepEqual(names, test.expected) {
+				t.Error("Unexpected search names: ", names)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+//
+// DNS query client.  Sends requests to an upstream server and returns the
+// validated replies.  See the dns package for the message codec.
+//
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+const DNSPort = 53
+const DoTPort = 853 // RFC 7858
+
+const UDPMaxMessageSize = 512 // Without EDNS, RFC 1035 4.2.1
+const TCPMaxMessageSize = 65535
+
+var ErrMessageTooLarge = errors.New("DNS message too large for TCP")
+var ErrNoServers		= errors.New("No upstream DNS servers")
+
+const DefaultTimeout	= 3 * time.Second
+const DefaultBackoff	= 250 * time.Millisecond
+
+
+//
+// Transport selection
+//
+type Transport int
+
+const (
+	TransportAuto	Transport = iota // UDP, with TCP fallback on truncation
+	TransportUDP
+	TransportTCP
+	TransportTLS	// DNS-over-TLS, RFC 7858
+	TransportHTTPS	// DNS-over-HTTPS, RFC 8484; servers are URLs
+	TransportQUIC	// DNS-over-QUIC, RFC 9250
+)
+
+
+//
+// Query client.  Each request is sent to each upstream server in turn until
+// one of them answers; if none do, then the whole list is retried after a
+// backoff delay, up to the retry limit.  A Client is safe for concurrent use
+// as long as its fields are not modified.  Connection-oriented transports
+// keep their connections open across requests, until Close
+//
+type Client struct {
+	Servers		[]string		// "address" or "address:port"
+	Timeout		time.Duration	// Per attempt, defaults to DefaultTimeout
+	Retries		int				// Additional passes through the server list
+	Backoff		time.Duration	// Initial delay between passes, doubling
+	Transport	Transport
+	Rotate		bool			// Spread the load across all servers?
+	TLSConfig	*tls.Config		// Optional, for TransportTLS/HTTPS/QUIC
+	HTTPGet		bool			// Use GET rather than POST, for TransportHTTPS
+	TSIG		*dns.TSIGKey	// Sign requests + verify replies, if set
+
+	// Optional hook for observing the raw request/reply bytes
+	Dump		func(header string, rawBytes []byte)
+
+	next		uint32			// Starting server, if rotating
+	connsLock	sync.Mutex
+	conns		map[string]*tlsConn	// Open DoT connections, by address
+	http		*http.Client		// Shared DoH connections
+	quicConns	map[string]*quicConn	// Open DoQ connections, by address
+}
+
+// Upstream server addresses may omit the port
+func serverAddress(server string, port int) string {
+	if (net.ParseIP(server) != nil) {
+		return net.JoinHostPort(server, fmt.Sprint(port))
+	}
+	return server
+}
+
+// Valid upstream server addresses are either a bare IP address, or an IP
+// address + port
+func ValidServer(server string) bool {
+	host, _, err := net.SplitHostPort(serverAddress(server, DNSPort))
+	return (err == nil && net.ParseIP(host) != nil)
+}
+
+
+//
+// Transports.  Each of these sends a single packed request to the upstream
+// server and returns the raw bytes of the corresponding reply
+//
+type transport func(ctx context.Context, address string,
+	requestBytes []byte, maxReplySize int) ([]byte, error)
+
+// Connections + streams, which support I/O deadlines
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// Abort any pending I/O on the connection if the context expires or is
+// cancelled.  The caller must invoke the returned function once the I/O is
+// complete
+func watchContext(ctx context.Context, conn deadliner) func() {
+	deadline, ok := ctx.Deadline()
+	if (ok) {
+		conn.SetDeadline(deadline)
+	}
+
+	done	:= make(chan struct{})
+	stopped	:= make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Now())
+			case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func exchangeUDP(ctx context.Context, address string,
+	requestBytes []byte, maxReplySize int) ([]byte, error) {
+	var dialer net.Dialer
+	upstream, err := dialer.DialContext(ctx, "udp", address)
+	if (err != nil) {
+		return nil, err
+	}
+	defer upstream.Close()
+	defer watchContext(ctx, upstream)()
+
+	// Send the actual DNS request
+	_, err = upstream.Write(requestBytes)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// Wait for a reply, if any.  The reply should never exceed the payload
+	// size advertised in the request
+	replyBytes := make([]byte, maxReplySize)
+	length, err := upstream.Read(replyBytes)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return replyBytes[:length], nil
+}
+
+func exchangeTCP(ctx context.Context, address string,
+	requestBytes []byte, maxReplySize int) ([]byte, error) {
+	var dialer net.Dialer
+	upstream, err := dialer.DialContext(ctx, "tcp", address)
+	if (err != nil) {
+		return nil, err
+	}
+	defer upstream.Close()
+	defer watchContext(ctx, upstream)()
+
+	// Send the request, prefixed with its 2-byte length (RFC 1035, 4.2.2)
+	err = WriteTCPMessage(upstream, requestBytes)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// Wait for the complete reply.  The length prefix bounds the reply, so
+	// the advertised payload size is irrelevant here
+	return ReadTCPMessage(upstream)
+}
+
+func WriteTCPMessage(writer io.Writer, messageBytes []byte) error {
+	if (len(messageBytes) > TCPMaxMessageSize) {
+		return(ErrMessageTooLarge)
+	}
+
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.BigEndian, uint16(len(messageBytes)))
+	buffer.Write(messageBytes)
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+func ReadTCPMessage(reader io.Reader) ([]byte, error) {
+	var length uint16
+	err := binary.Read(reader, binary.BigEndian, &length)
+	if (err != nil) {
+		return nil, err
+	}
+
+	messageBytes := make([]byte, length)
+	_, err = io.ReadFull(reader, messageBytes)
+	if (err != nil) {
+		return nil, err
+	}
+
+	return messageBytes, nil
+}
+
+
+//
+// Main resolver logic
+//
+func (client *Client) exchange(ctx context.Context, address string,
+	request *dns.Message, send transport) (*dns.Message, error) {
+	requestBytes, requestMAC, err := client.pack(request)
+	if (err != nil) {
+		return nil, err
+	}
+	if (client.Dump != nil) {
+		client.Dump("Raw request bytes", requestBytes)
+	}
+
+	// Each attempt has its own timeout, within the overall context
+	timeout := client.Timeout
+	if (timeout == 0) {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Send the request + wait for the reply, if any
+	maxReplySize := UDPMaxMessageSize
+	if (request.EDNS != nil && int(request.EDNS.UDPSize) > maxReplySize) {
+		maxReplySize = int(request.EDNS.UDPSize)
+	}
+	replyBytes, err := send(ctx, address, requestBytes, maxReplySize)
+	if (err != nil) {
+		return nil, err
+	}
+	if (client.Dump != nil) {
+		client.Dump("Raw reply bytes", replyBytes)
+	}
+
+	// Parse + validate the reply
+	reply := &dns.Message{}
+	_, err = reply.Unpack(replyBytes)
+	if (err != nil) {
+		return nil, err
+	}
+	err = reply.Validate(*request)
+	if (err == nil && client.TSIG != nil) {
+		err = client.TSIG.Verify(replyBytes, requestMAC, time.Now())
+	}
+
+	return reply, err
+}
+
+// Packed request, signed if the client has a TSIG key, along with its MAC
+func (client *Client) pack(request *dns.Message) ([]byte, []byte, error) {
+	if (client.TSIG == nil) {
+		packed, err := request.Pack()
+		return packed, nil, err
+	}
+	signed := *request
+	return client.TSIG.Sign(&signed, nil, time.Now())
+}
+
+// Send the request to a single upstream server
+func (client *Client) exchangeServer(ctx context.Context, server string,
+	request *dns.Message) (*dns.Message, error) {
+	// Prefer UDP, unless explicitly disabled.  If the reply is truncated,
+	// then retry the same request over TCP
+	var reply *dns.Message
+	var err error
+	address := serverAddress(server, DNSPort)
+	if (client.Transport == TransportQUIC) {
+		address = serverAddress(server, DoQPort)
+		reply, err = client.exchange(ctx, address, request, client.exchangeQUIC)
+	} else if (client.Transport == TransportHTTPS) {
+		var header http.Header
+		send := func(ctx context.Context, address string,
+			requestBytes []byte, maxReplySize int) ([]byte, error) {
+			var replyBytes []byte
+			var err error
+			replyBytes, header, err = client.exchangeHTTPS(ctx, address,
+				requestBytes)
+			return replyBytes, err
+		}
+		reply, err = client.exchange(ctx, server, request, send)
+		if (reply != nil) {
+			adjustTTLs(reply, header)
+		}
+	} else if (client.Transport == TransportTLS) {
+		address = serverAddress(server, DoTPort)
+		reply, err = client.exchange(ctx, address, request, client.exchangeTLS)
+	} else if (client.Transport == TransportTCP) {
+		reply, err = client.exchange(ctx, address, request, exchangeTCP)
+	} else {
+		reply, err = client.exchange(ctx, address, request, exchangeUDP)
+		if (err == dns.ErrTruncated && client.Transport == TransportAuto) {
+			reply, err = client.exchange(ctx, address, request, exchangeTCP)
+		}
+	}
+	if (err != nil) {
+		return reply, err
+	}
+
+	if (reply.Rcode() != dns.RcodeNoError) {
+		return reply, &dns.RcodeError{ Rcode: reply.Rcode() }
+	}
+
+	return reply, nil
+}
+
+// Errors that warrant asking a different server.  An error response code
+// is generally authoritative, except for these two
+func retryable(err error) bool {
+	var rcodeErr *dns.RcodeError
+	if (errors.As(err, &rcodeErr)) {
+		return (rcodeErr.Rcode == dns.RcodeServerFailure ||
+			rcodeErr.Rcode == dns.RcodeRefused)
+	}
+	return true
+}
+
+// Send a request to the upstream servers.  On success, the reply may still
+// carry a non-zero response code; in that case, the reply is returned along
+// with a *dns.RcodeError
+func (client *Client) Exchange(ctx context.Context,
+	request *dns.Message) (*dns.Message, error) {
+	if (len(client.Servers) == 0) {
+		return nil, ErrNoServers
+	}
+
+	// When rotating, each request starts with a different server
+	start := 0
+	if (client.Rotate) {
+		start = int(atomic.AddUint32(&client.next, 1) - 1)
+	}
+
+	var reply *dns.Message
+	var err error
+	backoff := client.Backoff
+	for attempt := 0; attempt <= client.Retries; attempt++ {
+		// Wait a little longer before each subsequent pass
+		if (attempt > 0) {
+			select {
+				case <-ctx.Done():
+					return reply, ctx.Err()
+				case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		// Fail over to the next server on any transient error
+		for i := range client.Servers {
+			server := client.Servers[(start + i) % len(client.Servers)]
+			reply, err = client.exchangeServer(ctx, server, request)
+			if (ctx.Err() != nil) {
+				return reply, ctx.Err()
+			}
+			if (err == nil || !retryable(err)) {
+				return reply, err
+			}
+		}
+	}
+
+	return reply, err
+}
+
+// Close any connections held open by the client
+func (client *Client) Close() error {
+	client.connsLock.Lock()
+	defer client.connsLock.Unlock()
+
+	for address, upstream := range client.conns {
+		upstream.Lock()
+		if (upstream.conn != nil) {
+			upstream.conn.Close()
+		}
+		upstream.Unlock()
+		delete(client.conns, address)
+	}
+	if (client.http != nil) {
+		client.http.CloseIdleConnections()
+	}
+	for address, upstream := range client.quicConns {
+		upstream.Lock()
+		if (upstream.conn != nil) {
+			upstream.conn.CloseWithError(DoQNoError, "")
+		}
+		upstream.Unlock()
+		delete(client.quicConns, address)
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package resolver
+
+import(
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake upstream server, listening for both UDP and TCP requests on the same
+// loopback port.  The handler generates the reply for each request
+//
+type upstreamHandler func(request dns.Message, tcp bool) dns.Message
+
+func startUpstream(t *testing.T, handler upstreamHandler) string {
+	// Bind TCP first, then UDP on the same port
+	for attempt := 0; attempt < 10; attempt++ {
+		address, stop, err := listenUpstream("127.0.0.1:0", handler)
+		if (err == nil) {
+			t.Cleanup(stop)
+			return address
+		}
+	}
+	t.Fatal("Unable to listen")
+	return ""
+}
+
+// Serve both UDP + TCP on the given address, until stopped
+func listenUpstream(address string,
+	handler upstreamHandler) (string, func(), error) {
+	listener, err := net.Listen("tcp", address)
+	if (err != nil) {
+		return "", nil, err
+	}
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+	if (err != nil) {
+		listener.Close()
+		return "", nil, err
+	}
+	stop := func() {
+		listener.Close()
+		conn.Close()
+	}
+
+	reply := func(requestBytes []byte, tcp bool) []byte {
+		request := dns.Message{}
+		_, err := request.Unpack(requestBytes)
+		if (err != nil) {
+			return nil
+		}
+		replyBytes, _ := handler(request, tcp).Pack()
+		return replyBytes
+	}
+
+	go func() {
+		buffer := make([]byte, TCPMaxMessageSize)
+		for {
+			length, address, err := conn.ReadFrom(buffer)
+			if (err != nil) {
+				return
+			}
+			conn.WriteTo(reply(buffer[:length], false), address)
+		}
+	}()
+
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if (err != nil) {
+				return
+			}
+			requestBytes, err := ReadTCPMessage(client)
+			if (err == nil) {
+				WriteTCPMessage(client, reply(requestBytes, true))
+			}
+			client.Close()
+		}
+	}()
+
+	return listener.Addr().String(), stop, nil
+}
+
+// Fake upstream server that never replies
+func startSilentUpstream(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.LocalAddr().String()
+}
+
+func newClient(servers ...string) *Client {
+	return &Client{
+		Servers:	servers,
+		Timeout:	time.Second,
+	}
+}
+
+func newQuery() *dns.Message {
+	query := dns.NewQuery("a.com", dns.RecordTypeA)
+	return &query
+}
+
+func newAnswer(request dns.Message) dns.Message {
+	reply := newReply(request)
+	reply.AddAnswer(dns.ResourceRecord{
+		Name:	request.Questions[0].Name,
+		Type:	dns.RecordTypeA,
+		Class:	dns.RecordClassIN,
+		Data:	&dns.RDataA{ Address: net.ParseIP("192.0.2.1").To4() },
+	})
+	return reply
+}
+
+func newReply(request dns.Message) dns.Message {
+	reply := request
+	reply.Header.Flags |= dns.MessageHeaderFlagResponse
+	reply.EDNS = nil
+	return reply
+}
+
+
+//
+// Validate the TCP length-prefix framing against a loopback listener
+//
+func TestTCPExchange(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	defer listener.Close()
+
+	// Trivial upstream server: echo each request back as the reply
+	go func() {
+		conn, err := listener.Accept()
+		if (err != nil) {
+			return
+		}
+		defer conn.Close()
+		request, err := ReadTCPMessage(conn)
+		if (err == nil) {
+			WriteTCPMessage(conn, request)
+		}
+	}()
+
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	requestBytes, _ := request.Pack()
+
+	replyBytes, err := exchangeTCP(context.Background(),
+		listener.Addr().String(), requestBytes, UDPMaxMessageSize)
+	if (err != nil) {
+		t.Fatal("Exchange error: ", err)
+	}
+	if !bytes.Equal(requestBytes, replyBytes) {
+		t.Error("Unexpected reply bytes: ", replyBytes)
+	}
+}
+
+
+//
+// Validate the TCP fallback when the UDP reply is truncated
+//
+func TestTruncationFallback(t *testing.T) {
+	client := newClient(startUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			if (!tcp) {
+				reply := newReply(request)
+				reply.Header.Flags |= dns.MessageHeaderFlagTruncation
+				return reply
+			}
+			return newAnswer(request)
+		}))
+
+	// Default transport retries over TCP
+	reply, err := client.Exchange(context.Background(), newQuery())
+	if (err != nil) {
+		t.Fatal("Exchange error: ", err)
+	}
+	if (len(reply.Answers) != 1) {
+		t.Error("Expected the complete TCP reply: ", reply)
+	}
+
+	// UDP-only transport reports the truncation instead
+	client.Transport = TransportUDP
+	_, err = client.Exchange(context.Background(), newQuery())
+	if (err != dns.ErrTruncated) {
+		t.Error("Expected truncation error: ", err)
+	}
+}
+
+
+//
+// Validate the reporting of error response codes
+//
+func TestRcodeError(t *testing.T) {
+	client := newClient(startUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			reply := newReply(request)
+			reply.Header.Flags |= dns.RcodeNameError
+			return reply
+		}))
+
+	reply, err := client.Exchange(context.Background(), newQuery())
+	var rcodeErr *dns.RcodeError
+	if (!errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeNameError) {
+		t.Error("Expected NXDOMAIN: ", err)
+	}
+	if (len(reply.Questions) != 1) {
+		t.Error("Expected the reply along with the error: ", reply)
+	}
+}
+
+
+//
+// Validate failover to the next server on SERVFAIL, REFUSED and timeouts
+//
+func TestServerFailover(t *testing.T) {
+	failing := func(rcode uint16) string {
+		return startUpstream(t,
+			func(request dns.Message, tcp bool) dns.Message {
+				reply := newReply(request)
+				reply.Header.Flags |= rcode
+				return reply
+			})
+	}
+	working := startUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			return newAnswer(request)
+		})
+
+	client := newClient(failing(dns.RcodeServerFailure),
+		failing(dns.RcodeRefused), startSilentUpstream(t), working)
+	client.Timeout = 100 * time.Millisecond
+
+	reply, err := client.Exchange(context.Background(), newQuery())
+	if (err != nil) {
+		t.Fatal("Exchange error: ", err)
+	}
+	if (len(reply.Answers) != 1) {
+		t.Error("Expected the reply from the working server: ", reply)
+	}
+}
+
+
+//
+// Validate the retry limit when every server fails
+//
+func TestRetries(t *testing.T) {
+	var attempts int32
+	client := newClient(startUpstream(t,
+		func(request dns.Message, tcp bool) dns.Message {
+			atomic.AddInt32(&attempts, 1)
+			reply := newReply(request)
+			reply.Header.Flags |= dns.RcodeServerFailure
+			return reply
+		}))
+	client.Retries = 2
+	client.Backoff = time.Millisecond
+
+	_, err := client.Exchange(context.Background(), newQuery())
+	var rcodeErr *dns.RcodeError
+	if (!errors.As(err, &rcodeErr) || rcodeErr.Rcode != dns.RcodeServerFailure) {
+		t.Error("Expected SERVFAIL: ", err)
+	}
+	if (atomic.LoadInt32(&attempts) != 3) {
+		t.Error("Unexpected attempt count: ", attempts)
+	}
+}
+
+
+//
+// Validate cancellation via the context
+//
+func TestCancellation(t *testing.T) {
+	client := newClient(startSilentUpstream(t))
+	client.Timeout = 10 * time.Second
+	client.Retries = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50 * time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Exchange(ctx, newQuery())
+	if (err != context.Canceled) {
+		t.Error("Expected cancellation: ", err)
+	}
+	if (time.Since(start) > 5 * time.Second) {
+		t.Error("Cancellation took too long: ", time.Since(start))
+	}
+}
+
+
+//
+// Validate rotation of the starting server across requests
+//
+func TestRotate(t *testing.T) {
+	var counts [2]int32
+	counting := func(i int) string {
+		return startUpstream(t,
+			func(request dns.Message, tcp bool) dns.Message {
+				atomic.AddInt32(&counts[i], 1)
+				return newAnswer(request)
+			})
+	}
+
+	client := newClient(counting(0), counting(1))
+	client.Rotate = true
+	for i := 0; i < 4; i++ {
+		_, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+	}
+	if (atomic.LoadInt32(&counts[0]) != 2 || atomic.LoadInt32(&counts[1]) != 2) {
+		t.Error("Unexpected distribution: ", counts)
+	}
+}
+
+
+//
+// Validate signed requests, and the verification of signed replies
+//
+func TestTSIGExchange(t *testing.T) {
+	key, _ := dns.ParseTSIGKey("key.example:hmac-sha512:c2VjcmV0IGtleSBieXRlcw==")
+	testCases := []struct{
+		sign		bool
+		tsigError	uint16
+		err			error
+	}{
+		{ true, 0, nil },
+		{ true, dns.TSIGErrorBadTime, dns.ErrBadTime },
+		{ true, dns.TSIGErrorBadSig, dns.ErrBadSig },
+		{ false, 0, dns.ErrTSIGMissing },
+	}
+
+	for i, test := range testCases {
+		client := newClient(startUpstream(t,
+			func(request dns.Message, tcp bool) dns.Message {
+				reply := newAnswer(request)
+				reply.TSIG = nil
+				if (!test.sign || request.TSIG == nil) {
+					return reply
+				}
+				requestMAC := request.TSIG.Data.(*dns.RDataTSIG).MAC
+				key.Sign(&reply, requestMAC, time.Now())
+				if (test.tsigError != 0) {
+					reply.Header.Flags |= dns.RcodeNotAuthoritative
+					reply.TSIG.Data.(*dns.RDataTSIG).Error = test.tsigError
+				}
+				return reply
+			}))
+		client.TSIG = key
+		_, err := client.Exchange(context.Background(), newQuery())
+		if (!errors.Is(err, test.err) || (test.err == nil && err != nil)) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		}
+	}
+}
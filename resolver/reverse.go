@@ -0,0 +1,156 @@
+//
+// Reverse lookups.  Maps IP addresses to names via PTR records in the
+// in-addr.arpa (RFC 1035, 3.5) and ip6.arpa (RFC 3596, 2.5) trees, and checks
+// that each name maps back to the same address (forward-confirmed reverse
+// DNS, FCrDNS).
+//
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"ddnsr/dns"
+)
+
+
+var ErrInvalidAddress	= errors.New("Invalid IP address or prefix")
+var ErrSweepTooLarge	= errors.New("Address prefix too large to sweep")
+
+const MaxSweepBits		= 12 // At most 4096 addresses per prefix
+
+
+// Resolve a single name + type, following any aliases
+type LookupFunc func(ctx context.Context, name string,
+	rtype uint16) (*Chain, error)
+
+
+// PTR name for an IP address
+func ReverseName(address net.IP) string {
+	if ipv4 := address.To4(); ipv4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa",
+			ipv4[3], ipv4[2], ipv4[1], ipv4[0])
+	}
+
+	// One label per nibble, least significant first
+	var builder strings.Builder
+	ipv6 := address.To16()
+	for i := len(ipv6) - 1; i >= 0; i-- {
+		fmt.Fprintf(&builder, "%x.%x.", ipv6[i] & 0x0F, ipv6[i] >> 4)
+	}
+	builder.WriteString("ip6.arpa")
+	return builder.String()
+}
+
+// Individual addresses for either a single address, or a CIDR prefix
+func ReverseAddresses(prefix string) ([]net.IP, error) {
+	if (!strings.Contains(prefix, "/")) {
+		address := net.ParseIP(prefix)
+		if (address == nil) {
+			return nil, ErrInvalidAddress
+		}
+		return []net.IP{ address }, nil
+	}
+
+	_, network, err := net.ParseCIDR(prefix)
+	if (err != nil) {
+		return nil, ErrInvalidAddress
+	}
+	ones, bits := network.Mask.Size()
+	if (bits - ones > MaxSweepBits) {
+		return nil, ErrSweepTooLarge
+	}
+
+	var addresses []net.IP
+	address := network.IP
+	for i := 0; i < 1 << (bits - ones); i++ {
+		addresses = append(addresses, address)
+
+		// Increment the address, with carry
+		next := append(net.IP{}, address...)
+		for j := len(next) - 1; j >= 0; j-- {
+			next[j]++
+			if (next[j] != 0) {
+				break
+			}
+		}
+		address = next
+	}
+	return addresses, nil
+}
+
+
+//
+// Result of a reverse lookup: each name for the address, and whether that
+// name maps back to the address
+//
+type PTRName struct {
+	Name		string
+	Confirmed	bool
+}
+
+type ReverseResult struct {
+	Address		net.IP
+	Names		[]PTRName
+}
+
+func (result ReverseResult) String() string {
+	var lines []string
+	for _, name := range result.Names {
+		confirmed := "not forward-confirmed"
+		if (name.Confirmed) {
+			confirmed = "forward-confirmed"
+		}
+		lines = append(lines, fmt.Sprintf("%s -> %s (%s)", result.Address,
+			name.Name, confirmed))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Look up the PTR names for the address, then confirm each name via its
+// A or AAAA records
+func ReverseLookup(ctx context.Context, lookup LookupFunc,
+	address net.IP) (*ReverseResult, error) {
+	chain, err := lookup(ctx, ReverseName(address), dns.RecordTypePTR)
+	if (err != nil) {
+		return nil, err
+	}
+
+	rtype := uint16(dns.RecordTypeAAAA)
+	if (address.To4() != nil) {
+		rtype = dns.RecordTypeA
+	}
+
+	result := &ReverseResult{ Address: address }
+	for _, rr := range chain.Answers {
+		ptr, ok := rr.Data.(*dns.RDataPTR)
+		if (!ok) {
+			continue
+		}
+		name := PTRName{ Name: ptr.Host }
+
+		forward, err := lookup(ctx, ptr.Host, rtype)
+		if (ctx.Err() != nil) {
+			return nil, ctx.Err()
+		}
+		if (err == nil) {
+			for _, rr := range forward.Answers {
+				switch data := rr.Data.(type) {
+					case *dns.RDataA:
+						name.Confirmed = (name.Confirmed ||
+							data.Address.Equal(address))
+					case *dns.RDataAAAA:
+						name.Confirmed = (name.Confirmed ||
+							data.Address.Equal(address))
+				}
+			}
+		}
+		result.Names = append(result.Names, name)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,122 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"ddnsr/dns"
+	)
+
+//
+// Validate the in-addr.arpa + ip6.arpa names
+//
+func TestReverseName(t *testing.T) {
+	testCases := []struct{
+		address		string
+		name		string
+	}{
+		{ "192.0.2.1",	"1.2.0.192.in-addr.arpa" },
+		{ "::ffff:10.1.2.3", "3.2.1.10.in-addr.arpa" },
+		{ "2001:db8::567:89ab",
+			"b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa" },
+	}
+
+	for _, test := range testCases {
+		name := ReverseName(net.ParseIP(test.address))
+		if (name != test.name) {
+			t.Errorf("Unexpected name for %s: %s", test.address, name)
+		}
+	}
+}
+
+
+//
+// Validate the expansion of address prefixes
+//
+func TestReverseAddresses(t *testing.T) {
+	testCases := []struct{
+		prefix		string
+		count		int
+		first		string
+		last		string
+		err			error
+	}{
+		{ "192.0.2.7", 1, "192.0.2.7", "192.0.2.7", nil },
+		{ "192.0.2.77/24", 256, "192.0.2.0", "192.0.2.255", nil },
+		{ "10.0.0.0/23", 512, "10.0.0.0", "10.0.1.255", nil },
+		{ "2001:db8::/126", 4, "2001:db8::", "2001:db8::3", nil },
+		{ "10.0.0.0/16", 0, "", "", ErrSweepTooLarge },
+		{ "2001:db8::/64", 0, "", "", ErrSweepTooLarge },
+		{ "not-an-address", 0, "", "", ErrInvalidAddress },
+		{ "192.0.2.0/33", 0, "", "", ErrInvalidAddress },
+	}
+
+	for _, test := range testCases {
+		addresses, err := ReverseAddresses(test.prefix)
+		if (err != test.err) {
+			t.Errorf("%s: unexpected error: %v", test.prefix, err)
+			continue
+		}
+		if (len(addresses) != test.count) {
+			t.Errorf("%s: unexpected count: %d", test.prefix, len(addresses))
+			continue
+		}
+		if (test.count > 0 &&
+			(!addresses[0].Equal(net.ParseIP(test.first)) ||
+			!addresses[test.count - 1].Equal(net.ParseIP(test.last)))) {
+			t.Errorf("%s: unexpected range: %s - %s", test.prefix,
+				addresses[0], addresses[test.count - 1])
+		}
+	}
+}
+
+
+//
+// Validate forward-confirmation of the PTR names
+//
+func TestReverseLookup(t *testing.T) {
+	ptr := func(name string, host string) dns.ResourceRecord {
+		return dns.ResourceRecord{
+			Name:	name,
+			Type:	dns.RecordTypePTR,
+			Class:	dns.RecordClassIN,
+			TTL:	300,
+			Data:	&dns.RDataPTR{ Host: host },
+		}
+	}
+	reverse := ReverseName(net.ParseIP("192.0.2.1"))
+	answers := map[string]fakeAnswer{
+		reverse: { records: []dns.ResourceRecord{
+			ptr(reverse, "host.example.com"),
+			ptr(reverse, "alias.example.com"),
+			ptr(reverse, "www.example.com"),
+		} },
+		"host.example.com": { records: []dns.ResourceRecord{
+			address("host.example.com", "192.0.2.1"),
+		} },
+		"alias.example.com": { records: []dns.ResourceRecord{
+			address("alias.example.com", "192.0.2.99"),
+		} },
+		"www.example.com": { records: []dns.ResourceRecord{
+			cname("www.example.com", "host.example.com"),
+		} },
+	}
+	lookup := func(ctx context.Context, name string,
+		rtype uint16) (*Chain, error) {
+		request := dns.NewQuery(name, rtype)
+		return FollowChain(ctx, fakeExchange(answers), &request, 0)
+	}
+
+	result, err := ReverseLookup(context.Background(), lookup,
+		net.ParseIP("192.0.2.1"))
+	if (err != nil) {
+		t.Fatal("Lookup error: ", err)
+	}
+	expected := "192.0.2.1 -> host.example.com (forward-confirmed)\n" +
+		"192.0.2.1 -> alias.example.com (not forward-confirmed)\n" +
+		"192.0.2.1 -> www.example.com (forward-confirmed)"
+	if (result.String() != expected) {
+		t.Error("Unexpected result: ", result)
+	}
+}
@@ -0,0 +1,150 @@
+//
+// DNS-over-TLS transport (RFC 7858).  Requests use the same 2-byte length
+// framing as TCP, over a TLS connection that is kept open and reused for
+// subsequent requests to the same server.
+//
+
+package resolver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+
+var ErrCABundle		= errors.New("No certificates in CA bundle")
+var ErrInvalidPin	= errors.New("Invalid SPKI pin, expected base64 SHA-256 digest")
+var ErrPinMismatch	= errors.New("Server certificate does not match any SPKI pin")
+
+
+//
+// TLS client configuration.  The server is authenticated against the given
+// name, or against its IP address if no name is given (RFC 8310, 8.1).  An
+// empty caFile means the system roots.  Pins are base64-encoded SHA-256
+// digests of the certificate SubjectPublicKeyInfo (RFC 7858, 4.2); if any
+// are given without a CA bundle, then the pin alone authenticates the server
+//
+func NewTLSConfig(serverName string, caFile string,
+	pins []string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:	serverName,
+		MinVersion:	tls.VersionTLS12, // RFC 8310, 9
+	}
+
+	if (caFile != "") {
+		pemBytes, err := os.ReadFile(caFile)
+		if (err != nil) {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if (!config.RootCAs.AppendCertsFromPEM(pemBytes)) {
+			return nil, ErrCABundle
+		}
+	}
+
+	if (len(pins) > 0) {
+		pinned := map[[sha256.Size]byte]bool{}
+		for _, pin := range pins {
+			digest, err := base64.StdEncoding.DecodeString(pin)
+			if (err != nil || len(digest) != sha256.Size) {
+				return nil, ErrInvalidPin
+			}
+			pinned[*(*[sha256.Size]byte)(digest)] = true
+		}
+
+		// Any certificate in the chain may match the pin.  The chain itself
+		// is still verified if a CA bundle was given
+		config.InsecureSkipVerify = (caFile == "")
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				if (pinned[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]) {
+					return nil
+				}
+			}
+			return ErrPinMismatch
+		}
+	}
+
+	return config, nil
+}
+
+
+//
+// Persistent connection to a single DoT server.  Requests on the connection
+// are serialized, so each reply always matches the preceding request
+//
+type tlsConn struct {
+	sync.Mutex
+	conn	net.Conn
+}
+
+func (client *Client) tlsConnection(address string) *tlsConn {
+	client.connsLock.Lock()
+	defer client.connsLock.Unlock()
+
+	if (client.conns == nil) {
+		client.conns = map[string]*tlsConn{}
+	}
+	upstream, ok := client.conns[address]
+	if (!ok) {
+		upstream = &tlsConn{}
+		client.conns[address] = upstream
+	}
+	return upstream
+}
+
+func (client *Client) exchangeTLS(ctx context.Context, address string,
+	requestBytes []byte, maxReplySize int) ([]byte, error) {
+	upstream := client.tlsConnection(address)
+	upstream.Lock()
+	defer upstream.Unlock()
+
+	for {
+		// The server may have closed an idle connection in the meantime, so
+		// a failure on a reused connection warrants one more attempt on a
+		// fresh connection
+		reused := (upstream.conn != nil)
+		if (!reused) {
+			dialer := tls.Dialer{ Config: client.TLSConfig }
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if (err != nil) {
+				return nil, err
+			}
+			upstream.conn = conn
+		}
+
+		replyBytes, err := exchangeStream(ctx, upstream.conn, requestBytes)
+		if (err == nil) {
+			return replyBytes, nil
+		}
+		upstream.conn.Close()
+		upstream.conn = nil
+		if (!reused || ctx.Err() != nil) {
+			return nil, err
+		}
+	}
+}
+
+// Send a single length-prefixed request over an open stream, and wait for
+// the reply.  The stream remains usable for subsequent requests
+func exchangeStream(ctx context.Context, conn net.Conn,
+	requestBytes []byte) ([]byte, error) {
+	release := watchContext(ctx, conn)
+	err := WriteTCPMessage(conn, requestBytes)
+	var replyBytes []byte
+	if (err == nil) {
+		replyBytes, err = ReadTCPMessage(conn)
+	}
+	release()
+	conn.SetDeadline(time.Time{})
+
+	return replyBytes, err
+}
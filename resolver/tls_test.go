@@ -0,0 +1,232 @@
+package resolver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Self-signed certificate for "dns.test" + 127.0.0.1
+//
+func newCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if (err != nil) {
+		t.Fatal("Unable to generate key: ", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:	big.NewInt(1),
+		Subject:		pkix.Name{ CommonName: "dns.test" },
+		DNSNames:		[]string{ "dns.test" },
+		IPAddresses:	[]net.IP{ net.ParseIP("127.0.0.1") },
+		NotBefore:		time.Now().Add(-time.Hour),
+		NotAfter:		time.Now().Add(time.Hour),
+		KeyUsage:		x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:	[]x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth },
+		IsCA:			true,
+		BasicConstraintsValid:	true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	if (err != nil) {
+		t.Fatal("Unable to create certificate: ", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	return tls.Certificate{
+		Certificate:	[][]byte{ der },
+		PrivateKey:		key,
+		Leaf:			leaf,
+	}
+}
+
+// Write the certificate as a PEM bundle
+func writeCABundle(t *testing.T, cert tls.Certificate) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{
+		Type:	"CERTIFICATE",
+		Bytes:	cert.Certificate[0],
+	})
+	err := os.WriteFile(path, pemBytes, 0600)
+	if (err != nil) {
+		t.Fatal("Unable to write CA bundle: ", err)
+	}
+	return path
+}
+
+func spkiPin(cert tls.Certificate) string {
+	digest := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+//
+// Fake DoT server.  Answers any number of requests per connection, unless
+// oneShot is set, and counts the accepted connections
+//
+func startTLSUpstream(t *testing.T, cert tls.Certificate, oneShot bool,
+	accepts *int32) string {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0",
+		&tls.Config{ Certificates: []tls.Certificate{ cert } })
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		for {
+			requestBytes, err := ReadTCPMessage(conn)
+			if (err != nil) {
+				return
+			}
+			request := dns.Message{}
+			_, err = request.Unpack(requestBytes)
+			if (err != nil) {
+				return
+			}
+			replyBytes, _ := newAnswer(request).Pack()
+			WriteTCPMessage(conn, replyBytes)
+			if (oneShot) {
+				return
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if (err != nil) {
+				return
+			}
+			atomic.AddInt32(accepts, 1)
+			go serve(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func newTLSClient(t *testing.T, server string, serverName string,
+	caFile string, pins ...string) *Client {
+	config, err := NewTLSConfig(serverName, caFile, pins)
+	if (err != nil) {
+		t.Fatal("Unable to configure TLS: ", err)
+	}
+	client := newClient(server)
+	client.Transport = TransportTLS
+	client.TLSConfig = config
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+
+//
+// Validate several requests over a single, reused DoT connection
+//
+func TestTLSExchange(t *testing.T) {
+	var accepts int32
+	cert := newCertificate(t)
+	server := startTLSUpstream(t, cert, false, &accepts)
+	client := newTLSClient(t, server, "dns.test", writeCABundle(t, cert))
+
+	for i := 0; i < 3; i++ {
+		reply, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (len(reply.Answers) != 1) {
+			t.Error("Unexpected reply: ", reply)
+		}
+	}
+	if (atomic.LoadInt32(&accepts) != 1) {
+		t.Error("Expected a single connection: ", accepts)
+	}
+}
+
+
+//
+// Validate reconnection once the server closes an idle connection
+//
+func TestTLSReconnect(t *testing.T) {
+	var accepts int32
+	cert := newCertificate(t)
+	server := startTLSUpstream(t, cert, true, &accepts)
+	client := newTLSClient(t, server, "", writeCABundle(t, cert))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Exchange(context.Background(), newQuery())
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+	}
+	if (atomic.LoadInt32(&accepts) != 3) {
+		t.Error("Expected a connection per request: ", accepts)
+	}
+}
+
+
+//
+// Validate server authentication via the CA bundle, name and SPKI pins
+//
+func TestTLSAuthentication(t *testing.T) {
+	var accepts int32
+	cert := newCertificate(t)
+	other := newCertificate(t)
+	server := startTLSUpstream(t, cert, false, &accepts)
+	caFile := writeCABundle(t, cert)
+
+	tests := []struct {
+		name		string
+		serverName	string
+		caFile		string
+		pins		[]string
+		ok			bool
+	}{
+		{ "CA + name", "dns.test", caFile, nil, true },
+		{ "CA + address", "", caFile, nil, true },
+		{ "CA, wrong name", "other.test", caFile, nil, false },
+		{ "untrusted", "dns.test", "", nil, false },
+		{ "pin only", "", "", []string{ spkiPin(cert) }, true },
+		{ "CA + pin", "dns.test", caFile, []string{ spkiPin(cert) }, true },
+		{ "wrong pin", "", "", []string{ spkiPin(other) }, false },
+		{ "any pin", "", "",
+			[]string{ spkiPin(other), spkiPin(cert) }, true },
+	}
+
+	for _, test := range tests {
+		client := newTLSClient(t, server, test.serverName, test.caFile,
+			test.pins...)
+		_, err := client.Exchange(context.Background(), newQuery())
+		if (test.ok && err != nil) {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if (!test.ok && err == nil) {
+			t.Errorf("%s: expected authentication failure", test.name)
+		}
+		if (test.name == "wrong pin" && !errors.Is(err, ErrPinMismatch)) {
+			t.Errorf("%s: expected pin mismatch: %s", test.name, err)
+		}
+	}
+
+	_, err := NewTLSConfig("", "", []string{ "not-a-pin" })
+	if (err != ErrInvalidPin) {
+		t.Error("Expected invalid pin: ", err)
+	}
+}
@@ -0,0 +1,371 @@
+//
+// Zone transfers.  AXFR (RFC 5936) streams the complete zone over TCP, as a
+// sequence of messages bracketed by the SOA; IXFR (RFC 1995) streams only the
+// differences since a given serial, which apply to an earlier snapshot of the
+// zone.
+//
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"ddnsr/dns"
+)
+
+
+var ErrTransferFormat	= errors.New("Malformed zone transfer")
+var ErrTransferType		= errors.New("Zone transfers are AXFR or IXFR only")
+var ErrSnapshotMismatch	= errors.New("IXFR differences do not apply to the snapshot")
+
+
+//
+// Changes from one version of the zone to the next
+//
+type Difference struct {
+	From		dns.ResourceRecord	// Old SOA
+	To			dns.ResourceRecord	// New SOA
+	Deleted		[]dns.ResourceRecord
+	Added		[]dns.ResourceRecord
+}
+
+//
+// Result of a transfer.  Either the complete zone, or for IXFR, possibly the
+// differences from the requested serial instead
+//
+type Transfer struct {
+	Zone			string
+	Records			[]dns.ResourceRecord	// Complete zone, SOA first
+	Differences		[]Difference			// Incremental, oldest first
+	UpToDate		bool					// IXFR serial is current
+	Messages		int
+}
+
+// The zone after the transfer, given the snapshot the IXFR serial refers to
+func (transfer *Transfer) Apply(snapshot []dns.ResourceRecord) ([]dns.ResourceRecord, error) {
+	if (transfer.UpToDate) {
+		return snapshot, nil
+	}
+	if (transfer.Differences == nil) {
+		return transfer.Records, nil
+	}
+
+	zone := append([]dns.ResourceRecord{}, snapshot...)
+	for _, difference := range transfer.Differences {
+		if (len(zone) == 0 || !SameRecord(zone[0], difference.From)) {
+			return nil, ErrSnapshotMismatch
+		}
+		zone = zone[1:]
+		for _, deleted := range difference.Deleted {
+			found := false
+			for i, rr := range zone {
+				if (SameRecord(rr, deleted)) {
+					zone = append(zone[:i], zone[i + 1:]...)
+					found = true
+					break
+				}
+			}
+			if (!found) {
+				return nil, ErrSnapshotMismatch
+			}
+		}
+		zone = append(append([]dns.ResourceRecord{ difference.To }, zone...),
+			difference.Added...)
+	}
+	return zone, nil
+}
+
+// Records with the same owner, type, class + RDATA, regardless of TTL
+func SameRecord(a dns.ResourceRecord, b dns.ResourceRecord) bool {
+	if (dns.CanonicalName(a.Name) != dns.CanonicalName(b.Name) ||
+		a.Type != b.Type || a.Class != b.Class) {
+		return false
+	}
+	return bytes.Equal(rdataBytes(a), rdataBytes(b))
+}
+
+func rdataBytes(rr dns.ResourceRecord) []byte {
+	if (rr.Data == nil) {
+		return rr.RData
+	}
+	buffer := new(bytes.Buffer)
+	rr.Data.Pack(buffer, nil)
+	return buffer.Bytes()
+}
+
+// Any SOA in the transfer must be a complete one, in the class of the zone,
+// e.g. not an UPDATE-style SOA with class ANY and no RDATA
+func validSOA(rr dns.ResourceRecord, class uint16) bool {
+	if (rr.Type != dns.RecordTypeSOA) {
+		return true
+	}
+	_, ok := rr.Data.(*dns.RDataSOA)
+	return (ok && rr.Class == class)
+}
+
+// Serial of an SOA already checked by validSOA
+func soaSerial(rr dns.ResourceRecord) uint32 {
+	return rr.Data.(*dns.RDataSOA).Serial
+}
+
+
+// Transfer the zone from the first server that allows it.  The serial only
+// applies to IXFR, as the version of the zone the client already has
+func (client *Client) Transfer(ctx context.Context, zone string, rtype uint16,
+	serial uint32) (*Transfer, error) {
+	if (rtype != dns.RecordTypeAXFR && rtype != dns.RecordTypeIXFR) {
+		return nil, ErrTransferType
+	}
+	if (len(client.Servers) == 0) {
+		return nil, ErrNoServers
+	}
+
+	request := dns.NewQuery(zone, rtype)
+	if (rtype == dns.RecordTypeIXFR) {
+		request.AddNameserver(dns.ResourceRecord{
+			Name:	zone,
+			Type:	dns.RecordTypeSOA,
+			Class:	dns.RecordClassIN,
+			Data:	&dns.RDataSOA{ Serial: serial },
+		})
+	}
+
+	var transfer *Transfer
+	var err error
+	for _, server := range client.Servers {
+		transfer, err = client.transferServer(ctx, server, &request, serial)
+		if (err == nil || ctx.Err() != nil) {
+			break
+		}
+	}
+	return transfer, err
+}
+
+// Stream the reply messages from a single server, until the transfer is
+// complete.  The timeout applies to each message in turn
+func (client *Client) transferServer(ctx context.Context, server string,
+	request *dns.Message, serial uint32) (*Transfer, error) {
+	conn, err := client.dialStream(ctx, server)
+	if (err != nil) {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := client.Timeout
+	if (timeout == 0) {
+		timeout = DefaultTimeout
+	}
+	requestBytes, requestMAC, err := client.pack(request)
+	if (err != nil) {
+		return nil, err
+	}
+	if (client.Dump != nil) {
+		client.Dump("Raw request bytes", requestBytes)
+	}
+	err = withinTimeout(ctx, conn, timeout, func() error {
+		return WriteTCPMessage(conn, requestBytes)
+	})
+	if (err != nil) {
+		return nil, err
+	}
+
+	zone := request.Questions[0].Name
+	incremental := (request.Questions[0].Type == dns.RecordTypeIXFR)
+	transfer := &Transfer{ Zone: zone }
+	var records []dns.ResourceRecord
+	var stream *dns.TSIGStream
+	if (client.TSIG != nil) {
+		stream = client.TSIG.NewStream(requestMAC)
+	}
+	for {
+		var replyBytes []byte
+		err := withinTimeout(ctx, conn, timeout, func() error {
+			var err error
+			replyBytes, err = ReadTCPMessage(conn)
+			return err
+		})
+		if (err != nil) {
+			return nil, err
+		}
+		if (client.Dump != nil) {
+			client.Dump("Raw reply bytes", replyBytes)
+		}
+		reply := &dns.Message{}
+		_, err = reply.Unpack(replyBytes)
+		if (err != nil) {
+			return nil, err
+		}
+		err = reply.Validate(*request)
+		if (err != nil) {
+			return nil, err
+		}
+		if (stream != nil) {
+			err = stream.Verify(replyBytes, time.Now())
+			if (err != nil) {
+				return nil, err
+			}
+		}
+		if (reply.Rcode() != dns.RcodeNoError) {
+			return nil, &dns.RcodeError{ Rcode: reply.Rcode() }
+		}
+		transfer.Messages++
+		for _, rr := range reply.Answers {
+			if (!validSOA(rr, request.Questions[0].Class)) {
+				return nil, ErrTransferFormat
+			}
+		}
+		records = append(records, reply.Answers...)
+
+		// The transfer must start with the SOA of the zone
+		if (len(records) == 0) {
+			return nil, ErrTransferFormat
+		}
+		if (records[0].Type != dns.RecordTypeSOA ||
+			dns.CanonicalName(records[0].Name) != dns.CanonicalName(zone)) {
+			return nil, ErrTransferFormat
+		}
+
+		// A lone SOA no newer than the IXFR serial means no changes
+		if (incremental && len(records) == 1 &&
+			!serialNewer(soaSerial(records[0]), serial)) {
+			err = transferSigned(stream)
+			if (err != nil) {
+				return nil, err
+			}
+			transfer.UpToDate = true
+			transfer.Records = records
+			return transfer, nil
+		}
+
+		complete, err := transferComplete(records, incremental)
+		if (err != nil) {
+			return nil, err
+		}
+		if (complete) {
+			break
+		}
+	}
+	err = transferSigned(stream)
+	if (err != nil) {
+		return nil, err
+	}
+
+	// Servers may answer IXFR with either the differences, or the complete
+	// zone in the same form as AXFR (RFC 1995, 4)
+	if (incremental && records[1].Type == dns.RecordTypeSOA) {
+		transfer.Differences, err = parseDifferences(records)
+		return transfer, err
+	}
+	transfer.Records = records[:len(records) - 1]
+	return transfer, nil
+}
+
+// The final message of a signed transfer must itself be signed, so that
+// nothing can be appended undetected (RFC 8945, 5.3.1)
+func transferSigned(stream *dns.TSIGStream) error {
+	if (stream != nil && !stream.Signed()) {
+		return dns.ErrTSIGMissing
+	}
+	return nil
+}
+
+// Read or write a single message on the stream, which must take no longer
+// than the timeout, nor outlast the context
+func withinTimeout(ctx context.Context, conn net.Conn, timeout time.Duration,
+	step func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer watchContext(ctx, conn)()
+	return step()
+}
+
+// Connection for the transfer, over TLS (RFC 9103) if so configured
+func (client *Client) dialStream(ctx context.Context,
+	server string) (net.Conn, error) {
+	if (client.Transport == TransportTLS) {
+		dialer := tls.Dialer{ Config: client.TLSConfig }
+		return dialer.DialContext(ctx, "tcp", serverAddress(server, DoTPort))
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", serverAddress(server, DNSPort))
+}
+
+// Serial number arithmetic (RFC 1982)
+func serialNewer(serial uint32, than uint32) bool {
+	return (serial != than && serial - than < 1 << 31)
+}
+
+// Whether the records so far form a complete transfer: either the complete
+// zone, ending with the SOA again, or sequences of differences, ending with
+// the new SOA.  Nothing may follow the final SOA
+func transferComplete(records []dns.ResourceRecord,
+	incremental bool) (bool, error) {
+	if (len(records) < 2) {
+		return false, nil
+	}
+	final := soaSerial(records[0])
+
+	// Complete zone, with the SOA only at either end
+	if (!incremental || records[1].Type != dns.RecordTypeSOA) {
+		for i, rr := range records[1:] {
+			if (rr.Type != dns.RecordTypeSOA) {
+				continue
+			}
+			if (i + 2 != len(records) || soaSerial(rr) != final) {
+				return false, ErrTransferFormat
+			}
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// Differences: old SOA, deletions, new SOA, additions, and so on, until
+	// the old SOA of the next sequence is the final SOA
+	i := 1
+	for (i < len(records)) {
+		if (soaSerial(records[i]) == final && i + 1 == len(records)) {
+			return true, nil
+		}
+		for half := 0; half < 2; half++ {
+			i++
+			for (i < len(records) && records[i].Type != dns.RecordTypeSOA) {
+				i++
+			}
+		}
+	}
+	return false, nil
+}
+
+// Split complete IXFR records into their sequences of differences
+func parseDifferences(records []dns.ResourceRecord) ([]Difference, error) {
+	var differences []Difference
+	deleting := false
+	for _, rr := range records[1:len(records) - 1] {
+		if (rr.Type == dns.RecordTypeSOA) {
+			if (!deleting) {
+				differences = append(differences, Difference{ From: rr })
+			} else {
+				differences[len(differences) - 1].To = rr
+			}
+			deleting = !deleting
+			continue
+		}
+		current := &differences[len(differences) - 1]
+		if (deleting) {
+			current.Deleted = append(current.Deleted, rr)
+		} else {
+			current.Added = append(current.Added, rr)
+		}
+	}
+	if (len(differences) == 0 || deleting ||
+		soaSerial(differences[len(differences) - 1].To) !=
+		soaSerial(records[0])) {
+		return nil, ErrTransferFormat
+	}
+	return differences, nil
+}
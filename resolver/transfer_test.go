@@ -0,0 +1,339 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	)
+
+//
+// Fake primary server, answering each transfer request over TCP with a
+// sequence of messages, each holding some of the records
+//
+func startPrimary(t *testing.T, rcode uint16,
+	messages ...[]dns.ResourceRecord) *Client {
+	return startSignedPrimary(t, nil, true, rcode, messages...)
+}
+
+// Primary that signs the first message with the key, and optionally the last,
+// but none in between
+func startSignedPrimary(t *testing.T, key *dns.TSIGKey, signLast bool,
+	rcode uint16, messages ...[]dns.ResourceRecord) *Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if (err != nil) {
+				return
+			}
+			requestBytes, err := ReadTCPMessage(conn)
+			request := dns.Message{}
+			if (err == nil) {
+				_, err = request.Unpack(requestBytes)
+			}
+			var stream *dns.TSIGStream
+			if (err == nil && key != nil && request.TSIG != nil) {
+				stream = key.NewStream(request.TSIG.Data.(*dns.RDataTSIG).MAC)
+			}
+			for i, records := range messages {
+				if (err != nil) {
+					break
+				}
+				reply := dns.NewReply(request, rcode)
+				for _, rr := range records {
+					reply.AddAnswer(rr)
+				}
+				replyBytes, _ := reply.Pack()
+				if (stream != nil &&
+					(i == 0 || (signLast && i == len(messages) - 1))) {
+					replyBytes, _ = stream.Sign(&reply, time.Now())
+				} else if (stream != nil) {
+					replyBytes, _ = stream.Skip(&reply)
+				}
+				err = WriteTCPMessage(conn, replyBytes)
+			}
+			conn.Close()
+		}
+	}()
+
+	return newClient(listener.Addr().String())
+}
+
+func serialSOA(serial uint32) dns.ResourceRecord {
+	rr := soa("example.com", 3600, 300)
+	rr.Data.(*dns.RDataSOA).Serial = serial
+	return rr
+}
+
+func transfer(client *Client, rtype uint16, serial uint32) (*Transfer, error) {
+	return client.Transfer(context.Background(), "example.com", rtype, serial)
+}
+
+func recordStrings(records []dns.ResourceRecord) []string {
+	var result []string
+	for _, rr := range records {
+		result = append(result, dns.ZoneString(rr))
+	}
+	return result
+}
+
+func sameRecords(a []dns.ResourceRecord, b []dns.ResourceRecord) bool {
+	if (len(a) != len(b)) {
+		return false
+	}
+	for i := range a {
+		if (!SameRecord(a[i], b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+
+//
+// Validate a complete zone, across several messages
+//
+func TestAXFR(t *testing.T) {
+	zone := []dns.ResourceRecord{
+		serialSOA(7),
+		address("example.com", "192.0.2.1"),
+		address("www.example.com", "192.0.2.2"),
+		address("mail.example.com", "192.0.2.3"),
+	}
+	client := startPrimary(t, dns.RcodeNoError,
+		zone[:2], zone[2:3], []dns.ResourceRecord{ zone[3], serialSOA(7) })
+
+	result, err := transfer(client, dns.RecordTypeAXFR, 0)
+	if (err != nil) {
+		t.Fatal("Transfer error: ", err)
+	}
+	if (!sameRecords(result.Records, zone) || result.Messages != 3) {
+		t.Error("Unexpected zone: ", recordStrings(result.Records),
+			result.Messages)
+	}
+	applied, err := result.Apply(nil)
+	if (err != nil || !sameRecords(applied, zone)) {
+		t.Error("Unexpected zone: ", recordStrings(applied), err)
+	}
+}
+
+
+//
+// Validate the rejection of incomplete or misplaced SOAs, and errors
+//
+func TestAXFRErrors(t *testing.T) {
+	a := address("example.com", "192.0.2.1")
+
+	// As for an UPDATE deletion: class ANY, and no RDATA at all
+	emptySOA := dns.ResourceRecord{
+		Name:	"example.com",
+		Type:	dns.RecordTypeSOA,
+		Class:	dns.RecordClassANY,
+	}
+	testCases := []struct{
+		messages	[][]dns.ResourceRecord
+		rcode		uint16
+		err			error
+	}{
+		{ [][]dns.ResourceRecord{ { a, serialSOA(1) } }, 0, ErrTransferFormat },
+		{ [][]dns.ResourceRecord{ { serialSOA(1), a, serialSOA(2) } }, 0,
+			ErrTransferFormat },
+		{ [][]dns.ResourceRecord{ { serialSOA(1), serialSOA(1), a } }, 0,
+			ErrTransferFormat },
+		{ [][]dns.ResourceRecord{ { serialSOA(1), a } }, 0, nil },
+		{ [][]dns.ResourceRecord{ { emptySOA, a } }, 0, ErrTransferFormat },
+		{ [][]dns.ResourceRecord{ { serialSOA(1), a, emptySOA } }, 0,
+			ErrTransferFormat },
+		{ [][]dns.ResourceRecord{ {} }, dns.RcodeRefused, nil },
+	}
+
+	for i, test := range testCases {
+		client := startPrimary(t, test.rcode, test.messages...)
+		_, err := transfer(client, dns.RecordTypeAXFR, 0)
+		var rcodeErr *dns.RcodeError
+		if (test.rcode != 0) {
+			if (!errors.As(err, &rcodeErr) || rcodeErr.Rcode != test.rcode) {
+				t.Errorf("%d: expected rcode %d: %v", i, test.rcode, err)
+			}
+		} else if (test.err != nil && err != test.err) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		} else if (err == nil) {
+			t.Errorf("%d: expected an error", i)
+		}
+	}
+}
+
+
+//
+// Validate incremental differences, applied to a snapshot
+//
+func TestIXFR(t *testing.T) {
+	a1 := address("a1.example.com", "192.0.2.1")
+	a2 := address("a2.example.com", "192.0.2.2")
+	a3 := address("a3.example.com", "192.0.2.3")
+	a4 := address("a4.example.com", "192.0.2.4")
+	snapshot := []dns.ResourceRecord{ serialSOA(1), a1, a2 }
+
+	client := startPrimary(t, dns.RcodeNoError,
+		[]dns.ResourceRecord{ serialSOA(3), serialSOA(1), a1, serialSOA(2) },
+		[]dns.ResourceRecord{ a3, serialSOA(2), a2, serialSOA(3), a4 },
+		[]dns.ResourceRecord{ serialSOA(3) })
+	result, err := transfer(client, dns.RecordTypeIXFR, 1)
+	if (err != nil) {
+		t.Fatal("Transfer error: ", err)
+	}
+	if (len(result.Differences) != 2 || result.Messages != 3) {
+		t.Fatal("Unexpected differences: ", result.Differences)
+	}
+	zone, err := result.Apply(snapshot)
+	expected := []dns.ResourceRecord{ serialSOA(3), a3, a4 }
+	if (err != nil || !sameRecords(zone, expected)) {
+		t.Error("Unexpected zone: ", recordStrings(zone), err)
+	}
+
+	// The differences must match the snapshot
+	_, err = result.Apply([]dns.ResourceRecord{ serialSOA(1), a2 })
+	if (err != ErrSnapshotMismatch) {
+		t.Error("Expected a snapshot mismatch: ", err)
+	}
+	_, err = result.Apply([]dns.ResourceRecord{ serialSOA(2), a2, a3 })
+	if (err != ErrSnapshotMismatch) {
+		t.Error("Expected a snapshot mismatch: ", err)
+	}
+
+	// No changes since the serial
+	client = startPrimary(t, dns.RcodeNoError,
+		[]dns.ResourceRecord{ serialSOA(1) })
+	result, err = transfer(client, dns.RecordTypeIXFR, 1)
+	if (err != nil || !result.UpToDate) {
+		t.Fatal("Expected no changes: ", err)
+	}
+	zone, _ = result.Apply(snapshot)
+	if (!sameRecords(zone, snapshot)) {
+		t.Error("Unexpected zone: ", recordStrings(zone))
+	}
+
+	// The complete zone instead of the differences
+	client = startPrimary(t, dns.RcodeNoError,
+		[]dns.ResourceRecord{ serialSOA(3), a3, a4, serialSOA(3) })
+	result, err = transfer(client, dns.RecordTypeIXFR, 1)
+	if (err != nil) {
+		t.Fatal("Transfer error: ", err)
+	}
+	zone, _ = result.Apply(snapshot)
+	if (!sameRecords(zone, expected)) {
+		t.Error("Unexpected zone: ", recordStrings(zone))
+	}
+}
+
+
+//
+// Validate the TSIG chaining across the messages of a signed transfer
+//
+func TestTransferTSIG(t *testing.T) {
+	key, _ := dns.ParseTSIGKey("xfr.example:hmac-sha256:c2VjcmV0IGtleSBieXRlcw==")
+	wrongKey, _ := dns.ParseTSIGKey("xfr.example:hmac-sha256:d3Jvbmcga2V5")
+	zone := [][]dns.ResourceRecord{
+		{ serialSOA(7), address("example.com", "192.0.2.1") },
+		{ address("www.example.com", "192.0.2.2") },
+		{ address("mail.example.com", "192.0.2.3") },
+		{ serialSOA(7) },
+	}
+	testCases := []struct{
+		clientKey	*dns.TSIGKey
+		signLast	bool
+		err			error
+	}{
+		{ key, true, nil },
+		{ wrongKey, true, dns.ErrBadSig },
+		{ key, false, dns.ErrTSIGMissing },
+	}
+
+	for i, test := range testCases {
+		client := startSignedPrimary(t, key, test.signLast, dns.RcodeNoError,
+			zone...)
+		client.TSIG = test.clientKey
+		result, err := transfer(client, dns.RecordTypeAXFR, 0)
+		if (err != test.err) {
+			t.Errorf("%d: unexpected error: %v", i, err)
+		} else if (err == nil && len(result.Records) != 4) {
+			t.Errorf("%d: unexpected zone: %v", i, recordStrings(result.Records))
+		}
+	}
+
+	// Unsigned transfers fail verification too
+	client := startPrimary(t, dns.RcodeNoError, zone...)
+	client.TSIG = key
+	_, err := transfer(client, dns.RecordTypeAXFR, 0)
+	if (err != dns.ErrTSIGMissing) {
+		t.Error("Expected a missing signature: ", err)
+	}
+}
+
+
+//
+// Validate that cancelling the context ends a stalled transfer at once, rather
+// than after the per-message timeout
+//
+func TestTransferCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	stalled := make(chan struct{})
+	t.Cleanup(func() {
+		close(stalled)
+		listener.Close()
+	})
+
+	// Primary that sends the first message of the zone, then nothing more
+	go func() {
+		conn, err := listener.Accept()
+		if (err != nil) {
+			return
+		}
+		defer conn.Close()
+		requestBytes, err := ReadTCPMessage(conn)
+		request := dns.Message{}
+		if (err == nil) {
+			_, err = request.Unpack(requestBytes)
+		}
+		if (err != nil) {
+			return
+		}
+		reply := dns.NewReply(request, dns.RcodeNoError)
+		reply.AddAnswer(serialSOA(1))
+		replyBytes, _ := reply.Pack()
+		WriteTCPMessage(conn, replyBytes)
+		<-stalled
+	}()
+
+	// Cancel as soon as the first message arrives, and give the cancel time
+	// to take effect before the next read
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := newClient(listener.Addr().String())
+	client.Timeout = 5 * time.Second
+	client.Dump = func(header string, rawBytes []byte) {
+		if (header == "Raw reply bytes") {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	start := time.Now()
+	_, err = client.Transfer(ctx, "example.com", dns.RecordTypeAXFR, 0)
+	if (err == nil || time.Since(start) > time.Second) {
+		t.Error("Expected the transfer to end with the context: ", err,
+			time.Since(start))
+	}
+}
@@ -0,0 +1,64 @@
+//
+// Dynamic updates (RFC 2136).  Updates must reach the primary server of the
+// zone, rather than a recursive resolver, so locate the primary via the SOA
+// of the zone when no server is given.
+//
+
+package resolver
+
+import (
+	"context"
+	"errors"
+
+	"ddnsr/dns"
+)
+
+
+var ErrNoPrimary	= errors.New("Unable to locate the primary server of the zone")
+
+
+// Addresses of the primary server of the zone, as named by the MNAME field
+// of its SOA (RFC 2136, 4)
+func PrimaryServers(ctx context.Context, lookup LookupFunc,
+	zone string) ([]string, error) {
+	chain, err := lookup(ctx, zone, dns.RecordTypeSOA)
+	if (err != nil) {
+		return nil, err
+	}
+	primary := ""
+	for _, rr := range chain.Answers {
+		soa, ok := rr.Data.(*dns.RDataSOA)
+		if (ok && dns.CanonicalName(rr.Name) == dns.CanonicalName(zone)) {
+			primary = soa.MName
+		}
+	}
+	if (primary == "") {
+		return nil, ErrNoPrimary
+	}
+
+	var servers []string
+	for _, rtype := range []uint16{ dns.RecordTypeA, dns.RecordTypeAAAA } {
+		chain, err := lookup(ctx, primary, rtype)
+		if (ctx.Err() != nil) {
+			return nil, ctx.Err()
+		}
+		if (err != nil) {
+			continue
+		}
+		for _, rr := range chain.Answers {
+			if (rr.Type != rtype) {
+				continue
+			}
+			switch data := rr.Data.(type) {
+				case *dns.RDataA:
+					servers = append(servers, data.Address.String())
+				case *dns.RDataAAAA:
+					servers = append(servers, data.Address.String())
+			}
+		}
+	}
+	if (len(servers) == 0) {
+		return nil, ErrNoPrimary
+	}
+	return servers, nil
+}
@@ -0,0 +1,48 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"ddnsr/dns"
+	)
+
+//
+// Validate locating the primary server via the SOA MNAME
+//
+func TestPrimaryServers(t *testing.T) {
+	primary := soa("example.com", 3600, 300)
+	primary.Data.(*dns.RDataSOA).MName = "ns1.example.net"
+	answers := map[string]fakeAnswer{
+		"example.com": { records: []dns.ResourceRecord{ primary } },
+		"ns1.example.net": { records: []dns.ResourceRecord{
+			address("ns1.example.net", "192.0.2.53"),
+		} },
+		"nxdomain.example": { rcode: dns.RcodeNameError },
+		"orphan.example": { records: []dns.ResourceRecord{
+			soa("orphan.example", 3600, 300),
+		} },
+	}
+	lookup := func(ctx context.Context, name string,
+		rtype uint16) (*Chain, error) {
+		request := dns.NewQuery(name, rtype)
+		return FollowChain(ctx, fakeExchange(answers), &request, 0)
+	}
+
+	servers, err := PrimaryServers(context.Background(), lookup, "example.com")
+	if (err != nil || len(servers) != 1 || servers[0] != "192.0.2.53") {
+		t.Error("Unexpected primary: ", servers, err)
+	}
+	_, err = PrimaryServers(context.Background(), lookup, "www.example.com")
+	if (err != ErrNoPrimary) {
+		t.Error("Expected no primary: ", err)
+	}
+	_, err = PrimaryServers(context.Background(), lookup, "orphan.example")
+	if (err != ErrNoPrimary) {
+		t.Error("Expected no primary addresses: ", err)
+	}
+	_, err = PrimaryServers(context.Background(), lookup, "nxdomain.example")
+	if (err == nil) {
+		t.Error("Expected an error")
+	}
+}
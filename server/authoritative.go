@@ -0,0 +1,235 @@
+//
+// Authoritative handler.  Answers from the records of one or more zones, as
+// loaded from zone files, per RFC 1034, 4.3.2: answers with the AA flag,
+// referrals with glue for delegated subzones, NXDOMAIN vs NODATA with the SOA
+// (RFC 2308), wildcards (RFC 4592) and CNAMEs within the zone.
+//
+
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"ddnsr/dns"
+)
+
+
+var ErrNoSOA		= errors.New("Zone has no SOA record at its origin")
+var ErrOutOfZone	= errors.New("Record lies outside of the zone")
+
+// CNAMEs to follow within the zones, for a single answer
+const MaxCNAMEChain	= 8
+
+
+//
+// Records of a single zone, by owner name
+//
+type Zone struct {
+	Origin		string
+	SOA			dns.ResourceRecord
+
+	records		map[string][]dns.ResourceRecord	// By canonical owner name
+	exists		map[string]bool					// Owners + empty non-terminals
+}
+
+func NewZone(origin string, records []dns.ResourceRecord) (*Zone, error) {
+	zone := &Zone{
+		Origin:		dns.CanonicalName(origin),
+		records:	map[string][]dns.ResourceRecord{},
+		exists:		map[string]bool{},
+	}
+
+	for _, rr := range records {
+		name := dns.CanonicalName(rr.Name)
+		if (!dns.IsSubdomain(name, zone.Origin)) {
+			return nil, ErrOutOfZone
+		}
+		if (rr.Type == dns.RecordTypeSOA && name == zone.Origin) {
+			zone.SOA = rr
+		}
+		zone.records[name] = append(zone.records[name], rr)
+
+		// Every ancestor within the zone exists too, even without records of
+		// its own (RFC 4592, 2.2.2)
+		for (name != zone.Origin && !zone.exists[name]) {
+			zone.exists[name] = true
+			name = parentName(name)
+		}
+		zone.exists[zone.Origin] = true
+	}
+	if (zone.SOA.Data == nil) {
+		return nil, ErrNoSOA
+	}
+
+	return zone, nil
+}
+
+func parentName(name string) string {
+	index := strings.Index(name, ".")
+	if (index < 0) {
+		return ""
+	}
+	return name[index + 1:]
+}
+
+// Records of the type at the name, if any
+func (zone *Zone) lookup(name string, rtype uint16) []dns.ResourceRecord {
+	var records []dns.ResourceRecord
+	for _, rr := range zone.records[name] {
+		if (rr.Type == rtype || rtype == dns.RecordTypeALL) {
+			records = append(records, rr)
+		}
+	}
+	return records
+}
+
+// Closest delegation point at or above the name, if any, excluding the zone
+// apex itself
+func (zone *Zone) delegation(name string) string {
+	cut := ""
+	for (name != zone.Origin) {
+		if (len(zone.lookup(name, dns.RecordTypeNS)) > 0) {
+			cut = name
+		}
+		name = parentName(name)
+	}
+	return cut
+}
+
+// SOA for negative answers, with the TTL limited by the SOA minimum
+// (RFC 2308, 3)
+func (zone *Zone) negativeSOA() dns.ResourceRecord {
+	soa := zone.SOA
+	soa.TTL = soa.Data.(*dns.RDataSOA).NegativeTTL(soa.TTL)
+	return soa
+}
+
+
+//
+// Answer requests from the zones.  Names outside of all the zones are
+// refused, since this is not a recursive server
+//
+func Authoritative(zones ...*Zone) Handler {
+	// Prefer the closest enclosing zone, i.e. the longest origin
+	zones = append([]*Zone{}, zones...)
+	sort.Slice(zones, func(i, j int) bool {
+		return len(zones[i].Origin) > len(zones[j].Origin)
+	})
+
+	return func(ctx context.Context, request *dns.Message) *dns.Message {
+		question := request.Questions[0]
+		name := dns.CanonicalName(question.Name)
+		for _, zone := range zones {
+			if (dns.IsSubdomain(name, zone.Origin)) {
+				reply := dns.NewReply(*request, dns.RcodeNoError)
+				zone.answer(&reply, question)
+				return &reply
+			}
+		}
+
+		reply := dns.NewReply(*request, dns.RcodeRefused)
+		return &reply
+	}
+}
+
+// Fill in the reply to the question from the zone
+func (zone *Zone) answer(reply *dns.Message, question dns.Question) {
+	name := dns.CanonicalName(question.Name)
+	owner := question.Name
+	for chain := 0; ; chain++ {
+		// Delegated names are answered by a referral to the child zone,
+		// which is not authoritative
+		cut := zone.delegation(name)
+		if (cut == name && question.Type == dns.RecordTypeDS) {
+			// Except for DS records, which belong to the parent side of the
+			// delegation (RFC 4035, 3.1.4.1)
+			cut = zone.delegation(parentName(name))
+		}
+		if (cut != "") {
+			for _, rr := range zone.lookup(cut, dns.RecordTypeNS) {
+				reply.AddNameserver(rr)
+				zone.addGlue(reply, rr.Data.(*dns.RDataNS).Host)
+			}
+			return
+		}
+		reply.Header.Flags |= dns.MessageHeaderFlagAuthoritative
+
+		// Otherwise, the name itself, else the wildcard at its closest
+		// encloser (RFC 4592, 3.3.1)
+		records := zone.records[name]
+		if (!zone.exists[name]) {
+			encloser := name
+			for (!zone.exists[encloser]) {
+				encloser = parentName(encloser)
+			}
+			wildcard := "*." + encloser
+			if (encloser == "") {
+				wildcard = "*"
+			}
+			if (!zone.exists[wildcard]) {
+				reply.Header.Flags |= dns.RcodeNameError
+				reply.AddNameserver(zone.negativeSOA())
+				return
+			}
+			records = zone.records[wildcard]
+		}
+
+		// Synthesized records take on the name from the question
+		var answers, cnames []dns.ResourceRecord
+		for _, rr := range records {
+			rr.Name = owner
+			if (rr.Type == question.Type || question.Type == dns.RecordTypeALL) {
+				answers = append(answers, rr)
+			} else if (rr.Type == dns.RecordTypeCNAME) {
+				cnames = append(cnames, rr)
+			}
+		}
+		if (len(answers) > 0) {
+			for _, rr := range answers {
+				reply.AddAnswer(rr)
+				zone.addAdditional(reply, rr)
+			}
+			return
+		}
+		if (len(cnames) == 0) {
+			reply.AddNameserver(zone.negativeSOA())
+			return
+		}
+
+		// Follow the alias while it stays within the zone
+		reply.AddAnswer(cnames[0])
+		target := cnames[0].Data.(*dns.RDataCNAME).Target
+		name = dns.CanonicalName(target)
+		owner = target
+		if (!dns.IsSubdomain(name, zone.Origin) || chain + 1 >= MaxCNAMEChain) {
+			return
+		}
+	}
+}
+
+// Addresses of an NS host below the delegation, which the resolver cannot
+// otherwise locate (RFC 1034, 4.2.1), or elsewhere within the zone
+func (zone *Zone) addGlue(reply *dns.Message, host string) {
+	name := dns.CanonicalName(host)
+	if (!dns.IsSubdomain(name, zone.Origin)) {
+		return
+	}
+	for _, rtype := range []uint16{ dns.RecordTypeA, dns.RecordTypeAAAA } {
+		for _, rr := range zone.lookup(name, rtype) {
+			reply.AddAdditional(rr)
+		}
+	}
+}
+
+// Addresses of any hosts named in an answer, as additional records
+func (zone *Zone) addAdditional(reply *dns.Message, rr dns.ResourceRecord) {
+	switch data := rr.Data.(type) {
+		case *dns.RDataNS:
+			zone.addGlue(reply, data.Host)
+		case *dns.RDataMX:
+			zone.addGlue(reply, data.Exchange)
+	}
+}
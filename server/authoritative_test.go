@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ddnsr/dns"
+	)
+
+func loadZone(t *testing.T) *Zone {
+	records, err := dns.LoadZone("testdata/example.com.zone", "example.com")
+	if (err != nil) {
+		t.Fatal("Unable to load the zone: ", err)
+	}
+	zone, err := NewZone("example.com", records)
+	if (err != nil) {
+		t.Fatal("Invalid zone: ", err)
+	}
+	return zone
+}
+
+// Summary of a section, e.g. "www.example.com CNAME web.example.com; ..."
+func sectionString(records []dns.ResourceRecord) string {
+	var summary []string
+	for _, rr := range records {
+		summary = append(summary, fmt.Sprintf("%s %s %s", rr.Name,
+			dns.RecordTypeString(rr.Type), rr.Data))
+	}
+	return strings.Join(summary, "; ")
+}
+
+
+//
+// Validate answers, referrals, negative answers, wildcards + CNAMEs
+//
+func TestAuthoritative(t *testing.T) {
+	handler := Authoritative(loadZone(t))
+	testCases := []struct{
+		name		string
+		rtype		uint16
+		rcode		uint16
+		aa			bool
+		answers		string
+		authority	string
+		additional	int
+	}{
+		{ "WEB.example.com", dns.RecordTypeA, dns.RcodeNoError, true,
+			"WEB.example.com A 192.0.2.80", "", 0 },
+		{ "example.com", dns.RecordTypeMX, dns.RcodeNoError, true,
+			"example.com MX 10 mail.example.com", "", 1 },
+		{ "example.com", dns.RecordTypeNS, dns.RcodeNoError, true,
+			"example.com NS ns1.example.com; example.com NS ns2.example.net",
+			"", 1 },
+
+		// CNAMEs, followed within the zone only
+		{ "www.example.com", dns.RecordTypeAAAA, dns.RcodeNoError, true,
+			"www.example.com CNAME web.example.com; " +
+			"web.example.com AAAA 2001:db8::80", "", 0 },
+		{ "www.example.com", dns.RecordTypeCNAME, dns.RcodeNoError, true,
+			"www.example.com CNAME web.example.com", "", 0 },
+		{ "alias.example.com", dns.RecordTypeA, dns.RcodeNoError, true,
+			"alias.example.com CNAME www.example.org", "", 0 },
+		{ "dangling.example.com", dns.RecordTypeA, dns.RcodeNameError, true,
+			"dangling.example.com CNAME missing.example.com",
+			"example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+
+		// NXDOMAIN vs NODATA, including empty non-terminals
+		{ "missing.example.com", dns.RecordTypeA, dns.RcodeNameError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+		{ "web.example.com", dns.RecordTypeTXT, dns.RcodeNoError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+		{ "b.c.example.com", dns.RecordTypeA, dns.RcodeNoError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+
+		// Wildcards, except where the name exists
+		{ "alice.users.example.com", dns.RecordTypeTXT, dns.RcodeNoError, true,
+			"alice.users.example.com TXT \"wildcard\"", "", 0 },
+		{ "a.b.users.example.com", dns.RecordTypeMX, dns.RcodeNoError, true,
+			"a.b.users.example.com MX 20 mail.example.com", "", 1 },
+		{ "bob.users.example.com", dns.RecordTypeA, dns.RcodeNoError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+		{ "host.users.example.com", dns.RecordTypeTXT, dns.RcodeNoError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+
+		// Referrals, with glue
+		{ "www.sub.example.com", dns.RecordTypeA, dns.RcodeNoError, false,
+			"", "sub.example.com NS ns.sub.example.com", 1 },
+		{ "sub.example.com", dns.RecordTypeNS, dns.RcodeNoError, false,
+			"", "sub.example.com NS ns.sub.example.com", 1 },
+
+		// DS records, from the parent side of the delegation
+		{ "sub.example.com", dns.RecordTypeDS, dns.RcodeNoError, true,
+			"sub.example.com DS 60485 5 1 " +
+			"2BB183AF5F22588179A53B0A98631FAD1A292118", "", 0 },
+		{ "other.example.com", dns.RecordTypeDS, dns.RcodeNoError, true,
+			"", "example.com SOA ns1.example.com hostmaster.example.com " +
+			"2024010101 7200 1800 1209600 300", 0 },
+		{ "www.sub.example.com", dns.RecordTypeDS, dns.RcodeNoError, false,
+			"", "sub.example.com NS ns.sub.example.com", 1 },
+		{ "other.example.com", dns.RecordTypeA, dns.RcodeNoError, false,
+			"", "other.example.com NS ns2.example.net", 0 },
+
+		// Other zones
+		{ "example.org", dns.RecordTypeA, dns.RcodeRefused, false, "", "", 0 },
+	}
+
+	for _, test := range testCases {
+		request := dns.NewQuery(test.name, test.rtype)
+		reply := handler(context.Background(), &request)
+		description := fmt.Sprintf("%s %s", test.name,
+			dns.RecordTypeString(test.rtype))
+		if (reply.Rcode() != test.rcode) {
+			t.Errorf("%s: unexpected rcode %d", description, reply.Rcode())
+		}
+		aa := (reply.Header.Flags & dns.MessageHeaderFlagAuthoritative != 0)
+		if (aa != test.aa) {
+			t.Errorf("%s: unexpected AA flag", description)
+		}
+		if (sectionString(reply.Answers) != test.answers) {
+			t.Errorf("%s: unexpected answers: %s", description,
+				sectionString(reply.Answers))
+		}
+		if (sectionString(reply.Nameservers) != test.authority) {
+			t.Errorf("%s: unexpected authority: %s", description,
+				sectionString(reply.Nameservers))
+		}
+		if (len(reply.AdditionalRR) != test.additional) {
+			t.Errorf("%s: unexpected additional records: %s", description,
+				sectionString(reply.AdditionalRR))
+		}
+	}
+
+	// The SOA TTL is limited by the SOA minimum, even one beyond int32
+	zone := loadZone(t)
+	for minimum, expected := range map[uint32]int32{ 300: 300,
+		0xFFFFFFFF: zone.SOA.TTL } {
+		zone.SOA.Data.(*dns.RDataSOA).Minimum = minimum
+		request := dns.NewQuery("missing.example.com", dns.RecordTypeA)
+		reply := Authoritative(zone)(context.Background(), &request)
+		if (reply.Nameservers[0].TTL != expected) {
+			t.Errorf("Minimum %d: unexpected negative TTL %d", minimum,
+				reply.Nameservers[0].TTL)
+		}
+	}
+}
+
+
+//
+// Validate the rejection of incomplete zones
+//
+func TestNewZone(t *testing.T) {
+	zone := `
+@	3600	SOA	ns1 hostmaster 1 2 3 4 5
+www.example.net.	3600	A	192.0.2.1
+`
+	records, err := dns.ParseZone(strings.NewReader(zone), "example.com", "test")
+	if (err != nil) {
+		t.Fatal("Parsing error: ", err)
+	}
+	_, err = NewZone("example.com", records)
+	if (err != ErrOutOfZone) {
+		t.Error("Expected an out-of-zone error: ", err)
+	}
+	_, err = NewZone("example.com", nil)
+	if (err != ErrNoSOA) {
+		t.Error("Expected a missing SOA: ", err)
+	}
+}
@@ -0,0 +1,46 @@
+//
+// Forwarding handler.  Relays each request to the upstream servers (or any
+// other exchange, e.g. one wrapped by a resolver.Cache), as a stub resolver.
+//
+
+package server
+
+import (
+	"context"
+	"errors"
+
+	"ddnsr/dns"
+	"ddnsr/resolver"
+)
+
+
+// Answer requests via the exchange.  Each request is sent upstream as a new
+// query, with its own id + EDNS, so that nothing from the client besides the
+// question, the RD + CD flags, and the DO bit reaches the upstream servers.
+// A validating client needs the last two, for the RRSIGs of the answer, and
+// for answers that the upstream servers would otherwise reject as bogus
+func Forwarder(exchange resolver.ExchangeFunc) Handler {
+	return func(ctx context.Context, request *dns.Message) *dns.Message {
+		question := request.Questions[0]
+		query := dns.NewQuery(question.Name, question.Type)
+		query.Questions[0].Class = question.Class
+		query.Header.Flags = (request.Header.Flags &
+			(dns.MessageHeaderFlagRecursionDesired |
+			dns.MessageHeaderFlagCheckingDisabled))
+		query.SetEDNS(dns.OPTRecord{
+			UDPSize:	dns.EDNSDefaultUDPSize,
+			Version:	dns.EDNSVersion,
+			DNSSECOK:	(request.EDNS != nil && request.EDNS.DNSSECOK),
+		})
+
+		// Error response codes still come with a valid reply, which is
+		// relayed as-is.  Any other error, e.g. a reply that does not match
+		// the query, is a server failure instead
+		reply, err := exchange(ctx, &query)
+		var rcodeErr *dns.RcodeError
+		if (err != nil && !errors.As(err, &rcodeErr)) {
+			return nil
+		}
+		return reply
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	"ddnsr/resolver"
+	)
+
+//
+// Validate forwarding via a cache to a fake upstream server, preserving the
+// id + question of each client request
+//
+func TestForwarder(t *testing.T) {
+	var queries int32
+	answer := answerHandler(1)
+	upstream := startServer(t, func(ctx context.Context,
+		request *dns.Message) *dns.Message {
+		atomic.AddInt32(&queries, 1)
+		return answer(ctx, request)
+	})
+
+	cache := &resolver.Cache{}
+	forwarder := startServer(t,
+		Forwarder(cache.Wrap(newClient(resolver.TransportAuto, upstream).Exchange)))
+	client := newClient(resolver.TransportAuto, forwarder)
+
+	for _, name := range []string{ "www.example.com", "WWW.Example.COM" } {
+		request := dns.NewQuery(name, dns.RecordTypeA)
+		request.Header.Flags |= dns.MessageHeaderFlagRecursionDesired
+		reply, err := client.Exchange(context.Background(), &request)
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (reply.Header.Id != request.Header.Id ||
+			reply.Questions[0] != request.Questions[0] ||
+			len(reply.Answers) != 1 || reply.EDNS != nil) {
+			t.Error("Unexpected reply: ", reply)
+		}
+	}
+	if (atomic.LoadInt32(&queries) != 1) {
+		t.Error("Expected a single upstream query: ", queries)
+	}
+	if (cache.Stats().Hits != 1) {
+		t.Errorf("Unexpected cache stats: %+v", cache.Stats())
+	}
+}
+
+
+//
+// Validate that the DO bit + CD flag of each client request reach the
+// upstream servers, that the cache keeps DO + CD answers apart, and that DO
+// requests get the DO bit back
+//
+func TestForwarderDNSSEC(t *testing.T) {
+	var lock sync.Mutex
+	var queries []dns.Message
+	answer := answerHandler(1)
+	upstream := startServer(t, func(ctx context.Context,
+		request *dns.Message) *dns.Message {
+		lock.Lock()
+		defer lock.Unlock()
+		queries = append(queries, *request)
+		return answer(ctx, request)
+	})
+
+	cache := &resolver.Cache{}
+	forwarder := startServer(t,
+		Forwarder(cache.Wrap(newClient(resolver.TransportAuto, upstream).Exchange)))
+	client := newClient(resolver.TransportAuto, forwarder)
+
+	// The second DO + CD request is answered from the cache, but an answer
+	// that skipped upstream validation must not reach a request without CD
+	type flags struct {
+		dnssec	bool
+		cd		bool
+	}
+	requests := []flags{ { false, false }, { true, true }, { true, true },
+		{ true, false } }
+	for _, test := range requests {
+		request := dns.NewQuery("www.example.com", dns.RecordTypeA)
+		request.Header.Flags |= dns.MessageHeaderFlagRecursionDesired
+		if (test.cd) {
+			request.Header.Flags |= dns.MessageHeaderFlagCheckingDisabled
+		}
+		if (test.dnssec) {
+			request.SetEDNS(dns.OPTRecord{ UDPSize: dns.EDNSDefaultUDPSize,
+				DNSSECOK: true })
+		}
+		reply, err := client.Exchange(context.Background(), &request)
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (test.dnssec && (reply.EDNS == nil || !reply.EDNS.DNSSECOK)) {
+			t.Errorf("Expected the DO bit in the reply: %s", reply)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []flags{ requests[0], requests[1], requests[3] }
+	if (len(queries) != len(expected)) {
+		t.Fatal("Unexpected upstream queries: ", queries)
+	}
+	for i, query := range queries {
+		if (query.EDNS == nil || query.EDNS.DNSSECOK != expected[i].dnssec ||
+			(query.Header.Flags & dns.MessageHeaderFlagCheckingDisabled != 0) !=
+			expected[i].cd) {
+			t.Errorf("Query %d: expected %+v: %s", i, expected[i], query)
+		}
+	}
+}
+
+
+//
+// Validate server failures when the upstream servers are unavailable
+//
+func TestForwarderFailure(t *testing.T) {
+	upstream := newClient(resolver.TransportUDP, "127.0.0.1:1")
+	upstream.Timeout = 100 * time.Millisecond
+	client := newClient(resolver.TransportAuto,
+		startServer(t, Forwarder(upstream.Exchange)))
+
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	reply, err := client.Exchange(context.Background(), &request)
+	if (reply == nil || reply.Rcode() != dns.RcodeServerFailure) {
+		t.Error("Expected a server failure: ", reply, err)
+	}
+
+	// Nor are replies that do not match the query relayed, e.g. with
+	// another id
+	mismatched := newClient(resolver.TransportUDP, startMismatchedUpstream(t))
+	mismatched.Timeout = 100 * time.Millisecond
+	client = newClient(resolver.TransportAuto,
+		startServer(t, Forwarder(mismatched.Exchange)))
+
+	reply, err = client.Exchange(context.Background(), &request)
+	if (reply == nil || reply.Rcode() != dns.RcodeServerFailure ||
+		len(reply.Answers) != 0) {
+		t.Error("Expected a server failure: ", reply, err)
+	}
+}
+
+// Fake upstream server that answers every request, but with the wrong id
+func startMismatchedUpstream(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	answer := answerHandler(1)
+	go func() {
+		buffer := make([]byte, dns.EDNSDefaultUDPSize)
+		for {
+			length, address, err := conn.ReadFrom(buffer)
+			if (err != nil) {
+				return
+			}
+			request := &dns.Message{}
+			_, err = request.Unpack(buffer[:length])
+			if (err != nil) {
+				continue
+			}
+			reply := answer(context.Background(), request)
+			reply.Header.Id++
+			replyBytes, _ := reply.Pack()
+			conn.WriteTo(replyBytes, address)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
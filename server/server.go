@@ -0,0 +1,318 @@
+//
+// DNS server.  Listens for requests over both UDP and TCP on the same address,
+// and answers each one via a Handler, e.g. a caching forwarder (see
+// forward.go).  Replies always carry the id + question of the request, and
+// UDP replies are truncated to fit the client's payload size.
+//
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"ddnsr/dns"
+	"ddnsr/resolver"
+)
+
+
+var ErrNoHandler		= errors.New("No request handler")
+var ErrNotListening		= errors.New("Server is not listening")
+
+const DefaultTimeout		= 5 * time.Second
+const DefaultIdleTimeout	= 10 * time.Second // TCP, RFC 7766, 6.2.3
+
+// Bind attempts for an ephemeral port, which must be free for both UDP + TCP
+const listenAttempts		= 10
+
+
+// Answer a single, well-formed request with exactly one question.  The
+// server fixes up the id, question, flags + EDNS of the reply.  A nil reply
+// becomes a server failure
+type Handler func(ctx context.Context, request *dns.Message) *dns.Message
+
+
+//
+// UDP + TCP server
+//
+type Server struct {
+	Address			string			// IP address + port, for both UDP + TCP
+	Handler			Handler
+	Timeout			time.Duration	// Per request; default DefaultTimeout
+	IdleTimeout		time.Duration	// TCP; default DefaultIdleTimeout
+
+	udp				net.PacketConn
+	tcp				net.Listener
+}
+
+
+// Bind the UDP + TCP sockets, and return the actual address.  Port 0 picks
+// an ephemeral port that is free for both protocols
+func (server *Server) Listen() (string, error) {
+	var err error
+	for attempt := 0; attempt < listenAttempts; attempt++ {
+		server.tcp, err = net.Listen("tcp", server.Address)
+		if (err != nil) {
+			return "", err
+		}
+		address := server.tcp.Addr().String()
+		server.udp, err = net.ListenPacket("udp", address)
+		if (err == nil) {
+			return address, nil
+		}
+
+		// Only an ephemeral port deserves another attempt
+		server.tcp.Close()
+		_, port, _ := net.SplitHostPort(server.Address)
+		if (port != "0") {
+			break
+		}
+	}
+	server.tcp = nil
+	return "", err
+}
+
+// Answer requests until the context is cancelled, or either socket fails
+func (server *Server) Serve(ctx context.Context) error {
+	if (server.Handler == nil) {
+		return ErrNoHandler
+	}
+	if (server.udp == nil || server.tcp == nil) {
+		return ErrNotListening
+	}
+
+	// Closing the sockets unblocks both of the accept loops.  Either loop
+	// failing stops the other one too
+	var wg sync.WaitGroup
+	var udpErr, tcpErr error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		server.udp.Close()
+		server.tcp.Close()
+	}()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		udpErr = server.serveUDP(ctx, &wg)
+		cancel()
+	}()
+	go func() {
+		defer wg.Done()
+		tcpErr = server.serveTCP(ctx, &wg)
+		cancel()
+	}()
+	<-ctx.Done()
+	wg.Wait()
+
+	if (udpErr != nil) {
+		return udpErr
+	}
+	return tcpErr
+}
+
+func (server *Server) ListenAndServe(ctx context.Context) error {
+	_, err := server.Listen()
+	if (err != nil) {
+		return err
+	}
+	return server.Serve(ctx)
+}
+
+
+func (server *Server) serveUDP(ctx context.Context,
+	wg *sync.WaitGroup) error {
+	for {
+		buffer := make([]byte, resolver.TCPMaxMessageSize)
+		length, client, err := server.udp.ReadFrom(buffer)
+		if (err != nil) {
+			if (ctx.Err() != nil) {
+				return nil
+			}
+			if (temporary(err)) {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			replyBytes := server.respond(ctx, buffer[:length], false)
+			if (replyBytes != nil) {
+				server.udp.WriteTo(replyBytes, client)
+			}
+		}()
+	}
+}
+
+func (server *Server) serveTCP(ctx context.Context,
+	wg *sync.WaitGroup) error {
+	for {
+		conn, err := server.tcp.Accept()
+		if (err != nil) {
+			if (ctx.Err() != nil) {
+				return nil
+			}
+			if (temporary(err)) {
+				continue
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// Whether a socket error is worth another read or accept, e.g. running out
+// of file descriptors for the moment
+func temporary(err error) bool {
+	var netErr net.Error
+	return (errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()))
+}
+
+// Answer each request on the connection in turn, until the client closes it
+// or falls idle
+func (server *Server) serveConn(ctx context.Context, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+			case <-ctx.Done():
+			case <-done:
+		}
+		conn.Close()
+	}()
+
+	idle := server.IdleTimeout
+	if (idle == 0) {
+		idle = DefaultIdleTimeout
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		requestBytes, err := resolver.ReadTCPMessage(conn)
+		if (err != nil) {
+			return
+		}
+		replyBytes := server.respond(ctx, requestBytes, true)
+		if (replyBytes == nil) {
+			return
+		}
+		err = resolver.WriteTCPMessage(conn, replyBytes)
+		if (err != nil) {
+			return
+		}
+	}
+}
+
+
+// Packed reply to the packed request, or nil if the request deserves no
+// reply at all
+func (server *Server) respond(ctx context.Context, requestBytes []byte,
+	tcp bool) []byte {
+	request := dns.Message{}
+	_, err := request.Unpack(requestBytes)
+	if (err != nil) {
+		// Malformed requests are reported, as long as the header survived
+		header := dns.MessageHeader{}
+		_, err = header.Unpack(requestBytes, 0)
+		if (err != nil || header.Flags & dns.MessageHeaderFlagResponse != 0) {
+			return nil
+		}
+		request = dns.Message{ Header: header }
+		formErr, _ := dns.NewReply(request, dns.RcodeFormatError).Pack()
+		return formErr
+	}
+
+	// Never answer responses, lest two servers answer each other forever
+	if (request.Header.Flags & dns.MessageHeaderFlagResponse != 0) {
+		return nil
+	}
+
+	// Replies that cannot be packed, e.g. with names too long for any
+	// message, fail instead.  Replies made from the request alone always pack,
+	// since its question came from the wire
+	reply := server.handle(ctx, &request)
+	replyBytes, err := reply.Pack()
+	if (err != nil) {
+		replyBytes, _ = dns.NewReply(request, dns.RcodeServerFailure).Pack()
+		return replyBytes
+	}
+	if (tcp || len(replyBytes) <= maxPayload(request)) {
+		return replyBytes
+	}
+
+	// Too large for UDP, so the client must retry over TCP
+	truncated := dns.NewReply(request, reply.Rcode())
+	truncated.Header.Flags = reply.Header.Flags | dns.MessageHeaderFlagTruncation
+	replyBytes, _ = truncated.Pack()
+	return replyBytes
+}
+
+// Reply to a single request, ready to send
+func (server *Server) handle(ctx context.Context,
+	request *dns.Message) *dns.Message {
+	if (request.Header.Flags & dns.MessageHeaderFlagOpcodeMask != 0) {
+		reply := dns.NewReply(*request, dns.RcodeNotImplemented)
+		return &reply
+	}
+	if (len(request.Questions) != 1) {
+		reply := dns.NewReply(*request, dns.RcodeFormatError)
+		return &reply
+	}
+	if (request.EDNS != nil && request.EDNS.Version > dns.EDNSVersion) {
+		reply := dns.NewReply(*request, dns.RcodeBadVersion)
+		return &reply
+	}
+
+	timeout := server.Timeout
+	if (timeout == 0) {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reply := server.Handler(ctx, request)
+	if (reply == nil) {
+		failure := dns.NewReply(*request, dns.RcodeServerFailure)
+		return &failure
+	}
+
+	// The reply may come from elsewhere, e.g. an upstream server or a cache,
+	// so restore the details of this particular request
+	rcode := reply.Rcode()
+	fixed := dns.NewReply(*request, rcode)
+	fixed.Header.Flags |= reply.Header.Flags &^ (dns.MessageHeaderFlagOpcodeMask |
+		dns.MessageHeaderFlagRecursionDesired)
+	for _, rr := range reply.Answers {
+		fixed.AddAnswer(rr)
+	}
+	for _, rr := range reply.Nameservers {
+		fixed.AddNameserver(rr)
+	}
+	for _, rr := range reply.AdditionalRR {
+		fixed.AddAdditional(rr)
+	}
+
+	// Echo the DO bit, whether or not the reply came with it (RFC 3225, 3)
+	if (fixed.EDNS != nil && request.EDNS != nil) {
+		fixed.EDNS.DNSSECOK = request.EDNS.DNSSECOK
+	}
+	return &fixed
+}
+
+// Largest UDP reply the client accepts (RFC 6891, 6.2.3)
+func maxPayload(request dns.Message) int {
+	if (request.EDNS == nil || request.EDNS.UDPSize < dns.EDNSMinUDPSize) {
+		return dns.EDNSMinUDPSize
+	}
+	return int(request.EDNS.UDPSize)
+}
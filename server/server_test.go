@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"ddnsr/dns"
+	"ddnsr/resolver"
+	)
+
+//
+// Loopback server, stopped at the end of the test
+//
+func startServer(t *testing.T, handler Handler) string {
+	server := &Server{ Address: "127.0.0.1:0", Handler: handler }
+	address, err := server.Listen()
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Serve(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return address
+}
+
+func newClient(transport resolver.Transport, servers ...string) *resolver.Client {
+	return &resolver.Client{
+		Servers:	servers,
+		Timeout:	time.Second,
+		Transport:	transport,
+	}
+}
+
+// Answer every question with the given number of A records
+func answerHandler(count int) Handler {
+	return func(ctx context.Context, request *dns.Message) *dns.Message {
+		reply := dns.NewReply(*request, dns.RcodeNoError)
+		for i := 0; i < count; i++ {
+			reply.AddAnswer(dns.ResourceRecord{
+				Name:	request.Questions[0].Name,
+				Type:	dns.RecordTypeA,
+				Class:	dns.RecordClassIN,
+				TTL:	300,
+				Data:	&dns.RDataA{ Address: net.IPv4(192, 0, 2, byte(i)).To4() },
+			})
+		}
+		return &reply
+	}
+}
+
+
+//
+// Validate exchanges over both UDP + TCP, including the truncation of large
+// UDP replies
+//
+func TestServe(t *testing.T) {
+	address := startServer(t, answerHandler(1))
+	for _, transport := range []resolver.Transport{
+		resolver.TransportUDP, resolver.TransportTCP } {
+		request := dns.NewQuery("a.com", dns.RecordTypeA)
+		reply, err := newClient(transport, address).Exchange(
+			context.Background(), &request)
+		if (err != nil) {
+			t.Fatal("Exchange error: ", err)
+		}
+		if (len(reply.Answers) != 1) {
+			t.Error("Unexpected reply: ", reply)
+		}
+	}
+
+	// 40 A records exceed 512 bytes, but not the EDNS payload size
+	address = startServer(t, answerHandler(40))
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	_, err := newClient(resolver.TransportUDP, address).Exchange(
+		context.Background(), &request)
+	if (err != dns.ErrTruncated) {
+		t.Error("Expected truncation: ", err)
+	}
+	reply, err := newClient(resolver.TransportAuto, address).Exchange(
+		context.Background(), &request)
+	if (err != nil || len(reply.Answers) != 40) {
+		t.Error("Expected the TCP fallback: ", err)
+	}
+	request.SetEDNS(dns.OPTRecord{ UDPSize: dns.EDNSDefaultUDPSize })
+	reply, err = newClient(resolver.TransportUDP, address).Exchange(
+		context.Background(), &request)
+	if (err != nil || len(reply.Answers) != 40 || reply.EDNS == nil) {
+		t.Error("Expected the complete UDP reply: ", err)
+	}
+}
+
+
+//
+// Validate that the server stops once a socket fails for good, but not on
+// temporary errors
+//
+func TestServeFailure(t *testing.T) {
+	server := &Server{ Address: "127.0.0.1:0", Handler: answerHandler(1) }
+	_, err := server.Listen()
+	if (err != nil) {
+		t.Fatal("Unable to listen: ", err)
+	}
+	server.udp.Close()
+	failing := &failingConn{ PacketConn: server.udp }
+	server.udp = failing
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = server.Serve(ctx)
+	if (err != errBroken || ctx.Err() != nil || failing.reads != 2) {
+		t.Error("Expected a socket failure: ", err, failing.reads)
+	}
+}
+
+var errBroken = errors.New("Broken socket")
+
+// UDP socket that times out once, then fails for good
+type failingConn struct {
+	net.PacketConn
+	reads	int
+}
+
+func (conn *failingConn) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	conn.reads++
+	if (conn.reads == 1) {
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+	return 0, nil, errBroken
+}
+func TestServeErrors(t *testing.T) {
+	server := &Server{ Handler: func(ctx context.Context,
+		request *dns.Message) *dns.Message {
+		return nil
+	} }
+
+	query := func(request dns.Message) dns.Message {
+		reply := dns.Message{}
+		requestBytes, _ := request.Pack()
+		replyBytes := server.respond(context.Background(), requestBytes, true)
+		if (replyBytes != nil) {
+			_, err := reply.Unpack(replyBytes)
+			if (err != nil) {
+				t.Fatal("Unpack error: ", err)
+			}
+		}
+		return reply
+	}
+
+	request := dns.NewQuery("a.com", dns.RecordTypeA)
+	reply := query(request)
+	if (reply.Validate(request) != nil ||
+		reply.Rcode() != dns.RcodeServerFailure) {
+		t.Error("Expected a server failure: ", reply)
+	}
+
+	request.Header.Flags |= 5 << 11 // UPDATE
+	if reply = query(request); reply.Rcode() != dns.RcodeNotImplemented {
+		t.Error("Expected not implemented: ", reply)
+	}
+
+	request = dns.NewQuery("a.com", dns.RecordTypeA)
+	request.AddQuestion(request.Questions[0])
+	if reply = query(request); reply.Rcode() != dns.RcodeFormatError {
+		t.Error("Expected a format error: ", reply)
+	}
+
+	request = dns.NewQuery("a.com", dns.RecordTypeA)
+	request.SetEDNS(dns.OPTRecord{ UDPSize: dns.EDNSDefaultUDPSize, Version: 1 })
+	if reply = query(request); reply.Rcode() != dns.RcodeBadVersion {
+		t.Error("Expected a bad version: ", reply)
+	}
+
+	// Replies that cannot be packed, e.g. with an empty label
+	server.Handler = func(ctx context.Context,
+		request *dns.Message) *dns.Message {
+		reply := dns.NewReply(*request, dns.RcodeNoError)
+		reply.AddAnswer(dns.ResourceRecord{ Name: "a.com",
+			Type: dns.RecordTypeCNAME, Class: dns.RecordClassIN,
+			Data: &dns.RDataCNAME{ Target: "b..com" } })
+		return &reply
+	}
+	request = dns.NewQuery("a.com", dns.RecordTypeA)
+	if reply = query(request); reply.Rcode() != dns.RcodeServerFailure ||
+		len(reply.Answers) != 0 {
+		t.Error("Expected a server failure: ", reply)
+	}
+
+	// Malformed requests, with a readable header
+	request = dns.NewQuery("a.com", dns.RecordTypeA)
+	requestBytes, _ := request.Pack()
+	replyBytes := server.respond(context.Background(),
+		requestBytes[:len(requestBytes) - 2], false)
+	_, err := reply.Unpack(replyBytes)
+	if (err != nil || reply.Rcode() != dns.RcodeFormatError ||
+		reply.Header.Id != request.Header.Id) {
+		t.Error("Expected a format error: ", reply)
+	}
+
+	// No replies to replies, nor to garbage
+	request.Header.Flags |= dns.MessageHeaderFlagResponse
+	requestBytes, _ = request.Pack()
+	if (server.respond(context.Background(), requestBytes, false) != nil ||
+		server.respond(context.Background(), []byte{ 1, 2, 3 }, false) != nil) {
+		t.Error("Unexpected reply")
+	}
+}